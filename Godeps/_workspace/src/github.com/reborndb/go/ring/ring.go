@@ -119,6 +119,17 @@ func (r *Ring) Reset() {
 	r.length = 0
 }
 
+// Restore sets current offset and data length, e.g. for a reopened file ring
+func (r *Ring) Restore(offset int, length int) error {
+	if offset < 0 || offset >= r.size || length < 0 || length > r.size {
+		return fmt.Errorf("invalid offset %d and length %d, size %d", offset, length, r.size)
+	}
+
+	r.offset = offset
+	r.length = length
+	return nil
+}
+
 func (r *Ring) Close() error {
 	return r.buf.Close()
 }
@@ -0,0 +1,113 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	redis "github.com/reborndb/go/redis/resp"
+)
+
+// client of a qdb server or sentinel, it reconnects on the next command after errors
+type client struct {
+	addr    string
+	auth    string
+	timeout time.Duration
+
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+func newClient(addr string, auth string, timeout time.Duration) *client {
+	return &client{addr: addr, auth: auth, timeout: timeout}
+}
+
+func (c *client) connect() error {
+	nc, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	c.nc = nc
+	c.r = bufio.NewReader(nc)
+	c.w = bufio.NewWriter(nc)
+
+	if len(c.auth) > 0 {
+		if _, err := c.do("AUTH", c.auth); err != nil {
+			c.Close()
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Do sends the command and returns its reply, error replies are returned as errors.
+func (c *client) Do(cmd string, args ...interface{}) (redis.Resp, error) {
+	if c.nc == nil {
+		if err := c.connect(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	resp, err := c.do(cmd, args...)
+	if err != nil {
+		c.Close()
+		return nil, errors.Trace(err)
+	}
+	return resp, nil
+}
+
+func (c *client) do(cmd string, args ...interface{}) (redis.Resp, error) {
+	if err := c.nc.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := redis.Encode(c.w, redis.NewRequest(cmd, args...)); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	resp, err := redis.Decode(c.r)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if e, ok := resp.(*redis.Error); ok {
+		return nil, errors.Errorf("%s %s failed - %s", cmd, c.addr, e.Value)
+	}
+	return resp, nil
+}
+
+func (c *client) Close() {
+	if c.nc != nil {
+		c.nc.Close()
+		c.nc = nil
+	}
+}
+
+// parse INFO reply into fields
+func parseInfo(resp redis.Resp) (map[string]string, error) {
+	b, ok := resp.(*redis.BulkBytes)
+	if !ok {
+		return nil, errors.Errorf("invalid info reply type %T", resp)
+	}
+
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(b.Value), "\r\n") {
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if i := strings.Index(line, ":"); i > 0 {
+			fields[line[:i]] = line[i+1:]
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"bytes"
+
+	"github.com/BurntSushi/toml"
+	"github.com/juju/errors"
+)
+
+// GroupConfig is a master and its slaves, any of them can be master after failover.
+type GroupConfig struct {
+	Name    string   `toml:"name"`
+	Servers []string `toml:"servers"`
+}
+
+type Config struct {
+	Listen string `toml:"listen_address"`
+
+	// other sentinels monitoring the same groups
+	Sentinels []string `toml:"sentinels"`
+
+	// number of sentinels agreeing the master is down before failover,
+	// the failover leader is still elected by majority of all sentinels
+	Quorum int `toml:"quorum"`
+
+	// master is down if it doesn't reply PING in time
+	DownAfter int `toml:"down_after_milliseconds"`
+	// sentinels don't failover the same master again in time
+	FailoverTimeout int `toml:"failover_timeout_milliseconds"`
+
+	// auth of qdb servers
+	Auth string `toml:"auth"`
+
+	// epochs and votes are saved in the file, not saved if empty
+	StateFile string `toml:"state_file"`
+
+	Groups []*GroupConfig `toml:"group"`
+}
+
+func NewDefaultConfig() *Config {
+	return &Config{
+		Listen: "0.0.0.0:26380",
+		Quorum: 1,
+
+		DownAfter:       5000,
+		FailoverTimeout: 60000,
+
+		StateFile: "sentinel.state",
+	}
+}
+
+func (c *Config) LoadFromFile(path string) error {
+	_, err := toml.DecodeFile(path, c)
+	return errors.Trace(err)
+}
+
+func (c *Config) String() string {
+	var b bytes.Buffer
+	e := toml.NewEncoder(&b)
+	e.Indent = "    "
+	e.Encode(c)
+	return b.String()
+}
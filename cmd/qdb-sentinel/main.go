@@ -0,0 +1,104 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/docopt/docopt-go"
+	"github.com/ngaut/log"
+)
+
+func init() {
+	log.SetLevel(log.LOG_LEVEL_INFO)
+}
+
+func setStringFromOpt(dest *string, d map[string]interface{}, key string) {
+	if s, ok := d[key].(string); ok && len(s) != 0 {
+		*dest = s
+	}
+}
+
+func setIntFromOpt(dest *int, d map[string]interface{}, key string) {
+	if s, ok := d[key].(string); ok && len(s) != 0 {
+		if n, err := strconv.Atoi(s); err != nil {
+			log.Fatalf("parse %s failed - %s", key, err)
+		} else {
+			*dest = n
+		}
+	}
+}
+
+func main() {
+	usage := `
+Usage:
+    qdb-sentinel [options]
+
+Options:
+    -L logfile                        log file path, if empty, use stdout
+    -c CONF, --config=CONF            specify the config file
+    --addr=ADDR                       sentinel listening address
+    --auth=AUTH                       auth of monitored servers
+    --quorum=N                        number of sentinels agreeing master is down before failover
+    --down_after=N                    master is down if no reply to PING in N milliseconds
+    --failover_timeout=N              not failover the same master again in N milliseconds
+    --state_file=FILE                 file saving epochs and votes
+`
+	d, err := docopt.Parse(usage, nil, true, "", false)
+	if err != nil {
+		log.Fatalf("parse arguments failed - %s", err)
+	}
+
+	if s, ok := d["-L"].(string); ok && len(s) > 0 {
+		log.SetHighlighting(false)
+		err = log.SetOutputByName(s)
+		if err != nil {
+			log.Fatalf("set log name failed - %s", err)
+		}
+	}
+
+	conf := NewDefaultConfig()
+
+	if s, ok := d["--config"].(string); ok && len(s) > 0 {
+		if err := conf.LoadFromFile(s); err != nil {
+			log.Fatalf("load config failed - %s", err)
+		}
+	}
+
+	setStringFromOpt(&conf.Listen, d, "--addr")
+	setStringFromOpt(&conf.Auth, d, "--auth")
+	setIntFromOpt(&conf.Quorum, d, "--quorum")
+	setIntFromOpt(&conf.DownAfter, d, "--down_after")
+	setIntFromOpt(&conf.FailoverTimeout, d, "--failover_timeout")
+	setStringFromOpt(&conf.StateFile, d, "--state_file")
+
+	log.Infof("load config\n%s\n\n", conf)
+
+	s, err := NewSentinel(conf)
+	if err != nil {
+		log.Fatalf("create sentinel failed - %s", err)
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, os.Interrupt, os.Kill)
+
+	go func() {
+		for _ = range c {
+			log.Infof("interrupt and shutdown")
+			s.Close()
+			os.Exit(0)
+		}
+	}()
+
+	s.Run()
+
+	if err := s.Serve(); err != nil {
+		log.Errorf("sentinel failed - %s", err)
+	}
+
+	s.Close()
+}
@@ -0,0 +1,644 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+	mrand "math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	redis "github.com/reborndb/go/redis/resp"
+)
+
+const (
+	// timeout of dialing and every command to servers and sentinels
+	clientTimeout = 3 * time.Second
+	// interval of checking servers
+	monitorPeriod = time.Second
+)
+
+// server state in a group, refreshed every monitorPeriod
+type server struct {
+	addr string
+	cli  *client
+
+	// last time the server replied PING
+	lastPong time.Time
+
+	role string
+	// master address of slave
+	masterAddr string
+	// replication offset of slave
+	offset int64
+}
+
+func (sv *server) isDown(downAfter time.Duration) bool {
+	return time.Now().Sub(sv.lastPong) > downAfter
+}
+
+type group struct {
+	sync.Mutex
+
+	name    string
+	servers []*server
+
+	// current master address, empty if unknown
+	master string
+	// epoch of the failover promoting current master
+	configEpoch int64
+
+	// master is down from sight of this sentinel or quorum of sentinels
+	sdown bool
+	odown bool
+
+	// failover leader this sentinel voted for in leaderEpoch
+	leader      string
+	leaderEpoch int64
+
+	// last time sentinels tried to failover the master
+	failoverTime time.Time
+}
+
+func (g *group) findServer(addr string) *server {
+	for _, sv := range g.servers {
+		if sv.addr == addr {
+			return sv
+		}
+	}
+	return nil
+}
+
+// votes the first sentinel asking in a newer epoch, returns the voted leader,
+// the voter won't failover by itself until failover timeout
+func (g *group) vote(runID string, epoch int64) (string, int64) {
+	if epoch > g.leaderEpoch {
+		g.leader, g.leaderEpoch = runID, epoch
+		g.failoverTime = time.Now()
+		log.Infof("group %s vote %s as failover leader, epoch = %d", g.name, runID, epoch)
+	}
+	return g.leader, g.leaderEpoch
+}
+
+type Sentinel struct {
+	mu sync.Mutex
+
+	conf  *Config
+	runID string
+
+	// the greatest epoch seen by this sentinel
+	currentEpoch int64
+
+	groups map[string]*group
+
+	// epochs and votes survive restart
+	state *stateFile
+
+	l    net.Listener
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewSentinel(conf *Config) (*Sentinel, error) {
+	s := &Sentinel{
+		conf:   conf,
+		groups: make(map[string]*group),
+		quit:   make(chan struct{}),
+	}
+
+	if conf.Quorum <= 0 {
+		return nil, errors.Errorf("invalid quorum = %d", conf.Quorum)
+	}
+
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, errors.Trace(err)
+	}
+	s.runID = hex.EncodeToString(buf)
+
+	for _, gc := range conf.Groups {
+		if len(gc.Name) == 0 || len(gc.Servers) == 0 {
+			return nil, errors.Errorf("invalid group name = '%s', servers = %v", gc.Name, gc.Servers)
+		}
+		if s.groups[gc.Name] != nil {
+			return nil, errors.Errorf("duplicated group name = '%s'", gc.Name)
+		}
+
+		g := &group{name: gc.Name}
+		for _, addr := range gc.Servers {
+			// servers report master address by ip, resolve it for comparing
+			tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			g.servers = append(g.servers, &server{
+				addr: tcpAddr.String(),
+				cli:  newClient(tcpAddr.String(), conf.Auth, clientTimeout),
+			})
+		}
+		s.groups[g.name] = g
+	}
+
+	state, err := loadStateFile(conf.StateFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s.state = state
+	s.restoreState()
+
+	l, err := net.Listen("tcp", conf.Listen)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s.l = l
+
+	log.Infof("sentinel listen on %s, run id = %s", conf.Listen, s.runID)
+	return s, nil
+}
+
+// restores epochs, master and vote of groups saved before restart
+func (s *Sentinel) restoreState() {
+	s.currentEpoch = s.state.state.CurrentEpoch
+	for name, gs := range s.state.state.Groups {
+		g := s.groups[name]
+		if g == nil {
+			continue
+		}
+		// master not monitored anymore is detected again
+		if g.findServer(gs.Master) != nil {
+			g.master = gs.Master
+		}
+		g.configEpoch = gs.ConfigEpoch
+		g.leader, g.leaderEpoch = gs.Leader, gs.LeaderEpoch
+		if gs.LeaderEpoch > s.currentEpoch {
+			s.currentEpoch = gs.LeaderEpoch
+		}
+		if gs.ConfigEpoch > s.currentEpoch {
+			s.currentEpoch = gs.ConfigEpoch
+		}
+	}
+	if s.currentEpoch > 0 {
+		log.Infof("sentinel restore state, current epoch = %d", s.currentEpoch)
+	}
+}
+
+func (s *Sentinel) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.quit:
+	default:
+		close(s.quit)
+		s.l.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Sentinel) nextEpoch() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentEpoch++
+	return s.currentEpoch
+}
+
+func (s *Sentinel) updateEpoch(epoch int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+	}
+}
+
+func (s *Sentinel) getGroup(name string) *group {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.groups[name]
+}
+
+// returns groups sorted by name
+func (s *Sentinel) sortedGroups() []*group {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.groups))
+	for name, _ := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gs := make([]*group, 0, len(names))
+	for _, name := range names {
+		gs = append(gs, s.groups[name])
+	}
+	return gs
+}
+
+func (s *Sentinel) downAfter() time.Duration {
+	return time.Duration(s.conf.DownAfter) * time.Millisecond
+}
+
+func (s *Sentinel) failoverTimeout() time.Duration {
+	return time.Duration(s.conf.FailoverTimeout) * time.Millisecond
+}
+
+// Run monitors all groups until closed.
+func (s *Sentinel) Run() {
+	for _, g := range s.sortedGroups() {
+		s.wg.Add(1)
+		go s.monitor(g)
+	}
+}
+
+func (s *Sentinel) monitor(g *group) {
+	defer s.wg.Done()
+
+	// every group uses its own clients of sentinels, clients are not thread-safe
+	peers := make([]*client, 0, len(s.conf.Sentinels))
+	for _, addr := range s.conf.Sentinels {
+		peers = append(peers, newClient(addr, "", clientTimeout))
+	}
+
+	defer func() {
+		for _, sv := range g.servers {
+			sv.cli.Close()
+		}
+		for _, peer := range peers {
+			peer.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(monitorPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+		}
+
+		s.refreshGroup(g)
+		s.checkMaster(g, peers)
+		s.reconfigureGroup(g)
+	}
+}
+
+// checks all servers of the group at the same time
+func (s *Sentinel) refreshGroup(g *group) {
+	var wg sync.WaitGroup
+	for _, sv := range g.servers {
+		wg.Add(1)
+		go func(sv *server) {
+			defer wg.Done()
+			if err := s.refreshServer(g, sv); err != nil {
+				log.Debugf("group %s refresh server %s failed - %s", g.name, sv.addr, err)
+			}
+		}(sv)
+	}
+	wg.Wait()
+
+	g.Lock()
+	defer g.Unlock()
+
+	if m := g.findServer(g.master); m != nil && !m.isDown(s.downAfter()) && m.role == "slave" {
+		// master is changed by others, forget it and detect again
+		log.Warningf("group %s master %s turns to slave of %s", g.name, m.addr, m.masterAddr)
+		g.master = ""
+	}
+
+	if g.master == "" {
+		g.master = s.detectMaster(g)
+		if g.master != "" {
+			log.Infof("group %s detect master %s", g.name, g.master)
+		}
+	}
+}
+
+func (s *Sentinel) refreshServer(g *group, sv *server) error {
+	if _, err := sv.cli.Do("PING"); err != nil {
+		return errors.Trace(err)
+	}
+
+	resp, err := sv.cli.Do("INFO", "replication")
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	info, err := parseInfo(resp)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// qdb reports slaveof, redis reports master_host and master_port instead
+	masterAddr := info["slaveof"]
+	if masterAddr == "" && info["master_host"] != "" {
+		masterAddr = net.JoinHostPort(info["master_host"], info["master_port"])
+	}
+
+	offset, _ := strconv.ParseInt(info["slave_repl_offset"], 10, 64)
+
+	g.Lock()
+	defer g.Unlock()
+
+	sv.lastPong = time.Now()
+	sv.role = info["role"]
+	sv.masterAddr = masterAddr
+	sv.offset = offset
+	return nil
+}
+
+// returns the alive master replicated by most slaves, must be called with group lock held
+func (s *Sentinel) detectMaster(g *group) string {
+	slaves := make(map[string]int)
+	for _, sv := range g.servers {
+		if !sv.isDown(s.downAfter()) && sv.role == "slave" {
+			slaves[sv.masterAddr]++
+		}
+	}
+
+	master, n := "", -1
+	for _, sv := range g.servers {
+		if !sv.isDown(s.downAfter()) && sv.role == "master" && slaves[sv.addr] > n {
+			master, n = sv.addr, slaves[sv.addr]
+		}
+	}
+	return master
+}
+
+func splitAddr(addr string) (string, int, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, errors.Trace(err)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, errors.Trace(err)
+	}
+	return host, n, nil
+}
+
+// asks other sentinels whether the master is down, votes for runID as failover leader
+// in epoch if runID is not '*', returns number of sentinels agreeing and voting
+func (s *Sentinel) askSentinels(peers []*client, master string, epoch int64, runID string) (int, int) {
+	host, port, err := splitAddr(master)
+	if err != nil {
+		log.Errorf("invalid master address %s - %s", master, err)
+		return 0, 0
+	}
+
+	downs, votes := 0, 0
+	for _, peer := range peers {
+		resp, err := peer.Do("SENTINEL", "is-master-down-by-addr", host, port, epoch, runID)
+		if err != nil {
+			log.Debugf("ask sentinel %s failed - %s", peer.addr, err)
+			continue
+		}
+
+		down, leader, leaderEpoch, err := parseMasterDownReply(resp)
+		if err != nil {
+			log.Warningf("ask sentinel %s failed - %s", peer.addr, err)
+			continue
+		}
+
+		if down {
+			downs++
+		}
+		if runID != "*" && leader == runID && leaderEpoch == epoch {
+			votes++
+		}
+	}
+	return downs, votes
+}
+
+func parseMasterDownReply(resp redis.Resp) (bool, string, int64, error) {
+	a, ok := resp.(*redis.Array)
+	if !ok || len(a.Value) != 3 {
+		return false, "", 0, errors.Errorf("invalid reply %v", resp)
+	}
+
+	down, ok1 := a.Value[0].(*redis.Int)
+	leader, ok2 := a.Value[1].(*redis.BulkBytes)
+	epoch, ok3 := a.Value[2].(*redis.Int)
+	if !ok1 || !ok2 || !ok3 {
+		return false, "", 0, errors.Errorf("invalid reply %v", resp)
+	}
+	return down.Value == 1, string(leader.Value), epoch.Value, nil
+}
+
+// checks whether master is down, and tries to failover if this sentinel is elected as leader
+func (s *Sentinel) checkMaster(g *group, peers []*client) {
+	g.Lock()
+	master := g.master
+	m := g.findServer(master)
+	g.sdown = m != nil && m.isDown(s.downAfter())
+	if !g.sdown {
+		g.odown = false
+	}
+	sdown := g.sdown
+	failoverTime := g.failoverTime
+	g.Unlock()
+
+	if !sdown || time.Now().Sub(failoverTime) < s.failoverTimeout() {
+		return
+	}
+
+	downs, _ := s.askSentinels(peers, master, 0, "*")
+
+	g.Lock()
+	g.odown = downs+1 >= s.conf.Quorum
+	odown := g.odown
+	g.Unlock()
+
+	if !odown {
+		return
+	}
+
+	log.Warningf("group %s master %s is down, agreed by %d sentinels", g.name, master, downs+1)
+
+	// sentinels finding master down at the same time may vote for themselves,
+	// sleep randomly to avoid none of them being elected
+	select {
+	case <-s.quit:
+		return
+	case <-time.After(time.Duration(mrand.Intn(1000)) * time.Millisecond):
+	}
+
+	g.Lock()
+	if g.master != master || time.Now().Sub(g.failoverTime) < s.failoverTimeout() {
+		// failover started by others during sleeping
+		g.Unlock()
+		return
+	}
+	epoch := s.nextEpoch()
+	g.vote(s.runID, epoch)
+	if err := s.saveState(g); err != nil {
+		g.Unlock()
+		log.Errorf("group %s save state failed, epoch = %d - %s", g.name, epoch, err)
+		return
+	}
+	g.Unlock()
+
+	_, votes := s.askSentinels(peers, master, epoch, s.runID)
+	votes++
+
+	need := (len(peers)+1)/2 + 1
+	if need < s.conf.Quorum {
+		need = s.conf.Quorum
+	}
+
+	if votes < need {
+		log.Warningf("group %s failover leader election failed, epoch = %d, votes = %d, need = %d", g.name, epoch, votes, need)
+		return
+	}
+
+	log.Infof("group %s elected as failover leader, epoch = %d, votes = %d", g.name, epoch, votes)
+
+	if err := s.failover(g, master, epoch, peers); err != nil {
+		log.Errorf("group %s failover failed - %s", g.name, err)
+	}
+}
+
+// returns the alive slave with the greatest replication offset, must be called with group lock held
+func (s *Sentinel) selectSlave(g *group, master string) *server {
+	var slave *server
+	for _, sv := range g.servers {
+		if sv.addr == master || sv.isDown(s.downAfter()) || sv.role != "slave" {
+			continue
+		}
+		if slave == nil || sv.offset > slave.offset {
+			slave = sv
+		}
+	}
+	return slave
+}
+
+func (s *Sentinel) failover(g *group, master string, epoch int64, peers []*client) error {
+	g.Lock()
+	if epoch <= g.configEpoch || epoch < g.leaderEpoch || g.master != master {
+		// others failovered or were voted in a newer epoch during election
+		g.Unlock()
+		return errors.Errorf("failover is stale, epoch = %d, config epoch = %d, leader epoch = %d", epoch, g.configEpoch, g.leaderEpoch)
+	}
+	slave := s.selectSlave(g, master)
+	g.Unlock()
+
+	if slave == nil {
+		return errors.Errorf("no slave can be promoted")
+	}
+
+	log.Infof("group %s promote %s, offset = %d", g.name, slave.addr, slave.offset)
+
+	if _, err := slave.cli.Do("SLAVEOF", "NO", "ONE"); err != nil {
+		return errors.Trace(err)
+	}
+
+	g.Lock()
+	if epoch <= g.configEpoch {
+		// master of the newer epoch is set during promoting, slave is repointed by reconfigureGroup
+		g.Unlock()
+		return errors.Errorf("failover is stale after promoting %s, epoch = %d, config epoch = %d", slave.addr, epoch, g.configEpoch)
+	}
+	g.master = slave.addr
+	g.configEpoch = epoch
+	slave.role = "master"
+	slave.masterAddr = ""
+	if err := s.saveState(g); err != nil {
+		log.Errorf("group %s save state failed, epoch = %d - %s", g.name, epoch, err)
+	}
+	g.Unlock()
+
+	host, port, err := splitAddr(slave.addr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for _, peer := range peers {
+		if _, err := peer.Do("SENTINEL", "set-master", g.name, host, port, epoch); err != nil {
+			log.Warningf("group %s announce master to sentinel %s failed - %s", g.name, peer.addr, err)
+		}
+	}
+
+	s.reconfigureGroup(g)
+	return nil
+}
+
+// repoints alive servers not replicating current master
+func (s *Sentinel) reconfigureGroup(g *group) {
+	g.Lock()
+	master := g.master
+	var servers []*server
+	if m := g.findServer(master); m != nil && !m.isDown(s.downAfter()) && m.role == "master" {
+		for _, sv := range g.servers {
+			if sv.addr == master || sv.isDown(s.downAfter()) {
+				continue
+			}
+			if sv.role == "master" || sv.masterAddr != master {
+				servers = append(servers, sv)
+			}
+		}
+	}
+	g.Unlock()
+
+	if len(servers) == 0 {
+		return
+	}
+
+	host, port, err := splitAddr(master)
+	if err != nil {
+		log.Errorf("invalid master address %s - %s", master, err)
+		return
+	}
+
+	for _, sv := range servers {
+		log.Infof("group %s repoint %s to master %s", g.name, sv.addr, master)
+		if _, err := sv.cli.Do("SLAVEOF", host, port); err != nil {
+			log.Warningf("group %s repoint %s failed - %s", g.name, sv.addr, err)
+			continue
+		}
+
+		g.Lock()
+		sv.role = "slave"
+		sv.masterAddr = master
+		g.Unlock()
+	}
+}
+
+// sets master announced by failover leader in epoch
+func (s *Sentinel) setMaster(g *group, master string, epoch int64) bool {
+	s.updateEpoch(epoch)
+
+	g.Lock()
+	defer g.Unlock()
+
+	if epoch <= g.configEpoch {
+		return false
+	}
+
+	log.Infof("group %s switch master from %s to %s, epoch = %d", g.name, g.master, master, epoch)
+
+	g.master = master
+	g.configEpoch = epoch
+	g.failoverTime = time.Now()
+	if err := s.saveState(g); err != nil {
+		log.Errorf("group %s save state failed, epoch = %d - %s", g.name, epoch, err)
+	}
+	return true
+}
+
+func init() {
+	// seed with crypto random, sentinels started at the same time must sleep differently
+	n, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		log.Fatalf("seed random failed - %s", err)
+	}
+	mrand.Seed(n.Int64())
+}
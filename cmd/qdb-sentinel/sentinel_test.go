@@ -0,0 +1,208 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	redis "github.com/reborndb/go/redis/resp"
+	. "gopkg.in/check.v1"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&testSentinelSuite{})
+
+type testSentinelSuite struct {
+}
+
+func testCreateSentinel() *Sentinel {
+	conf := NewDefaultConfig()
+	conf.DownAfter = 1000
+	conf.StateFile = ""
+	state, _ := loadStateFile("")
+	return &Sentinel{conf: conf, groups: make(map[string]*group), state: state}
+}
+
+func (s *testSentinelSuite) TestVote(c *C) {
+	g := &group{name: "vote"}
+
+	leader, epoch := g.vote("a", 1)
+	c.Assert(leader, Equals, "a")
+	c.Assert(epoch, Equals, int64(1))
+	c.Assert(g.failoverTime.IsZero(), Equals, false)
+
+	// only the first sentinel asking in an epoch is voted
+	leader, epoch = g.vote("b", 1)
+	c.Assert(leader, Equals, "a")
+	c.Assert(epoch, Equals, int64(1))
+
+	leader, epoch = g.vote("b", 3)
+	c.Assert(leader, Equals, "b")
+	c.Assert(epoch, Equals, int64(3))
+
+	leader, epoch = g.vote("a", 2)
+	c.Assert(leader, Equals, "b")
+	c.Assert(epoch, Equals, int64(3))
+}
+
+func (s *testSentinelSuite) TestSetMaster(c *C) {
+	st := testCreateSentinel()
+	g := &group{name: "set_master", master: "m1", configEpoch: 2}
+
+	// master announced in a stale epoch is rejected
+	c.Assert(st.setMaster(g, "m2", 1), Equals, false)
+	c.Assert(st.setMaster(g, "m2", 2), Equals, false)
+	c.Assert(g.master, Equals, "m1")
+	c.Assert(g.configEpoch, Equals, int64(2))
+	c.Assert(g.failoverTime.IsZero(), Equals, true)
+
+	c.Assert(st.setMaster(g, "m2", 3), Equals, true)
+	c.Assert(g.master, Equals, "m2")
+	c.Assert(g.configEpoch, Equals, int64(3))
+	c.Assert(g.failoverTime.IsZero(), Equals, false)
+
+	// current epoch follows the greatest epoch seen
+	c.Assert(st.currentEpoch, Equals, int64(3))
+	c.Assert(st.nextEpoch(), Equals, int64(4))
+}
+
+func (s *testSentinelSuite) TestSelectSlave(c *C) {
+	st := testCreateSentinel()
+
+	now, old := time.Now(), time.Now().Add(-time.Hour)
+	g := &group{name: "select_slave", master: "m"}
+	g.servers = []*server{
+		{addr: "m", lastPong: old, role: "master"},
+		{addr: "s1", lastPong: now, role: "slave", masterAddr: "m", offset: 10},
+		{addr: "s2", lastPong: old, role: "slave", masterAddr: "m", offset: 30},
+		{addr: "s3", lastPong: now, role: "slave", masterAddr: "m", offset: 20},
+		{addr: "s4", lastPong: now, role: "master", offset: 40},
+	}
+
+	// alive slave with the greatest offset
+	c.Assert(st.selectSlave(g, "m").addr, Equals, "s3")
+
+	g.servers[3].lastPong = old
+	c.Assert(st.selectSlave(g, "m").addr, Equals, "s1")
+
+	g.servers[1].lastPong = old
+	c.Assert(st.selectSlave(g, "m"), IsNil)
+}
+
+func (s *testSentinelSuite) TestParseMasterDownReply(c *C) {
+	newReply := func(values ...redis.Resp) *redis.Array {
+		a := redis.NewArray()
+		for _, v := range values {
+			a.Append(v)
+		}
+		return a
+	}
+
+	down, leader, epoch, err := parseMasterDownReply(newReply(redis.NewInt(1), redis.NewBulkBytesWithString("a"), redis.NewInt(3)))
+	c.Assert(err, IsNil)
+	c.Assert(down, Equals, true)
+	c.Assert(leader, Equals, "a")
+	c.Assert(epoch, Equals, int64(3))
+
+	down, leader, epoch, err = parseMasterDownReply(newReply(redis.NewInt(0), redis.NewBulkBytesWithString("*"), redis.NewInt(0)))
+	c.Assert(err, IsNil)
+	c.Assert(down, Equals, false)
+	c.Assert(leader, Equals, "*")
+	c.Assert(epoch, Equals, int64(0))
+
+	for _, resp := range []redis.Resp{
+		redis.NewString("OK"),
+		newReply(redis.NewInt(1), redis.NewBulkBytesWithString("a")),
+		newReply(redis.NewInt(1), redis.NewBulkBytesWithString("a"), redis.NewInt(3), redis.NewInt(0)),
+		newReply(redis.NewBulkBytesWithString("1"), redis.NewBulkBytesWithString("a"), redis.NewInt(3)),
+		newReply(redis.NewInt(1), redis.NewString("a"), redis.NewInt(3)),
+		newReply(redis.NewInt(1), redis.NewBulkBytesWithString("a"), redis.NewBulkBytesWithString("3")),
+	} {
+		_, _, _, err := parseMasterDownReply(resp)
+		c.Assert(err, NotNil)
+	}
+}
+
+func (s *testSentinelSuite) TestFailoverStale(c *C) {
+	st := testCreateSentinel()
+
+	// slave has no client, failover must stop before promoting it
+	g := &group{name: "failover_stale", master: "m", configEpoch: 3}
+	g.servers = []*server{
+		{addr: "m", lastPong: time.Now().Add(-time.Hour), role: "master"},
+		{addr: "s", lastPong: time.Now(), role: "slave", masterAddr: "m"},
+	}
+
+	// others failovered in the epoch
+	c.Assert(st.failover(g, "m", 3, nil), NotNil)
+
+	// voted for others in a newer epoch
+	g.leader, g.leaderEpoch = "other", 5
+	c.Assert(st.failover(g, "m", 4, nil), NotNil)
+
+	// master is changed
+	g.master = "s"
+	c.Assert(st.failover(g, "m", 5, nil), NotNil)
+
+	c.Assert(g.master, Equals, "s")
+	c.Assert(g.configEpoch, Equals, int64(3))
+	c.Assert(g.servers[1].role, Equals, "slave")
+}
+
+func (s *testSentinelSuite) TestStateFile(c *C) {
+	file := path.Join(c.MkDir(), "sentinel.state")
+
+	newSentinel := func() *Sentinel {
+		st := testCreateSentinel()
+		state, err := loadStateFile(file)
+		c.Assert(err, IsNil)
+		st.state = state
+		for _, name := range []string{"g1", "g2"} {
+			st.groups[name] = &group{name: name, servers: []*server{{addr: "m1"}, {addr: "m2"}}}
+		}
+		st.restoreState()
+		return st
+	}
+
+	st := newSentinel()
+	c.Assert(st.currentEpoch, Equals, int64(0))
+
+	// vote and master are saved before replied
+	g1 := st.getGroup("g1")
+	st.updateEpoch(3)
+	g1.vote("a", 3)
+	c.Assert(st.saveState(g1), IsNil)
+	c.Assert(st.setMaster(st.getGroup("g2"), "m2", 5), Equals, true)
+
+	st = newSentinel()
+	c.Assert(st.currentEpoch, Equals, int64(5))
+	g1 = st.getGroup("g1")
+	c.Assert(g1.leader, Equals, "a")
+	c.Assert(g1.leaderEpoch, Equals, int64(3))
+	c.Assert(g1.master, Equals, "")
+	g2 := st.getGroup("g2")
+	c.Assert(g2.master, Equals, "m2")
+	c.Assert(g2.configEpoch, Equals, int64(5))
+
+	// not voting others in the same epoch after restart
+	leader, epoch := g1.vote("b", 3)
+	c.Assert(leader, Equals, "a")
+	c.Assert(epoch, Equals, int64(3))
+	c.Assert(st.setMaster(g2, "m1", 5), Equals, false)
+	c.Assert(st.nextEpoch(), Equals, int64(6))
+
+	// master not monitored anymore is forgotten
+	delete(st.groups, "g1")
+	g2.servers = g2.servers[:1]
+	st.state, _ = loadStateFile(file)
+	g2.master = ""
+	st.restoreState()
+	c.Assert(g2.master, Equals, "")
+	c.Assert(g2.configEpoch, Equals, int64(5))
+}
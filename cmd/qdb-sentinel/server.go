@@ -0,0 +1,284 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	redis "github.com/reborndb/go/redis/resp"
+)
+
+// Serve answers commands from clients and other sentinels until closed.
+func (s *Sentinel) Serve() error {
+	for {
+		nc, err := s.l.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return nil
+			default:
+			}
+			return errors.Trace(err)
+		}
+
+		go s.serveConn(nc)
+	}
+}
+
+func (s *Sentinel) serveConn(nc net.Conn) {
+	defer nc.Close()
+
+	r := bufio.NewReader(nc)
+	w := bufio.NewWriter(nc)
+
+	for {
+		request, err := redis.DecodeRequest(r)
+		if err != nil {
+			log.Debugf("connection %s closed - %s", nc.RemoteAddr(), err)
+			return
+		}
+
+		var resp redis.Resp
+		if cmd, args, err := redis.ParseArgs(request); err != nil {
+			resp = toRespError(err)
+		} else {
+			resp = s.handleRequest(cmd, args)
+		}
+
+		if err := redis.Encode(w, resp); err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func toRespError(err error) redis.Resp {
+	return redis.NewError(err)
+}
+
+func toRespErrorf(format string, args ...interface{}) redis.Resp {
+	return redis.NewError(errors.Errorf(format, args...))
+}
+
+func (s *Sentinel) handleRequest(cmd string, args [][]byte) redis.Resp {
+	switch cmd {
+	case "ping":
+		return redis.NewString("PONG")
+	case "sentinel":
+		if len(args) == 0 {
+			return toRespErrorf("len(args) = %d, expect >= 1", len(args))
+		}
+		return s.sentinelCmd(strings.ToLower(string(args[0])), args[1:])
+	default:
+		return toRespErrorf("unknown command %s", cmd)
+	}
+}
+
+func (s *Sentinel) sentinelCmd(sub string, args [][]byte) redis.Resp {
+	switch sub {
+	case "get-master-addr-by-name":
+		return s.getMasterAddrByNameCmd(args)
+	case "masters":
+		return s.mastersCmd(args)
+	case "slaves":
+		return s.slavesCmd(args)
+	case "is-master-down-by-addr":
+		return s.isMasterDownByAddrCmd(args)
+	case "set-master":
+		return s.setMasterCmd(args)
+	default:
+		return toRespErrorf("unknown sentinel subcommand %s", sub)
+	}
+}
+
+func newArrayWithStrings(ss ...string) *redis.Array {
+	resp := redis.NewArray()
+	for _, s := range ss {
+		resp.AppendBulkBytes([]byte(s))
+	}
+	return resp
+}
+
+// SENTINEL get-master-addr-by-name name
+func (s *Sentinel) getMasterAddrByNameCmd(args [][]byte) redis.Resp {
+	if len(args) != 1 {
+		return toRespErrorf("len(args) = %d, expect = 1", len(args))
+	}
+
+	g := s.getGroup(string(args[0]))
+	if g == nil {
+		return redis.NewBulkBytes(nil)
+	}
+
+	g.Lock()
+	master := g.master
+	g.Unlock()
+
+	if master == "" {
+		return redis.NewBulkBytes(nil)
+	}
+
+	host, port, err := net.SplitHostPort(master)
+	if err != nil {
+		return toRespError(errors.Trace(err))
+	}
+	return newArrayWithStrings(host, port)
+}
+
+// SENTINEL masters
+func (s *Sentinel) mastersCmd(args [][]byte) redis.Resp {
+	if len(args) != 0 {
+		return toRespErrorf("len(args) = %d, expect = 0", len(args))
+	}
+
+	resp := redis.NewArray()
+	for _, g := range s.sortedGroups() {
+		g.Lock()
+		host, port, _ := net.SplitHostPort(g.master)
+
+		flags := "master"
+		if g.sdown {
+			flags += ",s_down"
+		}
+		if g.odown {
+			flags += ",o_down"
+		}
+
+		slaves := 0
+		for _, sv := range g.servers {
+			if sv.addr != g.master {
+				slaves++
+			}
+		}
+
+		resp.Append(newArrayWithStrings(
+			"name", g.name,
+			"ip", host,
+			"port", port,
+			"flags", flags,
+			"num-slaves", strconv.Itoa(slaves),
+			"config-epoch", strconv.FormatInt(g.configEpoch, 10),
+		))
+		g.Unlock()
+	}
+	return resp
+}
+
+// SENTINEL slaves name
+func (s *Sentinel) slavesCmd(args [][]byte) redis.Resp {
+	if len(args) != 1 {
+		return toRespErrorf("len(args) = %d, expect = 1", len(args))
+	}
+
+	g := s.getGroup(string(args[0]))
+	if g == nil {
+		return toRespErrorf("no such master with that name")
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	resp := redis.NewArray()
+	for _, sv := range g.servers {
+		if sv.addr == g.master {
+			continue
+		}
+
+		host, port, _ := net.SplitHostPort(sv.addr)
+
+		flags := "slave"
+		if sv.isDown(s.downAfter()) {
+			flags += ",s_down"
+		}
+
+		resp.Append(newArrayWithStrings(
+			"name", sv.addr,
+			"ip", host,
+			"port", port,
+			"flags", flags,
+			"master-addr", sv.masterAddr,
+			"slave-repl-offset", strconv.FormatInt(sv.offset, 10),
+		))
+	}
+	return resp
+}
+
+// SENTINEL is-master-down-by-addr ip port epoch runid
+//
+// replies whether the master is down from sight of this sentinel, and votes runid
+// as failover leader in epoch if runid is not '*'
+func (s *Sentinel) isMasterDownByAddrCmd(args [][]byte) redis.Resp {
+	if len(args) != 4 {
+		return toRespErrorf("len(args) = %d, expect = 4", len(args))
+	}
+
+	master := net.JoinHostPort(string(args[0]), string(args[1]))
+	epoch, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return toRespError(errors.Trace(err))
+	}
+	runID := string(args[3])
+
+	down, leader, leaderEpoch := false, "*", int64(0)
+	for _, g := range s.sortedGroups() {
+		g.Lock()
+		if g.master == master {
+			if m := g.findServer(master); m != nil {
+				down = m.isDown(s.downAfter())
+			}
+			if runID != "*" {
+				s.updateEpoch(epoch)
+				leader, leaderEpoch = g.vote(runID, epoch)
+				// vote can't be replied until saved
+				if err := s.saveState(g); err != nil {
+					g.Unlock()
+					return toRespError(errors.Trace(err))
+				}
+			}
+		}
+		g.Unlock()
+	}
+
+	resp := redis.NewArray()
+	if down {
+		resp.AppendInt(1)
+	} else {
+		resp.AppendInt(0)
+	}
+	resp.AppendBulkBytes([]byte(leader))
+	resp.AppendInt(leaderEpoch)
+	return resp
+}
+
+// SENTINEL set-master name ip port epoch
+//
+// announced by failover leader after promoting the new master
+func (s *Sentinel) setMasterCmd(args [][]byte) redis.Resp {
+	if len(args) != 4 {
+		return toRespErrorf("len(args) = %d, expect = 4", len(args))
+	}
+
+	g := s.getGroup(string(args[0]))
+	if g == nil {
+		return toRespErrorf("no such master with that name")
+	}
+
+	master := net.JoinHostPort(string(args[1]), string(args[2]))
+	epoch, err := strconv.ParseInt(string(args[3]), 10, 64)
+	if err != nil {
+		return toRespError(errors.Trace(err))
+	}
+
+	if s.setMaster(g, master, epoch) {
+		return redis.NewString("OK")
+	}
+	return toRespErrorf("epoch %d is stale", epoch)
+}
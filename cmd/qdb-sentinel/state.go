@@ -0,0 +1,111 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// epochs and votes saved in state file, a restarted sentinel must not vote twice
+// in an epoch or go back to an older master
+type sentinelState struct {
+	CurrentEpoch int64                  `json:"current_epoch"`
+	Groups       map[string]*groupState `json:"groups"`
+}
+
+type groupState struct {
+	Master      string `json:"master"`
+	ConfigEpoch int64  `json:"config_epoch"`
+	Leader      string `json:"leader"`
+	LeaderEpoch int64  `json:"leader_epoch"`
+}
+
+type stateFile struct {
+	sync.Mutex
+
+	path  string
+	state sentinelState
+}
+
+func loadStateFile(path string) (*stateFile, error) {
+	f := &stateFile{path: path}
+	f.state.Groups = make(map[string]*groupState)
+
+	if len(path) == 0 {
+		return f, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := json.Unmarshal(b, &f.state); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if f.state.Groups == nil {
+		f.state.Groups = make(map[string]*groupState)
+	}
+	return f, nil
+}
+
+// saves state of the group, nothing is saved if path is empty
+func (f *stateFile) save(epoch int64, name string, gs *groupState) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if epoch > f.state.CurrentEpoch {
+		f.state.CurrentEpoch = epoch
+	}
+	f.state.Groups[name] = gs
+
+	if len(f.path) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(&f.state)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	tmp := f.path + ".tmp"
+	w, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return errors.Trace(err)
+	}
+	// vote must be on disk before it is replied
+	if err := w.Sync(); err != nil {
+		w.Close()
+		return errors.Trace(err)
+	}
+	if err := w.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmp, f.path))
+}
+
+// saves epochs, master and vote of the group, must be called with group lock held
+func (s *Sentinel) saveState(g *group) error {
+	s.mu.Lock()
+	epoch := s.currentEpoch
+	s.mu.Unlock()
+
+	return s.state.save(epoch, g.name, &groupState{
+		Master:      g.master,
+		ConfigEpoch: g.configEpoch,
+		Leader:      g.leader,
+		LeaderEpoch: g.leaderEpoch,
+	})
+}
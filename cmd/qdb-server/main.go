@@ -30,6 +30,7 @@ var args struct {
 	config string
 	create bool
 	repair bool
+	backup string
 }
 
 func init() {
@@ -85,6 +86,7 @@ Options:
     -n N, --ncpu=N                    set runtime.GOMAXPROCS to N
     -c CONF, --config=CONF            specify the config file
     --repair                          repair database
+    --from_backup=DIR                 restore database from backup in DIR before starting, dbpath must be empty
     --dbtype=TYPE                     dtabase type, like rocksdb, leveldb, goleveldb	
     --dbpath=PATH                     database store path						
     --addr=ADDR                       service listening address	
@@ -92,12 +94,18 @@ Options:
     --pidfile=FILE                    service pid file 
     --conn_timeout=N                  connection timeout after N seconds
     --dump_path=PATH                  path saving snapshot rdb file
+    --bgsave_workers=N                number of workers reading snapshot for SAVE and BGSAVE
     --sync_file_path=PATH             path saving replication syncing data
     --sync_file_size=SIZE             maximum file(bytes) size for replication syncing 
     --sync_buff_size=SIZE             maximum memory buffer size(bytes) for replication syncing
+    --sync_checkpoint_path=PATH       path saving checkpoint for full resync between qdb of the same engine
+    --sync_rdb_workers=N              number of workers restoring rdb of full resync
+    --sync_rdb_batch_size=N           number of rdb entries restored by one commit
     --repl_backlog_file_path=PATH     path saving replication backlog data, if empty, use memory instead
     --repl_backlog_size=SIZE          maximum backlog size(bytes)
     --repl_ping_slave_period=N        Master pings slave in an interval(seconds) when replication
+    --min_slaves_to_write=N           refuse writes if less than N slaves acked in max lag, 0 means to disable it
+    --min_slaves_max_lag=N            maximum lag(seconds) of slaves counted by min_slaves_to_write
     --master_auth=MASTERAUTH          Master auth for replication
 `
 	d, err := docopt.Parse(usage, nil, true, "", false)
@@ -125,6 +133,7 @@ Options:
 
 	args.config, _ = d["--config"].(string)
 	args.repair, _ = d["--repair"].(bool)
+	args.backup, _ = d["--from_backup"].(string)
 
 	conf := &Config{
 		DBType:    "goleveldb",
@@ -149,12 +158,18 @@ Options:
 	setStringFromOpt(&conf.Service.Auth, d, "--auth")
 	setIntFromOpt(&conf.Service.ConnTimeout, d, "--conn_timeout")
 	setStringFromOpt(&conf.Service.DumpPath, d, "--dump_path")
+	setIntFromOpt(&conf.Service.BgsaveWorkers, d, "--bgsave_workers")
 	setStringFromOpt(&conf.Service.SyncFilePath, d, "--sync_file_path")
 	setIntFromOpt(&conf.Service.SyncFileSize, d, "--sync_file_size")
 	setIntFromOpt(&conf.Service.SyncBuffSize, d, "--sync_buff_size")
+	setStringFromOpt(&conf.Service.SyncCheckpointPath, d, "--sync_checkpoint_path")
+	setIntFromOpt(&conf.Service.SyncRDBWorkers, d, "--sync_rdb_workers")
+	setIntFromOpt(&conf.Service.SyncRDBBatchSize, d, "--sync_rdb_batch_size")
 	setStringFromOpt(&conf.Service.ReplBacklogFilePath, d, "--repl_backlog_file_path")
 	setIntFromOpt(&conf.Service.ReplBacklogSize, d, "--repl_backlog_size")
 	setIntFromOpt(&conf.Service.ReplPingSlavePeriod, d, "--repl_ping_slave_period")
+	setIntFromOpt(&conf.Service.MinSlavesToWrite, d, "--min_slaves_to_write")
+	setIntFromOpt(&conf.Service.MinSlavesMaxLag, d, "--min_slaves_max_lag")
 	setStringFromOpt(&conf.Service.MasterAuth, d, "--master_auth")
 
 	log.Infof("load config\n%s\n\n", conf)
@@ -172,6 +187,15 @@ Options:
 		dbConf = conf.GoLevelDB
 	}
 
+	var backup *service.BackupManifest
+	if args.backup != "" {
+		backup, err = service.RestoreBackup(args.backup, conf.DBPath)
+		if err != nil {
+			log.Fatalf("restore backup failed - %s", err)
+		}
+		log.Infof("restore backup %s, run id = %s, offset = %d", args.backup, backup.RunID, backup.Offset)
+	}
+
 	db, err = engine.Open(conf.DBType, conf.DBPath, dbConf, args.repair)
 
 	if err != nil {
@@ -180,6 +204,13 @@ Options:
 
 	dbStore := store.New(db)
 
+	if backup != nil {
+		// partial resync from the server backed up, see SLAVEOF
+		if err := dbStore.SaveReplState(backup.ReplState()); err != nil {
+			log.Fatalf("save replication state of backup failed - %s", err)
+		}
+	}
+
 	if args.repair {
 		return
 	}
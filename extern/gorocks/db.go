@@ -319,6 +319,50 @@ func (db *DB) CompactRange(r Range) {
 		db.Ldb, start, C.size_t(len(r.Start)), limit, C.size_t(len(r.Limit)))
 }
 
+// Flush writes memtables of the database into table files, it blocks until
+// the flush is done if wait is true.
+func (db *DB) Flush(wait bool) error {
+	fo := C.rocksdb_flushoptions_create()
+	defer C.rocksdb_flushoptions_destroy(fo)
+	C.rocksdb_flushoptions_set_wait(fo, boolToUchar(wait))
+
+	var errStr *C.char
+	C.rocksdb_flush(db.Ldb, fo, &errStr)
+	if errStr != nil {
+		gs := C.GoString(errStr)
+		C.free(unsafe.Pointer(errStr))
+		return DatabaseError(gs)
+	}
+	return nil
+}
+
+// DisableFileDeletions prevents obsolete files from being deleted, so live
+// files can be copied or linked safely.
+func (db *DB) DisableFileDeletions() error {
+	var errStr *C.char
+	C.rocksdb_disable_file_deletions(db.Ldb, &errStr)
+	if errStr != nil {
+		gs := C.GoString(errStr)
+		C.free(unsafe.Pointer(errStr))
+		return DatabaseError(gs)
+	}
+	return nil
+}
+
+// EnableFileDeletions allows obsolete files to be deleted again. Calls of
+// DisableFileDeletions are counted, file deletions are enabled after the
+// same number of calls unless force is true.
+func (db *DB) EnableFileDeletions(force bool) error {
+	var errStr *C.char
+	C.rocksdb_enable_file_deletions(db.Ldb, boolToUchar(force), &errStr)
+	if errStr != nil {
+		gs := C.GoString(errStr)
+		C.free(unsafe.Pointer(errStr))
+		return DatabaseError(gs)
+	}
+	return nil
+}
+
 // Close closes the database, rendering it unusable for I/O, by deallocating
 // the underlying handle.
 //
@@ -0,0 +1,148 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package engine
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Checkpoint pins the state of a database when it is created,
+// the state can be saved as database files later.
+type Checkpoint interface {
+	// Save writes the database files into dir, dir must not exist.
+	Save(dir string) error
+	Close()
+}
+
+const copyBatchSize = 4 * 1024 * 1024
+
+// CopySnapshot writes all keys of the snapshot into db by batches.
+func CopySnapshot(db Database, sp Snapshot) error {
+	it := sp.NewIterator()
+	defer it.Close()
+
+	bt := NewBatch()
+	size := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		key, value := it.Key(), it.Value()
+		bt.Set(key, value)
+		if size += len(key) + len(value); size >= copyBatchSize {
+			if err := db.Commit(bt); err != nil {
+				return errors.Trace(err)
+			}
+			bt.Reset()
+			size = 0
+		}
+	}
+	if err := it.Error(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(db.Commit(bt))
+}
+
+// CheckDirNotExists returns an error if dir exists.
+func CheckDirNotExists(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return errors.Errorf("%s already exists", dir)
+	} else if !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// table files are never modified after written
+func isTableFile(name string) bool {
+	return strings.HasSuffix(name, ".sst") || strings.HasSuffix(name, ".ldb")
+}
+
+// CheckpointFiles returns names of database files saved by a checkpoint in dir,
+// lock and info log files are skipped.
+func CheckpointFiles(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var names []string
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || name == "LOCK" || strings.HasPrefix(name, "LOG") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// CopyCheckpoint copies database files saved by a checkpoint from src into dst,
+// table files are hard linked if possible.
+func CopyCheckpoint(src, dst string) error {
+	names, err := CheckpointFiles(src)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return errors.Trace(err)
+	}
+	for _, name := range names {
+		if isTableFile(name) {
+			err = LinkFile(filepath.Join(src, name), filepath.Join(dst, name))
+		} else {
+			err = CopyFile(filepath.Join(src, name), filepath.Join(dst, name))
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// ReplaceDir removes files of path and moves database files in dir to path.
+func ReplaceDir(dir, path string) error {
+	if err := os.RemoveAll(path); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.Rename(dir, path); err == nil {
+		return nil
+	}
+	if err := CopyCheckpoint(dir, path); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.RemoveAll(dir))
+}
+
+// LinkFile creates a hard link of src, or copies it if links are not supported.
+func LinkFile(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return CopyFile(src, dst)
+}
+
+func CopyFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer w.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return errors.Trace(err)
+	}
+	if err := w.Sync(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(w.Close())
+}
@@ -4,6 +4,7 @@
 package engine
 
 type Database interface {
+	Name() string
 	Close()
 	Clear() error
 	NewIterator() Iterator
@@ -12,4 +13,8 @@ type Database interface {
 	Compact(start, limit []byte) error
 	Get(key []byte) ([]byte, error)
 	Stats() string
+	NewCheckpoint() (Checkpoint, error)
+	// Restore replaces the database by files saved by a checkpoint in dir,
+	// dir is moved or removed after restoring.
+	Restore(dir string) error
 }
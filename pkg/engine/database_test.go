@@ -5,6 +5,7 @@ package engine_test
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"testing"
 
@@ -143,13 +144,78 @@ func (s *testEngineSuite) testSnapshot(c *C, db Database) {
 	c.Assert(value, DeepEquals, []byte("value"))
 }
 
+func (s *testEngineSuite) testCheckpoint(c *C, db Database, name string, conf interface{}) {
+	err := db.Clear()
+	c.Assert(err, IsNil)
+
+	batch := NewBatch()
+	for i := 0; i < 10; i++ {
+		batch.Set([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i)))
+	}
+	err = db.Commit(batch)
+	c.Assert(err, IsNil)
+
+	cp, err := db.NewCheckpoint()
+	c.Assert(err, IsNil)
+	defer cp.Close()
+
+	batch.Reset()
+	batch.Set([]byte("key_new"), []byte("value_new"))
+	err = db.Commit(batch)
+	c.Assert(err, IsNil)
+
+	dir := fmt.Sprintf("/tmp/test_qdb/engine/%s_checkpoint", name)
+	err = os.RemoveAll(dir)
+	c.Assert(err, IsNil)
+	err = cp.Save(dir)
+	c.Assert(err, IsNil)
+	c.Assert(cp.Save(dir), NotNil)
+
+	// restored database is independent of the checkpoint
+	path := fmt.Sprintf("/tmp/test_qdb/engine/%s_restore", name)
+	err = os.RemoveAll(path)
+	c.Assert(err, IsNil)
+	err = CopyCheckpoint(dir, path)
+	c.Assert(err, IsNil)
+
+	restore, err := Open(name, path, conf, false)
+	c.Assert(err, IsNil)
+	defer restore.Close()
+
+	for i := 0; i < 10; i++ {
+		value, err := restore.Get([]byte(fmt.Sprintf("key_%d", i)))
+		c.Assert(err, IsNil)
+		c.Assert(string(value), Equals, fmt.Sprintf("value_%d", i))
+	}
+	value, err := restore.Get([]byte("key_new"))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	// replace the database by the checkpoint
+	err = db.Restore(dir)
+	c.Assert(err, IsNil)
+	_, err = os.Stat(dir)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	for i := 0; i < 10; i++ {
+		value, err := db.Get([]byte(fmt.Sprintf("key_%d", i)))
+		c.Assert(err, IsNil)
+		c.Assert(string(value), Equals, fmt.Sprintf("value_%d", i))
+	}
+	value, err = db.Get([]byte("key_new"))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+}
+
 func (s *testEngineSuite) test(c *C, name string, conf interface{}) {
 	db := s.testOpen(c, name, conf)
 	defer db.Close()
+	c.Assert(db.Name(), Equals, name)
 
 	s.testSimple(c, db)
 	s.testIterator(c, db)
 	s.testSnapshot(c, db)
+	s.testCheckpoint(c, db, name, conf)
 }
 
 func (s *testEngineSuite) TestRocksDB(c *C) {
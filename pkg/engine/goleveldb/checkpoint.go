@@ -0,0 +1,36 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package goleveldb
+
+import (
+	"github.com/juju/errors"
+	"github.com/reborndb/qdb/pkg/engine"
+)
+
+// goleveldb can't stop deleting obsolete table files, so the checkpoint
+// copies a snapshot into a new database instead of linking files.
+type checkpoint struct {
+	conf *Config
+	sp   *Snapshot
+}
+
+func (db *GoLevelDB) NewCheckpoint() (engine.Checkpoint, error) {
+	return &checkpoint{conf: db.conf, sp: newSnapshot(db)}, nil
+}
+
+func (cp *checkpoint) Save(dir string) error {
+	if err := engine.CheckDirNotExists(dir); err != nil {
+		return errors.Trace(err)
+	}
+	db, err := Open(dir, cp.conf, false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer db.Close()
+	return errors.Trace(engine.CopySnapshot(db, cp.sp))
+}
+
+func (cp *checkpoint) Close() {
+	cp.sp.Close()
+}
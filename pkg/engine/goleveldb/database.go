@@ -19,6 +19,7 @@ import (
 
 type GoLevelDB struct {
 	path string
+	conf *Config
 	lvdb *leveldb.DB
 	opts *opt.Options
 	ropt *opt.ReadOptions
@@ -61,6 +62,7 @@ func (db *GoLevelDB) init(path string, conf *Config, repair bool) error {
 	opts.WriteL0PauseTrigger = 64
 
 	db.path = path
+	db.conf = conf
 	db.opts = opts
 	db.ropt = nil
 	db.wopt = nil
@@ -81,6 +83,10 @@ func (db *GoLevelDB) init(path string, conf *Config, repair bool) error {
 	return nil
 }
 
+func (db *GoLevelDB) Name() string {
+	return "goleveldb"
+}
+
 func (db *GoLevelDB) Clear() error {
 	if db.lvdb != nil {
 		db.lvdb.Close()
@@ -97,6 +103,24 @@ func (db *GoLevelDB) Clear() error {
 	return nil
 }
 
+func (db *GoLevelDB) Restore(dir string) error {
+	if db.lvdb != nil {
+		db.lvdb.Close()
+		db.lvdb = nil
+	}
+	if err := engine.ReplaceDir(dir, db.path); err != nil {
+		return errors.Trace(err)
+	}
+	db.opts.ErrorIfMissing = true
+	db.opts.ErrorIfExist = false
+
+	var err error
+	if db.lvdb, err = leveldb.OpenFile(db.path, db.opts); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
 func (db *GoLevelDB) Close() {
 	if db.lvdb != nil {
 		db.lvdb.Close()
@@ -17,6 +17,7 @@ import (
 
 type LevelDB struct {
 	path  string
+	conf  *Config
 	lvdb  *levigo.DB
 	opts  *levigo.Options
 	ropt  *levigo.ReadOptions
@@ -60,6 +61,7 @@ func (db *LevelDB) init(path string, conf *Config, repair bool) error {
 	opts.SetFilterPolicy(bloom)
 
 	db.path = path
+	db.conf = conf
 	db.opts = opts
 	db.ropt = levigo.NewReadOptions()
 	db.wopt = levigo.NewWriteOptions()
@@ -79,6 +81,10 @@ func (db *LevelDB) init(path string, conf *Config, repair bool) error {
 	return nil
 }
 
+func (db *LevelDB) Name() string {
+	return "leveldb"
+}
+
 func (db *LevelDB) Clear() error {
 	if db.lvdb != nil {
 		db.lvdb.Close()
@@ -94,6 +100,24 @@ func (db *LevelDB) Clear() error {
 	return nil
 }
 
+func (db *LevelDB) Restore(dir string) error {
+	if db.lvdb != nil {
+		db.lvdb.Close()
+		db.lvdb = nil
+	}
+	if err := engine.ReplaceDir(dir, db.path); err != nil {
+		return errors.Trace(err)
+	}
+	db.opts.SetCreateIfMissing(false)
+	db.opts.SetErrorIfExists(false)
+
+	var err error
+	if db.lvdb, err = levigo.Open(db.path, db.opts); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
 func (db *LevelDB) Close() {
 	if db.lvdb != nil {
 		db.lvdb.Close()
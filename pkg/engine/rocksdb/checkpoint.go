@@ -0,0 +1,91 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+// +build all rocksdb
+
+package rocksdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/qdb/pkg/engine"
+)
+
+// Memtables are flushed and file deletions are disabled when the checkpoint is
+// created, then table files are hard linked with a copy of the manifest.
+// Log files are not needed, all data of the checkpoint are in table files.
+type checkpoint struct {
+	db *RocksDB
+
+	current  []byte
+	manifest []byte
+	tables   []string
+}
+
+func (db *RocksDB) NewCheckpoint() (engine.Checkpoint, error) {
+	if err := db.rkdb.Flush(true); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := db.rkdb.DisableFileDeletions(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	cp := &checkpoint{db: db}
+	if err := cp.pin(); err != nil {
+		cp.Close()
+		return nil, errors.Trace(err)
+	}
+	return cp, nil
+}
+
+func (cp *checkpoint) pin() error {
+	var err error
+	if cp.current, err = ioutil.ReadFile(filepath.Join(cp.db.path, "CURRENT")); err != nil {
+		return errors.Trace(err)
+	}
+	name := strings.TrimSpace(string(cp.current))
+	if cp.manifest, err = ioutil.ReadFile(filepath.Join(cp.db.path, name)); err != nil {
+		return errors.Trace(err)
+	}
+
+	// tables not in the manifest are ignored and deleted by rocksdb
+	files, err := ioutil.ReadDir(cp.db.path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".sst") {
+			cp.tables = append(cp.tables, f.Name())
+		}
+	}
+	return nil
+}
+
+func (cp *checkpoint) Save(dir string) error {
+	if err := engine.CheckDirNotExists(dir); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Trace(err)
+	}
+	for _, name := range cp.tables {
+		if err := engine.LinkFile(filepath.Join(cp.db.path, name), filepath.Join(dir, name)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	name := strings.TrimSpace(string(cp.current))
+	if err := ioutil.WriteFile(filepath.Join(dir, name), cp.manifest, 0600); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(filepath.Join(dir, "CURRENT"), cp.current, 0600))
+}
+
+func (cp *checkpoint) Close() {
+	if err := cp.db.rkdb.EnableFileDeletions(false); err != nil {
+		log.Warningf("enable rocksdb file deletions failed - %s", err)
+	}
+}
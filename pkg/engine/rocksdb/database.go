@@ -17,6 +17,7 @@ import (
 
 type RocksDB struct {
 	path string
+	conf *Config
 	rkdb *gorocks.DB
 	opts *gorocks.Options
 	ropt *gorocks.ReadOptions
@@ -90,6 +91,7 @@ func (db *RocksDB) init(path string, conf *Config, repair bool) error {
 	opts.SetEnv(env)
 
 	db.path = path
+	db.conf = conf
 	db.opts = opts
 	db.ropt = gorocks.NewReadOptions()
 	db.wopt = gorocks.NewWriteOptions()
@@ -111,6 +113,10 @@ func (db *RocksDB) init(path string, conf *Config, repair bool) error {
 	return nil
 }
 
+func (db *RocksDB) Name() string {
+	return "rocksdb"
+}
+
 func (db *RocksDB) Clear() error {
 	if db.rkdb != nil {
 		db.rkdb.Close()
@@ -126,6 +132,24 @@ func (db *RocksDB) Clear() error {
 	return nil
 }
 
+func (db *RocksDB) Restore(dir string) error {
+	if db.rkdb != nil {
+		db.rkdb.Close()
+		db.rkdb = nil
+	}
+	if err := engine.ReplaceDir(dir, db.path); err != nil {
+		return errors.Trace(err)
+	}
+	db.opts.SetCreateIfMissing(false)
+	db.opts.SetErrorIfExists(false)
+
+	var err error
+	if db.rkdb, err = gorocks.Open(db.path, db.opts); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
 func (db *RocksDB) Close() {
 	if db.rkdb != nil {
 		db.rkdb.Close()
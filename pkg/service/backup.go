@@ -0,0 +1,152 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	redis "github.com/reborndb/go/redis/resp"
+	"github.com/reborndb/qdb/pkg/engine"
+	"github.com/reborndb/qdb/pkg/store"
+)
+
+const (
+	backupDBDir        = "db"
+	backupManifestFile = "MANIFEST.json"
+)
+
+// BackupManifest describes the replication state of a backup, data of the
+// backup are the replication stream of RunID applied up to Offset, with DB
+// selected there. Filter is the filter of the stream if the backup is from a
+// filtered slave.
+type BackupManifest struct {
+	RunID  string `json:"run_id"`
+	Offset int64  `json:"offset"`
+	DB     uint32 `json:"db"`
+	Filter string `json:"filter,omitempty"`
+	Time   int64  `json:"time"`
+}
+
+// ReplState returns the replication state restored with the backup, so the
+// restored server can partial resync from the server it was backed up from.
+func (m *BackupManifest) ReplState() *store.ReplState {
+	return &store.ReplState{RunID: m.RunID, Offset: m.Offset, DB: m.DB, Filter: m.Filter}
+}
+
+// BACKUP dir
+func BackupCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) != 1 {
+		return toRespErrorf("len(args) = %d, expect = 1", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	// backup can't run with others saving the store
+	if ok := c.h.bgSaveSem.AcquireTimeout(time.Second); !ok {
+		return toRespErrorf("wait others do bgsave timeout")
+	}
+	defer c.h.bgSaveSem.Release()
+
+	if err := c.h.backupTo(string(args[0])); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewString("OK"), nil
+	}
+}
+
+func (h *Handler) backupTo(dir string) error {
+	if err := engine.CheckDirNotExists(dir); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Trace(err)
+	}
+
+	m := &BackupManifest{}
+	err := h.store.Checkpoint(filepath.Join(dir, backupDBDir), func() {
+		st := h.replicationState()
+		m.RunID, m.Offset, m.DB, m.Filter = st.RunID, st.Offset, st.DB, st.Filter
+	})
+	if err == nil {
+		m.Time = time.Now().Unix()
+		err = writeBackupManifest(dir, m)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return errors.Trace(err)
+	}
+
+	log.Infof("backup to %s, run id = %s, offset = %d", dir, m.RunID, m.Offset)
+	return nil
+}
+
+// replication state of the stream applied to the store, must be called with the store locked
+func (h *Handler) replicationState() *store.ReplState {
+	if h.masterAddr.Get() != "" {
+		return &store.ReplState{
+			RunID:  h.masterRunID.Get(),
+			Offset: h.syncOffset.Get(),
+			DB:     uint32(h.syncDB.Get()),
+			Filter: h.replFilter.String(),
+		}
+	}
+
+	// same as full sync, master selects db again before the next write
+	var st replSyncState
+	h.replicationPinSyncState(&st)()
+	return &store.ReplState{RunID: st.runID, Offset: st.offset - 1, DB: st.db}
+}
+
+func writeBackupManifest(dir string, m *BackupManifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tmp := filepath.Join(dir, backupManifestFile+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmp, filepath.Join(dir, backupManifestFile)))
+}
+
+func LoadBackupManifest(dir string) (*BackupManifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	m := &BackupManifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return m, nil
+}
+
+// RestoreBackup copies database files of the backup in dir into path,
+// path must not exist or be empty.
+func RestoreBackup(dir string, path string) (*BackupManifest, error) {
+	m, err := LoadBackupManifest(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if files, err := ioutil.ReadDir(path); err == nil && len(files) != 0 {
+		return nil, errors.Errorf("restore backup into non-empty path %s", path)
+	}
+	if err := engine.CopyCheckpoint(filepath.Join(dir, backupDBDir), path); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return m, nil
+}
+
+func init() {
+	Register("backup", BackupCmd, CmdReadonly|CmdNoMulti)
+}
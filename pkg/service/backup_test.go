@@ -0,0 +1,68 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/reborndb/qdb/pkg/store"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testServiceSuite) TestBackup(c *C) {
+	k := randomKey(c)
+	s.checkOK(c, "flushall")
+	const max = 100
+	for i := 0; i < max; i++ {
+		s.checkOK(c, "set", k+strconv.Itoa(i), i)
+	}
+
+	dir := "/tmp/test_qdb/test_service/backup"
+	err := os.RemoveAll(dir)
+	c.Assert(err, IsNil)
+	s.checkOK(c, "backup", dir)
+	s.checkContainError(c, "already exists", "backup", dir)
+
+	// backup can't run with others saving the store
+	os.RemoveAll(dir + "_busy")
+	s.s.h.bgSaveSem.Acquire()
+	s.checkContainError(c, "timeout", "backup", dir+"_busy")
+	s.s.h.bgSaveSem.Release()
+
+	nc := s.getConn(c)
+	defer nc.Recycle()
+	nc.checkOK(c, "multi")
+	nc.checkContainError(c, "not allowed", "backup", dir+"_busy")
+	nc.checkContainError(c, "EXECABORT", "exec")
+
+	// written after backup
+	s.checkOK(c, "set", k, "new")
+
+	path := "/tmp/test_qdb/test_service/backup_restore"
+	err = os.RemoveAll(path)
+	c.Assert(err, IsNil)
+	m, err := RestoreBackup(dir, path)
+	c.Assert(err, IsNil)
+	c.Assert(m.RunID, Equals, string(s.s.h.runID))
+	c.Assert(m.Offset, Equals, s.s.h.replicationSyncState().offset-1)
+	c.Assert(m.DB, Equals, uint32(0))
+
+	_, err = RestoreBackup(dir, path)
+	c.Assert(err, NotNil)
+
+	x := store.New(testOpenDB(c, path))
+	defer x.Close()
+
+	for i := 0; i < max; i++ {
+		v, err := x.Get(0, [][]byte{[]byte(k + strconv.Itoa(i))})
+		c.Assert(err, IsNil)
+		c.Assert(string(v), Equals, strconv.Itoa(i))
+	}
+	v, err := x.Get(0, [][]byte{[]byte(k)})
+	c.Assert(err, IsNil)
+	c.Assert(v, IsNil)
+
+	s.checkOK(c, "flushall")
+}
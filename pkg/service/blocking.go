@@ -0,0 +1,301 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+	redis "github.com/reborndb/go/redis/resp"
+	"github.com/reborndb/qdb/pkg/store"
+)
+
+type blockKey struct {
+	db  uint32
+	key string
+}
+
+// client blocked by BLPOP, BRPOP or BRPOPLPUSH
+type blockWaiter struct {
+	db   uint32
+	keys [][]byte
+
+	// signaled when one of the keys may have been pushed
+	wake chan struct{}
+}
+
+// pop from key, returns nil if key is empty
+type blockPopFunc func(s *store.Store, db uint32, key []byte) (redis.Resp, error)
+
+func (h *Handler) addBlockWaiter(w *blockWaiter) {
+	h.blocking.Lock()
+	defer h.blocking.Unlock()
+
+	for _, key := range w.keys {
+		k := blockKey{w.db, string(key)}
+		h.blocking.waiters[k] = append(h.blocking.waiters[k], w)
+	}
+}
+
+func (h *Handler) removeBlockWaiter(w *blockWaiter) {
+	h.blocking.Lock()
+	defer h.blocking.Unlock()
+
+	for _, key := range w.keys {
+		k := blockKey{w.db, string(key)}
+		ws := h.blocking.waiters[k]
+		for i := range ws {
+			if ws[i] == w {
+				ws = append(ws[:i], ws[i+1:]...)
+				break
+			}
+		}
+		if len(ws) == 0 {
+			delete(h.blocking.waiters, k)
+		} else {
+			h.blocking.waiters[k] = ws
+		}
+	}
+}
+
+// wake the first waiter of key, waiters are served in FIFO order
+func (h *Handler) wakeBlockWaiter(db uint32, key []byte) {
+	h.blocking.Lock()
+	defer h.blocking.Unlock()
+
+	if ws := h.blocking.waiters[blockKey{db, string(key)}]; len(ws) != 0 {
+		select {
+		case ws[0].wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// post commit handler, wakes waiters once a list may have been pushed
+func (h *Handler) signalBlockedKeys(f *store.Forward) error {
+	switch f.Op {
+	case "LPush", "RPush", "Restore":
+		if len(f.Args) != 0 {
+			h.wakeBlockWaiter(f.DB, f.Args[0])
+		}
+	case "RPopLPush", "LMove":
+		if len(f.Args) > 1 {
+			h.wakeBlockWaiter(f.DB, f.Args[1])
+		}
+	case "SlotsRestore":
+		// key ttlms value [key ttlms value ...]
+		for i := 0; i+2 < len(f.Args); i += 3 {
+			h.wakeBlockWaiter(f.DB, f.Args[i])
+		}
+	}
+	return nil
+}
+
+// leave the waiting queues, pass the wake up to next waiters if needed
+func (h *Handler) finishBlockWaiter(w *blockWaiter, popped bool) {
+	h.removeBlockWaiter(w)
+
+	select {
+	case <-w.wake:
+		popped = true
+	default:
+	}
+
+	// other waiters may be waiting for the rest elements
+	if popped {
+		for _, key := range w.keys {
+			h.wakeBlockWaiter(w.db, key)
+		}
+	}
+}
+
+// watch client while blocking, the returned channel is closed if client disconnects
+// stop must be called before reading next request
+func (c *conn) watchDisconnect() (disconnected <-chan struct{}, stop func()) {
+	closed := make(chan struct{})
+	done := make(chan struct{})
+
+	c.nc.SetReadDeadline(time.Time{})
+	go func() {
+		defer close(done)
+		if _, err := c.r.Peek(1); err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				return
+			}
+			close(closed)
+		}
+	}()
+
+	stop = func() {
+		// interrupt peek
+		c.nc.SetReadDeadline(time.Now())
+		<-done
+		c.nc.SetReadDeadline(time.Time{})
+	}
+	return closed, stop
+}
+
+func parseBlockTimeout(arg []byte) (time.Duration, error) {
+	v, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, errors.Errorf("timeout is not a float or out of range")
+	}
+	if v < 0 {
+		return 0, errors.Errorf("timeout is negative")
+	}
+	return time.Duration(v * float64(time.Second)), nil
+}
+
+func tryBlockPop(s *store.Store, db uint32, keys [][]byte, pop blockPopFunc) (redis.Resp, []byte, error) {
+	for _, key := range keys {
+		resp, err := pop(s, db, key)
+		if err != nil || resp != nil {
+			return resp, key, errors.Trace(err)
+		}
+	}
+	return nil, nil, nil
+}
+
+// pop from the first non empty key, or block until timeout, zero timeout blocks forever
+// Returns nil if timeout.
+func blockPop(s Session, keys [][]byte, timeout time.Duration, pop blockPopFunc) (redis.Resp, error) {
+	resp, _, err := tryBlockPop(s.Store(), s.DB(), keys, pop)
+	if err != nil || resp != nil {
+		return resp, errors.Trace(err)
+	}
+
+	c, _ := s.(*conn)
+	if c == nil || c.tx != nil {
+		// never block in transaction or script
+		return nil, nil
+	}
+
+	h := c.h
+	w := &blockWaiter{db: c.db, keys: keys, wake: make(chan struct{}, 1)}
+
+	// register before trying again, so no push after that can be missed
+	h.addBlockWaiter(w)
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+
+	var disconnected <-chan struct{}
+	for {
+		// every pop locks its key only, and is forwarded as a plain pop
+		resp, _, err := tryBlockPop(c.Store(), w.db, keys, pop)
+		if err != nil || resp != nil {
+			h.finishBlockWaiter(w, resp != nil)
+			return resp, errors.Trace(err)
+		}
+
+		if disconnected == nil {
+			var stop func()
+			disconnected, stop = c.watchDisconnect()
+			defer stop()
+		}
+
+		select {
+		case <-w.wake:
+		case <-deadline:
+			h.finishBlockWaiter(w, false)
+			return nil, nil
+		case <-disconnected:
+			h.finishBlockWaiter(w, false)
+			return nil, errors.New("connection closed while blocking")
+		}
+	}
+}
+
+func blockLPop(s *store.Store, db uint32, key []byte) (redis.Resp, error) {
+	if v, err := s.LPop(db, [][]byte{key}); err != nil || v == nil {
+		return nil, errors.Trace(err)
+	} else {
+		resp := redis.NewArray()
+		resp.AppendBulkBytes(key)
+		resp.AppendBulkBytes(v)
+		return resp, nil
+	}
+}
+
+func blockRPop(s *store.Store, db uint32, key []byte) (redis.Resp, error) {
+	if v, err := s.RPop(db, [][]byte{key}); err != nil || v == nil {
+		return nil, errors.Trace(err)
+	} else {
+		resp := redis.NewArray()
+		resp.AppendBulkBytes(key)
+		resp.AppendBulkBytes(v)
+		return resp, nil
+	}
+}
+
+func blockListCmd(s Session, args [][]byte, pop blockPopFunc) (redis.Resp, error) {
+	if len(args) < 2 {
+		return toRespErrorf("len(args) = %d, expect >= 2", len(args))
+	}
+
+	timeout, err := parseBlockTimeout(args[len(args)-1])
+	if err != nil {
+		return toRespError(err)
+	}
+
+	if resp, err := blockPop(s, args[:len(args)-1], timeout, pop); err != nil {
+		return toRespError(err)
+	} else if resp == nil {
+		return redis.NewArray(), nil
+	} else {
+		return resp, nil
+	}
+}
+
+// BLPOP key [key ...] timeout
+func BLPopCmd(s Session, args [][]byte) (redis.Resp, error) {
+	return blockListCmd(s, args, blockLPop)
+}
+
+// BRPOP key [key ...] timeout
+func BRPopCmd(s Session, args [][]byte) (redis.Resp, error) {
+	return blockListCmd(s, args, blockRPop)
+}
+
+// BRPOPLPUSH source destination timeout
+func BRPopLPushCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) != 3 {
+		return toRespErrorf("len(args) = %d, expect = 3", len(args))
+	}
+
+	timeout, err := parseBlockTimeout(args[2])
+	if err != nil {
+		return toRespError(err)
+	}
+
+	dst := args[1]
+	pop := func(s *store.Store, db uint32, key []byte) (redis.Resp, error) {
+		if v, err := s.RPopLPush(db, [][]byte{key, dst}); err != nil || v == nil {
+			return nil, errors.Trace(err)
+		} else {
+			return redis.NewBulkBytes(v), nil
+		}
+	}
+
+	if resp, err := blockPop(s, args[:1], timeout, pop); err != nil {
+		return toRespError(err)
+	} else if resp == nil {
+		return redis.NewBulkBytes(nil), nil
+	} else {
+		return resp, nil
+	}
+}
+
+func init() {
+	Register("blpop", BLPopCmd, CmdWrite)
+	Register("brpop", BRPopCmd, CmdWrite)
+	Register("brpoplpush", BRPopLPushCmd, CmdWrite)
+}
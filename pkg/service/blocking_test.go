@@ -0,0 +1,164 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"time"
+
+	redis "github.com/reborndb/go/redis/resp"
+	. "gopkg.in/check.v1"
+)
+
+// wait until n clients are blocked by key in db 0
+func (s *testServiceSuite) waitBlocked(c *C, key string, n int) {
+	h := s.s.h
+	for i := 0; i < 500; i++ {
+		h.blocking.Lock()
+		m := len(h.blocking.waiters[blockKey{0, key}])
+		h.blocking.Unlock()
+		if m == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("wait %d blocked clients of %s timeout", n, key)
+}
+
+func (s *testServiceSuite) TestBLPop(c *C) {
+	k1, k2 := randomKey(c), randomKey(c)
+
+	s.checkInt(c, 2, "RPUSH", k2, "a", "b")
+	ay := s.checkBytesArray(c, "BLPOP", k1, k2, 0)
+	c.Assert(ay, DeepEquals, [][]byte{[]byte(k2), []byte("a")})
+	ay = s.checkBytesArray(c, "BRPOP", k1, k2, 0)
+	c.Assert(ay, DeepEquals, [][]byte{[]byte(k2), []byte("b")})
+
+	s.checkNil(c, "BLPOP", k1, k2, 0.1)
+	s.checkContainError(c, "timeout is negative", "BLPOP", k1, -1)
+	s.checkContainError(c, "timeout is not a float", "BLPOP", k1, "a")
+
+	// blocked until another client pushes
+	sc := newTestSubConn(c, 16380)
+	defer sc.Close()
+	sc.send(c, "BRPOP", k1, k2, 0)
+	s.waitBlocked(c, k2, 1)
+	s.checkInt(c, 2, "LPUSH", k2, "c", "d")
+	c.Assert(sc.recv(c), DeepEquals, redis.Resp(&redis.Array{Value: []redis.Resp{
+		redis.NewBulkBytesWithString(k2), redis.NewBulkBytesWithString("c"),
+	}}))
+	s.waitBlocked(c, k2, 0)
+	s.checkInt(c, 1, "LLEN", k2)
+	s.checkInt(c, 1, "DEL", k2)
+
+	// never blocks in transaction
+	nc := s.getConn(c)
+	defer nc.Recycle()
+	nc.checkOK(c, "MULTI")
+	nc.checkString(c, "QUEUED", "BLPOP", k1, 0)
+	resp := nc.doCmd(c, "EXEC")
+	c.Assert(resp, DeepEquals, redis.Resp(&redis.Array{Value: []redis.Resp{redis.NewArray()}}))
+}
+
+func (s *testServiceSuite) TestBLPopFIFO(c *C) {
+	k := randomKey(c)
+
+	sc1 := newTestSubConn(c, 16380)
+	defer sc1.Close()
+	sc2 := newTestSubConn(c, 16380)
+	defer sc2.Close()
+
+	sc1.send(c, "BLPOP", k, 0)
+	s.waitBlocked(c, k, 1)
+	sc2.send(c, "BLPOP", k, 0)
+	s.waitBlocked(c, k, 2)
+
+	s.checkInt(c, 2, "RPUSH", k, "a", "b")
+	sc1.checkRecv(c, k, "a")
+	sc2.checkRecv(c, k, "b")
+	s.waitBlocked(c, k, 0)
+	s.checkInt(c, 0, "LLEN", k)
+
+	sc1.send(c, "BLPOP", k, 0)
+	s.waitBlocked(c, k, 1)
+	sc2.send(c, "BLPOP", k, 0)
+	s.waitBlocked(c, k, 2)
+	s.checkInt(c, 1, "RPUSH", k, "c")
+	sc1.checkRecv(c, k, "c")
+	s.checkInt(c, 1, "RPUSH", k, "d")
+	sc2.checkRecv(c, k, "d")
+
+	// timeout waiter leaves the queue
+	sc1.send(c, "BLPOP", k, 0.2)
+	c.Assert(sc1.recv(c), DeepEquals, redis.Resp(redis.NewArray()))
+	s.waitBlocked(c, k, 0)
+
+	// closed client leaves the queue without taking any element
+	sc3 := newTestSubConn(c, 16380)
+	sc3.send(c, "BLPOP", k, 0)
+	s.waitBlocked(c, k, 1)
+	sc3.Close()
+	s.waitBlocked(c, k, 0)
+	s.checkInt(c, 1, "RPUSH", k, "e")
+	s.checkString(c, "e", "LPOP", k)
+}
+
+func (s *testServiceSuite) TestBLPopRestore(c *C) {
+	k1, k2 := randomKey(c), randomKey(c)
+
+	s.checkInt(c, 1, "RPUSH", k2, "a")
+	nc := s.getConn(c)
+	defer nc.Recycle()
+	dump, ok := nc.doCmd(c, "DUMP", k2).(*redis.BulkBytes)
+	c.Assert(ok, Equals, true)
+
+	sc := newTestSubConn(c, 16380)
+	defer sc.Close()
+
+	// wakes the client blocked by keys restored
+	sc.send(c, "BLPOP", k1, 0)
+	s.waitBlocked(c, k1, 1)
+	s.checkOK(c, "RESTORE", k1, 0, dump.Value)
+	sc.checkRecv(c, k1, "a")
+
+	sc.send(c, "BLPOP", k1, 0)
+	s.waitBlocked(c, k1, 1)
+	s.checkOK(c, "SLOTSRESTORE", k1, 0, dump.Value)
+	sc.checkRecv(c, k1, "a")
+
+	s.waitBlocked(c, k1, 0)
+	s.checkInt(c, 1, "DEL", k2)
+}
+
+func (s *testServiceSuite) TestBRPopLPush(c *C) {
+	k1, k2 := randomKey(c), randomKey(c)
+
+	s.checkInt(c, 2, "RPUSH", k1, "a", "b")
+	s.checkString(c, "b", "BRPOPLPUSH", k1, k2, 0)
+	s.checkString(c, "a", "BRPOPLPUSH", k1, k2, 0)
+	s.checkNil(c, "BRPOPLPUSH", k1, k2, 0.1)
+	c.Assert(s.checkBytesArray(c, "LRANGE", k2, 0, -1), DeepEquals, [][]byte{[]byte("a"), []byte("b")})
+
+	sc := newTestSubConn(c, 16380)
+	defer sc.Close()
+	sc.send(c, "BRPOPLPUSH", k1, k2, 0)
+	s.waitBlocked(c, k1, 1)
+	s.checkInt(c, 1, "RPUSH", k1, "c")
+	c.Assert(sc.recv(c), DeepEquals, redis.Resp(redis.NewBulkBytesWithString("c")))
+	c.Assert(s.checkBytesArray(c, "LRANGE", k2, 0, -1), DeepEquals, [][]byte{[]byte("c"), []byte("a"), []byte("b")})
+
+	// source is kept if destination is not a list
+	s.checkOK(c, "SET", k2, "x")
+	s.checkInt(c, 1, "RPUSH", k1, "d")
+	s.checkContainError(c, "not list", "BRPOPLPUSH", k1, k2, 0)
+	s.checkInt(c, 1, "LLEN", k1)
+
+	// wakes the client blocked by destination
+	k3 := randomKey(c)
+	sc.send(c, "BLPOP", k3, 0)
+	s.waitBlocked(c, k3, 1)
+	s.checkString(c, "d", "BRPOPLPUSH", k1, k3, 0)
+	sc.checkRecv(c, k3, "d")
+
+	s.checkInt(c, 1, "DEL", k2)
+}
@@ -50,6 +50,8 @@ type CommandFlag uint32
 const (
 	CmdWrite CommandFlag = 1 << iota
 	CmdReadonly
+	// command can not be queued in MULTI
+	CmdNoMulti
 )
 
 func Register(name string, f CommandFunc, flag CommandFlag) {
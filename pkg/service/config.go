@@ -16,20 +16,49 @@ type Config struct {
 	DumpPath    string `toml:"dump_filepath"`
 	ConnTimeout int    `toml:"conn_timeout"`
 
+	// Snapshot of SAVE and BGSAVE is read by workers, each scans a range of slots
+	BgsaveWorkers int `toml:"bgsave_workers"`
+
 	SyncFilePath string `toml:"sync_file_path"`
 	SyncFileSize int    `toml:"sync_file_size"`
 	SyncBuffSize int    `toml:"sync_memory_buffer"`
 
+	// Full resync between qdb of the same engine ships a checkpoint of database files saved here.
+	// If empty, RDB is always used.
+	SyncCheckpointPath string `toml:"sync_checkpoint_path"`
+
+	// RDB of full resync is restored by workers, each commits a batch of entries at a time
+	SyncRDBWorkers   int `toml:"sync_rdb_workers"`
+	SyncRDBBatchSize int `toml:"sync_rdb_batch_size"`
+
 	ReplPingSlavePeriod int `toml:"repl_ping_slave_period"`
-	// If empty, we will use memory for replication backlog
+	// If empty, we will use memory for replication backlog.
+	// File backlog and run id are kept after restarting, so slaves can partial resync.
 	ReplBacklogFilePath string `toml:"repl_backlog_file_path"`
 	ReplBacklogSize     int    `toml:"repl_backlog_size"`
 	// If no slaves after time, backlog will be released.
 	// 0 means to no release at all.
 	ReplBacklogTTL int `toml:"repl_backlog_ttl"`
 
+	// Slave only mirrors keys of the dbs and slots, e.g. "0,2" and "0-511,1000".
+	// If empty, all dbs or all slots are mirrored.
+	ReplFilterDB    string `toml:"repl_filter_db"`
+	ReplFilterSlots string `toml:"repl_filter_slots"`
+
+	// Writes are refused if less than min slaves acked in max lag seconds.
+	// 0 means to accept writes always.
+	MinSlavesToWrite int `toml:"min_slaves_to_write"`
+	MinSlavesMaxLag  int `toml:"min_slaves_max_lag"`
+
 	Auth       string `toml:"auth"`
 	MasterAuth string `toml:"master_auth"`
+
+	// If true, DEL and commands overwriting keys delete big collections in background like UNLINK.
+	LazyFree bool `toml:"lazy_free"`
+
+	// Scripts running longer than the limit in milliseconds make other commands reply BUSY,
+	// and can be killed by SCRIPT KILL if they haven't written. 0 means no limit.
+	LuaTimeLimit int `toml:"lua_time_limit"`
 }
 
 func NewDefaultConfig() *Config {
@@ -39,12 +68,23 @@ func NewDefaultConfig() *Config {
 		DumpPath:    "dump.rdb",
 		ConnTimeout: 900,
 
+		BgsaveWorkers: 4,
+
 		SyncFilePath: "./var/sync.pipe",
 		SyncFileSize: bytesize.GB * 32,
 		SyncBuffSize: bytesize.MB * 32,
 
+		SyncCheckpointPath: "./var/sync.checkpoint",
+
+		SyncRDBWorkers:   4,
+		SyncRDBBatchSize: 128,
+
 		ReplPingSlavePeriod: 10,
 		ReplBacklogSize:     bytesize.GB * 10,
+
+		MinSlavesMaxLag: 10,
+
+		LuaTimeLimit: 5000,
 	}
 }
 
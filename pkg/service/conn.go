@@ -50,6 +50,34 @@ type conn struct {
 
 	// whether sync from master or not
 	isSyncing bool
+
+	// slave accepts a checkpoint of the same engine in full resync
+	syncCheckpoint bool
+
+	// master asks slave to ack sync offset by REPLCONF GETACK
+	syncACKRequested bool
+
+	// replication offset reached by the last write of this connection, WAIT waits for it
+	writeOffset int64
+
+	// slave only syncs the keys matched if not nil
+	syncFilter *replStreamFilter
+
+	// queued commands after MULTI, nil if not in transaction
+	multi *multiState
+
+	// keys watched by WATCH
+	watched []*watchedKey
+
+	// store bound to the running transaction in EXEC
+	tx *store.Store
+
+	// pub/sub channels and patterns subscribed by this connection
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	// pub/sub messages waiting to be pushed to client
+	pushQueue chan redis.Resp
 }
 
 func newConn(nc net.Conn, h *Handler, timeout int) *conn {
@@ -74,6 +102,8 @@ func (c *conn) String() string {
 
 func (c *conn) serve(h *Handler) error {
 	defer func() {
+		c.unwatchAll()
+		c.unsubscribeAll()
 		h.removeSlave(c)
 		h.removeConn(c)
 		c.Close()
@@ -89,6 +119,12 @@ func (c *conn) serve(h *Handler) error {
 			continue
 		}
 
+		if c.pushQueue != nil {
+			// keep in order with pushed pub/sub messages
+			c.pushReply(response)
+			continue
+		}
+
 		if c.timeout > 0 {
 			deadline := time.Now().Add(c.timeout)
 			if err := c.nc.SetWriteDeadline(deadline); err != nil {
@@ -103,8 +139,20 @@ func (c *conn) serve(h *Handler) error {
 }
 
 func (c *conn) handleRequest(h *Handler) (redis.Resp, error) {
+	request, err := c.readRequest()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return c.handleDecodedRequest(h, request), nil
+}
+
+func (c *conn) readRequest() (redis.Resp, error) {
 	if c.timeout > 0 {
-		deadline := time.Now().Add(c.timeout)
+		// subscribed client may wait for messages for a long time, no deadline
+		var deadline time.Time
+		if !c.isSubscribed() {
+			deadline = time.Now().Add(c.timeout)
+		}
 		if err := c.nc.SetReadDeadline(deadline); err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -113,9 +161,12 @@ func (c *conn) handleRequest(h *Handler) (redis.Resp, error) {
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	return request, nil
+}
 
+func (c *conn) handleDecodedRequest(h *Handler, request redis.Resp) redis.Resp {
 	if request.Type() == redis.TypePing {
-		return nil, nil
+		return nil
 	}
 
 	h.counters.commands.Add(1)
@@ -126,7 +177,7 @@ func (c *conn) handleRequest(h *Handler) (redis.Resp, error) {
 		log.Warningf("handle commands failed, conn = %s, request = '%s', err = %s", c, base64.StdEncoding.EncodeToString(b), err)
 	}
 
-	return response, nil
+	return response
 }
 
 func (c *conn) dispatch(h *Handler, request redis.Resp) (redis.Resp, error) {
@@ -137,22 +188,52 @@ func (c *conn) dispatch(h *Handler, request redis.Resp) (redis.Resp, error) {
 
 	// check cmd first, then auth
 	if f := h.htable[cmd]; f == nil {
+		c.abortMulti()
 		return toRespErrorf("unknown command %s", cmd)
 	} else {
 		if len(h.config.Auth) > 0 && !c.authenticated && strings.ToLower(cmd) != "auth" {
 			return toRespErrorf("NOAUTH Authentication required")
 		}
 
+		if !c.isSyncing && c.h.scriptBusy() && !isScriptKill(cmd, args) {
+			return toRespErrorf("BUSY Redis is busy running a script. You can only call SCRIPT KILL.")
+		}
+
 		masterAddr := c.h.masterAddr.Get()
 		if len(masterAddr) > 0 && f.flag&CmdWrite > 0 && !c.isSyncing {
 			// we are a slave, so can not receive any write operations except from master in syncing
+			c.abortMulti()
 			return toRespErrorf("READONLY You can't write against a read only slave.")
 		}
 
-		return f.f(c, args)
+		if len(masterAddr) == 0 && f.flag&CmdWrite > 0 && !c.h.enoughGoodSlaves() {
+			c.abortMulti()
+			return toRespErrorf("NOREPLICAS Not enough good slaves to write.")
+		}
+
+		if c.isSubscribed() && !subscribedModeCommands[cmd] {
+			return toRespErrorf("only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING allowed in this context")
+		}
+
+		if c.multi != nil {
+			return c.queueCommand(cmd, f, args)
+		}
+
+		resp, err := f.f(c, args)
+		if len(masterAddr) == 0 && (f.flag&CmdWrite > 0 || runWriteCommands[cmd]) {
+			c.writeOffset = c.h.replicationOffset()
+		}
+		return resp, err
 	}
 }
 
+// commands not flagged as write, but may run writes, e.g. EXEC and scripts
+var runWriteCommands = map[string]bool{
+	"exec":    true,
+	"eval":    true,
+	"evalsha": true,
+}
+
 // read a RESP line, return buffer ignoring \r\n
 // sometimes, only \n is a valid RESP line, we will ignore this
 func (c *conn) readLine() (line []byte, err error) {
@@ -195,13 +276,14 @@ func (c *conn) ping() error {
 }
 
 // send PSYNC command and return the first reply line from master
-func (c *conn) prePSync(masterRunID string, syncOffset int64) (string, error) {
+// args are run id, sync offset and db selected at the offset if needed
+func (c *conn) prePSync(args ...interface{}) (string, error) {
 	deadline := time.Now().Add(time.Second * 5)
 	if err := c.nc.SetDeadline(deadline); err != nil {
 		return "", errors.Trace(err)
 	}
 
-	if err := c.writeRESP(redis.NewRequest("PSYNC", masterRunID, syncOffset)); err != nil {
+	if err := c.writeRESP(redis.NewRequest("PSYNC", args...)); err != nil {
 		return "", errors.Trace(err)
 	}
 
@@ -292,5 +374,8 @@ func (c *conn) SetDB(db uint32) {
 }
 
 func (c *conn) Store() *store.Store {
+	if c.tx != nil {
+		return c.tx
+	}
 	return c.h.store
 }
@@ -0,0 +1,396 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+	redis "github.com/reborndb/go/redis/resp"
+	"github.com/reborndb/qdb/pkg/store"
+)
+
+// replFilter selects the keys a slave mirrors by db and by slot
+type replFilter struct {
+	// nil matches all dbs
+	dbs map[uint32]bool
+	// nil matches all slots, indexed by slot
+	slots []bool
+}
+
+// returns nil if both dbs and slots are empty, which means no filter
+func newReplFilter(dbs string, slots string) (*replFilter, error) {
+	f := &replFilter{}
+	if err := f.setDBs(dbs); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := f.setSlots(slots); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if f.dbs == nil && f.slots == nil {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// dbs are separated by comma, e.g. 0,2,5
+func (f *replFilter) setDBs(s string) error {
+	f.dbs = nil
+	if s = strings.TrimSpace(s); s == "" {
+		return nil
+	}
+
+	dbs := make(map[uint32]bool)
+	for _, p := range strings.Split(s, ",") {
+		db, err := strconv.ParseUint(strings.TrimSpace(p), 10, 32)
+		if err != nil {
+			return errors.Errorf("invalid db %q of filter", p)
+		}
+		dbs[uint32(db)] = true
+	}
+	f.dbs = dbs
+	return nil
+}
+
+// slots are ranges separated by comma, e.g. 0-511,1000
+func (f *replFilter) setSlots(s string) error {
+	f.slots = nil
+	if s = strings.TrimSpace(s); s == "" {
+		return nil
+	}
+
+	slots := make([]bool, store.MaxSlotNum)
+	for _, p := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(p), "-", 2)
+		from, err := strconv.ParseUint(bounds[0], 10, 32)
+		if err != nil {
+			return errors.Errorf("invalid slot range %q of filter", p)
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = strconv.ParseUint(bounds[1], 10, 32); err != nil {
+				return errors.Errorf("invalid slot range %q of filter", p)
+			}
+		}
+		if from > to || to >= store.MaxSlotNum {
+			return errors.Errorf("invalid slot range %q of filter, expect in [0, %d)", p, store.MaxSlotNum)
+		}
+		for slot := from; slot <= to; slot++ {
+			slots[slot] = true
+		}
+	}
+	f.slots = slots
+	return nil
+}
+
+func (f *replFilter) matchDB(db uint32) bool {
+	return f.dbs == nil || f.dbs[db]
+}
+
+func (f *replFilter) matchSlot(db uint32, slot uint32) bool {
+	return f.matchDB(db) && (f.slots == nil || f.slots[slot])
+}
+
+func (f *replFilter) matchKey(db uint32, key []byte) bool {
+	if f.slots == nil {
+		return f.matchDB(db)
+	}
+	_, slot := store.HashKeyToSlot(key)
+	return f.matchSlot(db, slot)
+}
+
+// returns number of keys matched
+func (f *replFilter) countKeys(db uint32, keys [][]byte) int {
+	n := 0
+	for _, key := range keys {
+		if f.matchKey(db, key) {
+			n++
+		}
+	}
+	return n
+}
+
+// returns error if some keys written by cmd with args are matched and others are not,
+// e.g. SUNIONSTORE with sets out of the filter, the slave can't replay it
+func (f *replFilter) checkSplitKeys(db uint32, cmd string, args [][]byte) error {
+	keys := streamRequestKeys(cmd, args)
+	if n := f.countKeys(db, keys); n != 0 && n != len(keys) {
+		return errors.Errorf("keys of %s are split by filter %s", cmd, f)
+	}
+	return nil
+}
+
+// same format as setDBs, in order
+func (f *replFilter) dbsString() string {
+	dbs := make([]int, 0, len(f.dbs))
+	for db, _ := range f.dbs {
+		dbs = append(dbs, int(db))
+	}
+	sort.Ints(dbs)
+
+	ss := make([]string, len(dbs))
+	for i, db := range dbs {
+		ss[i] = strconv.Itoa(db)
+	}
+	return strings.Join(ss, ",")
+}
+
+// same format as setSlots, in order
+func (f *replFilter) slotsString() string {
+	var ss []string
+	for from := 0; from < len(f.slots); from++ {
+		if !f.slots[from] {
+			continue
+		}
+		to := from
+		for to+1 < len(f.slots) && f.slots[to+1] {
+			to++
+		}
+		if from == to {
+			ss = append(ss, strconv.Itoa(from))
+		} else {
+			ss = append(ss, fmt.Sprintf("%d-%d", from, to))
+		}
+		from = to
+	}
+	return strings.Join(ss, ",")
+}
+
+// returns empty string for nil filter
+func (f *replFilter) String() string {
+	if f == nil {
+		return ""
+	}
+
+	var ss []string
+	if f.dbs != nil {
+		ss = append(ss, "db="+f.dbsString())
+	}
+	if f.slots != nil {
+		ss = append(ss, "slots="+f.slotsString())
+	}
+	return strings.Join(ss, " ")
+}
+
+// replStreamFilter filters the replication stream sent to a slave.
+// Requests skipped are told by REPLCONF OFFSET with the offset the next request
+// ends at, so offsets of slave still match the master.
+type replStreamFilter struct {
+	replFilter
+
+	// db selected by the stream
+	db uint32
+}
+
+// returns requests of p matched and the size of p handled, p begins at offset
+// of the stream, requests not complete at the end are not handled
+func (f *replStreamFilter) filter(p []byte, offset int64) ([]byte, int, error) {
+	var b bytes.Buffer
+	// offset the last request handled ends at
+	writeOffset := func(used int) {
+		buf, _ := redis.EncodeToBytes(redis.NewRequest("REPLCONF", "OFFSET", offset+int64(used)-1))
+		b.Write(buf)
+	}
+
+	used, skipped := 0, false
+	for used < len(p) {
+		args, n, err := parseStreamRequest(p[used:])
+		if err != nil {
+			return nil, 0, errors.Trace(err)
+		} else if n == 0 {
+			break
+		}
+		request := p[used : used+n]
+		used += n
+
+		keep, rewritten, err := f.filterRequest(args)
+		if err != nil {
+			return nil, 0, errors.Trace(err)
+		}
+		switch {
+		case keep == nil:
+			skipped = true
+		case rewritten:
+			ay := redis.NewArray()
+			for _, arg := range keep {
+				ay.AppendBulkBytes(arg)
+			}
+			buf, _ := redis.EncodeToBytes(ay)
+			writeOffset(used)
+			b.Write(buf)
+			skipped = false
+		default:
+			if skipped {
+				writeOffset(used)
+				skipped = false
+			}
+			b.Write(request)
+		}
+	}
+
+	if skipped {
+		// slave moves on to the end of skipped requests with PING
+		writeOffset(used)
+		buf, _ := redis.EncodeToBytes(redis.NewRequest("PING"))
+		b.Write(buf)
+	}
+	return b.Bytes(), used, nil
+}
+
+// returns nil if request is skipped, or the request with the keys matched,
+// rewritten is true if some keys of the request are removed
+func (f *replStreamFilter) filterRequest(args [][]byte) (keep [][]byte, rewritten bool, err error) {
+	cmd := strings.ToLower(string(args[0]))
+	switch cmd {
+	case "select":
+		if len(args) == 2 {
+			if db, err := strconv.ParseUint(string(args[1]), 10, 32); err == nil {
+				f.db = uint32(db)
+			}
+		}
+		// slave keeps tracking the db selected by the stream
+		return args, false, nil
+	case "ping", "replconf", "multi", "exec":
+		return args, false, nil
+	case "publish":
+		// channels are not scoped by db or slot, subscribers on slave receive all messages
+		return args, false, nil
+	}
+
+	if !f.matchDB(f.db) {
+		return nil, false, nil
+	} else if f.slots == nil {
+		return args, false, nil
+	}
+
+	// keys of these commands are independent of each other
+	switch cmd {
+	case "del", "unlink":
+		keep, rewritten = f.filterKeys(args, 1)
+		return keep, rewritten, nil
+	case "mset":
+		keep, rewritten = f.filterKeys(args, 2)
+		return keep, rewritten, nil
+	case "slotsrestore":
+		keep, rewritten = f.filterKeys(args, 3)
+		return keep, rewritten, nil
+	}
+
+	// others are kept if all keys match, the same as keys of a hash tag,
+	// keys split by the filter can't be replayed, the slave must resync fully
+	if err := f.checkSplitKeys(f.db, cmd, args[1:]); err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	if f.countKeys(f.db, streamRequestKeys(cmd, args[1:])) == 0 {
+		return nil, false, nil
+	}
+	return args, false, nil
+}
+
+// args[1:] are groups of size step led by a key
+func (f *replStreamFilter) filterKeys(args [][]byte, step int) ([][]byte, bool) {
+	keep := args[:1:1]
+	for i := 1; i+step <= len(args); i += step {
+		if f.matchKey(f.db, args[i]) {
+			keep = append(keep, args[i:i+step]...)
+		}
+	}
+	switch len(keep) {
+	case 1:
+		return nil, false
+	case len(args):
+		return args, false
+	default:
+		return keep, true
+	}
+}
+
+// returns keys of forward command cmd with args, or of the command received by the master
+func streamRequestKeys(cmd string, args [][]byte) [][]byte {
+	switch cmd {
+	case "rpoplpush", "brpoplpush", "lmove", "blmove", "smove":
+		if len(args) >= 2 {
+			return args[:2]
+		}
+	case "sinterstore", "sunionstore", "sdiffstore":
+		return args
+	case "bitop":
+		if len(args) >= 1 {
+			return args[1:]
+		}
+	case "zunionstore", "zinterstore":
+		// destination numkeys key [key ...] ...
+		if len(args) >= 2 {
+			n, err := strconv.Atoi(string(args[1]))
+			if err == nil && n >= 0 && n <= len(args)-2 {
+				return append([][]byte{args[0]}, args[2:2+n]...)
+			}
+		}
+	}
+
+	if len(args) >= 1 {
+		return args[:1]
+	}
+	return nil
+}
+
+// requests of the replication stream are arrays of bulk strings,
+// returns the request and its size, or 0 if p has no complete request
+func parseStreamRequest(p []byte) ([][]byte, int, error) {
+	if len(p) == 0 {
+		return nil, 0, nil
+	} else if p[0] != '*' {
+		return nil, 0, errors.Errorf("invalid request of stream, expect array")
+	}
+
+	count, i, err := parseStreamInt(p, 1)
+	if err != nil || i == 0 {
+		return nil, 0, err
+	} else if count <= 0 {
+		return nil, 0, errors.Errorf("invalid request of stream, array size = %d", count)
+	}
+
+	args := make([][]byte, 0, count)
+	for ; count != 0; count-- {
+		if i == len(p) {
+			return nil, 0, nil
+		} else if p[i] != '$' {
+			return nil, 0, errors.Errorf("invalid request of stream, expect bulk string")
+		}
+
+		var n int64
+		if n, i, err = parseStreamInt(p, i+1); err != nil || i == 0 {
+			return nil, 0, err
+		} else if n < 0 {
+			return nil, 0, errors.Errorf("invalid request of stream, bulk size = %d", n)
+		}
+
+		if int64(len(p)-i) < n+2 {
+			return nil, 0, nil
+		} else if p[i+int(n)] != '\r' || p[i+int(n)+1] != '\n' {
+			return nil, 0, errors.Errorf("invalid request of stream, bad CRLF end")
+		}
+		args = append(args, p[i:i+int(n)])
+		i += int(n) + 2
+	}
+	return args, i, nil
+}
+
+// returns the integer ending with CRLF from p[i:] and the index after CRLF,
+// index is 0 if CRLF is not found
+func parseStreamInt(p []byte, i int) (int64, int, error) {
+	end := bytes.Index(p[i:], []byte("\r\n"))
+	if end == -1 {
+		return 0, 0, nil
+	}
+	n, err := strconv.ParseInt(string(p[i:i+end]), 10, 64)
+	if err != nil {
+		return 0, 0, errors.Errorf("invalid request of stream, bad integer %q", p[i:i+end])
+	}
+	return n, i + end + 2, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/juju/errors"
+	redis "github.com/reborndb/go/redis/resp"
+	"github.com/reborndb/qdb/pkg/store"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testServiceSuite) TestReplFilterParse(c *C) {
+	f, err := newReplFilter("", "")
+	c.Assert(err, IsNil)
+	c.Assert(f, IsNil)
+	c.Assert(f.String(), Equals, "")
+
+	f, err = newReplFilter("2, 0,2", "1000,0-511,512")
+	c.Assert(err, IsNil)
+	c.Assert(f.String(), Equals, "db=0,2 slots=0-512,1000")
+	c.Assert(f.matchSlot(0, 512), Equals, true)
+	c.Assert(f.matchSlot(2, 1000), Equals, true)
+	c.Assert(f.matchSlot(1, 0), Equals, false)
+	c.Assert(f.matchSlot(0, 513), Equals, false)
+
+	f, err = newReplFilter("1", "")
+	c.Assert(err, IsNil)
+	c.Assert(f.String(), Equals, "db=1")
+	c.Assert(f.matchKey(1, []byte("a")), Equals, true)
+	c.Assert(f.matchKey(0, []byte("a")), Equals, false)
+
+	for _, slots := range []string{"a", "1-a", "2-1", fmt.Sprint(store.MaxSlotNum), "-1"} {
+		_, err = newReplFilter("", slots)
+		c.Assert(err, NotNil)
+	}
+	_, err = newReplFilter("0,x", "")
+	c.Assert(err, NotNil)
+}
+
+// returns keys in slots below and above half of all slots
+func testSlotKeys(prefix string, n int) (low []string, high []string) {
+	for i := 0; len(low) < n || len(high) < n; i++ {
+		key := fmt.Sprintf("%s_%d", prefix, i)
+		if _, slot := store.HashKeyToSlot([]byte(key)); slot < store.MaxSlotNum/2 {
+			if len(low) < n {
+				low = append(low, key)
+			}
+		} else if len(high) < n {
+			high = append(high, key)
+		}
+	}
+	return
+}
+
+func (s *testServiceSuite) TestReplStreamFilter(c *C) {
+	low, high := testSlotKeys("filter", 2)
+
+	var stream bytes.Buffer
+	for _, r := range []redis.Resp{
+		redis.NewRequest("SELECT", 0),
+		redis.NewRequest("Set", low[0], "1"),
+		redis.NewRequest("SELECT", 1),
+		redis.NewRequest("Set", high[0], "2"),
+		redis.NewRequest("Set", high[1], "3"),
+		redis.NewRequest("Set", low[0], "4"),
+		redis.NewRequest("MSet", high[0], "5", low[1], "6"),
+		redis.NewRequest("Del", high[0], high[1]),
+		redis.NewRequest("PING"),
+		redis.NewRequest("Publish", high[1], "msg"),
+		redis.NewRequest("SELECT", 0),
+		redis.NewRequest("Publish", low[1], "msg"),
+		redis.NewRequest("SELECT", 1),
+		redis.NewRequest("Del", high[0]),
+	} {
+		b, err := redis.EncodeToBytes(r)
+		c.Assert(err, IsNil)
+		stream.Write(b)
+	}
+	p := stream.Bytes()
+
+	f, err := newReplFilter("1", "0-511")
+	c.Assert(err, IsNil)
+
+	// slave counts offsets of requests like doSyncFromMater, returns requests not skipped
+	const base = 100
+	replay := func(out []byte) []string {
+		var requests []string
+		offset, next := int64(base-1), int64(-1)
+		r := bufio.NewReader(bytes.NewReader(out))
+		for {
+			request, err := redis.DecodeRequest(r)
+			if errors.Cause(err) == io.EOF {
+				break
+			}
+			c.Assert(err, IsNil)
+			b, _ := redis.EncodeToBytes(request)
+			if o, ok := syncStreamOffset(request); ok {
+				next = o
+				continue
+			}
+			if offset += int64(len(b)); next != -1 {
+				offset, next = next, -1
+			}
+			cmd, args, err := redis.ParseArgs(request)
+			c.Assert(err, IsNil)
+			requests = append(requests, strings.TrimSpace(fmt.Sprintf("%s %s", cmd, bytes.Join(args, []byte(" ")))))
+		}
+		c.Assert(offset, Equals, int64(base+len(p)-1))
+		return requests
+	}
+
+	expect := []string{
+		"select 0",
+		"select 1",
+		"set " + low[0] + " 4",
+		"mset " + low[1] + " 6",
+		"ping",
+		"publish " + high[1] + " msg",
+		"select 0",
+		"publish " + low[1] + " msg",
+		"select 1",
+		"ping",
+	}
+
+	sf := &replStreamFilter{replFilter: *f}
+	out, n, err := sf.filter(p, base)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(p))
+	c.Assert(replay(out), DeepEquals, expect)
+	c.Assert(sf.db, Equals, uint32(1))
+
+	// feed the stream in small pieces, requests may be broken,
+	// PING is sent if the piece ends with requests skipped
+	sf = &replStreamFilter{replFilter: *f}
+	var b bytes.Buffer
+	for used, size := 0, 0; used < len(p); {
+		if size += 7; size > len(p)-used {
+			size = len(p) - used
+		}
+		out, n, err := sf.filter(p[used:used+size], int64(base+used))
+		c.Assert(err, IsNil)
+		b.Write(out)
+		used, size = used+n, size-n
+	}
+	var requests []string
+	for _, r := range replay(b.Bytes()) {
+		if r != "ping" {
+			requests = append(requests, r)
+		}
+	}
+	expect = []string{
+		"select 0",
+		"select 1",
+		"set " + low[0] + " 4",
+		"mset " + low[1] + " 6",
+		"publish " + high[1] + " msg",
+		"select 0",
+		"publish " + low[1] + " msg",
+		"select 1",
+	}
+	c.Assert(requests, DeepEquals, expect)
+
+	_, _, err = sf.filter([]byte("+OK\r\n"), base)
+	c.Assert(err, NotNil)
+
+	// slave can't replay requests writing keys split by the filter
+	for _, r := range []redis.Resp{
+		redis.NewRequest("SMove", high[0], low[1], "7"),
+		redis.NewRequest("SUnionStore", low[0], low[1], high[0]),
+		redis.NewRequest("ZUnionStore", low[0], 2, low[1], high[0]),
+	} {
+		b, err := redis.EncodeToBytes(r)
+		c.Assert(err, IsNil)
+		sf = &replStreamFilter{replFilter: *f, db: 1}
+		_, _, err = sf.filter(b, base)
+		c.Assert(err, NotNil)
+		cmd, args, err := redis.ParseArgs(r)
+		c.Assert(err, IsNil)
+		c.Assert(f.checkSplitKeys(1, cmd, args), NotNil)
+		c.Assert(f.checkSplitKeys(0, cmd, args), IsNil)
+	}
+	c.Assert(f.checkSplitKeys(1, "sunionstore", [][]byte{[]byte(low[0]), []byte(low[1])}), IsNil)
+}
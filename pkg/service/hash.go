@@ -21,6 +21,15 @@ func HGetAllCmd(s Session, args [][]byte) (redis.Resp, error) {
 	}
 }
 
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func HScanCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if cursor, a, err := s.Store().HScan(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return newScanResp(cursor, a), nil
+	}
+}
+
 // HDEL key field [field ...]
 func HDelCmd(s Session, args [][]byte) (redis.Resp, error) {
 	if n, err := s.Store().HDel(s.DB(), args); err != nil {
@@ -152,6 +161,7 @@ func init() {
 	Register("hlen", HLenCmd, CmdReadonly)
 	Register("hmget", HMGetCmd, CmdReadonly)
 	Register("hmset", HMSetCmd, CmdWrite)
+	Register("hscan", HScanCmd, CmdReadonly)
 	Register("hset", HSetCmd, CmdWrite)
 	Register("hsetnx", HSetNXCmd, CmdWrite)
 	Register("hvals", HValsCmd, CmdReadonly)
@@ -129,3 +129,20 @@ func (s *testServiceSuite) TestHVals(c *C) {
 	c.Assert(a, HasLen, 1)
 	c.Assert(a, DeepEquals, [][]byte{[]byte("value")})
 }
+
+func (s *testServiceSuite) TestHScan(c *C) {
+	key := randomKey(c)
+	nc := s.getConn(c)
+	defer nc.Recycle()
+
+	c.Assert(nc.checkScanAll(c, "hscan", 1, key), HasLen, 0)
+	nc.checkOK(c, "hmset", key, "a", "0", "b", "1", "c", "2")
+	ay := nc.checkScanAll(c, "hscan", 2, key)
+	c.Assert(ay, HasLen, 6)
+	expect := map[string]string{"a": "0", "b": "1", "c": "2"}
+	for i := 0; i < len(ay); i += 2 {
+		c.Assert(expect[string(ay[i])], Equals, string(ay[i+1]))
+	}
+	_, ay = nc.checkScan(c, "hscan", key, 0, "match", "b")
+	c.Assert(ay, DeepEquals, [][]byte{[]byte("b"), []byte("1")})
+}
@@ -17,6 +17,15 @@ func DelCmd(s Session, args [][]byte) (redis.Resp, error) {
 	}
 }
 
+// UNLINK key [key ...]
+func UnlinkCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if n, err := s.Store().Unlink(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewInt(n), nil
+	}
+}
+
 // DUMP key
 func DumpCmd(s Session, args [][]byte) (redis.Resp, error) {
 	if x, err := s.Store().Dump(s.DB(), args); err != nil {
@@ -118,17 +127,66 @@ func RestoreCmd(s Session, args [][]byte) (redis.Resp, error) {
 	}
 }
 
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func ScanCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if cursor, keys, err := s.Store().Scan(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return newScanResp(cursor, keys), nil
+	}
+}
+
+// KEYS pattern
+func KeysCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if a, err := s.Store().Keys(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		resp := redis.NewArray()
+		for _, v := range a {
+			resp.AppendBulkBytes(v)
+		}
+		return resp, nil
+	}
+}
+
+// RANDOMKEY
+func RandomKeyCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if key, err := s.Store().RandomKey(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewBulkBytes(key), nil
+	}
+}
+
+// reply of SCAN family is a two elements array, cursor and an array of elements
+func newScanResp(cursor []byte, a [][]byte) redis.Resp {
+	ay := redis.NewArray()
+	ay.Value = make([]redis.Resp, 0, len(a))
+	for _, v := range a {
+		ay.AppendBulkBytes(v)
+	}
+
+	resp := redis.NewArray()
+	resp.AppendBulkBytes(cursor)
+	resp.Append(ay)
+	return resp
+}
+
 func init() {
 	Register("del", DelCmd, CmdWrite)
 	Register("dump", DumpCmd, CmdReadonly)
 	Register("exists", ExistsCmd, CmdReadonly)
 	Register("expire", ExpireCmd, CmdWrite)
 	Register("expireat", ExpireAtCmd, CmdWrite)
+	Register("keys", KeysCmd, CmdReadonly)
 	Register("persist", PersistCmd, CmdWrite)
 	Register("pexpire", PExpireCmd, CmdWrite)
 	Register("pexpireat", PExpireAtCmd, CmdWrite)
 	Register("pttl", PTTLCmd, CmdReadonly)
+	Register("randomkey", RandomKeyCmd, CmdReadonly)
 	Register("restore", RestoreCmd, CmdWrite)
+	Register("scan", ScanCmd, CmdReadonly)
 	Register("ttl", TTLCmd, CmdReadonly)
 	Register("type", TypeCmd, CmdReadonly)
+	Register("unlink", UnlinkCmd, CmdWrite)
 }
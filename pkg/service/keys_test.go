@@ -4,6 +4,7 @@
 package service
 
 import (
+	redis "github.com/reborndb/go/redis/resp"
 	"github.com/reborndb/qdb/pkg/store"
 	. "gopkg.in/check.v1"
 )
@@ -33,6 +34,21 @@ func (s *testServiceSuite) TestDel(c *C) {
 	s.checkInt(c, 0, "del", k)
 }
 
+func (s *testServiceSuite) TestUnlink(c *C) {
+	k := randomKey(c)
+	s.checkOK(c, "set", k, 100)
+	s.checkInt(c, 1, "unlink", k, k)
+	for i := 0; i < 100; i++ {
+		s.checkInt(c, 1, "sadd", k, i)
+	}
+	s.checkInt(c, 100, "scard", k)
+	s.checkInt(c, 1, "unlink", k)
+	s.checkInt(c, 0, "scard", k)
+	s.checkInt(c, 1, "sadd", k, "a")
+	s.checkInt(c, 1, "scard", k)
+	s.checkInt(c, 1, "del", k)
+}
+
 func (s *testServiceSuite) TestDump(c *C) {
 	k := randomKey(c)
 	s.checkOK(c, "set", k, "hello")
@@ -110,3 +126,89 @@ func (s *testServiceSuite) TestPersist(c *C) {
 	s.checkInt(c, 0, "persist", k)
 	s.checkInt(c, -1, "pttl", k)
 }
+
+func (pc *testPoolConn) checkScan(c *C, cmd string, args ...interface{}) (string, [][]byte) {
+	resp := pc.doCmd(c, cmd, args...)
+	v, ok := resp.(*redis.Array)
+	c.Assert(ok, Equals, true)
+	c.Assert(v.Value, HasLen, 2)
+
+	cursor, ok := v.Value[0].(*redis.BulkBytes)
+	c.Assert(ok, Equals, true)
+
+	ay, ok := v.Value[1].(*redis.Array)
+	c.Assert(ok, Equals, true)
+	c.Assert(ay.Value, NotNil)
+
+	a := make([][]byte, len(ay.Value))
+	for i, vv := range ay.Value {
+		b, ok := vv.(*redis.BulkBytes)
+		c.Assert(ok, Equals, true)
+		a[i] = b.Value
+	}
+	return string(cursor.Value), a
+}
+
+// run cmd [key] cursor COUNT count until cursor is 0, returns all elements
+func (pc *testPoolConn) checkScanAll(c *C, cmd string, count int64, key ...interface{}) [][]byte {
+	var all [][]byte
+	cursor := "0"
+	for {
+		args := append(append([]interface{}{}, key...), cursor, "count", count)
+		next, a := pc.checkScan(c, cmd, args...)
+		all = append(all, a...)
+		if next == "0" {
+			return all
+		}
+		cursor = next
+	}
+}
+
+func (s *testServiceSuite) TestScan(c *C) {
+	nc := s.getConn(c)
+	defer nc.Recycle()
+
+	nc.checkOK(c, "select", 101)
+	nc.checkOK(c, "set", "a", 1)
+	nc.checkOK(c, "set", "b", 2)
+	nc.checkInt(c, 1, "hset", "c", "f", "v")
+
+	keys := make(map[string]bool)
+	for _, v := range nc.checkScanAll(c, "scan", 1) {
+		keys[string(v)] = true
+	}
+	c.Assert(keys, DeepEquals, map[string]bool{"a": true, "b": true, "c": true})
+
+	nc.checkContainError(c, "count = 0", "scan", "0", "count", 0)
+	nc.checkInt(c, 3, "del", "a", "b", "c")
+	nc.checkOK(c, "select", 0)
+}
+
+func (s *testServiceSuite) TestKeys(c *C) {
+	nc := s.getConn(c)
+	defer nc.Recycle()
+
+	nc.checkOK(c, "select", 102)
+	c.Assert(nc.checkBytesArray(c, "keys", "*"), HasLen, 0)
+	nc.checkOK(c, "set", "hello", 1)
+	nc.checkOK(c, "set", "hallo", 1)
+	nc.checkOK(c, "set", "world", 1)
+	c.Assert(nc.checkBytesArray(c, "keys", "w*"), DeepEquals, [][]byte{[]byte("world")})
+	c.Assert(nc.checkBytesArray(c, "keys", "h?llo"), HasLen, 2)
+	c.Assert(nc.checkBytesArray(c, "keys", "*"), HasLen, 3)
+	nc.checkInt(c, 3, "del", "hello", "hallo", "world")
+	nc.checkOK(c, "select", 0)
+}
+
+func (s *testServiceSuite) TestRandomKey(c *C) {
+	nc := s.getConn(c)
+	defer nc.Recycle()
+
+	nc.checkOK(c, "select", 103)
+	nc.checkNil(c, "randomkey")
+	nc.checkOK(c, "set", "a", 1)
+	nc.checkString(c, "a", "randomkey")
+	nc.checkInt(c, 1, "del", "a")
+	nc.checkNil(c, "randomkey")
+	nc.checkOK(c, "select", 0)
+}
@@ -3,7 +3,11 @@
 
 package service
 
-import redis "github.com/reborndb/go/redis/resp"
+import (
+	"strings"
+
+	redis "github.com/reborndb/go/redis/resp"
+)
 
 // LINDEX key index
 func LIndexCmd(s Session, args [][]byte) (redis.Resp, error) {
@@ -108,16 +112,82 @@ func RPushXCmd(s Session, args [][]byte) (redis.Resp, error) {
 	}
 }
 
+// LINSERT key BEFORE|AFTER pivot value
+func LInsertCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if n, err := s.Store().LInsert(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewInt(n), nil
+	}
+}
+
+// LREM key count value
+func LRemCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if n, err := s.Store().LRem(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewInt(n), nil
+	}
+}
+
+// RPOPLPUSH source destination
+func RPopLPushCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if v, err := s.Store().RPopLPush(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewBulkBytes(v), nil
+	}
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func LMoveCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if v, err := s.Store().LMove(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewBulkBytes(v), nil
+	}
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func LPosCmd(s Session, args [][]byte) (redis.Resp, error) {
+	a, err := s.Store().LPos(s.DB(), args)
+	if err != nil {
+		return toRespError(err)
+	}
+
+	// reply array only if COUNT is given
+	for i := 2; i < len(args); i += 2 {
+		if strings.ToUpper(string(args[i])) == "COUNT" {
+			resp := redis.NewArray()
+			resp.Value = make([]redis.Resp, 0, len(a))
+			for _, v := range a {
+				resp.AppendInt(v)
+			}
+			return resp, nil
+		}
+	}
+
+	if len(a) == 0 {
+		return redis.NewBulkBytes(nil), nil
+	}
+	return redis.NewInt(a[0]), nil
+}
+
 func init() {
 	Register("lindex", LIndexCmd, CmdReadonly)
+	Register("linsert", LInsertCmd, CmdWrite)
 	Register("llen", LLenCmd, CmdReadonly)
+	Register("lmove", LMoveCmd, CmdWrite)
 	Register("lpop", LPopCmd, CmdWrite)
+	Register("lpos", LPosCmd, CmdReadonly)
 	Register("lpush", LPushCmd, CmdWrite)
 	Register("lpushx", LPushXCmd, CmdWrite)
 	Register("lrange", LRangeCmd, CmdReadonly)
+	Register("lrem", LRemCmd, CmdWrite)
 	Register("lset", LSetCmd, CmdWrite)
 	Register("ltrim", LTrimCmd, CmdWrite)
 	Register("rpop", RPopCmd, CmdWrite)
+	Register("rpoplpush", RPopLPushCmd, CmdWrite)
 	Register("rpush", RPushCmd, CmdWrite)
 	Register("rpushx", RPushXCmd, CmdWrite)
 }
@@ -113,3 +113,48 @@ func (s *testServiceSuite) TestTrim(c *C) {
 	s.checkOK(c, "ltrim", k, 1, 0)
 	s.checkInt(c, 0, "llen", k)
 }
+
+func (s *testServiceSuite) TestLInsert(c *C) {
+	k := randomKey(c)
+	s.checkInt(c, 0, "linsert", k, "before", "a", "x")
+	s.checkInt(c, 3, "rpush", k, "a", "b", "c")
+	s.checkInt(c, 4, "linsert", k, "before", "b", "x")
+	s.checkInt(c, 5, "linsert", k, "after", "c", "y")
+	s.checkInt(c, -1, "linsert", k, "after", "z", "y")
+	s.checkList(c, k, []string{"a", "x", "b", "c", "y"})
+}
+
+func (s *testServiceSuite) TestLRem(c *C) {
+	k := randomKey(c)
+	s.checkInt(c, 0, "lrem", k, 0, "a")
+	s.checkInt(c, 5, "rpush", k, "a", "b", "a", "c", "a")
+	s.checkInt(c, 1, "lrem", k, -1, "a")
+	s.checkList(c, k, []string{"a", "b", "a", "c"})
+	s.checkInt(c, 2, "lrem", k, 0, "a")
+	s.checkList(c, k, []string{"b", "c"})
+}
+
+func (s *testServiceSuite) TestRPopLPush(c *C) {
+	k1, k2 := randomKey(c), randomKey(c)
+	s.checkNil(c, "rpoplpush", k1, k2)
+	s.checkInt(c, 3, "rpush", k1, "a", "b", "c")
+	s.checkString(c, "c", "rpoplpush", k1, k2)
+	s.checkString(c, "a", "lmove", k1, k2, "left", "right")
+	s.checkString(c, "b", "lmove", k1, k2, "LEFT", "LEFT")
+	s.checkList(c, k1, nil)
+	s.checkList(c, k2, []string{"b", "c", "a"})
+	s.checkString(c, "a", "rpoplpush", k2, k2)
+	s.checkList(c, k2, []string{"a", "b", "c"})
+}
+
+func (s *testServiceSuite) TestLPos(c *C) {
+	k := randomKey(c)
+	s.checkNil(c, "lpos", k, "a")
+	s.checkInt(c, 5, "rpush", k, "a", "b", "c", "b", "a")
+	s.checkInt(c, 1, "lpos", k, "b")
+	s.checkInt(c, 3, "lpos", k, "b", "rank", -1)
+	s.checkNil(c, "lpos", k, "z")
+	s.checkIntArray(c, []int64{0, 4}, "lpos", k, "a", "count", 0)
+	s.checkIntArray(c, []int64{}, "lpos", k, "z", "count", 0)
+	s.checkContainError(c, "RANK can't be zero", "lpos", k, "a", "rank", 0)
+}
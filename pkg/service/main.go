@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/juju/errors"
 	"github.com/ngaut/log"
@@ -17,6 +18,7 @@ import (
 	"github.com/reborndb/go/ring"
 	"github.com/reborndb/go/sync2"
 	"github.com/reborndb/qdb/pkg/store"
+	lua "github.com/yuin/gopher-lua"
 )
 
 type Server struct {
@@ -79,8 +81,12 @@ type Handler struct {
 	syncSince atomic2.Int64
 	// replication sync offset
 	syncOffset atomic2.Int64
+	// replication sync db selected at sync offset
+	syncDB atomic2.Int64
 	// replication sync master run ID
-	masterRunID string
+	masterRunID atomic2.String
+	// replication sync keys matched only if not nil
+	replFilter *replFilter
 	// replication master connection
 	master chan *conn
 	// replication slaveof reply channel
@@ -97,18 +103,37 @@ type Handler struct {
 		commands        atomic2.Int64
 		commandsFailed  atomic2.Int64
 		syncRdbRemains  atomic2.Int64
+		syncRdbSize     atomic2.Int64
+		syncRdbEntries  atomic2.Int64
+		syncRdbSince    atomic2.Int64
 		syncCacheBytes  atomic2.Int64
 		syncTotalBytes  atomic2.Int64
 		syncFull        atomic2.Int64
+		syncCheckpoint  atomic2.Int64
+		syncLoadedFiles atomic2.Int64
 		syncPartialOK   atomic2.Int64
 		syncPartialErr  atomic2.Int64
 	}
 
-	// 40 bytes, hex random run id for different server
+	// 40 bytes, hex random run id of the replication stream in backlog,
+	// slave uses the run id of its master, protected by repl lock
 	runID []byte
 
 	bgSaveSem *sync2.Semaphore
 
+	// state of saving snapshots, see INFO persistence
+	rdbSave struct {
+		// unix time of the last successful save
+		lastSave   atomic2.Int64
+		lastStatus atomic2.String
+		// seconds taken by the last save
+		lastTime atomic2.Int64
+
+		// unix time and saved keys of the running save
+		since     atomic2.Int64
+		savedKeys atomic2.Int64
+	}
+
 	repl struct {
 		sync.RWMutex
 
@@ -124,6 +149,50 @@ type Handler struct {
 		lastSelectDB atomic2.Int64
 
 		slaves map[*conn]chan struct{}
+
+		// closed and replaced once a slave acks, see waitSlavesACK
+		ackSignal chan struct{}
+
+		// run id before the last shift, PSYNC with it is still accepted up to prevOffset
+		prevRunID  []byte
+		prevOffset int64
+
+		// db selected at the end of the stream fed by slave
+		streamDB uint32
+
+		// request from master being applied by slave, fed into backlog once committed
+		pendingStream []byte
+		pendingOffset int64
+		pendingDB     uint32
+	}
+
+	// pub/sub subscribers of channels and patterns
+	pubsub struct {
+		sync.RWMutex
+
+		channels map[string]map[*conn]struct{}
+		patterns map[string]map[*conn]struct{}
+	}
+
+	// clients blocked by BLPOP, BRPOP and BRPOPLPUSH, in FIFO order for every key
+	blocking struct {
+		sync.Mutex
+
+		waiters map[blockKey][]*blockWaiter
+	}
+
+	// compiled lua scripts, keyed by sha1 of script
+	scripts struct {
+		sync.RWMutex
+
+		m map[string]*lua.FunctionProto
+	}
+
+	// script holding the store, see runScript
+	script struct {
+		sync.Mutex
+
+		running *runningScript
 	}
 
 	// conn mutex
@@ -144,10 +213,25 @@ func newHandler(c *Config, s *store.Store) (*Handler, error) {
 		conns:        make(map[*conn]struct{}),
 	}
 
+	h.pubsub.channels = make(map[string]map[*conn]struct{})
+	h.pubsub.patterns = make(map[string]map[*conn]struct{})
+	h.scripts.m = make(map[string]*lua.FunctionProto)
+	h.blocking.waiters = make(map[blockKey][]*blockWaiter)
+
+	h.rdbSave.lastSave.Set(time.Now().Unix())
+	h.rdbSave.lastStatus.Set("ok")
+	h.rdbSave.lastTime.Set(-1)
+
 	h.runID = make([]byte, 40)
 	getRandomHex(h.runID)
 	log.Infof("server runid is %s", h.runID)
 
+	f, err := newReplFilter(c.ReplFilterDB, c.ReplFilterSlots)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	h.replFilter = f
+
 	l, err := net.Listen("tcp", h.config.Listen)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -159,6 +243,9 @@ func newHandler(c *Config, s *store.Store) (*Handler, error) {
 		return nil, errors.Trace(err)
 	}
 
+	s.RegPostCommitHandler(h.signalBlockedKeys)
+	s.SetLazyFree(c.LazyFree)
+
 	h.htable = globalCommands
 
 	go h.daemonSyncMaster()
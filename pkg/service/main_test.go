@@ -22,6 +22,7 @@ import (
 	"github.com/reborndb/go/bytesize"
 	"github.com/reborndb/go/pools"
 	redis "github.com/reborndb/go/redis/resp"
+	"github.com/reborndb/qdb/pkg/engine"
 	"github.com/reborndb/qdb/pkg/engine/rocksdb"
 	"github.com/reborndb/qdb/pkg/store"
 	. "gopkg.in/check.v1"
@@ -88,6 +89,13 @@ type testServer struct {
 	h *Handler
 }
 
+func testOpenDB(c *C, dir string) engine.Database {
+	conf := rocksdb.NewDefaultConfig()
+	testdb, err := rocksdb.Open(dir, conf, false)
+	c.Assert(err, IsNil)
+	return testdb
+}
+
 func testCreateServer(c *C, port int) *testServer {
 	base := fmt.Sprintf("/tmp/test_qdb/test_service/%d", port)
 	err := os.RemoveAll(base)
@@ -96,16 +104,20 @@ func testCreateServer(c *C, port int) *testServer {
 	err = os.MkdirAll(base, 0700)
 	c.Assert(err, IsNil)
 
-	conf := rocksdb.NewDefaultConfig()
-	testdb, err := rocksdb.Open(path.Join(base, "db"), conf, false)
-	c.Assert(err, IsNil)
+	return testOpenServer(c, port)
+}
 
-	store := store.New(testdb)
+// open server with data left by the last server of the same port
+func testOpenServer(c *C, port int) *testServer {
+	base := fmt.Sprintf("/tmp/test_qdb/test_service/%d", port)
+	store := store.New(testOpenDB(c, path.Join(base, "db")))
 
 	cfg := NewDefaultConfig()
 	cfg.Listen = fmt.Sprintf("127.0.0.1:%d", port)
 	cfg.DumpPath = path.Join(base, "rdb.dump")
 	cfg.SyncFilePath = path.Join(base, "sync.pipe")
+	cfg.SyncCheckpointPath = path.Join(base, "sync.checkpoint")
+	cfg.ReplBacklogFilePath = path.Join(base, "repl.backlog")
 	cfg.ReplBacklogSize = bytesize.MB
 
 	h, err := newHandler(cfg, store)
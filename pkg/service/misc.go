@@ -8,8 +8,11 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/juju/errors"
 	redis "github.com/reborndb/go/redis/resp"
@@ -68,10 +71,14 @@ func EchoCmd(s Session, args [][]byte) (redis.Resp, error) {
 	return redis.NewBulkBytes(args[0]), nil
 }
 
-// FLUSHALL
+// FLUSHALL [ASYNC]
+// Store drops its files instead of deleting keys one by one, so ASYNC is the same.
 func FlushAllCmd(s Session, args [][]byte) (redis.Resp, error) {
-	if len(args) != 0 {
-		return toRespErrorf("len(args) = %d, expect = 0", len(args))
+	if len(args) > 1 {
+		return toRespErrorf("len(args) = %d, expect <= 1", len(args))
+	}
+	if len(args) == 1 && strings.ToLower(string(args[0])) != "async" {
+		return toRespErrorf("syntax error")
 	}
 
 	if err := s.Store().Reset(); err != nil {
@@ -136,16 +143,18 @@ func InfoCmd(s Session, args [][]byte) (redis.Resp, error) {
 
 	switch section {
 	case "database":
-		c.h.infoDataBase(&b)
+		c.h.infoDataBase(&b, c.Store())
 	case "config":
 		c.h.infoConfig(&b)
 	case "clients":
 		c.h.infoClients(&b)
+	case "persistence":
+		c.h.infoPersistence(&b)
 	case "replication":
 		c.h.infoReplication(&b)
 	default:
 		// all
-		c.h.infoAll(&b)
+		c.h.infoAll(&b, c.Store())
 	}
 
 	fmt.Fprintf(&b, "\r\n")
@@ -153,13 +162,15 @@ func InfoCmd(s Session, args [][]byte) (redis.Resp, error) {
 	return redis.NewBulkBytes(b.Bytes()), nil
 }
 
-func (h *Handler) infoAll(w io.Writer) {
-	h.infoDataBase(w)
+func (h *Handler) infoAll(w io.Writer, s *store.Store) {
+	h.infoDataBase(w, s)
 	fmt.Fprintf(w, "\r\n")
 	h.infoConfig(w)
 	fmt.Fprintf(w, "\r\n")
 	h.infoClients(w)
 	fmt.Fprintf(w, "\r\n")
+	h.infoPersistence(w)
+	fmt.Fprintf(w, "\r\n")
 	h.infoReplication(w)
 }
 
@@ -168,8 +179,9 @@ func (h *Handler) infoConfig(w io.Writer) {
 	fmt.Fprintf(w, "%s\r\n", h.config)
 }
 
-func (h *Handler) infoDataBase(w io.Writer) {
-	v, _ := h.store.Info()
+// s is the store of the session, it is locked already in transaction
+func (h *Handler) infoDataBase(w io.Writer, s *store.Store) {
+	v, _ := s.Info()
 
 	fmt.Fprintf(w, "# Database\r\n")
 	fmt.Fprintf(w, "%s\r\n", v)
@@ -185,6 +197,47 @@ func (h *Handler) infoClients(w io.Writer) {
 
 }
 
+func (h *Handler) infoPersistence(w io.Writer) {
+	inProgress, current := 0, int64(-1)
+	if h.counters.bgsave.Get() != 0 {
+		inProgress, current = 1, time.Now().Unix()-h.rdbSave.since.Get()
+	}
+
+	fmt.Fprintf(w, "# Persistence\r\n")
+	fmt.Fprintf(w, "rdb_bgsave_in_progress:%d\r\n", inProgress)
+	fmt.Fprintf(w, "rdb_last_save_time:%d\r\n", h.rdbSave.lastSave.Get())
+	fmt.Fprintf(w, "rdb_last_bgsave_status:%s\r\n", h.rdbSave.lastStatus.Get())
+	fmt.Fprintf(w, "rdb_last_bgsave_time_sec:%d\r\n", h.rdbSave.lastTime.Get())
+	fmt.Fprintf(w, "rdb_current_bgsave_time_sec:%d\r\n", current)
+	fmt.Fprintf(w, "rdb_current_bgsave_keys:%d\r\n", h.rdbSave.savedKeys.Get())
+}
+
+// list slaves ordered by address, must be called with repl lock held
+func (h *Handler) infoSlaves(w io.Writer) {
+	slaves := make(map[string]*conn, len(h.repl.slaves))
+	addrs := make([]string, 0, len(h.repl.slaves))
+	for slave, _ := range h.repl.slaves {
+		if addr := slave.nc.RemoteAddr(); addr != nil {
+			slaves[addr.String()] = slave
+			addrs = append(addrs, addr.String())
+		}
+	}
+	sort.Strings(addrs)
+
+	fmt.Fprintf(w, "connected_slaves:%d\r\n", len(addrs))
+	now := time.Now().Unix()
+	for i, addr := range addrs {
+		slave := slaves[addr]
+		ip, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			ip = addr
+		}
+		// slaves are online after full sync
+		fmt.Fprintf(w, "slave%d:ip=%s,port=%d,state=online,offset=%d,lag=%d\r\n", i, ip,
+			slave.listeningPort.Get(), slave.backlogACKOffset.Get(), now-slave.backlogACKTime.Get())
+	}
+}
+
 func (h *Handler) infoReplication(w io.Writer) {
 	fmt.Fprintf(w, "# Replication\r\n")
 
@@ -197,26 +250,20 @@ func (h *Handler) infoReplication(w io.Writer) {
 		h.repl.RLock()
 		defer h.repl.RUnlock()
 
-		fmt.Fprintf(w, "master_repl_offset:%d\r\n", h.repl.masterOffset)
-		if h.repl.backlogBuf == nil {
-			fmt.Fprintf(w, "repl_backlog_active:0\r\n")
-		} else {
-			fmt.Fprintf(w, "repl_backlog_active:1\r\n")
-			fmt.Fprintf(w, "repl_backlog_size:%d\r\n", h.repl.backlogBuf.Size())
-			fmt.Fprintf(w, "repl_backlog_first_byte_offset:%d\r\n", h.repl.backlogOffset)
-			fmt.Fprintf(w, "repl_backlog_histlen:%d\r\n", h.repl.backlogBuf.Len())
-		}
-
-		slaves := make([]string, 0, len(h.repl.slaves))
-		for slave, _ := range h.repl.slaves {
-			if addr := slave.nc.RemoteAddr(); addr != nil {
-				slaves = append(slaves, addr.String())
-			}
+		h.infoBacklog(w)
+		h.infoSlaves(w)
+		if h.config.MinSlavesToWrite > 0 && h.config.MinSlavesMaxLag > 0 {
+			fmt.Fprintf(w, "min_slaves_good_slaves:%d\r\n", h.goodSlaves(int64(h.config.MinSlavesMaxLag)))
 		}
-		fmt.Fprintf(w, "slaves:%s\r\n", strings.Join(slaves, ","))
+		fmt.Fprintf(w, "sync_full:%d\r\n", h.counters.syncFull.Get())
+		fmt.Fprintf(w, "sync_full_checkpoint:%d\r\n", h.counters.syncCheckpoint.Get())
 	} else {
 		fmt.Fprintf(w, "role:slave\r\n")
 		fmt.Fprintf(w, "sync_rdb_remains:%d\r\n", h.counters.syncRdbRemains.Get())
+		fmt.Fprintf(w, "sync_rdb_size:%d\r\n", h.counters.syncRdbSize.Get())
+		fmt.Fprintf(w, "sync_rdb_loaded_entries:%d\r\n", h.counters.syncRdbEntries.Get())
+		fmt.Fprintf(w, "sync_rdb_eta_seconds:%d\r\n", h.syncRDBETA())
+		fmt.Fprintf(w, "sync_checkpoint_loaded_files:%d\r\n", h.counters.syncLoadedFiles.Get())
 		fmt.Fprintf(w, "sync_cache_bytes:%d\r\n", h.counters.syncCacheBytes.Get())
 		fmt.Fprintf(w, "sync_total_bytes:%d\r\n", h.counters.syncTotalBytes.Get())
 		fmt.Fprintf(w, "slaveof:%s\r\n", h.masterAddr.Get())
@@ -229,6 +276,34 @@ func (h *Handler) infoReplication(w io.Writer) {
 		// now all slaves have same priority
 		fmt.Fprintf(w, "slave_priority:100\r\n")
 		fmt.Fprintf(w, "slave_repl_offset:%d\r\n", h.syncOffset.Get())
+		if h.replFilter != nil {
+			fmt.Fprintf(w, "slave_repl_filter:%s\r\n", h.replFilter)
+		}
+
+		// sub-slaves sync the stream from master kept in our backlog
+		h.repl.RLock()
+		defer h.repl.RUnlock()
+
+		h.infoBacklog(w)
+		h.infoSlaves(w)
+	}
+}
+
+// must be called with repl lock held
+func (h *Handler) infoBacklog(w io.Writer) {
+	fmt.Fprintf(w, "master_run_id:%s\r\n", h.runID)
+	if h.repl.prevRunID != nil {
+		fmt.Fprintf(w, "master_prev_run_id:%s\r\n", h.repl.prevRunID)
+		fmt.Fprintf(w, "master_prev_run_id_offset:%d\r\n", h.repl.prevOffset)
+	}
+	fmt.Fprintf(w, "master_repl_offset:%d\r\n", h.repl.masterOffset)
+	if h.repl.backlogBuf == nil {
+		fmt.Fprintf(w, "repl_backlog_active:0\r\n")
+	} else {
+		fmt.Fprintf(w, "repl_backlog_active:1\r\n")
+		fmt.Fprintf(w, "repl_backlog_size:%d\r\n", h.repl.backlogBuf.Size())
+		fmt.Fprintf(w, "repl_backlog_first_byte_offset:%d\r\n", h.repl.backlogOffset)
+		fmt.Fprintf(w, "repl_backlog_histlen:%d\r\n", h.repl.backlogBuf.Len())
 	}
 }
 
@@ -282,5 +357,5 @@ func init() {
 	Register("info", InfoCmd, CmdReadonly)
 	Register("ping", PingCmd, CmdReadonly)
 	Register("select", SelectCmd, CmdReadonly)
-	Register("shutdown", ShutdownCmd, CmdWrite)
+	Register("shutdown", ShutdownCmd, CmdWrite|CmdNoMulti)
 }
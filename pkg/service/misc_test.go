@@ -21,6 +21,10 @@ func (s *testServiceSuite) TestFlushAll(c *C) {
 	s.checkString(c, "hello world", "get", k)
 	s.checkOK(c, "flushall")
 	s.checkNil(c, "get", k)
+	s.checkOK(c, "set", k, "a")
+	s.checkOK(c, "flushall", "async")
+	s.checkNil(c, "get", k)
+	s.checkContainError(c, "syntax error", "flushall", "sync")
 }
 
 func (s *testServiceSuite) TestAuth(c *C) {
@@ -0,0 +1,233 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	redis "github.com/reborndb/go/redis/resp"
+	"github.com/reborndb/qdb/pkg/store"
+)
+
+type queuedCommand struct {
+	name string
+	f    *command
+	args [][]byte
+}
+
+type multiState struct {
+	cmds []*queuedCommand
+
+	// set if any command failed to queue, EXEC will abort
+	dirty bool
+}
+
+type watchedKey struct {
+	db      uint32
+	key     []byte
+	version uint64
+}
+
+func (c *conn) queueCommand(name string, f *command, args [][]byte) (redis.Resp, error) {
+	switch name {
+	case "exec", "discard", "multi", "watch":
+		// these commands check transaction state by themselves
+		return f.f(c, args)
+	}
+
+	if f.flag&CmdNoMulti > 0 {
+		c.abortMulti()
+		return toRespErrorf("command %s is not allowed in MULTI", name)
+	}
+
+	c.multi.cmds = append(c.multi.cmds, &queuedCommand{name, f, args})
+	return redis.NewString("QUEUED"), nil
+}
+
+func (m *multiState) hasWrite() bool {
+	for _, q := range m.cmds {
+		if q.f.flag&CmdWrite > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// mark transaction dirty if in MULTI
+func (c *conn) abortMulti() {
+	if c.multi != nil {
+		c.multi.dirty = true
+	}
+}
+
+func (c *conn) unwatchAll() {
+	for _, w := range c.watched {
+		if err := c.Store().Unwatch(w.db, w.key); err != nil {
+			log.Warningf("unwatch key failed - %s", err)
+		}
+	}
+	c.watched = nil
+}
+
+// run queued commands with store locked, returns nil if any watched key has been modified
+func (c *conn) execMulti(m *multiState) (*redis.Array, error) {
+	var resp *redis.Array
+	err := c.h.store.Multi(func(tx *store.Store) error {
+		for _, w := range c.watched {
+			if v, err := tx.WatchVersion(w.db, w.key); err != nil {
+				return errors.Trace(err)
+			} else if v != w.version {
+				return nil
+			}
+		}
+
+		c.tx = tx
+		defer func() {
+			c.tx = nil
+		}()
+
+		resp = redis.NewArray()
+		resp.Value = make([]redis.Resp, 0, len(m.cmds))
+		for _, q := range m.cmds {
+			r, err := q.f.f(c, q.args)
+			if err != nil {
+				log.Warningf("exec command %s in transaction failed, conn = %s, err = %s", q.name, c, err)
+				if r == nil {
+					r = redis.NewError(err)
+				}
+			}
+			if r == nil {
+				r = redis.NewBulkBytes(nil)
+			}
+			resp.Append(r)
+		}
+		return nil
+	})
+	return resp, errors.Trace(err)
+}
+
+// MULTI
+func MultiCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) != 0 {
+		return toRespErrorf("len(args) = %d, expect = 0", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	if c.multi != nil {
+		return toRespErrorf("MULTI calls can not be nested")
+	}
+
+	c.multi = &multiState{}
+	return redis.NewString("OK"), nil
+}
+
+// EXEC
+func ExecCmd(s Session, args [][]byte) (redis.Resp, error) {
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	if c.multi == nil {
+		return toRespErrorf("EXEC without MULTI")
+	}
+
+	m := c.multi
+	c.multi = nil
+	defer c.unwatchAll()
+
+	if len(args) != 0 {
+		return toRespErrorf("len(args) = %d, expect = 0", len(args))
+	}
+
+	if m.dirty {
+		return toRespErrorf("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	// good slaves may be lost after commands are queued
+	if len(c.h.masterAddr.Get()) == 0 && m.hasWrite() && !c.h.enoughGoodSlaves() {
+		return toRespErrorf("NOREPLICAS Not enough good slaves to write.")
+	}
+
+	if resp, err := c.execMulti(m); err != nil {
+		return toRespError(err)
+	} else if resp == nil {
+		// watched keys modified, return nil array
+		return redis.NewArray(), nil
+	} else {
+		return resp, nil
+	}
+}
+
+// DISCARD
+func DiscardCmd(s Session, args [][]byte) (redis.Resp, error) {
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	if c.multi == nil {
+		return toRespErrorf("DISCARD without MULTI")
+	}
+
+	c.multi = nil
+	c.unwatchAll()
+
+	if len(args) != 0 {
+		return toRespErrorf("len(args) = %d, expect = 0", len(args))
+	}
+	return redis.NewString("OK"), nil
+}
+
+// WATCH key [key ...]
+func WatchCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) == 0 {
+		return toRespErrorf("len(args) = %d, expect != 0", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	if c.multi != nil {
+		return toRespErrorf("WATCH inside MULTI is not allowed")
+	}
+
+	for _, key := range args {
+		v, err := c.Store().Watch(c.db, key)
+		if err != nil {
+			return toRespError(err)
+		}
+		c.watched = append(c.watched, &watchedKey{c.db, key, v})
+	}
+	return redis.NewString("OK"), nil
+}
+
+// UNWATCH
+func UnwatchCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) != 0 {
+		return toRespErrorf("len(args) = %d, expect = 0", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	c.unwatchAll()
+	return redis.NewString("OK"), nil
+}
+
+func init() {
+	Register("discard", DiscardCmd, CmdReadonly)
+	Register("exec", ExecCmd, CmdReadonly)
+	Register("multi", MultiCmd, CmdReadonly)
+	Register("unwatch", UnwatchCmd, CmdReadonly)
+	Register("watch", WatchCmd, CmdReadonly)
+}
@@ -0,0 +1,122 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	redis "github.com/reborndb/go/redis/resp"
+	. "gopkg.in/check.v1"
+)
+
+func (pc *testPoolConn) checkExec(c *C, n int) []redis.Resp {
+	resp := pc.doCmd(c, "exec")
+	ay, ok := resp.(*redis.Array)
+	c.Assert(ok, Equals, true)
+	c.Assert(ay.Value, NotNil)
+	c.Assert(ay.Value, HasLen, n)
+	return ay.Value
+}
+
+func (s *testServiceSuite) TestMulti(c *C) {
+	k := randomKey(c)
+
+	nc := s.getConn(c)
+	defer nc.Recycle()
+
+	nc.checkContainError(c, "EXEC without MULTI", "exec")
+	nc.checkContainError(c, "DISCARD without MULTI", "discard")
+
+	nc.checkOK(c, "multi")
+	nc.checkContainError(c, "MULTI calls can not be nested", "multi")
+	nc.checkString(c, "QUEUED", "set", k, 1)
+	nc.checkString(c, "QUEUED", "incrby", k, 10)
+	nc.checkString(c, "QUEUED", "get", k)
+	nc.checkString(c, "QUEUED", "hget", k, "f")
+
+	ay := nc.checkExec(c, 4)
+	c.Assert(ay[0], DeepEquals, redis.NewString("OK"))
+	c.Assert(ay[1], DeepEquals, redis.NewInt(11))
+	c.Assert(ay[2], DeepEquals, redis.NewBulkBytes([]byte("11")))
+	_, ok := ay[3].(*redis.Error)
+	c.Assert(ok, Equals, true)
+
+	nc.checkOK(c, "multi")
+	nc.checkString(c, "QUEUED", "set", k, 100)
+	nc.checkOK(c, "discard")
+	nc.checkString(c, "11", "get", k)
+
+	nc.checkOK(c, "multi")
+	nc.checkString(c, "QUEUED", "set", k, 100)
+	nc.checkContainError(c, "unknown command", "unknowncmd")
+	nc.checkContainError(c, "not allowed", "sync")
+	nc.checkContainError(c, "EXECABORT", "exec")
+	nc.checkString(c, "11", "get", k)
+
+	// empty transaction
+	nc.checkOK(c, "multi")
+	nc.checkExec(c, 0)
+
+	// commands using the store by themselves would wait for the store locked by EXEC
+	nc.checkOK(c, "multi")
+	nc.checkString(c, "QUEUED", "info")
+	ay = nc.checkExec(c, 1)
+	_, ok = ay[0].(*redis.BulkBytes)
+	c.Assert(ok, Equals, true)
+	s.checkString(c, "11", "get", k)
+
+	nc.checkOK(c, "multi")
+	nc.checkContainError(c, "not allowed", "shutdown")
+	nc.checkContainError(c, "not allowed", "slaveof", "no", "one")
+	nc.checkContainError(c, "not allowed", "save")
+	nc.checkContainError(c, "not allowed", "bgsaveto", "/tmp/dump.rdb")
+	nc.checkContainError(c, "EXECABORT", "exec")
+}
+
+func (s *testServiceSuite) TestWatch(c *C) {
+	k := randomKey(c)
+
+	nc := s.getConn(c)
+	defer nc.Recycle()
+
+	nc.checkOK(c, "set", k, 1)
+
+	// key modified by other connection
+	nc.checkOK(c, "watch", k)
+	s.checkOK(c, "set", k, 2)
+	nc.checkOK(c, "multi")
+	nc.checkContainError(c, "WATCH inside MULTI", "watch", k)
+	nc.checkString(c, "QUEUED", "set", k, 3)
+	nc.checkNil(c, "exec")
+	nc.checkString(c, "2", "get", k)
+
+	// key not modified
+	nc.checkOK(c, "watch", k)
+	nc.checkOK(c, "multi")
+	nc.checkString(c, "QUEUED", "set", k, 3)
+	nc.checkExec(c, 1)
+	nc.checkString(c, "3", "get", k)
+
+	// watch is cleared after EXEC
+	s.checkOK(c, "set", k, 4)
+	nc.checkOK(c, "multi")
+	nc.checkString(c, "QUEUED", "incr", k)
+	nc.checkExec(c, 1)
+	nc.checkString(c, "5", "get", k)
+
+	// unwatch
+	nc.checkOK(c, "watch", k)
+	nc.checkOK(c, "unwatch")
+	s.checkOK(c, "set", k, 6)
+	nc.checkOK(c, "multi")
+	nc.checkString(c, "QUEUED", "incr", k)
+	nc.checkExec(c, 1)
+	nc.checkString(c, "7", "get", k)
+
+	// deleted key
+	nc.checkOK(c, "watch", k)
+	s.checkInt(c, 1, "del", k)
+	nc.checkOK(c, "multi")
+	nc.checkString(c, "QUEUED", "set", k, 1)
+	nc.checkNil(c, "exec")
+	nc.checkInt(c, 0, "exists", k)
+}
@@ -0,0 +1,386 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	redis "github.com/reborndb/go/redis/resp"
+	"github.com/reborndb/qdb/pkg/store"
+)
+
+// max pending messages of a subscriber, slow subscriber will be disconnected
+const maxPushQueueSize = 1024
+
+// commands allowed when connection has subscribed any channel or pattern
+var subscribedModeCommands = map[string]bool{
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"ping":         true,
+}
+
+func (c *conn) isSubscribed() bool {
+	return len(c.channels)+len(c.patterns) > 0
+}
+
+func (c *conn) subscriptions() int64 {
+	return int64(len(c.channels) + len(c.patterns))
+}
+
+// once started, all replies of this connection are sent by pushLoop to keep them in order with messages
+func (c *conn) startPushLoop() {
+	if c.pushQueue != nil {
+		return
+	}
+	c.pushQueue = make(chan redis.Resp, maxPushQueueSize)
+	go c.pushLoop(c.pushQueue)
+}
+
+func (c *conn) pushLoop(q chan redis.Resp) {
+	var err error
+	for resp := range q {
+		if err != nil {
+			// drain the queue until it is closed
+			continue
+		}
+		if err = c.writeRESP(resp); err != nil {
+			log.Warningf("push to conn %s failed - %s", c, err)
+			c.nc.Close()
+		}
+	}
+}
+
+// queue reply of the connection itself, blocks if queue is full
+func (c *conn) pushReply(resp redis.Resp) {
+	c.pushQueue <- resp
+}
+
+// queue published message, never blocks the publisher
+func (c *conn) pushMessage(resp redis.Resp) {
+	select {
+	case c.pushQueue <- resp:
+	default:
+		log.Warningf("conn %s has too many pending messages, close it", c)
+		c.nc.Close()
+	}
+}
+
+func newPushResp(kind string, values ...interface{}) redis.Resp {
+	resp := redis.NewArray()
+	resp.AppendBulkBytes([]byte(kind))
+	for _, v := range values {
+		switch x := v.(type) {
+		case nil:
+			resp.AppendBulkBytes(nil)
+		case []byte:
+			resp.AppendBulkBytes(x)
+		case string:
+			resp.AppendBulkBytes([]byte(x))
+		case int64:
+			resp.AppendInt(x)
+		}
+	}
+	return resp
+}
+
+func addSubscriber(m map[string]map[*conn]struct{}, name string, c *conn) {
+	conns := m[name]
+	if conns == nil {
+		conns = make(map[*conn]struct{})
+		m[name] = conns
+	}
+	conns[c] = struct{}{}
+}
+
+func removeSubscriber(m map[string]map[*conn]struct{}, name string, c *conn) {
+	if conns := m[name]; conns != nil {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(m, name)
+		}
+	}
+}
+
+func (c *conn) subscribe(channel string) {
+	if _, ok := c.channels[channel]; !ok {
+		c.h.pubsub.Lock()
+		addSubscriber(c.h.pubsub.channels, channel, c)
+		c.h.pubsub.Unlock()
+
+		if c.channels == nil {
+			c.channels = make(map[string]struct{})
+		}
+		c.channels[channel] = struct{}{}
+	}
+	c.pushReply(newPushResp("subscribe", channel, c.subscriptions()))
+}
+
+func (c *conn) unsubscribe(channel string) {
+	if _, ok := c.channels[channel]; ok {
+		c.h.pubsub.Lock()
+		removeSubscriber(c.h.pubsub.channels, channel, c)
+		c.h.pubsub.Unlock()
+
+		delete(c.channels, channel)
+	}
+	c.pushReply(newPushResp("unsubscribe", channel, c.subscriptions()))
+}
+
+func (c *conn) psubscribe(pattern string) {
+	if _, ok := c.patterns[pattern]; !ok {
+		c.h.pubsub.Lock()
+		addSubscriber(c.h.pubsub.patterns, pattern, c)
+		c.h.pubsub.Unlock()
+
+		if c.patterns == nil {
+			c.patterns = make(map[string]struct{})
+		}
+		c.patterns[pattern] = struct{}{}
+	}
+	c.pushReply(newPushResp("psubscribe", pattern, c.subscriptions()))
+}
+
+func (c *conn) punsubscribe(pattern string) {
+	if _, ok := c.patterns[pattern]; ok {
+		c.h.pubsub.Lock()
+		removeSubscriber(c.h.pubsub.patterns, pattern, c)
+		c.h.pubsub.Unlock()
+
+		delete(c.patterns, pattern)
+	}
+	c.pushReply(newPushResp("punsubscribe", pattern, c.subscriptions()))
+}
+
+// remove all subscriptions and stop pushing, called when connection is closed
+func (c *conn) unsubscribeAll() {
+	if c.pushQueue == nil {
+		return
+	}
+
+	c.h.pubsub.Lock()
+	for channel := range c.channels {
+		removeSubscriber(c.h.pubsub.channels, channel, c)
+	}
+	for pattern := range c.patterns {
+		removeSubscriber(c.h.pubsub.patterns, pattern, c)
+	}
+	c.h.pubsub.Unlock()
+
+	c.channels, c.patterns = nil, nil
+
+	// no publisher can see this connection now
+	close(c.pushQueue)
+	c.pushQueue = nil
+}
+
+// send message to subscribers, returns the number of receivers
+func (h *Handler) publish(channel []byte, message []byte) int64 {
+	h.pubsub.RLock()
+	defer h.pubsub.RUnlock()
+
+	var n int64
+	if conns := h.pubsub.channels[string(channel)]; len(conns) != 0 {
+		resp := newPushResp("message", channel, message)
+		for c := range conns {
+			c.pushMessage(resp)
+			n++
+		}
+	}
+
+	for pattern, conns := range h.pubsub.patterns {
+		if !store.MatchPattern([]byte(pattern), channel) {
+			continue
+		}
+		resp := newPushResp("pmessage", pattern, channel, message)
+		for c := range conns {
+			c.pushMessage(resp)
+			n++
+		}
+	}
+	return n
+}
+
+// PUBLISH channel message
+func PublishCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) != 2 {
+		return toRespErrorf("len(args) = %d, expect = 2", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	n := c.h.publish(args[0], args[1])
+
+	// propagate to slaves, so subscribers on slaves can receive the message too,
+	// slave passes messages from master through with the stream after applying
+	// each request, and its own messages are not propagated, the same as redis
+	if len(c.h.masterAddr.Get()) == 0 {
+		f := &store.Forward{DB: uint32(c.h.repl.lastSelectDB.Get()), Op: "PUBLISH", Args: args}
+		if err := c.h.replicationFeedSlaves(f); err != nil {
+			log.Warningf("feed publish to slaves failed - %s", err)
+		}
+	}
+
+	return redis.NewInt(n), nil
+}
+
+// SUBSCRIBE channel [channel ...]
+func SubscribeCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) == 0 {
+		return toRespErrorf("len(args) = %d, expect != 0", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	c.startPushLoop()
+	for _, channel := range args {
+		c.subscribe(string(channel))
+	}
+	return nil, nil
+}
+
+// UNSUBSCRIBE [channel ...]
+func UnsubscribeCmd(s Session, args [][]byte) (redis.Resp, error) {
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	c.startPushLoop()
+	channels := make([]string, 0, len(args))
+	for _, channel := range args {
+		channels = append(channels, string(channel))
+	}
+	if len(args) == 0 {
+		for channel := range c.channels {
+			channels = append(channels, channel)
+		}
+		if len(channels) == 0 {
+			c.pushReply(newPushResp("unsubscribe", nil, c.subscriptions()))
+		}
+	}
+
+	for _, channel := range channels {
+		c.unsubscribe(channel)
+	}
+	return nil, nil
+}
+
+// PSUBSCRIBE pattern [pattern ...]
+func PSubscribeCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) == 0 {
+		return toRespErrorf("len(args) = %d, expect != 0", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	c.startPushLoop()
+	for _, pattern := range args {
+		c.psubscribe(string(pattern))
+	}
+	return nil, nil
+}
+
+// PUNSUBSCRIBE [pattern ...]
+func PUnsubscribeCmd(s Session, args [][]byte) (redis.Resp, error) {
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	c.startPushLoop()
+	patterns := make([]string, 0, len(args))
+	for _, pattern := range args {
+		patterns = append(patterns, string(pattern))
+	}
+	if len(args) == 0 {
+		for pattern := range c.patterns {
+			patterns = append(patterns, pattern)
+		}
+		if len(patterns) == 0 {
+			c.pushReply(newPushResp("punsubscribe", nil, c.subscriptions()))
+		}
+	}
+
+	for _, pattern := range patterns {
+		c.punsubscribe(pattern)
+	}
+	return nil, nil
+}
+
+// PUBSUB CHANNELS [pattern]
+// PUBSUB NUMSUB [channel ...]
+// PUBSUB NUMPAT
+func PubSubCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) == 0 {
+		return toRespErrorf("len(args) = %d, expect != 0", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	h := c.h
+	h.pubsub.RLock()
+	defer h.pubsub.RUnlock()
+
+	switch sub := strings.ToLower(string(args[0])); sub {
+	case "channels":
+		if len(args) > 2 {
+			return toRespErrorf("len(args) = %d, expect <= 2", len(args))
+		}
+		var channels []string
+		for channel := range h.pubsub.channels {
+			if len(args) == 1 || store.MatchPattern(args[1], []byte(channel)) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+
+		resp := redis.NewArray()
+		resp.Value = make([]redis.Resp, 0, len(channels))
+		for _, channel := range channels {
+			resp.AppendBulkBytes([]byte(channel))
+		}
+		return resp, nil
+	case "numsub":
+		resp := redis.NewArray()
+		resp.Value = make([]redis.Resp, 0, (len(args)-1)*2)
+		for _, channel := range args[1:] {
+			resp.AppendBulkBytes(channel)
+			resp.AppendInt(int64(len(h.pubsub.channels[string(channel)])))
+		}
+		return resp, nil
+	case "numpat":
+		if len(args) != 1 {
+			return toRespErrorf("len(args) = %d, expect = 1", len(args))
+		}
+		return redis.NewInt(int64(len(h.pubsub.patterns))), nil
+	default:
+		return toRespErrorf("unknown PUBSUB subcommand %s", sub)
+	}
+}
+
+func init() {
+	Register("psubscribe", PSubscribeCmd, CmdReadonly|CmdNoMulti)
+	Register("publish", PublishCmd, CmdReadonly)
+	Register("pubsub", PubSubCmd, CmdReadonly)
+	Register("punsubscribe", PUnsubscribeCmd, CmdReadonly|CmdNoMulti)
+	Register("subscribe", SubscribeCmd, CmdReadonly|CmdNoMulti)
+	Register("unsubscribe", UnsubscribeCmd, CmdReadonly|CmdNoMulti)
+}
@@ -0,0 +1,133 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"bufio"
+	"time"
+
+	redis "github.com/reborndb/go/redis/resp"
+	. "gopkg.in/check.v1"
+)
+
+// connection in subscribed mode, keeps the reader so pushed messages are not lost
+type testSubConn struct {
+	*testConn
+	r *bufio.Reader
+}
+
+func newTestSubConn(c *C, port int) *testSubConn {
+	tc := testCreateConn(port)
+	c.Assert(tc, NotNil)
+	return &testSubConn{tc, bufio.NewReader(tc)}
+}
+
+func (sc *testSubConn) send(c *C, cmd string, args ...interface{}) {
+	w := bufio.NewWriter(sc)
+	err := redis.Encode(w, redis.NewRequest(cmd, args...))
+	c.Assert(err, IsNil)
+	err = w.Flush()
+	c.Assert(err, IsNil)
+}
+
+func (sc *testSubConn) recv(c *C) redis.Resp {
+	err := sc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	c.Assert(err, IsNil)
+	resp, err := redis.Decode(sc.r)
+	c.Assert(err, IsNil)
+	return resp
+}
+
+// check pushed array, string expects bulk bytes and int expects integer
+func (sc *testSubConn) checkRecv(c *C, expect ...interface{}) {
+	resp := sc.recv(c)
+	v, ok := resp.(*redis.Array)
+	c.Assert(ok, Equals, true)
+	c.Assert(v.Value, HasLen, len(expect))
+	for i, e := range expect {
+		switch x := e.(type) {
+		case string:
+			c.Assert(v.Value[i], DeepEquals, redis.NewBulkBytesWithString(x))
+		case int:
+			c.Assert(v.Value[i], DeepEquals, redis.NewInt(int64(x)))
+		case nil:
+			c.Assert(v.Value[i], DeepEquals, redis.NewBulkBytes(nil))
+		}
+	}
+}
+
+func (s *testServiceSuite) TestPubSub(c *C) {
+	sc := newTestSubConn(c, 16380)
+	defer sc.Close()
+
+	sc.send(c, "SUBSCRIBE", "news", "sport")
+	sc.checkRecv(c, "subscribe", "news", 1)
+	sc.checkRecv(c, "subscribe", "sport", 2)
+	sc.send(c, "PSUBSCRIBE", "new*")
+	sc.checkRecv(c, "psubscribe", "new*", 3)
+
+	s.checkInt(c, 2, "PUBLISH", "news", "hello")
+	sc.checkRecv(c, "message", "news", "hello")
+	sc.checkRecv(c, "pmessage", "new*", "news", "hello")
+	s.checkInt(c, 1, "PUBLISH", "sport", "ball")
+	sc.checkRecv(c, "message", "sport", "ball")
+	s.checkInt(c, 1, "PUBLISH", "newyork", "hi")
+	sc.checkRecv(c, "pmessage", "new*", "newyork", "hi")
+	s.checkInt(c, 0, "PUBLISH", "none", "hi")
+
+	c.Assert(s.checkBytesArray(c, "PUBSUB", "CHANNELS"), DeepEquals, [][]byte{[]byte("news"), []byte("sport")})
+	c.Assert(s.checkBytesArray(c, "PUBSUB", "CHANNELS", "s*"), DeepEquals, [][]byte{[]byte("sport")})
+	s.checkInt(c, 1, "PUBSUB", "NUMPAT")
+	nc := s.getConn(c)
+	resp := nc.doCmd(c, "PUBSUB", "NUMSUB", "news", "none")
+	nc.Recycle()
+	c.Assert(resp, DeepEquals, redis.Resp(&redis.Array{Value: []redis.Resp{
+		redis.NewBulkBytesWithString("news"), redis.NewInt(1),
+		redis.NewBulkBytesWithString("none"), redis.NewInt(0),
+	}}))
+
+	// only subscribe commands are allowed in subscribed mode
+	sc.send(c, "GET", "a")
+	resp = sc.recv(c)
+	c.Assert(resp, FitsTypeOf, (*redis.Error)(nil))
+	sc.send(c, "PING")
+	c.Assert(sc.recv(c), DeepEquals, redis.Resp(redis.NewString("PONG")))
+
+	sc.send(c, "UNSUBSCRIBE", "news")
+	sc.checkRecv(c, "unsubscribe", "news", 2)
+	sc.send(c, "PUNSUBSCRIBE")
+	sc.checkRecv(c, "punsubscribe", "new*", 1)
+	s.checkInt(c, 0, "PUBLISH", "news", "hello")
+	sc.send(c, "UNSUBSCRIBE")
+	sc.checkRecv(c, "unsubscribe", "sport", 0)
+	sc.send(c, "UNSUBSCRIBE")
+	sc.checkRecv(c, "unsubscribe", nil, 0)
+
+	// leave subscribed mode
+	sc.send(c, "SET", "pubsub_key", "1")
+	c.Assert(sc.recv(c), DeepEquals, redis.Resp(redis.NewString("OK")))
+	s.checkInt(c, 1, "DEL", "pubsub_key")
+
+	c.Assert(s.checkBytesArray(c, "PUBSUB", "CHANNELS"), HasLen, 0)
+	s.checkInt(c, 0, "PUBSUB", "NUMPAT")
+
+	// subscriptions are removed when connection is closed
+	sc2 := newTestSubConn(c, 16380)
+	sc2.send(c, "SUBSCRIBE", "news")
+	sc2.checkRecv(c, "subscribe", "news", 1)
+	sc2.Close()
+	for i := 0; i < 100; i++ {
+		if len(s.checkBytesArray(c, "PUBSUB", "CHANNELS")) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.checkInt(c, 0, "PUBLISH", "news", "hello")
+
+	nc = s.getConn(c)
+	defer nc.Recycle()
+	nc.checkOK(c, "MULTI")
+	nc.checkContainError(c, "not allowed in MULTI", "SUBSCRIBE", "news")
+	nc.checkOK(c, "DISCARD")
+}
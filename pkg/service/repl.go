@@ -5,9 +5,12 @@ package service
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -17,6 +20,7 @@ import (
 	"github.com/reborndb/go/bytesize"
 	redis "github.com/reborndb/go/redis/resp"
 	"github.com/reborndb/go/ring"
+	"github.com/reborndb/qdb/pkg/engine"
 	"github.com/reborndb/qdb/pkg/store"
 )
 
@@ -25,9 +29,15 @@ func (h *Handler) initReplication(bl *store.Store) error {
 	defer h.repl.Unlock()
 
 	h.repl.slaves = make(map[*conn]chan struct{})
+	h.repl.ackSignal = make(chan struct{})
 
 	h.repl.lastSelectDB.Set(int64(math.MaxUint32))
 
+	if err := h.loadReplicationBacklog(); err != nil {
+		log.Errorf("load replication backlog failed, use a new one - %s", err)
+		h.destoryReplicationBacklog()
+	}
+
 	bl.RegPostCommitHandler(h.replicationFeedSlaves)
 
 	go func() {
@@ -37,6 +47,10 @@ func (h *Handler) initReplication(bl *store.Store) error {
 			case <-h.signal:
 				return
 			case <-time.After(pingPeriod):
+				if h.masterAddr.Get() != "" {
+					// slave feeds sub-slaves with pings from its master
+					continue
+				}
 				f := &store.Forward{Op: "PING",
 					DB:   uint32(h.repl.lastSelectDB.Get()),
 					Args: nil}
@@ -56,39 +70,143 @@ func (h *Handler) closeReplication() error {
 	defer h.repl.Unlock()
 
 	// notice all slave to quit replication
-	for c, ch := range h.repl.slaves {
-		delete(h.repl.slaves, c)
-		close(ch)
-	}
+	h.replicationDisconnectSlaves()
 
 	// need wait all slave replication done later???
 
+	if err := h.saveReplicationBacklog(); err != nil {
+		log.Errorf("save replication backlog failed - %s", err)
+	}
+
 	return h.destoryReplicationBacklog()
 }
 
-func (h *Handler) createReplicationBacklog() error {
-	var err error
+// state of file backlog saved when server is closed, so slaves can partial
+// resync with the restarted master if backlog still covers their offsets
+type replBacklogMeta struct {
+	RunID         string `json:"run_id"`
+	MasterOffset  int64  `json:"master_offset"`
+	BacklogOffset int64  `json:"backlog_offset"`
+	Size          int    `json:"size"`
+	RingOffset    int    `json:"ring_offset"`
+	RingLength    int    `json:"ring_length"`
+}
+
+func (h *Handler) replicationBacklogSize() int {
 	bufSize := h.config.ReplBacklogSize
 
 	// minimal backlog bufsize is 1MB
 	if bufSize < bytesize.MB {
 		bufSize = bytesize.MB
 	}
+	return bufSize
+}
 
-	start := time.Now()
-	if path := h.config.ReplBacklogFilePath; len(path) == 0 {
-		h.repl.backlogBuf, err = ring.NewMemRing(bufSize)
-	} else {
-		h.repl.backlogBuf, err = ring.NewFileRing(path, bufSize)
+func replicationBacklogMetaPath(path string) string {
+	return path + ".meta"
+}
+
+func (h *Handler) saveReplicationBacklog() error {
+	path := h.config.ReplBacklogFilePath
+	if h.repl.backlogBuf == nil || len(path) == 0 {
+		return nil
 	}
 
+	r := h.repl.backlogBuf
+	m := &replBacklogMeta{
+		RunID:         string(h.runID),
+		MasterOffset:  h.repl.masterOffset,
+		BacklogOffset: h.repl.backlogOffset,
+		Size:          r.Size(),
+		RingOffset:    r.Offset(),
+		RingLength:    r.Len(),
+	}
+	b, err := json.Marshal(m)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	log.Infof("create backlog buf with size %d cost %s", bufSize, time.Now().Sub(start).String())
+	// backlog must be on disk before its meta
+	if err := syncFile(path); err != nil {
+		return errors.Trace(err)
+	}
 
-	h.repl.backlogBuf.Reset()
+	tmp := replicationBacklogMetaPath(path) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Trace(err)
+	}
+	if err := syncFile(tmp); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.Rename(tmp, replicationBacklogMetaPath(path)); err != nil {
+		return errors.Trace(err)
+	}
+
+	log.Infof("save backlog, run id = %s, master offset = %d, backlog offset = %d", m.RunID, m.MasterOffset, m.BacklogOffset)
+	return nil
+}
+
+// reopen the file backlog saved by the last closed server
+func (h *Handler) loadReplicationBacklog() error {
+	path := h.config.ReplBacklogFilePath
+	if len(path) == 0 {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(replicationBacklogMetaPath(path))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Trace(err)
+	}
+
+	// backlog will be changed since now, meta is invalid if server crashes
+	if err := os.Remove(replicationBacklogMetaPath(path)); err != nil {
+		return errors.Trace(err)
+	}
+
+	m := &replBacklogMeta{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return errors.Trace(err)
+	}
+
+	if bufSize := h.replicationBacklogSize(); m.Size != bufSize {
+		return errors.Errorf("backlog size is changed from %d to %d", m.Size, bufSize)
+	} else if len(m.RunID) != len(h.runID) {
+		return errors.Errorf("invalid backlog run id %s", m.RunID)
+	} else if m.MasterOffset-m.BacklogOffset+1 != int64(m.RingLength) {
+		return errors.Errorf("backlog offsets [%d, %d] mismatch length %d", m.BacklogOffset, m.MasterOffset, m.RingLength)
+	}
+
+	if h.repl.backlogBuf, err = ring.NewFileRing(path, m.Size); err != nil {
+		return errors.Trace(err)
+	}
+	if err := h.repl.backlogBuf.Restore(m.RingOffset, m.RingLength); err != nil {
+		return errors.Trace(err)
+	}
+
+	h.runID = []byte(m.RunID)
+	h.repl.masterOffset = m.MasterOffset
+	h.repl.backlogOffset = m.BacklogOffset
+
+	log.Infof("load backlog, run id = %s, master offset = %d, backlog offset = %d", m.RunID, m.MasterOffset, m.BacklogOffset)
+	return nil
+}
+
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+
+	return errors.Trace(f.Sync())
+}
+
+func (h *Handler) createReplicationBacklog() error {
+	if err := h.openReplicationBacklog(); err != nil {
+		return errors.Trace(err)
+	}
 
 	// Increment the global replication offset by one to make sure
 	// we will not PSYNC with any previos slave.
@@ -100,6 +218,27 @@ func (h *Handler) createReplicationBacklog() error {
 	return nil
 }
 
+func (h *Handler) openReplicationBacklog() error {
+	var err error
+	bufSize := h.replicationBacklogSize()
+
+	start := time.Now()
+	if path := h.config.ReplBacklogFilePath; len(path) == 0 {
+		h.repl.backlogBuf, err = ring.NewMemRing(bufSize)
+	} else {
+		h.repl.backlogBuf, err = ring.NewFileRing(path, bufSize)
+	}
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	log.Infof("create backlog buf with size %d cost %s", bufSize, time.Now().Sub(start).String())
+
+	h.repl.backlogBuf.Reset()
+	return nil
+}
+
 func (h *Handler) destoryReplicationBacklog() error {
 	if h.repl.backlogBuf == nil {
 		return nil
@@ -145,6 +284,12 @@ func (h *Handler) replicationFeedSlaves(f *store.Forward) error {
 	h.repl.Lock()
 	defer h.repl.Unlock()
 
+	if h.masterAddr.Get() != "" {
+		// slave feeds sub-slaves with the stream from its master as it is,
+		// the request being applied is committed now
+		return h.replicationFeedStream()
+	}
+
 	r := &h.repl
 	if r.backlogBuf == nil && len(r.slaves) == 0 {
 		return nil
@@ -192,7 +337,144 @@ func (h *Handler) replicationNoticeSlavesSyncing() error {
 	return nil
 }
 
-// REPLCONF listening-port port / ack sync-offset
+// slave appends the request from master before applying it, buf ends at offset of
+// the stream and db is selected after the request
+func (h *Handler) replicationAppendStream(buf []byte, offset int64, db uint32) {
+	h.repl.Lock()
+	defer h.repl.Unlock()
+
+	r := &h.repl
+	r.pendingStream = append(r.pendingStream, buf...)
+	r.pendingOffset = offset
+	r.pendingDB = db
+}
+
+// slave feeds the pending requests from master into backlog, must be called with repl lock held
+func (h *Handler) replicationFeedStream() error {
+	r := &h.repl
+	if len(r.pendingStream) == 0 {
+		return nil
+	}
+
+	buf, offset := r.pendingStream, r.pendingOffset
+	r.pendingStream = nil
+	r.streamDB = r.pendingDB
+
+	start := offset - int64(len(buf)) + 1
+	if r.backlogBuf != nil && start <= r.masterOffset {
+		// the same stream is in backlog already, e.g. slave restarts and resyncs from its saved offset
+		if offset <= r.masterOffset {
+			return nil
+		}
+		buf = buf[r.masterOffset+1-start:]
+		start = r.masterOffset + 1
+	}
+
+	if r.backlogBuf == nil {
+		// slave always keeps backlog, so it can be promoted without full resync of others
+		if err := h.openReplicationBacklog(); err != nil {
+			return errors.Trace(err)
+		}
+		r.masterOffset, r.backlogOffset = start-1, start
+	} else if start != r.masterOffset+1 {
+		log.Warningf("stream from master at %d doesn't continue backlog at %d, reset backlog", start, r.masterOffset+1)
+		r.backlogBuf.Reset()
+		r.masterOffset, r.backlogOffset = start-1, start
+	}
+
+	if err := h.feedReplicationBacklog(buf); err != nil {
+		return errors.Trace(err)
+	}
+
+	return h.replicationNoticeSlavesSyncing()
+}
+
+// slave flushes requests not committed, e.g. SELECT and PING
+func (h *Handler) replicationFlushStream() error {
+	h.repl.Lock()
+	defer h.repl.Unlock()
+
+	return h.replicationFeedStream()
+}
+
+// slave starts full resync from master with run id, sub-slaves must full resync
+// again because data will be replaced
+func (h *Handler) replicationResetStream(runID string, offset int64, db uint32) {
+	h.repl.Lock()
+	defer h.repl.Unlock()
+
+	if runID == "?" {
+		// master doesn't support PSYNC, no one can partial resync with us
+		b := make([]byte, 40)
+		runID = string(getRandomHex(b))
+	}
+
+	h.runID = []byte(runID)
+	h.repl.prevRunID = nil
+	h.repl.pendingStream = nil
+	h.repl.masterOffset = offset
+	h.repl.streamDB = db
+
+	if err := h.destoryReplicationBacklog(); err != nil {
+		log.Errorf("destroy replication backlog failed - %s", err)
+	}
+	h.replicationDisconnectSlaves()
+}
+
+// stream continues with a new run id, slaves learn it when they reconnect and
+// PSYNC with the old one up to the current offset, must be called with repl lock held
+func (h *Handler) replicationShiftRunID(runID []byte) {
+	h.repl.prevRunID = h.runID
+	h.repl.prevOffset = h.repl.masterOffset + 1
+	h.runID = runID
+
+	log.Infof("shift run id from %s to %s, offset = %d", h.repl.prevRunID, h.runID, h.repl.prevOffset)
+	h.replicationDisconnectSlaves()
+}
+
+// slave becomes master and continues the stream with a new run id
+func (h *Handler) replicationPromote() {
+	h.repl.Lock()
+	defer h.repl.Unlock()
+
+	runID := make([]byte, 40)
+	h.replicationShiftRunID(getRandomHex(runID))
+
+	// db selected in the stream is unknown to the master path, select again
+	h.repl.lastSelectDB.Set(int64(math.MaxUint32))
+}
+
+// master becomes slave, the new master continuing our stream, e.g. promoted from
+// our slave, accepts partial resync from our offset
+func (h *Handler) replicationDemote() {
+	h.repl.RLock()
+	defer h.repl.RUnlock()
+
+	if h.repl.backlogBuf == nil {
+		return
+	}
+
+	db := h.repl.lastSelectDB.Get()
+	if db == int64(math.MaxUint32) {
+		// master selects db before the next write
+		db = 0
+	}
+
+	h.masterRunID.Set(string(h.runID))
+	h.syncOffset.Set(h.repl.masterOffset)
+	h.syncDB.Set(db)
+}
+
+// must be called with repl lock held
+func (h *Handler) replicationDisconnectSlaves() {
+	for c, ch := range h.repl.slaves {
+		delete(h.repl.slaves, c)
+		close(ch)
+	}
+}
+
+// REPLCONF listening-port port / ack sync-offset / getack * / checkpoint engine /
+// filter-db dbs / filter-slots slots
 func ReplConfCmd(s Session, args [][]byte) (redis.Resp, error) {
 	if len(args) != 2 {
 		return toRespErrorf("len(args) = %d, expect = 2", len(args))
@@ -216,9 +498,34 @@ func ReplConfCmd(s Session, args [][]byte) (redis.Resp, error) {
 		} else {
 			c.backlogACKOffset.Set(ack)
 			c.backlogACKTime.Set(time.Now().Unix())
+			c.h.replicationNotifyACK()
 			// ACK will not reply anything
 			return nil, nil
 		}
+	case "getack":
+		// slave acks after handling this command, see doSyncFromMater
+		c.syncACKRequested = true
+		return nil, nil
+	case "checkpoint":
+		// slave can load a checkpoint only if it uses the same engine
+		c.syncCheckpoint = strings.EqualFold(string(args[1]), c.h.store.EngineName())
+	case "filter-db", "filter-slots":
+		if c.h.isSlave(c) {
+			return toRespErrorf("can't change filter while syncing")
+		}
+		if c.syncFilter == nil {
+			c.syncFilter = &replStreamFilter{}
+		}
+
+		var err error
+		if strings.EqualFold(string(args[0]), "filter-db") {
+			err = c.syncFilter.setDBs(string(args[1]))
+		} else {
+			err = c.syncFilter.setSlots(string(args[1]))
+		}
+		if err != nil {
+			return toRespError(err)
+		}
 	default:
 		return toRespErrorf("Unrecognized REPLCONF option:%s", args[0])
 	}
@@ -236,10 +543,11 @@ func SyncCmd(s Session, args [][]byte) (redis.Resp, error) {
 	return c.h.handleSyncCommand("sync", c, args)
 }
 
-// PSYNC run-id sync-offset
+// PSYNC run-id sync-offset [db]
+// db is selected by the stream at sync-offset, the filtered stream needs it
 func PSyncCmd(s Session, args [][]byte) (redis.Resp, error) {
-	if len(args) != 2 {
-		return toRespErrorf("len(args) = %d, expect = 2", len(args))
+	if len(args) != 2 && len(args) != 3 {
+		return toRespErrorf("len(args) = %d, expect = 2 or 3", len(args))
 	}
 
 	c, _ := s.(*conn)
@@ -256,12 +564,28 @@ func (h *Handler) handleSyncCommand(opt string, c *conn, args [][]byte) (redis.R
 		return nil, nil
 	}
 
+	if h.masterAddr.Get() != "" && (h.masterConnState.Get() != masterConnConnected || h.syncOffset.Get() == -1) {
+		// offsets of the stream fed to sub-slaves must be known
+		return toRespErrorf("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+
+	if h.masterAddr.Get() != "" && h.replFilter != nil {
+		// we don't have the stream of master as it is
+		return toRespErrorf("Can't SYNC from a slave with filter %s", h.replFilter)
+	}
+
 	if opt == "psync" {
 		// first try whether full resync or not
-		need, syncOffset := h.needFullReSync(c, args)
+		need, syncOffset, runID := h.needFullReSync(c, args)
 		if !need {
+			reply := "CONTINUE"
+			if !bytes.EqualFold(args[0], []byte(runID)) {
+				// slave must use the new run id since now
+				reply = fmt.Sprintf("CONTINUE %s", runID)
+			}
+
 			// write CONTINUE and resume replication
-			if err := c.writeRESP(redis.NewString("CONTINUE")); err != nil {
+			if err := c.writeRESP(redis.NewString(reply)); err != nil {
 				log.Errorf("reply slave %s psync CONTINUE err - %s", c, err)
 				c.Close()
 				return nil, errors.Trace(err)
@@ -269,45 +593,62 @@ func (h *Handler) handleSyncCommand(opt string, c *conn, args [][]byte) (redis.R
 
 			h.counters.syncPartialOK.Add(1)
 
-			h.startSlaveReplication(c, syncOffset)
+			var db uint64
+			if len(args) == 3 {
+				db, _ = strconv.ParseUint(string(args[2]), 10, 32)
+			}
+			h.startSlaveReplication(c, syncOffset, runID, uint32(db))
 			return nil, nil
 		}
 
-		// we must handle full resync
-		if err := h.replicationReplyFullReSync(c); err != nil {
-			return nil, errors.Trace(err)
-		}
-
 		// slave will use ? to force resync, this is not error
 		if !bytes.Equal(args[0], []byte{'?'}) {
 			h.counters.syncPartialErr.Add(1)
 		}
 	}
 
-	offset, resp, err := h.replicationSlaveFullSync(c)
+	st, resp, err := h.replicationSlaveFullSync(c, opt == "psync")
 	if err != nil {
 		return resp, errors.Trace(err)
 	}
 
-	h.startSlaveReplication(c, offset)
+	h.startSlaveReplication(c, st.offset, st.runID, st.db)
 
 	return nil, nil
 }
 
-func (h *Handler) replicationReplyFullReSync(c *conn) error {
-	// lock all to get the current master replication offset
-	if err := c.Store().Acquire(); err != nil {
-		return errors.Trace(err)
+// state of replication stream pinned for full resync
+type replSyncState struct {
+	runID string
+	// offset of the next byte slave syncs from
+	offset int64
+	// db selected at the offset
+	db uint32
+}
+
+// must be called with repl lock held
+func (h *Handler) replicationSyncState() replSyncState {
+	r := &h.repl
+	st := replSyncState{runID: string(h.runID), offset: r.masterOffset + 1}
+
+	if h.masterAddr.Get() != "" {
+		// slave feeds the stream from master as it is, db is not selected again
+		st.db = r.streamDB
+	} else if r.backlogBuf == nil {
+		// we will create backlog buffer and increment master offset by one later
+		st.offset++
 	}
+	return st
+}
 
-	syncOffset := h.repl.masterOffset
-	if h.repl.backlogBuf == nil {
-		// we will increment the master offset by one when backlog buffer created
-		syncOffset++
+func (h *Handler) replicationReplyFullReSync(c *conn, st replSyncState) error {
+	reply := fmt.Sprintf("FULLRESYNC %s %d", st.runID, st.offset-1)
+	if st.db != 0 {
+		// slave of slave must know the db selected in the stream
+		reply = fmt.Sprintf("%s %d", reply, st.db)
 	}
-	c.Store().Release()
 
-	if err := c.writeRESP(redis.NewString(fmt.Sprintf("FULLRESYNC %s %d", h.runID, syncOffset))); err != nil {
+	if err := c.writeRESP(redis.NewString(reply)); err != nil {
 		log.Errorf("reply slave %s psync FULLRESYNC err - %s", c, err)
 		c.Close()
 		return errors.Trace(err)
@@ -315,8 +656,52 @@ func (h *Handler) replicationReplyFullReSync(c *conn) error {
 	return nil
 }
 
-// if full sync ok, return sync offset for later backlog syncing
-func (h *Handler) replicationSlaveFullSync(c *conn) (syncOffset int64, resp redis.Resp, err error) {
+// if full sync ok, return the state for later backlog syncing
+func (h *Handler) replicationSlaveFullSync(c *conn, psync bool) (st replSyncState, resp redis.Resp, err error) {
+	// now begin full sync
+	h.counters.syncFull.Add(1)
+
+	// checkpoint can't be filtered
+	if c.syncCheckpoint && c.syncFilter == nil && h.config.SyncCheckpointPath != "" {
+		if psync {
+			// checkpoint is sent with the offset pinned later
+			h.repl.RLock()
+			st = h.replicationSyncState()
+			h.repl.RUnlock()
+			if err = h.replicationReplyFullReSync(c, st); err != nil {
+				return
+			}
+		}
+
+		// we don't allow others do bgsave or checkpoint before full sync done.
+		if ok := h.bgSaveSem.AcquireTimeout(time.Minute); !ok {
+			resp, err = toRespErrorf("wait others do bgsave timeout")
+			return
+		}
+		defer h.bgSaveSem.Release()
+
+		h.counters.syncCheckpoint.Add(1)
+		if st, err = h.replicationSendCheckpoint(c); err != nil {
+			log.Errorf("slave %s sync checkpoint err - %s", c, err)
+			c.Close()
+		}
+		return
+	}
+
+	// pin the snapshot before replying, so the offset replied matches the RDB
+	sp, err := h.store.NewSnapshotFunc(h.replicationPinSyncState(&st))
+	if err != nil {
+		resp, err = toRespError(err)
+		return
+	}
+	defer h.store.ReleaseSnapshot(sp)
+
+	if psync {
+		if err = h.replicationReplyFullReSync(c, st); err != nil {
+			return
+		}
+	}
+
 	// after bgsave, we must send this RDB to slave,
 	// so we don't allow others do bgsave before full sync done.
 	if ok := h.bgSaveSem.AcquireTimeout(time.Minute); !ok {
@@ -325,11 +710,20 @@ func (h *Handler) replicationSlaveFullSync(c *conn) (syncOffset int64, resp redi
 	}
 	defer h.bgSaveSem.Release()
 
-	// now begin full sync
-	h.counters.syncFull.Add(1)
+	path := h.config.DumpPath
+	var match func(db, slot uint32) bool
+	if c.syncFilter != nil {
+		// RDB with only the keys matched is not a dump of ours
+		path = fmt.Sprintf("%s.filtered", path)
+		defer os.Remove(path)
+		match = c.syncFilter.matchSlot
+	}
+	if err = h.bgsaveTo(sp, path, match); err != nil {
+		resp, err = toRespError(err)
+		return
+	}
 
-	var rdb *os.File
-	rdb, syncOffset, err = h.replicationBgSave()
+	rdb, err := os.Open(path)
 	if err != nil {
 		resp, err = toRespError(err)
 		return
@@ -337,9 +731,9 @@ func (h *Handler) replicationSlaveFullSync(c *conn) (syncOffset int64, resp redi
 	defer rdb.Close()
 
 	// send rdb to slave
-	st, _ := rdb.Stat()
+	info, _ := rdb.Stat()
 
-	rdbSize := st.Size()
+	rdbSize := info.Size()
 
 	if err = c.writeRDBFrom(rdbSize, rdb); err != nil {
 		// close this connection here???
@@ -348,26 +742,17 @@ func (h *Handler) replicationSlaveFullSync(c *conn) (syncOffset int64, resp redi
 		return
 	}
 
-	return syncOffset, nil, nil
+	return st, nil, nil
 }
 
-// if no need full resync, returns false and sync offset
-func (h *Handler) needFullReSync(c *conn, args [][]byte) (bool, int64) {
+// if no need full resync, returns false, sync offset and run id the slave should use
+func (h *Handler) needFullReSync(c *conn, args [][]byte) (bool, int64, string) {
 	masterRunID := args[0]
 
-	if !bytes.EqualFold(masterRunID, h.runID) {
-		if !bytes.Equal(masterRunID, []byte{'?'}) {
-			log.Infof("Partial resynchronization not accepted, runid mismatch, server is %s, but client is %s", h.runID, masterRunID)
-		} else {
-			log.Infof("Full resync requested by slave.")
-		}
-		return true, 0
-	}
-
 	syncOffset, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
+	if err != nil && !bytes.Equal(masterRunID, []byte{'?'}) {
 		log.Errorf("PSYNC parse sync offset err, try full resync - %s", err)
-		return true, 0
+		return true, 0, ""
 	}
 
 	r := &h.repl
@@ -375,6 +760,19 @@ func (h *Handler) needFullReSync(c *conn, args [][]byte) (bool, int64) {
 	h.repl.RLock()
 	defer h.repl.RUnlock()
 
+	if !bytes.EqualFold(masterRunID, h.runID) {
+		if bytes.Equal(masterRunID, []byte{'?'}) {
+			log.Infof("Full resync requested by slave.")
+			return true, 0, ""
+		}
+
+		// stream with the previous run id is continued up to prevOffset
+		if r.prevRunID == nil || !bytes.EqualFold(masterRunID, r.prevRunID) || syncOffset > r.prevOffset {
+			log.Infof("Partial resynchronization not accepted, runid mismatch, server is %s, but client is %s", h.runID, masterRunID)
+			return true, 0, ""
+		}
+	}
+
 	if r.backlogBuf == nil || syncOffset < r.backlogOffset ||
 		syncOffset > (r.backlogOffset+int64(r.backlogBuf.Len())) {
 		log.Infof("unable to partial resync with the slave for lack of backlog, slave offset %d", syncOffset)
@@ -382,14 +780,37 @@ func (h *Handler) needFullReSync(c *conn, args [][]byte) (bool, int64) {
 			log.Infof("slave tried to PSYNC with an offset %d larger than master offset %d", syncOffset, r.masterOffset)
 		}
 
-		return true, 0
+		return true, 0, ""
+	}
+
+	if c.syncFilter != nil {
+		// backlog written without the filter checked may have requests the slave can't replay
+		var db uint64
+		if len(args) == 3 {
+			db, _ = strconv.ParseUint(string(args[2]), 10, 32)
+		}
+		f := &replStreamFilter{replFilter: c.syncFilter.replFilter, db: uint32(db)}
+
+		p := make([]byte, r.backlogOffset+int64(r.backlogBuf.Len())-syncOffset)
+		if _, err := r.backlogBuf.ReadAt(p, syncOffset-r.backlogOffset); err != nil {
+			log.Errorf("PSYNC read backlog err, try full resync - %s", err)
+			return true, 0, ""
+		}
+		if _, _, err := f.filter(p, syncOffset); err != nil {
+			log.Infof("Partial resynchronization not accepted, %s", err)
+			return true, 0, ""
+		}
 	}
 
-	return false, syncOffset
+	return false, syncOffset, string(h.runID)
 }
 
-func (h *Handler) startSlaveReplication(c *conn, syncOffset int64) {
+// db is selected by the stream at syncOffset
+func (h *Handler) startSlaveReplication(c *conn, syncOffset int64, runID string, db uint32) {
 	c.syncOffset.Set(syncOffset)
+	if c.syncFilter != nil {
+		c.syncFilter.db = db
+	}
 
 	// we may not receive any data, so ignore timeout
 	c.timeout = 0
@@ -400,6 +821,13 @@ func (h *Handler) startSlaveReplication(c *conn, syncOffset int64) {
 	ch <- struct{}{}
 
 	h.repl.Lock()
+	if !bytes.Equal(h.runID, []byte(runID)) {
+		// stream is reset or shifted during syncing, slave must sync again
+		h.repl.Unlock()
+		log.Infof("slave %s sync run id %s mismatch %s, close it", c, runID, h.runID)
+		c.Close()
+		return
+	}
 	h.repl.slaves[c] = ch
 	h.repl.Unlock()
 
@@ -469,14 +897,31 @@ func (h *Handler) replicationSlaveSyncBacklog(c *conn, buf []byte) (int, error)
 		return 0, nil
 	}
 
+	data, used := buf[0:n], n
+	for c.syncFilter != nil {
+		if data, used, err = c.syncFilter.filter(buf[0:n], offset); err != nil {
+			return 0, errors.Errorf("slave %s filter backlog data err %v", c, err)
+		} else if used != 0 || n < len(buf) {
+			break
+		}
+
+		// buf can't hold a whole request
+		buf = make([]byte, len(buf)*2)
+		if n, err = h.repl.backlogBuf.ReadAt(buf, offset-start); err != nil {
+			return 0, errors.Errorf("slave %s read backlog data err %v", c, err)
+		}
+	}
+
 	// use write timeout here, now 5s, later, use config
 	c.nc.SetWriteDeadline(time.Now().Add(5 * time.Second))
 
-	if err = c.writeRaw(buf[0:n]); err != nil {
-		return 0, errors.Errorf("slave %s sync backlog data err %v", c, err)
+	if len(data) != 0 {
+		if err = c.writeRaw(data); err != nil {
+			return 0, errors.Errorf("slave %s sync backlog data err %v", c, err)
+		}
 	}
 
-	c.syncOffset.Add(int64(n))
+	c.syncOffset.Add(int64(used))
 
 	return n, nil
 }
@@ -500,36 +945,73 @@ func (h *Handler) removeSlave(c *conn) {
 	}
 }
 
-func (h *Handler) replicationBgSave() (*os.File, int64, error) {
-	// need to improve later
-	syncOffset := new(int64)
-	sp, err := h.store.NewSnapshotFunc(func() {
-		offset := h.repl.masterOffset
-		// we will sync from masterOffset + 1
-		*syncOffset = offset + 1
-		if h.repl.backlogBuf == nil {
-			// we will create backlog buffer and increment master offset by one later
-			*syncOffset = offset + 2
-		}
+// returns a function called when the data for full sync is pinned,
+// which saves the state of replication stream the slave will sync from
+func (h *Handler) replicationPinSyncState(st *replSyncState) func() {
+	return func() {
+		h.repl.Lock()
+		defer h.repl.Unlock()
 
+		*st = h.replicationSyncState()
 		h.repl.lastSelectDB.Set(int64(math.MaxUint32))
-	})
+	}
+}
+
+// Checkpoint is sent as a line of "+CHECKPOINT <offset> <count> [db]" followed by
+// files, each file is a bulk string of name and then a bulk of its content
+// without the trailing CRLF, just like RDB. Offset is the last replication
+// offset applied to the checkpoint, db is selected there if not 0.
+func (h *Handler) replicationSendCheckpoint(c *conn) (replSyncState, error) {
+	st := replSyncState{}
+
+	dir := filepath.Join(h.config.SyncCheckpointPath, "master")
+	if err := os.RemoveAll(dir); err != nil {
+		return st, errors.Trace(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := h.store.Checkpoint(dir, h.replicationPinSyncState(&st)); err != nil {
+		return st, errors.Trace(err)
+	}
+
+	names, err := engine.CheckpointFiles(dir)
 	if err != nil {
-		return nil, 0, errors.Trace(err)
+		return st, errors.Trace(err)
 	}
-	defer h.store.ReleaseSnapshot(sp)
 
-	path := h.config.DumpPath
-	if err := h.bgsaveTo(sp, path); err != nil {
-		return nil, 0, errors.Trace(err)
+	header := fmt.Sprintf("+CHECKPOINT %d %d", st.offset-1, len(names))
+	if st.db != 0 {
+		header = fmt.Sprintf("%s %d", header, st.db)
+	}
+	if err := c.writeRaw([]byte(header + "\r\n")); err != nil {
+		return st, errors.Trace(err)
+	}
+	for _, name := range names {
+		if err := h.replicationSendCheckpointFile(c, dir, name); err != nil {
+			return st, errors.Trace(err)
+		}
+	}
+
+	log.Infof("slave %s sync checkpoint of %d files, offset = %d", c, len(names), st.offset-1)
+	return st, nil
+}
+
+func (h *Handler) replicationSendCheckpointFile(c *conn, dir string, name string) error {
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return errors.Trace(err)
 	}
+	defer f.Close()
 
-	f, err := os.Open(path)
+	st, err := f.Stat()
 	if err != nil {
-		return nil, 0, errors.Trace(err)
+		return errors.Trace(err)
 	}
 
-	return f, *syncOffset, nil
+	if err := c.writeRESP(redis.NewBulkBytesWithString(name)); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.writeRDBFrom(st.Size(), f))
 }
 
 const (
@@ -595,8 +1077,8 @@ func RoleCmd(s Session, args [][]byte) (redis.Resp, error) {
 
 func init() {
 	// redis set sync/psync read flag, so we set read flag here too
-	Register("psync", PSyncCmd, CmdReadonly)
+	Register("psync", PSyncCmd, CmdReadonly|CmdNoMulti)
 	Register("replconf", ReplConfCmd, CmdReadonly)
 	Register("role", RoleCmd, CmdReadonly)
-	Register("sync", SyncCmd, CmdReadonly)
+	Register("sync", SyncCmd, CmdReadonly|CmdNoMulti)
 }
@@ -6,13 +6,16 @@ package service
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"os/exec"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	redis "github.com/reborndb/go/redis/resp"
+	"github.com/reborndb/qdb/pkg/store"
 	. "gopkg.in/check.v1"
 )
 
@@ -289,6 +292,68 @@ func (s *testReplSuite) testReplication(c *C, master testReplNode, slave testRep
 	resp = s.doCmd(c, slave.Port(), "GET", "ttl_key")
 	c.Assert(resp, DeepEquals, redis.NewBulkBytes(nil))
 
+	// transaction is replicated as one unit
+	mc := s.getConn(c, master.Port())
+	mc.checkOK(c, "MULTI")
+	mc.checkString(c, "QUEUED", "SET", "tx_key", "1")
+	mc.checkString(c, "QUEUED", "INCR", "tx_key")
+	resp = mc.doCmd(c, "EXEC")
+	c.Assert(resp, FitsTypeOf, (*redis.Array)(nil))
+	mc.Recycle()
+
+	time.Sleep(500 * time.Millisecond)
+	resp = s.doCmd(c, slave.Port(), "GET", "tx_key")
+	c.Assert(resp, DeepEquals, redis.NewBulkBytesWithString("2"))
+
+	// list reindexing is replicated
+	s.doCmd(c, master.Port(), "RPUSH", "list_key", "a", "b", "a", "c")
+	s.doCmd(c, master.Port(), "LREM", "list_key", 0, "a")
+	s.doCmd(c, master.Port(), "LINSERT", "list_key", "BEFORE", "c", "x")
+	s.doCmd(c, master.Port(), "RPOPLPUSH", "list_key", "list_key")
+
+	time.Sleep(500 * time.Millisecond)
+	resp = s.doCmd(c, slave.Port(), "LRANGE", "list_key", 0, -1)
+	c.Assert(resp, DeepEquals, redis.Resp(&redis.Array{Value: []redis.Resp{
+		redis.NewBulkBytesWithString("c"), redis.NewBulkBytesWithString("b"), redis.NewBulkBytesWithString("x"),
+	}}))
+
+	// zset aggregation writes both data and index keys on slave
+	s.doCmd(c, master.Port(), "ZADD", "zset_key1", 1, "a", 2, "b")
+	s.doCmd(c, master.Port(), "ZADD", "zset_key2", 10, "a", 1, "c")
+	s.doCmd(c, master.Port(), "ZUNIONSTORE", "zset_dest", 2, "zset_key1", "zset_key2", "WEIGHTS", 1, 0.5)
+
+	time.Sleep(500 * time.Millisecond)
+	resp = s.doCmd(c, slave.Port(), "ZRANGE", "zset_dest", 0, -1, "WITHSCORES")
+	c.Assert(resp, DeepEquals, redis.Resp(&redis.Array{Value: []redis.Resp{
+		redis.NewBulkBytesWithString("c"), redis.NewBulkBytes(store.FormatFloat(0.5)),
+		redis.NewBulkBytesWithString("b"), redis.NewBulkBytes(store.FormatFloat(2)),
+		redis.NewBulkBytesWithString("a"), redis.NewBulkBytes(store.FormatFloat(6)),
+	}}))
+
+	// unlinked key is deleted on slave too
+	s.doCmd(c, master.Port(), "UNLINK", "zset_dest")
+
+	time.Sleep(500 * time.Millisecond)
+	resp = s.doCmd(c, slave.Port(), "EXISTS", "zset_dest")
+	c.Assert(resp, DeepEquals, redis.NewInt(0))
+
+	// message published on master is received by subscribers on slave
+	sc := newTestSubConn(c, slave.Port())
+	sc.send(c, "SUBSCRIBE", "repl_channel")
+	sc.checkRecv(c, "subscribe", "repl_channel", 1)
+	resp = s.doCmd(c, master.Port(), "PUBLISH", "repl_channel", "hello")
+	c.Assert(resp, FitsTypeOf, (*redis.Int)(nil))
+	sc.checkRecv(c, "message", "repl_channel", "hello")
+	sc.Close()
+
+	// script writes are replicated
+	resp = s.doCmd(c, master.Port(), "EVAL", "redis.call('set', KEYS[1], ARGV[1]) return redis.call('incr', KEYS[1])", 1, "script_key", "10")
+	c.Assert(resp, DeepEquals, redis.NewInt(11))
+
+	time.Sleep(500 * time.Millisecond)
+	resp = s.doCmd(c, slave.Port(), "GET", "script_key")
+	c.Assert(resp, DeepEquals, redis.NewBulkBytesWithString("11"))
+
 	offset = slave.SyncOffset(c)
 	// now close replication connection
 	nc.Close(c)
@@ -314,6 +379,684 @@ func (s *testReplSuite) testReplication(c *C, master testReplNode, slave testRep
 	s.checkRole(c, slave.Port(), "master")
 }
 
+func (s *testReplSuite) infoField(c *C, port int, section string, field string) string {
+	resp := s.doCmd(c, port, "INFO", section)
+	c.Assert(resp, FitsTypeOf, (*redis.BulkBytes)(nil))
+	for _, line := range strings.Split(string(resp.(*redis.BulkBytes).Value), "\r\n") {
+		if strings.HasPrefix(line, field+":") {
+			return line[len(field)+1:]
+		}
+	}
+	c.Fatalf("field %s not found in info %s", field, section)
+	return ""
+}
+
+func (s *testReplSuite) TestSyncRDB(c *C) {
+	master, slave := s.srv1, s.srv2
+	s.doCmdMustOK(c, master.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+
+	// entries of different dbs are restored by different batches
+	mc := s.getConn(c, master.Port())
+	for db := 0; db < 2; db++ {
+		mc.checkOK(c, "SELECT", db)
+		for i := 0; i < 100; i++ {
+			mc.checkOK(c, "SET", fmt.Sprintf("sync_rdb_%d", i), db*100+i)
+		}
+	}
+	mc.checkOK(c, "SELECT", 0)
+	mc.Recycle()
+
+	// slave without checkpoint path always accepts RDB
+	config := slave.s.h.config
+	workers, batchSize, checkpointPath := config.SyncRDBWorkers, config.SyncRDBBatchSize, config.SyncCheckpointPath
+	config.SyncRDBWorkers, config.SyncRDBBatchSize, config.SyncCheckpointPath = 3, 7, ""
+	defer func() {
+		config.SyncRDBWorkers, config.SyncRDBBatchSize, config.SyncCheckpointPath = workers, batchSize, checkpointPath
+	}()
+
+	nc := slave.Slaveof(c, master.Port())
+	defer nc.Close(c)
+
+	for i := 0; i < 20; i++ {
+		if s.infoField(c, slave.Port(), "replication", "sync_rdb_loaded_entries") == "200" {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	c.Assert(s.infoField(c, slave.Port(), "replication", "sync_rdb_loaded_entries"), Equals, "200")
+
+	sc := s.getConn(c, slave.Port())
+	for db := 0; db < 2; db++ {
+		sc.checkOK(c, "SELECT", db)
+		for i := 0; i < 100; i += 9 {
+			sc.checkString(c, strconv.Itoa(db*100+i), "GET", fmt.Sprintf("sync_rdb_%d", i))
+		}
+	}
+	sc.checkOK(c, "SELECT", 0)
+	sc.Recycle()
+
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+}
+
+func (s *testReplSuite) TestSyncCheckpoint(c *C) {
+	master, slave := s.srv1, s.srv2
+	s.doCmdMustOK(c, master.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+	s.doCmdMustOK(c, slave.Port(), "SET", "sync_checkpoint_stale", "1")
+
+	mc := s.getConn(c, master.Port())
+	defer mc.Recycle()
+	for db := 0; db < 2; db++ {
+		mc.checkOK(c, "SELECT", db)
+		for i := 0; i < 100; i++ {
+			mc.checkOK(c, "SET", fmt.Sprintf("sync_checkpoint_%d", i), db*100+i)
+		}
+	}
+	mc.checkOK(c, "SELECT", 0)
+
+	full := s.infoField(c, master.Port(), "replication", "sync_full_checkpoint")
+
+	nc := slave.Slaveof(c, master.Port())
+	defer nc.Close(c)
+
+	for i := 0; i < 20; i++ {
+		// link is up before full sync too
+		if s.infoField(c, slave.Port(), "replication", "sync_checkpoint_loaded_files") != "0" &&
+			s.infoField(c, slave.Port(), "replication", "master_link_status") == "up" {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	c.Assert(s.infoField(c, slave.Port(), "replication", "sync_checkpoint_loaded_files"), Not(Equals), "0")
+	c.Assert(s.infoField(c, slave.Port(), "replication", "master_link_status"), Equals, "up")
+	c.Assert(s.infoField(c, master.Port(), "replication", "sync_full_checkpoint"), Not(Equals), full)
+
+	// written after full sync
+	offset := slave.SyncOffset(c)
+	mc.checkOK(c, "SET", "sync_checkpoint_new", "new")
+	s.waitAndCheckSyncOffset(c, slave, offset)
+
+	sc := s.getConn(c, slave.Port())
+	defer sc.Recycle()
+	for db := 0; db < 2; db++ {
+		sc.checkOK(c, "SELECT", db)
+		for i := 0; i < 100; i += 9 {
+			sc.checkString(c, strconv.Itoa(db*100+i), "GET", fmt.Sprintf("sync_checkpoint_%d", i))
+		}
+	}
+	sc.checkOK(c, "SELECT", 0)
+	sc.checkNil(c, "GET", "sync_checkpoint_stale")
+	sc.checkString(c, "new", "GET", "sync_checkpoint_new")
+
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+}
+
+func (s *testReplSuite) TestPartialResyncAfterRestart(c *C) {
+	master := s.srv1
+	s.doCmdMustOK(c, master.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+
+	port := 17780
+	slave := &testReplSrvNode{port: port, s: testCreateServer(c, port)}
+	defer func() {
+		slave.s.Close()
+	}()
+
+	nc := slave.Slaveof(c, master.Port())
+
+	// state is saved once full sync is done
+	for i := 0; i < 20; i++ {
+		if st, err := slave.s.s.LoadReplState(); err == nil && st != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	mc := s.getConn(c, master.Port())
+	defer mc.Recycle()
+	mc.checkOK(c, "SELECT", 1)
+	mc.checkOK(c, "SET", "restart_1", "1")
+	s.waitAndCheckSyncOffset(c, slave, -1)
+	for i := 0; i < 20 && slave.s.h.syncDB.Get() != 1; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// state is saved with the applied writes
+	st, err := slave.s.s.LoadReplState()
+	c.Assert(err, IsNil)
+	c.Assert(st, DeepEquals, &store.ReplState{
+		RunID:  string(master.s.h.runID),
+		Offset: slave.SyncOffset(c),
+		DB:     1,
+	})
+
+	nc.Close(c)
+	slave.s.Close()
+
+	// written when slave is down, master doesn't select db again
+	mc.checkOK(c, "SET", "restart_2", "2")
+
+	slave.s = testOpenServer(c, port)
+	partial := master.s.h.counters.syncPartialOK.Get()
+
+	offset := slave.SyncOffset(c)
+	nc = slave.Slaveof(c, master.Port())
+	defer nc.Close(c)
+	s.waitAndCheckSyncOffset(c, slave, offset)
+	c.Assert(master.s.h.counters.syncPartialOK.Get(), Equals, partial+1)
+
+	for i, key := range []string{"restart_1", "restart_2"} {
+		v, err := slave.s.s.Get(1, [][]byte{[]byte(key)})
+		c.Assert(err, IsNil)
+		c.Assert(string(v), Equals, strconv.Itoa(i+1))
+	}
+
+	mc.checkOK(c, "SELECT", 0)
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+}
+
+func (s *testReplSuite) TestPartialResyncAfterMasterRestart(c *C) {
+	slave := s.srv2
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, slave.Port(), "FLUSHALL")
+
+	port := 17781
+	master := &testReplSrvNode{port: port, s: testCreateServer(c, port)}
+	defer func() {
+		master.s.Close()
+	}()
+
+	nc := slave.Slaveof(c, port)
+	defer nc.Close(c)
+
+	for i := 0; i < 20; i++ {
+		if st, err := slave.s.s.LoadReplState(); err == nil && st != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	offset := slave.SyncOffset(c)
+	err := master.s.s.Set(0, [][]byte{[]byte("master_restart_1"), []byte("1")})
+	c.Assert(err, IsNil)
+	s.waitAndCheckSyncOffset(c, slave, offset)
+
+	runID := string(master.s.h.runID)
+	masterOffset := master.s.h.repl.masterOffset
+	master.s.Close()
+
+	// run id and backlog are kept, slave reconnects by itself
+	master.s = testOpenServer(c, port)
+	c.Assert(string(master.s.h.runID), Equals, runID)
+	c.Assert(master.s.h.repl.masterOffset, Equals, masterOffset)
+
+	err = master.s.s.Set(0, [][]byte{[]byte("master_restart_2"), []byte("2")})
+	c.Assert(err, IsNil)
+
+	for i := 0; i < 50 && slave.SyncOffset(c) <= masterOffset; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(master.s.h.counters.syncPartialOK.Get(), Equals, int64(1))
+	c.Assert(master.s.h.counters.syncFull.Get(), Equals, int64(0))
+
+	for i, key := range []string{"master_restart_1", "master_restart_2"} {
+		v, err := slave.s.s.Get(0, [][]byte{[]byte(key)})
+		c.Assert(err, IsNil)
+		c.Assert(string(v), Equals, strconv.Itoa(i+1))
+	}
+
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, slave.Port(), "FLUSHALL")
+}
+
+func (s *testReplSuite) TestPartialResyncFromBackup(c *C) {
+	master := s.srv1
+	s.doCmdMustOK(c, master.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+
+	// backlog of master is created by the first slave
+	port := 17784
+	slave := &testReplSrvNode{port: port, s: testCreateServer(c, port)}
+	nc := slave.Slaveof(c, master.Port())
+	for i := 0; i < 20; i++ {
+		if st, err := slave.s.s.LoadReplState(); err == nil && st != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	mc := s.getConn(c, master.Port())
+	defer mc.Recycle()
+	mc.checkOK(c, "SELECT", 1)
+	mc.checkOK(c, "SET", "backup_1", "1")
+	s.waitAndCheckSyncOffset(c, slave, -1)
+	nc.Close(c)
+	slave.s.Close()
+
+	dir := "/tmp/test_qdb/test_service/repl_backup"
+	err := os.RemoveAll(dir)
+	c.Assert(err, IsNil)
+	mc.checkOK(c, "BACKUP", dir)
+
+	// written after backup, master doesn't select db again
+	mc.checkOK(c, "SET", "backup_2", "2")
+
+	// restore backup like qdb-server --from_backup
+	base := fmt.Sprintf("/tmp/test_qdb/test_service/%d", port)
+	err = os.RemoveAll(base)
+	c.Assert(err, IsNil)
+	m, err := RestoreBackup(dir, path.Join(base, "db"))
+	c.Assert(err, IsNil)
+	x := store.New(testOpenDB(c, path.Join(base, "db")))
+	err = x.SaveReplState(m.ReplState())
+	c.Assert(err, IsNil)
+	x.Close()
+
+	slave.s = testOpenServer(c, port)
+	defer func() {
+		slave.s.Close()
+	}()
+	st, err := slave.s.s.LoadReplState()
+	c.Assert(err, IsNil)
+	c.Assert(st, DeepEquals, &store.ReplState{RunID: string(master.s.h.runID), Offset: m.Offset})
+
+	partial := master.s.h.counters.syncPartialOK.Get()
+	nc = slave.Slaveof(c, master.Port())
+	defer nc.Close(c)
+	s.waitAndCheckSyncOffset(c, slave, m.Offset)
+	c.Assert(master.s.h.counters.syncPartialOK.Get(), Equals, partial+1)
+
+	for i, key := range []string{"backup_1", "backup_2"} {
+		v, err := slave.s.s.Get(1, [][]byte{[]byte(key)})
+		c.Assert(err, IsNil)
+		c.Assert(string(v), Equals, strconv.Itoa(i+1))
+	}
+
+	mc.checkOK(c, "SELECT", 0)
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+}
+
+func (s *testReplSuite) TestWait(c *C) {
+	master, slave := s.srv1, s.srv2
+	s.doCmdMustOK(c, master.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+
+	nc := slave.Slaveof(c, master.Port())
+	defer nc.Close(c)
+
+	for i := 0; i < 20; i++ {
+		if st, err := slave.s.s.LoadReplState(); err == nil && st != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	mc := s.getConn(c, master.Port())
+	defer mc.Recycle()
+	mc.checkOK(c, "SET", "wait_1", "1")
+	mc.checkInt(c, 1, "WAIT", 1, 5000)
+
+	// only one slave acks
+	mc.checkOK(c, "SET", "wait_2", "2")
+	mc.checkInt(c, 1, "WAIT", 2, 100)
+
+	sc := s.getConn(c, slave.Port())
+	defer sc.Recycle()
+	sc.checkContainError(c, "slave", "WAIT", 1, 100)
+
+	// WAIT waits for the last write of its own client, slave can't apply writes
+	// while its store is locked
+	locked, release := make(chan struct{}), make(chan struct{})
+	go slave.s.s.Multi(func(tx *store.Store) error {
+		close(locked)
+		<-release
+		return nil
+	})
+	<-locked
+	mc.checkOK(c, "SET", "wait_5", "5")
+	mc.checkInt(c, 0, "WAIT", 1, 100)
+	pool := testCreateConnPool(master.Port())
+	oc := pool.Get(c)
+	oc.checkInt(c, 1, "WAIT", 1, 100)
+	oc.Recycle()
+	pool.Close()
+	close(release)
+	mc.checkInt(c, 1, "WAIT", 1, 5000)
+
+	config := master.s.h.config
+	defer func() {
+		config.MinSlavesToWrite = 0
+	}()
+
+	config.MinSlavesToWrite = 2
+	mc.checkContainError(c, "NOREPLICAS", "SET", "wait_3", "3")
+	mc.checkString(c, "2", "GET", "wait_2")
+
+	config.MinSlavesToWrite = 1
+	c.Assert(s.infoField(c, master.Port(), "replication", "min_slaves_good_slaves"), Equals, "1")
+	mc.checkOK(c, "SET", "wait_3", "3")
+
+	// checked again by EXEC, slaves may be lost after queuing
+	mc.checkOK(c, "MULTI")
+	mc.checkString(c, "QUEUED", "SET", "wait_4", "4")
+	config.MinSlavesToWrite = 2
+	mc.checkContainError(c, "NOREPLICAS", "EXEC")
+	mc.checkOK(c, "MULTI")
+	mc.checkString(c, "QUEUED", "GET", "wait_3")
+	mc.checkExec(c, 1)
+	mc.checkNil(c, "GET", "wait_4")
+
+	// checked by scripts once they write
+	mc.checkContainError(c, "NOREPLICAS", "EVAL", "return redis.call('set', 'wait_4', 4)", 0)
+	mc.checkString(c, "3", "EVAL", "return redis.call('get', 'wait_3')", 0)
+
+	config.MinSlavesToWrite = 0
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+}
+
+func (s *testReplSuite) TestInfoSlaves(c *C) {
+	master, slave := s.srv1, s.srv2
+	s.doCmdMustOK(c, master.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+	c.Assert(s.infoField(c, master.Port(), "replication", "connected_slaves"), Equals, "0")
+
+	nc := slave.Slaveof(c, master.Port())
+	defer nc.Close(c)
+
+	for i := 0; i < 20; i++ {
+		if s.infoField(c, master.Port(), "replication", "connected_slaves") == "1" {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(s.infoField(c, master.Port(), "replication", "connected_slaves"), Equals, "1")
+
+	mc := s.getConn(c, master.Port())
+	defer mc.Recycle()
+	mc.checkOK(c, "SET", "info_slaves", "1")
+	mc.checkInt(c, 1, "WAIT", 1, 5000)
+
+	info := s.infoField(c, master.Port(), "replication", "slave0")
+	fields := make(map[string]string)
+	for _, kv := range strings.Split(info, ",") {
+		p := strings.SplitN(kv, "=", 2)
+		c.Assert(p, HasLen, 2)
+		fields[p[0]] = p[1]
+	}
+	c.Assert(fields["ip"], Equals, "127.0.0.1")
+	c.Assert(fields["port"], Equals, strconv.Itoa(slave.Port()))
+	c.Assert(fields["state"], Equals, "online")
+
+	offset, err := strconv.ParseInt(fields["offset"], 10, 64)
+	c.Assert(err, IsNil)
+	c.Assert(offset > 0 && offset <= slave.SyncOffset(c), Equals, true)
+
+	lag, err := strconv.ParseInt(fields["lag"], 10, 64)
+	c.Assert(err, IsNil)
+	c.Assert(lag >= 0 && lag <= 1, Equals, true)
+
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+}
+
+func (s *testReplSuite) TestReplicaChaining(c *C) {
+	master, slave := s.srv1, s.srv2
+	s.doCmdMustOK(c, master.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+	s.doCmdMustOK(c, slave.Port(), "FLUSHALL")
+
+	port := 17782
+	sub := &testReplSrvNode{port: port, s: testCreateServer(c, port)}
+	defer func() {
+		sub.s.Close()
+	}()
+
+	masterOffset := func(n *testReplSrvNode) int64 {
+		n.s.h.repl.RLock()
+		defer n.s.h.repl.RUnlock()
+		return n.s.h.repl.masterOffset
+	}
+	runID := func(n *testReplSrvNode) string {
+		n.s.h.repl.RLock()
+		defer n.s.h.repl.RUnlock()
+		return string(n.s.h.runID)
+	}
+	checkValue := func(n *testReplSrvNode, db uint32, key string, value string) {
+		for i := 0; i < 50; i++ {
+			if v, err := n.s.s.Get(db, [][]byte{[]byte(key)}); err == nil && string(v) == value {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		c.Fatalf("server %d doesn't sync %s = %s", n.Port(), key, value)
+	}
+
+	nc := slave.Slaveof(c, master.Port())
+	defer nc.Close(c)
+	for i := 0; i < 20 && slave.SyncOffset(c) == -1; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// sub-slave can sync from slave once slave has synced with master
+	sc := sub.Slaveof(c, slave.Port())
+	defer sc.Close(c)
+
+	mc := s.getConn(c, master.Port())
+	defer mc.Recycle()
+	mc.checkOK(c, "SELECT", 1)
+	mc.checkOK(c, "SET", "chain_1", "1")
+	checkValue(sub, 1, "chain_1", "1")
+
+	// the stream of master is passed through with the same run id and offsets
+	for i := 0; i < 20 && sub.SyncOffset(c) != masterOffset(master); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(sub.SyncOffset(c), Equals, masterOffset(master))
+	c.Assert(slave.SyncOffset(c), Equals, masterOffset(master))
+	c.Assert(masterOffset(slave), Equals, masterOffset(master))
+	c.Assert(runID(slave), Equals, runID(master))
+	c.Assert(sub.s.h.masterRunID.Get(), Equals, runID(master))
+
+	// message published on master is received once on sub-slave, and published on
+	// slave is not sent to sub-slave, the stream of master is kept as it is
+	subc := newTestSubConn(c, port)
+	subc.send(c, "SUBSCRIBE", "chain_channel")
+	subc.checkRecv(c, "subscribe", "chain_channel", 1)
+	s.doCmd(c, slave.Port(), "PUBLISH", "chain_channel", "slave")
+	mc.checkInt(c, 0, "PUBLISH", "chain_channel", "master")
+	mc.checkOK(c, "SET", "chain_4", "4")
+	checkValue(sub, 1, "chain_4", "4")
+	subc.checkRecv(c, "message", "chain_channel", "master")
+	subc.Close()
+
+	for i := 0; i < 20 && sub.SyncOffset(c) != masterOffset(master); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(sub.SyncOffset(c), Equals, masterOffset(master))
+	c.Assert(masterOffset(slave), Equals, masterOffset(master))
+
+	// promoted slave continues the stream with a new run id, sub-slave partial resyncs
+	partial, full := slave.s.h.counters.syncPartialOK.Get(), slave.s.h.counters.syncFull.Get()
+	s.doCmdMustOK(c, slave.Port(), "SLAVEOF", "NO", "ONE")
+	c.Assert(runID(slave), Not(Equals), runID(master))
+
+	for i := 0; i < 50 && sub.s.h.masterRunID.Get() != runID(slave); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(sub.s.h.masterRunID.Get(), Equals, runID(slave))
+	c.Assert(slave.s.h.counters.syncPartialOK.Get(), Equals, partial+1)
+	c.Assert(slave.s.h.counters.syncFull.Get(), Equals, full)
+
+	s.doCmdMustOK(c, slave.Port(), "SET", "chain_2", "2")
+	checkValue(sub, 0, "chain_2", "2")
+
+	// the old master written after promotion has diverged, it must full resync
+	mc.checkOK(c, "SELECT", 0)
+	mc.checkOK(c, "SET", "chain_3", "3")
+	s.doCmdMustOK(c, slave.Port(), "SET", "chain_3", "4")
+	mnc := master.Slaveof(c, slave.Port())
+	defer mnc.Close(c)
+	checkValue(master, 0, "chain_3", "4")
+	checkValue(master, 0, "chain_2", "2")
+	c.Assert(slave.s.h.counters.syncFull.Get(), Equals, full+1)
+
+	s.doCmdMustOK(c, master.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+	s.doCmdMustOK(c, slave.Port(), "FLUSHALL")
+}
+
+func (s *testReplSuite) TestSyncFilter(c *C) {
+	master := s.srv1
+	s.doCmdMustOK(c, master.Port(), "SLAVEOF", "NO", "ONE")
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+
+	port := 17783
+	slave := &testReplSrvNode{port: port, s: testCreateServer(c, port)}
+	defer func() {
+		slave.s.Close()
+	}()
+
+	filter, err := newReplFilter("1", "0-511")
+	c.Assert(err, IsNil)
+	slave.s.h.replFilter = filter
+
+	checkKeys := func(db uint32, keys []string, exists bool) {
+		for _, key := range keys {
+			v, err := slave.s.s.Get(db, [][]byte{[]byte(key)})
+			c.Assert(err, IsNil)
+			c.Assert(v != nil, Equals, exists, Commentf("db = %d, key = %s", db, key))
+		}
+	}
+	// offset of full sync is set before data are loaded, wait for the link up too
+	waitSynced := func() {
+		offset := s.infoField(c, master.Port(), "replication", "master_repl_offset")
+		synced := func() bool {
+			return strconv.FormatInt(slave.SyncOffset(c), 10) == offset && slave.s.h.masterConnState.Get() == masterConnConnected
+		}
+		for i := 0; i < 50 && !synced(); i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		c.Assert(synced(), Equals, true)
+	}
+
+	low, high := testSlotKeys("sync_filter", 3)
+
+	// written before full sync
+	mc := s.getConn(c, master.Port())
+	defer mc.Recycle()
+	for db := 0; db < 2; db++ {
+		mc.checkOK(c, "SELECT", db)
+		mc.checkOK(c, "SET", low[0], db)
+		mc.checkOK(c, "SET", high[0], db)
+	}
+
+	nc := slave.Slaveof(c, master.Port())
+	for i := 0; i < 20 && slave.SyncOffset(c) == -1; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// written after full sync, offsets of slave still match master
+	mc.checkOK(c, "MSET", low[1], "1", high[1], "1")
+	mc.checkOK(c, "SELECT", 0)
+	mc.checkOK(c, "SET", low[2], "0")
+	mc.checkInt(c, 1, "WAIT", 1, 5000)
+	waitSynced()
+
+	checkKeys(0, []string{low[0], high[0], low[2]}, false)
+	checkKeys(1, []string{low[0], low[1]}, true)
+	checkKeys(1, []string{high[0], high[1]}, false)
+
+	// keys split by the filter are written, the stream can't tell slave how to write
+	// the keys matched, slave is disconnected and syncs fully
+	setLow, setHigh := testSlotKeys("sync_filter_set", 1)
+	mc.checkOK(c, "SELECT", 1)
+	mc.checkInt(c, 1, "SADD", setHigh[0], "a")
+	full := master.s.h.counters.syncFull.Get()
+	mc.checkInt(c, 1, "SUNIONSTORE", setLow[0], setHigh[0])
+	for i := 0; i < 50 && master.s.h.counters.syncFull.Get() == full; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(master.s.h.counters.syncFull.Get(), Equals, full+1)
+	waitSynced()
+	n, err := slave.s.s.SCard(1, [][]byte{[]byte(setLow[0])})
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(1))
+	mc.checkOK(c, "SELECT", 0)
+
+	// sub-slaves can't sync the filtered stream
+	pool := testCreateConnPool(port)
+	sc := pool.Get(c)
+	info := sc.doCmd(c, "INFO", "replication")
+	c.Assert(info, FitsTypeOf, (*redis.BulkBytes)(nil))
+	c.Assert(strings.Contains(string(info.(*redis.BulkBytes).Value), "slave_repl_filter:db=1 slots=0-511\r\n"), Equals, true)
+	sc.checkContainError(c, "filter", "SYNC")
+	sc.Recycle()
+	pool.Close()
+
+	// messages are not scoped by db or slot, subscribers on slave receive all of them
+	sub := newTestSubConn(c, port)
+	sub.send(c, "SUBSCRIBE", high[0])
+	sub.checkRecv(c, "subscribe", high[0], 1)
+	mc.checkInt(c, 0, "PUBLISH", high[0], "hello")
+	sub.checkRecv(c, "message", high[0], "hello")
+	sub.Close()
+
+	nc.Close(c)
+	slave.s.Close()
+
+	// written when slave is down
+	mc.checkOK(c, "SELECT", 1)
+	mc.checkOK(c, "SET", low[2], "1")
+	mc.checkOK(c, "SET", high[2], "1")
+
+	slave.s = testOpenServer(c, port)
+	slave.s.h.replFilter = filter
+	partial := master.s.h.counters.syncPartialOK.Get()
+
+	nc = slave.Slaveof(c, master.Port())
+	waitSynced()
+	c.Assert(master.s.h.counters.syncPartialOK.Get(), Equals, partial+1)
+
+	checkKeys(1, []string{low[2]}, true)
+	checkKeys(1, []string{high[2]}, false)
+
+	nc.Close(c)
+	slave.s.Close()
+
+	// written when slave is down and split by the filter, slave syncs fully
+	for i := 0; i < 20; i++ {
+		if s.infoField(c, master.Port(), "replication", "connected_slaves") == "0" {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	mc.checkInt(c, 1, "SMOVE", setHigh[0], setLow[0], "a")
+
+	slave.s = testOpenServer(c, port)
+	slave.s.h.replFilter = filter
+	full = master.s.h.counters.syncFull.Get()
+
+	nc = slave.Slaveof(c, master.Port())
+	defer nc.Close(c)
+	waitSynced()
+	c.Assert(master.s.h.counters.syncFull.Get(), Equals, full+1)
+
+	n, err = slave.s.s.SCard(1, [][]byte{[]byte(setLow[0])})
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(1))
+	checkKeys(1, []string{low[2]}, true)
+
+	mc.checkOK(c, "SELECT", 0)
+	s.doCmdMustOK(c, master.Port(), "FLUSHALL")
+}
+
 func (s *testReplSuite) TestRedisMaster(c *C) {
 	if !s.redisExists {
 		c.Skip("no redis, skip")
@@ -0,0 +1,508 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	redis "github.com/reborndb/go/redis/resp"
+	"github.com/reborndb/qdb/pkg/store"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// session for commands called by script, bound to the store of the running script
+type scriptSession struct {
+	db    uint32
+	store *store.Store
+}
+
+func (s *scriptSession) DB() uint32 {
+	return s.db
+}
+
+func (s *scriptSession) SetDB(db uint32) {
+	s.db = db
+}
+
+func (s *scriptSession) Store() *store.Store {
+	return s.store
+}
+
+// error reply can not contain newlines
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func scriptSHA1(script []byte) string {
+	h := sha1.Sum(script)
+	return hex.EncodeToString(h[:])
+}
+
+func compileScript(script []byte) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(bytes.NewReader(script), "user_script")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return lua.Compile(chunk, "user_script")
+}
+
+// compile and cache script, returns its sha1
+func (h *Handler) loadScript(script []byte) (string, *lua.FunctionProto, error) {
+	sha := scriptSHA1(script)
+
+	h.scripts.RLock()
+	proto := h.scripts.m[sha]
+	h.scripts.RUnlock()
+	if proto != nil {
+		return sha, proto, nil
+	}
+
+	proto, err := compileScript(script)
+	if err != nil {
+		return "", nil, errors.Errorf("Error compiling script (new function): %s", oneLine(err.Error()))
+	}
+
+	h.scripts.Lock()
+	h.scripts.m[sha] = proto
+	h.scripts.Unlock()
+	return sha, proto, nil
+}
+
+func (h *Handler) getScript(sha string) *lua.FunctionProto {
+	h.scripts.RLock()
+	defer h.scripts.RUnlock()
+
+	return h.scripts.m[strings.ToLower(sha)]
+}
+
+// script runs in a sandbox, only base, table, string and math libraries are available
+func newScriptState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		f    lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.f))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	return L
+}
+
+// runningScript is the script holding the store, it can be killed until it writes
+type runningScript struct {
+	start  time.Time
+	cancel context.CancelFunc
+
+	written bool
+	killed  bool
+}
+
+func (h *Handler) startScript(cancel context.CancelFunc) *runningScript {
+	h.script.Lock()
+	defer h.script.Unlock()
+
+	r := &runningScript{start: time.Now(), cancel: cancel}
+	h.script.running = r
+	return r
+}
+
+// returns true if the script has been killed
+func (h *Handler) stopScript(r *runningScript) bool {
+	h.script.Lock()
+	defer h.script.Unlock()
+
+	if h.script.running == r {
+		h.script.running = nil
+	}
+	return r.killed
+}
+
+// returns false if the script has been killed
+func (h *Handler) markScriptWritten(r *runningScript) bool {
+	h.script.Lock()
+	defer h.script.Unlock()
+
+	r.written = true
+	return !r.killed
+}
+
+// returns true if a script has run longer than lua time limit
+func (h *Handler) scriptBusy() bool {
+	limit := h.config.LuaTimeLimit
+	if limit <= 0 {
+		return false
+	}
+
+	h.script.Lock()
+	defer h.script.Unlock()
+
+	r := h.script.running
+	return r != nil && time.Since(r.start) > time.Duration(limit)*time.Millisecond
+}
+
+func isScriptKill(cmd string, args [][]byte) bool {
+	return cmd == "script" && len(args) == 1 && strings.EqualFold(string(args[0]), "kill")
+}
+
+func (h *Handler) killScript() error {
+	h.script.Lock()
+	defer h.script.Unlock()
+
+	r := h.script.running
+	switch {
+	case r == nil:
+		return errors.New("NOTBUSY No scripts in execution right now.")
+	case r.written:
+		return errors.New("UNKILLABLE Sorry the script already executed write commands against the dataset.")
+	}
+	r.killed = true
+	r.cancel()
+	return nil
+}
+
+func newScriptTable(L *lua.LState, args [][]byte) *lua.LTable {
+	t := L.NewTable()
+	for _, arg := range args {
+		t.Append(lua.LString(arg))
+	}
+	return t
+}
+
+// run script with the store locked, all writes are forwarded as one transaction.
+// Script is stopped by the context once it is killed, see killScript.
+func (c *conn) runScript(proto *lua.FunctionProto, keys, argv [][]byte) (redis.Resp, error) {
+	L := newScriptState()
+	defer L.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	L.SetContext(ctx)
+
+	var resp redis.Resp
+	err := c.Store().Multi(func(tx *store.Store) error {
+		s := &scriptSession{db: c.db, store: tx}
+
+		r := c.h.startScript(cancel)
+		defer c.h.stopScript(r)
+
+		t := L.NewTable()
+		L.SetFuncs(t, map[string]lua.LGFunction{
+			"call": func(L *lua.LState) int {
+				return c.scriptCall(L, s, r, true)
+			},
+			"pcall": func(L *lua.LState) int {
+				return c.scriptCall(L, s, r, false)
+			},
+			"status_reply": func(L *lua.LState) int {
+				return scriptReplyTable(L, "ok")
+			},
+			"error_reply": func(L *lua.LState) int {
+				return scriptReplyTable(L, "err")
+			},
+			"sha1hex": func(L *lua.LState) int {
+				L.Push(lua.LString(scriptSHA1([]byte(L.CheckString(1)))))
+				return 1
+			},
+		})
+		L.SetGlobal("redis", t)
+		L.SetGlobal("KEYS", newScriptTable(L, keys))
+		L.SetGlobal("ARGV", newScriptTable(L, argv))
+
+		L.Push(L.NewFunctionFromProto(proto))
+		if err := L.PCall(0, 1, nil); err != nil {
+			if c.h.stopScript(r) {
+				return errors.New("Error running script: Script killed by user with SCRIPT KILL...")
+			}
+			if e, ok := err.(*lua.ApiError); ok {
+				return errors.Errorf("Error running script: %s", oneLine(e.Object.String()))
+			}
+			return errors.Trace(err)
+		}
+		resp = luaToResp(L.Get(-1))
+		L.Pop(1)
+		return nil
+	})
+	if err != nil {
+		return toRespError(err)
+	}
+	return resp, nil
+}
+
+// redis.call and redis.pcall, errors are raised by call and returned as error table by pcall
+func (c *conn) scriptCall(L *lua.LState, s *scriptSession, r *runningScript, raise bool) int {
+	fail := func(format string, args ...interface{}) int {
+		if raise {
+			L.RaiseError(format, args...)
+			return 0
+		}
+		t := L.NewTable()
+		t.RawSetString("err", lua.LString(errors.Errorf(format, args...).Error()))
+		L.Push(t)
+		return 1
+	}
+
+	n := L.GetTop()
+	if n == 0 {
+		return fail("Please specify at least one argument for redis.call()")
+	}
+
+	args := make([][]byte, 0, n)
+	for i := 1; i <= n; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString:
+			args = append(args, []byte(v))
+		case lua.LNumber:
+			args = append(args, []byte(v.String()))
+		default:
+			return fail("Lua redis() command arguments must be strings or integers")
+		}
+	}
+
+	name := strings.ToLower(string(args[0]))
+	f := c.h.htable[name]
+	if f == nil {
+		return fail("Unknown Redis command called from Lua script")
+	}
+	if f.flag&CmdNoMulti > 0 {
+		return fail("This Redis command is not allowed from scripts")
+	}
+	if f.flag&CmdWrite > 0 {
+		masterAddr := c.h.masterAddr.Get()
+		if len(masterAddr) > 0 && !c.isSyncing {
+			return fail("READONLY You can't write against a read only slave.")
+		}
+		if len(masterAddr) == 0 && !c.h.enoughGoodSlaves() {
+			return fail("NOREPLICAS Not enough good slaves to write.")
+		}
+		// script can't be killed once it writes
+		if !c.h.markScriptWritten(r) {
+			return fail("Script killed by user with SCRIPT KILL...")
+		}
+	}
+
+	resp, err := f.f(s, args[1:])
+	if x, ok := resp.(*redis.Error); ok {
+		return fail("%s", x.Value)
+	} else if err != nil {
+		return fail("%s", err)
+	}
+	L.Push(respToLua(L, resp))
+	return 1
+}
+
+// redis.status_reply and redis.error_reply
+func scriptReplyTable(L *lua.LState, field string) int {
+	t := L.NewTable()
+	t.RawSetString(field, lua.LString(L.CheckString(1)))
+	L.Push(t)
+	return 1
+}
+
+// convert command reply to lua value, same as redis
+func respToLua(L *lua.LState, r redis.Resp) lua.LValue {
+	switch x := r.(type) {
+	case *redis.Int:
+		return lua.LNumber(x.Value)
+	case *redis.String:
+		t := L.NewTable()
+		t.RawSetString("ok", lua.LString(x.Value))
+		return t
+	case *redis.Error:
+		t := L.NewTable()
+		t.RawSetString("err", lua.LString(x.Value))
+		return t
+	case *redis.BulkBytes:
+		if x.Value == nil {
+			return lua.LFalse
+		}
+		return lua.LString(x.Value)
+	case *redis.Array:
+		if x.Value == nil {
+			return lua.LFalse
+		}
+		t := L.NewTable()
+		for _, v := range x.Value {
+			t.Append(respToLua(L, v))
+		}
+		return t
+	default:
+		return lua.LFalse
+	}
+}
+
+// convert script result to reply, same as redis
+func luaToResp(v lua.LValue) redis.Resp {
+	switch x := v.(type) {
+	case lua.LNumber:
+		return redis.NewInt(int64(x))
+	case lua.LString:
+		return redis.NewBulkBytes([]byte(x))
+	case lua.LBool:
+		if x {
+			return redis.NewInt(1)
+		}
+		return redis.NewBulkBytes(nil)
+	case *lua.LTable:
+		if s, ok := x.RawGetString("ok").(lua.LString); ok {
+			return redis.NewString(string(s))
+		}
+		if s, ok := x.RawGetString("err").(lua.LString); ok {
+			return redis.NewError(errors.New(string(s)))
+		}
+		resp := redis.NewArray()
+		resp.Value = make([]redis.Resp, 0, x.Len())
+		for i := 1; ; i++ {
+			e := x.RawGetInt(i)
+			if e == lua.LNil {
+				break
+			}
+			resp.Append(luaToResp(e))
+		}
+		return resp
+	default:
+		return redis.NewBulkBytes(nil)
+	}
+}
+
+func parseScriptArgs(args [][]byte) (keys, argv [][]byte, err error) {
+	n, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, nil, errors.Errorf("value is not an integer or out of range")
+	}
+	if n < 0 {
+		return nil, nil, errors.Errorf("Number of keys can't be negative")
+	}
+	if n > int64(len(args)-1) {
+		return nil, nil, errors.Errorf("Number of keys can't be greater than number of args")
+	}
+	return args[1 : n+1], args[n+1:], nil
+}
+
+// EVAL script numkeys key [key ...] arg [arg ...]
+func EvalCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) < 2 {
+		return toRespErrorf("len(args) = %d, expect >= 2", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	keys, argv, err := parseScriptArgs(args[1:])
+	if err != nil {
+		return toRespError(err)
+	}
+
+	_, proto, err := c.h.loadScript(args[0])
+	if err != nil {
+		return toRespError(err)
+	}
+	return c.runScript(proto, keys, argv)
+}
+
+// EVALSHA sha1 numkeys key [key ...] arg [arg ...]
+func EvalSHACmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) < 2 {
+		return toRespErrorf("len(args) = %d, expect >= 2", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	keys, argv, err := parseScriptArgs(args[1:])
+	if err != nil {
+		return toRespError(err)
+	}
+
+	proto := c.h.getScript(string(args[0]))
+	if proto == nil {
+		return toRespErrorf("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return c.runScript(proto, keys, argv)
+}
+
+// SCRIPT LOAD script
+// SCRIPT EXISTS sha1 [sha1 ...]
+// SCRIPT FLUSH
+// SCRIPT KILL
+func ScriptCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) == 0 {
+		return toRespErrorf("len(args) = %d, expect != 0", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	switch sub := strings.ToLower(string(args[0])); sub {
+	case "load":
+		if len(args) != 2 {
+			return toRespErrorf("len(args) = %d, expect = 2", len(args))
+		}
+		sha, _, err := c.h.loadScript(args[1])
+		if err != nil {
+			return toRespError(err)
+		}
+		return redis.NewBulkBytes([]byte(sha)), nil
+	case "exists":
+		if len(args) < 2 {
+			return toRespErrorf("len(args) = %d, expect >= 2", len(args))
+		}
+		resp := redis.NewArray()
+		for _, sha := range args[1:] {
+			if c.h.getScript(string(sha)) != nil {
+				resp.AppendInt(1)
+			} else {
+				resp.AppendInt(0)
+			}
+		}
+		return resp, nil
+	case "flush":
+		if len(args) != 1 {
+			return toRespErrorf("len(args) = %d, expect = 1", len(args))
+		}
+		c.h.scripts.Lock()
+		c.h.scripts.m = make(map[string]*lua.FunctionProto)
+		c.h.scripts.Unlock()
+		return redis.NewString("OK"), nil
+	case "kill":
+		if len(args) != 1 {
+			return toRespErrorf("len(args) = %d, expect = 1", len(args))
+		}
+		if err := c.h.killScript(); err != nil {
+			return toRespError(err)
+		}
+		return redis.NewString("OK"), nil
+	default:
+		return toRespErrorf("unknown SCRIPT subcommand %s", sub)
+	}
+}
+
+func init() {
+	Register("eval", EvalCmd, CmdReadonly)
+	Register("evalsha", EvalSHACmd, CmdReadonly)
+	Register("script", ScriptCmd, CmdReadonly)
+}
@@ -0,0 +1,151 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"time"
+
+	redis "github.com/reborndb/go/redis/resp"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testServiceSuite) TestEval(c *C) {
+	nc := s.getConn(c)
+	defer nc.Recycle()
+
+	nc.checkOK(c, "SELECT", 104)
+	defer nc.checkOK(c, "SELECT", 0)
+
+	nc.checkInt(c, 10, "EVAL", "return 10", 0)
+	nc.checkInt(c, 3, "EVAL", "return 3.99", 0)
+	nc.checkString(c, "hello", "EVAL", "return 'hello'", 0)
+	nc.checkInt(c, 1, "EVAL", "return true", 0)
+	nc.checkNil(c, "EVAL", "return false", 0)
+	nc.checkNil(c, "EVAL", "return nil", 0)
+	nc.checkString(c, "OK", "EVAL", "return redis.status_reply('OK')", 0)
+	nc.checkError(c, "my error", "EVAL", "return redis.error_reply('my error')", 0)
+
+	ay := nc.checkBytesArray(c, "EVAL", "return {KEYS[1], KEYS[2], ARGV[1], ARGV[2]}", 2, "k1", "k2", "a1", "a2")
+	c.Assert(ay, DeepEquals, [][]byte{[]byte("k1"), []byte("k2"), []byte("a1"), []byte("a2")})
+	// array is truncated at the first nil
+	resp := nc.doCmd(c, "EVAL", "return {1, 'a', nil, 2}", 0)
+	c.Assert(resp, DeepEquals, redis.Resp(&redis.Array{Value: []redis.Resp{redis.NewInt(1), redis.NewBulkBytesWithString("a")}}))
+
+	nc.checkOK(c, "EVAL", "return redis.call('set', KEYS[1], ARGV[1])", 1, "a", "100")
+	nc.checkInt(c, 101, "EVAL", "return redis.call('incr', KEYS[1])", 1, "a")
+	nc.checkString(c, "101", "GET", "a")
+	nc.checkInt(c, 1, "EVAL", "if redis.call('get', KEYS[1]) == false then return 1 end return 0", 1, "b")
+	nc.checkInt(c, 2, "EVAL", "redis.call('rpush', KEYS[1], 'x', 'y') return #redis.call('lrange', KEYS[1], 0, -1)", 1, "l")
+	nc.checkInt(c, 1, "EVAL", "redis.call('select', 105) return redis.call('set', 'a', 1) and 1", 0)
+
+	// redis.call raises error, redis.pcall returns it
+	nc.checkContainError(c, "Error running script", "EVAL", "return redis.call('lpush', KEYS[1], 'x')", 1, "a")
+	nc.checkContainError(c, "Unknown Redis command", "EVAL", "return redis.call('nocommand')", 0)
+	nc.checkContainError(c, "not allowed from scripts", "EVAL", "return redis.call('sync')", 0)
+	// commands using the store by themselves would wait for the store locked by the script
+	for _, cmd := range []string{"save", "bgsaveto", "backup", "slaveof", "shutdown"} {
+		nc.checkContainError(c, "not allowed from scripts", "EVAL", "return redis.call(KEYS[1], 'a', 'b')", 1, cmd)
+	}
+	nc.checkContainError(c, "not list", "EVAL", "return redis.pcall('lpush', KEYS[1], 'x')", 1, "a")
+	nc.checkContainError(c, "Error compiling script", "EVAL", "return (", 0)
+	nc.checkContainError(c, "Error running script", "EVAL", "return dofile('/etc/passwd')", 0)
+
+	nc.checkContainError(c, "can't be negative", "EVAL", "return 1", -1)
+	nc.checkContainError(c, "can't be greater", "EVAL", "return 1", 2, "a")
+
+	nc.checkInt(c, 2, "DEL", "a", "l")
+	nc.checkOK(c, "SELECT", 105)
+	nc.checkInt(c, 1, "DEL", "a")
+}
+
+func (s *testServiceSuite) TestEvalSHA(c *C) {
+	nc := s.getConn(c)
+	defer nc.Recycle()
+
+	nc.checkOK(c, "SELECT", 106)
+	defer nc.checkOK(c, "SELECT", 0)
+
+	script := "return redis.call('set', KEYS[1], ARGV[1])"
+	sha := "2ec6e6b2b8d6e5cbc6c7b5bd8c0b5e9b15b5e2c2"
+	nc.checkError(c, "NOSCRIPT No matching script. Please use EVAL.", "EVALSHA", sha, 1, "a", "1")
+
+	resp := nc.doCmd(c, "SCRIPT", "LOAD", script)
+	b, ok := resp.(*redis.BulkBytes)
+	c.Assert(ok, Equals, true)
+	sha = string(b.Value)
+	c.Assert(sha, HasLen, 40)
+
+	nc.checkString(c, sha, "EVAL", "return redis.sha1hex(ARGV[1])", 0, script)
+	nc.checkIntArray(c, []int64{1, 0}, "SCRIPT", "EXISTS", sha, "ffffffffffffffffffffffffffffffffffffffff")
+	nc.checkOK(c, "EVALSHA", sha, 1, "a", "1")
+	nc.checkString(c, "1", "GET", "a")
+
+	nc.checkOK(c, "SCRIPT", "FLUSH")
+	nc.checkIntArray(c, []int64{0}, "SCRIPT", "EXISTS", sha)
+	nc.checkContainError(c, "NOSCRIPT", "EVALSHA", sha, 1, "a", "1")
+
+	// EVAL caches script too
+	nc.checkOK(c, "EVAL", script, 1, "a", "2")
+	nc.checkIntArray(c, []int64{1}, "SCRIPT", "EXISTS", sha)
+
+	// script in transaction
+	nc.checkOK(c, "MULTI")
+	nc.checkString(c, "QUEUED", "EVALSHA", sha, 1, "a", "3")
+	nc.checkString(c, "QUEUED", "GET", "a")
+	resp = nc.doCmd(c, "EXEC")
+	c.Assert(resp, DeepEquals, redis.Resp(&redis.Array{Value: []redis.Resp{redis.NewString("OK"), redis.NewBulkBytesWithString("3")}}))
+
+	nc.checkInt(c, 1, "DEL", "a")
+}
+
+// wait until a script has run longer than lua time limit
+func (s *testServiceSuite) waitScriptBusy(c *C) {
+	for i := 0; i < 500; i++ {
+		if s.s.h.scriptBusy() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("wait busy script timeout")
+}
+
+func (s *testServiceSuite) TestScriptKill(c *C) {
+	k := randomKey(c)
+
+	config := s.s.h.config
+	defer func(limit int) {
+		config.LuaTimeLimit = limit
+	}(config.LuaTimeLimit)
+	config.LuaTimeLimit = 10
+
+	s.checkContainError(c, "NOTBUSY", "SCRIPT", "KILL")
+
+	sc := newTestSubConn(c, 16380)
+	defer sc.Close()
+
+	// read only script is killed
+	sc.send(c, "EVAL", "while true do end", 0)
+	s.waitScriptBusy(c)
+	s.checkContainError(c, "BUSY", "GET", k)
+	s.checkOK(c, "SCRIPT", "KILL")
+	e, ok := sc.recv(c).(*redis.Error)
+	c.Assert(ok, Equals, true)
+	c.Assert(e.Value, Matches, ".*killed by user.*")
+	s.checkNil(c, "GET", k)
+
+	// script which has written can't be killed
+	sc.send(c, "EVAL", "redis.call('set', KEYS[1], 1) while true do end", 1, k)
+	s.waitScriptBusy(c)
+	s.checkContainError(c, "UNKILLABLE", "SCRIPT", "KILL")
+
+	h := s.s.h
+	h.script.Lock()
+	h.script.running.cancel()
+	h.script.Unlock()
+	_, ok = sc.recv(c).(*redis.Error)
+	c.Assert(ok, Equals, true)
+
+	s.checkString(c, "1", "GET", k)
+	s.checkInt(c, 1, "DEL", k)
+}
@@ -45,6 +45,15 @@ func SMembersCmd(s Session, args [][]byte) (redis.Resp, error) {
 	}
 }
 
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func SScanCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if cursor, a, err := s.Store().SScan(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return newScanResp(cursor, a), nil
+	}
+}
+
 // SPOP key
 func SPopCmd(s Session, args [][]byte) (redis.Resp, error) {
 	if v, err := s.Store().SPop(s.DB(), args); err != nil {
@@ -76,12 +85,95 @@ func SRemCmd(s Session, args [][]byte) (redis.Resp, error) {
 	}
 }
 
+// SINTER key [key ...]
+func SInterCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if a, err := s.Store().SInter(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		resp := redis.NewArray()
+		for _, v := range a {
+			resp.AppendBulkBytes(v)
+		}
+		return resp, nil
+	}
+}
+
+// SUNION key [key ...]
+func SUnionCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if a, err := s.Store().SUnion(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		resp := redis.NewArray()
+		for _, v := range a {
+			resp.AppendBulkBytes(v)
+		}
+		return resp, nil
+	}
+}
+
+// SDIFF key [key ...]
+func SDiffCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if a, err := s.Store().SDiff(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		resp := redis.NewArray()
+		for _, v := range a {
+			resp.AppendBulkBytes(v)
+		}
+		return resp, nil
+	}
+}
+
+// SINTERSTORE destination key [key ...]
+func SInterStoreCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if n, err := s.Store().SInterStore(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewInt(n), nil
+	}
+}
+
+// SUNIONSTORE destination key [key ...]
+func SUnionStoreCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if n, err := s.Store().SUnionStore(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewInt(n), nil
+	}
+}
+
+// SDIFFSTORE destination key [key ...]
+func SDiffStoreCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if n, err := s.Store().SDiffStore(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewInt(n), nil
+	}
+}
+
+// SMOVE source destination member
+func SMoveCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if n, err := s.Store().SMove(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewInt(n), nil
+	}
+}
+
 func init() {
 	Register("sadd", SAddCmd, CmdWrite)
 	Register("scard", SCardCmd, CmdReadonly)
+	Register("sdiff", SDiffCmd, CmdReadonly)
+	Register("sdiffstore", SDiffStoreCmd, CmdWrite)
+	Register("sinter", SInterCmd, CmdReadonly)
+	Register("sinterstore", SInterStoreCmd, CmdWrite)
 	Register("sismember", SIsMemberCmd, CmdReadonly)
 	Register("smembers", SMembersCmd, CmdReadonly)
+	Register("smove", SMoveCmd, CmdWrite)
 	Register("spop", SPopCmd, CmdWrite)
 	Register("srandmember", SRandMemberCmd, CmdReadonly)
 	Register("srem", SRemCmd, CmdWrite)
+	Register("sscan", SScanCmd, CmdReadonly)
+	Register("sunion", SUnionCmd, CmdReadonly)
+	Register("sunionstore", SUnionStoreCmd, CmdWrite)
 }
@@ -89,3 +89,70 @@ func (s *testServiceSuite) TestRandMember(c *C) {
 	c.Assert(m["key2"], Equals, true)
 	c.Assert(m["key3"], Equals, true)
 }
+
+func (s *testServiceSuite) TestSScan(c *C) {
+	key := randomKey(c)
+	nc := s.getConn(c)
+	defer nc.Recycle()
+
+	c.Assert(nc.checkScanAll(c, "sscan", 1, key), HasLen, 0)
+	nc.checkInt(c, 3, "sadd", key, "a", "b", "c")
+	c.Assert(nc.checkScanAll(c, "sscan", 2, key), HasLen, 3)
+	_, ay := nc.checkScan(c, "sscan", key, 0, "match", "[ac]")
+	c.Assert(ay, DeepEquals, [][]byte{[]byte("a"), []byte("c")})
+}
+
+func (s *testServiceSuite) TestSInter(c *C) {
+	k1, k2, k3 := randomKey(c), randomKey(c), randomKey(c)
+	s.checkInt(c, 4, "sadd", k1, "a", "b", "c", "d")
+	s.checkInt(c, 3, "sadd", k2, "c", "d", "e")
+
+	checkMembers := func(ay [][]byte, expect ...string) {
+		c.Assert(ay, HasLen, len(expect))
+		m := make(map[string]bool)
+		for _, v := range ay {
+			m[string(v)] = true
+		}
+		for _, e := range expect {
+			c.Assert(m[e], Equals, true)
+		}
+	}
+
+	checkMembers(s.checkBytesArray(c, "sinter", k1, k2), "c", "d")
+	checkMembers(s.checkBytesArray(c, "sinter", k1, k3))
+	checkMembers(s.checkBytesArray(c, "sunion", k1, k2, k3), "a", "b", "c", "d", "e")
+	checkMembers(s.checkBytesArray(c, "sdiff", k1, k2), "a", "b")
+
+	s.checkInt(c, 2, "sinterstore", k3, k1, k2)
+	s.checkSet(c, k3, []string{"c", "d"})
+	s.checkInt(c, 5, "sunionstore", k3, k1, k2)
+	s.checkSet(c, k3, []string{"a", "b", "c", "d", "e"})
+	s.checkInt(c, 1, "sdiffstore", k3, k2, k1)
+	s.checkSet(c, k3, []string{"e"})
+	s.checkInt(c, 0, "sdiffstore", k3, k1, k1)
+	s.checkInt(c, 0, "exists", k3)
+
+	s.checkOK(c, "set", k3, "value")
+	s.checkContainError(c, "not set", "sinter", k1, k3)
+	s.checkInt(c, 2, "sinterstore", k3, k1, k2)
+	s.checkSet(c, k3, []string{"c", "d"})
+
+	s.checkInt(c, 3, "del", k1, k2, k3)
+}
+
+func (s *testServiceSuite) TestSMove(c *C) {
+	k1, k2 := randomKey(c), randomKey(c)
+	s.checkInt(c, 2, "sadd", k1, "a", "b")
+	s.checkInt(c, 0, "smove", k1, k2, "x")
+	s.checkInt(c, 1, "smove", k1, k2, "a")
+	s.checkSet(c, k1, []string{"b"})
+	s.checkSet(c, k2, []string{"a"})
+	s.checkInt(c, 1, "smove", k1, k2, "b")
+	s.checkInt(c, 0, "exists", k1)
+	s.checkSet(c, k2, []string{"a", "b"})
+
+	s.checkOK(c, "set", k1, "value")
+	s.checkContainError(c, "not set", "smove", k2, k1, "a")
+	s.checkSet(c, k2, []string{"a", "b"})
+	s.checkInt(c, 2, "del", k1, k2)
+}
@@ -0,0 +1,54 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/juju/errors"
+	"github.com/reborndb/go/redis/rdb"
+)
+
+// snapshotWriter saves objects of a store snapshot in a file format,
+// objects are written by one goroutine at a time in no particular order.
+type snapshotWriter interface {
+	WriteObjects(objs []*rdb.ObjEntry) error
+
+	// Close writes the end of file and flushes buffered data,
+	// the underlying writer is not closed.
+	Close() error
+}
+
+type newSnapshotWriterFunc func(w io.Writer) (snapshotWriter, error)
+
+type rdbSnapshotWriter struct {
+	buf *bufio.Writer
+	enc *rdb.Encoder
+}
+
+func newRDBSnapshotWriter(w io.Writer) (snapshotWriter, error) {
+	buf := bufio.NewWriterSize(w, 1024*1024)
+	enc := rdb.NewEncoder(buf)
+	if err := enc.EncodeHeader(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &rdbSnapshotWriter{buf: buf, enc: enc}, nil
+}
+
+func (w *rdbSnapshotWriter) WriteObjects(objs []*rdb.ObjEntry) error {
+	for _, obj := range objs {
+		if err := w.enc.EncodeObject(obj.DB, obj.Key, obj.ExpireAt, obj.Value); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func (w *rdbSnapshotWriter) Close() error {
+	if err := w.enc.EncodeFooter(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(w.buf.Flush())
+}
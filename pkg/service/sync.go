@@ -5,10 +5,12 @@ package service
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -35,22 +37,34 @@ func BgsaveCmd(s Session, args [][]byte) (redis.Resp, error) {
 		return nil, errors.New("invalid connection")
 	}
 
+	if c.h.counters.bgsave.Get() != 0 {
+		return toRespErrorf("background save already in progress")
+	}
+
 	if ok := c.h.bgSaveSem.AcquireTimeout(time.Second); !ok {
 		return toRespErrorf("wait others do bgsave timeout")
 	}
-	defer c.h.bgSaveSem.Release()
 
 	sp, err := c.Store().NewSnapshot()
 	if err != nil {
+		c.h.bgSaveSem.Release()
 		return toRespError(err)
 	}
-	defer c.Store().ReleaseSnapshot(sp)
 
-	if err := c.h.bgsaveTo(sp, c.h.config.DumpPath); err != nil {
-		return toRespError(err)
-	} else {
-		return redis.NewString("OK"), nil
-	}
+	// in progress before replying, so pollers of INFO never miss it
+	c.h.startSave()
+	go func() {
+		defer c.h.bgSaveSem.Release()
+		defer c.h.store.ReleaseSnapshot(sp)
+
+		err := c.h.saveTo(sp, c.h.config.DumpPath, newRDBSnapshotWriter, nil)
+		if err != nil {
+			log.Errorf("background save failed - %s", err)
+		}
+		c.h.finishSave(err)
+	}()
+
+	return redis.NewString("Background saving started"), nil
 }
 
 // BGSAVETO path
@@ -64,68 +78,116 @@ func BgsaveToCmd(s Session, args [][]byte) (redis.Resp, error) {
 		return nil, errors.New("invalid connection")
 	}
 
-	if ok := c.h.bgSaveSem.AcquireTimeout(time.Second); !ok {
+	return c.h.saveCmd(string(args[0]))
+}
+
+// SAVE
+func SaveCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) != 0 {
+		return toRespErrorf("len(args) = %d, expect = 0", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	return c.h.saveCmd(c.h.config.DumpPath)
+}
+
+// LASTSAVE
+func LastSaveCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) != 0 {
+		return toRespErrorf("len(args) = %d, expect = 0", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	return redis.NewInt(c.h.rdbSave.lastSave.Get()), nil
+}
+
+func (h *Handler) saveCmd(path string) (redis.Resp, error) {
+	if ok := h.bgSaveSem.AcquireTimeout(time.Second); !ok {
 		return toRespErrorf("wait others do bgsave timeout")
 	}
-	defer c.h.bgSaveSem.Release()
+	defer h.bgSaveSem.Release()
 
-	sp, err := c.Store().NewSnapshot()
+	sp, err := h.store.NewSnapshot()
 	if err != nil {
 		return toRespError(err)
 	}
-	defer c.Store().ReleaseSnapshot(sp)
+	defer h.store.ReleaseSnapshot(sp)
 
-	if err := c.h.bgsaveTo(sp, string(args[0])); err != nil {
+	if err := h.bgsaveTo(sp, path, nil); err != nil {
 		return toRespError(err)
 	} else {
 		return redis.NewString("OK"), nil
 	}
 }
 
-func (h *Handler) bgsaveTo(sp *store.StoreSnapshot, path string) error {
+// only objects in slots of dbs accepted by match are saved, nil match accepts all
+func (h *Handler) bgsaveTo(sp *store.StoreSnapshot, path string, match func(db, slot uint32) bool) error {
+	h.startSave()
+	err := h.saveTo(sp, path, newRDBSnapshotWriter, match)
+	h.finishSave(err)
+	return err
+}
+
+func (h *Handler) startSave() {
 	h.counters.bgsave.Add(1)
-	defer h.counters.bgsave.Sub(1)
+	h.rdbSave.savedKeys.Set(0)
+	h.rdbSave.since.Set(time.Now().Unix())
+}
 
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+func (h *Handler) finishSave(err error) {
+	now := time.Now().Unix()
+	h.rdbSave.lastTime.Set(now - h.rdbSave.since.Get())
+	if err != nil {
+		h.rdbSave.lastStatus.Set("err")
+	} else {
+		h.rdbSave.lastStatus.Set("ok")
+		h.rdbSave.lastSave.Set(now)
+	}
+	h.counters.bgsave.Sub(1)
+}
+
+// saveTo writes snapshot to a temporary file by workers and renames it to path
+func (h *Handler) saveTo(sp *store.StoreSnapshot, path string, newWriter newSnapshotWriterFunc, match func(db, slot uint32) bool) error {
+	tmp := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	defer os.Remove(tmp)
 	defer f.Close()
 
-	buf := bufio.NewWriterSize(f, 1024*1024)
-	enc := rdb.NewEncoder(buf)
-
-	if err := enc.EncodeHeader(); err != nil {
+	w, err := newWriter(f)
+	if err != nil {
 		return errors.Trace(err)
 	}
 
-	ncpu := 1
-	cron := time.Millisecond * time.Duration(100)
-	for {
-		objs, more, err := sp.LoadObjCron(cron, ncpu, 1024)
-		if err != nil {
-			return errors.Trace(err)
-		} else {
-			for _, obj := range objs {
-				if err := enc.EncodeObject(obj.DB, obj.Key, obj.ExpireAt, obj.Value); err != nil {
-					return errors.Trace(err)
-				}
-			}
-		}
-		if !more {
-			break
-		}
+	workers := h.config.BgsaveWorkers
+	if workers <= 0 {
+		workers = 1
 	}
-
-	if err := enc.EncodeFooter(); err != nil {
+	err = sp.LoadObjSlotsMatch(workers, 1024, match, func(objs []*rdb.ObjEntry) error {
+		h.rdbSave.savedKeys.Add(int64(len(objs)))
+		return w.WriteObjects(objs)
+	})
+	if err != nil {
 		return errors.Trace(err)
 	}
 
-	if err := errors.Trace(buf.Flush()); err != nil {
+	if err := w.Close(); err != nil {
 		return errors.Trace(err)
 	}
-
-	return errors.Trace(f.Close())
+	if err := f.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmp, path))
 }
 
 // SLAVEOF host port
@@ -194,6 +256,25 @@ func (h *Handler) replicationConnectMaster(addr string) (*conn, error) {
 	} else {
 		log.Errorf("server listening addr %s has invalid port", h.config.Listen)
 	}
+
+	if h.replFilter != nil {
+		// master must not send the keys filtered out
+		if err := c.doMustOK("REPLCONF", "filter-db", h.replFilter.dbsString()); err != nil {
+			c.Close()
+			return nil, errors.Trace(err)
+		}
+		if err := c.doMustOK("REPLCONF", "filter-slots", h.replFilter.slotsString()); err != nil {
+			c.Close()
+			return nil, errors.Trace(err)
+		}
+	}
+
+	if h.config.SyncCheckpointPath != "" {
+		// master which doesn't support checkpoint will still send RDB
+		if err := c.doMustOK("REPLCONF", "checkpoint", h.store.EngineName()); err != nil {
+			log.Warningf("master %s doesn't accept checkpoint - %s", addr, err)
+		}
+	}
 	h.masterConnState.Set(masterConnConnected)
 	return c, nil
 }
@@ -204,7 +285,7 @@ func (h *Handler) daemonSyncMaster() {
 	var last *conn
 	lost := make(chan int, 0)
 
-	h.masterRunID = "?"
+	h.masterRunID.Set("?")
 	h.syncOffset.Set(-1)
 	h.masterConnState.Set(masterConnNone)
 
@@ -250,13 +331,21 @@ LOOP:
 		if c != nil {
 			masterAddr := c.nc.RemoteAddr().String()
 
+			if h.masterAddr.Get() == "" {
+				// state saved by the last synchronization, maybe before restarting,
+				// the master will check its run id
+				h.loadReplState()
+				if h.masterRunID.Get() == "?" {
+					h.replicationDemote()
+				}
+			}
+
 			syncOffset := h.syncOffset.Get()
-			if masterAddr == h.masterAddr.Get() && h.masterRunID != "?" {
-				// sync same master with last synchronization
+			if h.masterRunID.Get() != "?" {
+				// any master continuing the same stream accepts partial resync,
+				// e.g. the last one or the one promoted from its slave
 				syncOffset++
 			} else {
-				// last sync master is not same
-				h.masterRunID = "?"
 				h.syncOffset.Set(-1)
 				syncOffset = -1
 			}
@@ -272,17 +361,23 @@ LOOP:
 					lost <- 0
 				}()
 				defer c.Close()
-				err := h.psync(c, h.masterRunID, syncOffset)
+				err := h.psync(c, h.masterRunID.Get(), syncOffset)
 				log.Warningf("slave %s do psync err - %s", c, err)
 			}(syncOffset)
 
 			h.syncSince.Set(time.Now().UnixNano() / int64(time.Millisecond))
 			log.Infof("slaveof %s", h.masterAddr.Get())
 		} else {
+			if h.masterAddr.Get() != "" {
+				h.replicationPromote()
+			}
 			h.masterAddr.Set("")
 			h.syncOffset.Set(-1)
-			h.masterRunID = "?"
+			h.masterRunID.Set("?")
 			h.syncSince.Set(0)
+			if err := h.store.ClearReplState(); err != nil {
+				log.Errorf("clear replication state failed - %s", err)
+			}
 
 			// we are master now, so can delete expired key automatically
 			h.store.SetDeleteIfExpired(true)
@@ -295,9 +390,35 @@ LOOP:
 	}
 }
 
-func (h *Handler) parseFullResyncReply(resp string) (string, int64) {
+// replication state saved with the applied writes, so a restarted slave can partial resync
+func (h *Handler) loadReplState() {
+	h.masterRunID.Set("?")
+	h.syncOffset.Set(-1)
+	h.syncDB.Set(0)
+
+	st, err := h.store.LoadReplState()
+	if err != nil {
+		log.Errorf("load replication state failed - %s", err)
+		return
+	} else if st == nil {
+		return
+	}
+
+	if st.Filter != h.replFilter.String() {
+		log.Infof("replication state of filter '%s' is ignored, current filter is '%s'", st.Filter, h.replFilter)
+		return
+	}
+
+	h.masterRunID.Set(st.RunID)
+	h.syncOffset.Set(st.Offset)
+	h.syncDB.Set(int64(st.DB))
+	log.Infof("load replication state, master run id = %s, offset = %d", st.RunID, st.Offset)
+}
+
+// returns run id, offset and db selected at the offset
+func (h *Handler) parseFullResyncReply(resp string) (string, int64, uint32) {
 	seps := strings.Split(resp, " ")
-	if len(seps) != 3 || len(seps[1]) != 40 {
+	if (len(seps) != 3 && len(seps) != 4) || len(seps[1]) != 40 {
 		log.Errorf("master %s returns invalid fullresync format %s", h.masterAddr, resp)
 	}
 
@@ -307,66 +428,141 @@ func (h *Handler) parseFullResyncReply(resp string) (string, int64) {
 		log.Errorf("master %s returns invalid fullresync offset, err: %v", h.masterAddr, err)
 		initailSyncOffset = -1
 	}
-	return masterRunID, initailSyncOffset
+
+	// slave of slave replies db selected in the stream
+	db := uint32(0)
+	if len(seps) == 4 {
+		n, err := strconv.ParseUint(seps[3], 10, 32)
+		if err != nil {
+			log.Errorf("master %s returns invalid fullresync db, err: %v", h.masterAddr.Get(), err)
+		}
+		db = uint32(n)
+	}
+	return masterRunID, initailSyncOffset, db
 }
 
-func (h *Handler) readSyncRDBSize(c *conn) (int64, error) {
-	// wait rdb size line
+// reads the first line of full sync, returns the function which loads
+// the RDB or checkpoint following the line
+func (h *Handler) readSyncFullHeader(c *conn) (func(c *conn) error, error) {
 	line, err := c.readLine()
 	if err != nil {
-		return 0, errors.Trace(err)
+		return nil, errors.Trace(err)
+	}
+
+	if bytes.HasPrefix(line, []byte("+CHECKPOINT ")) {
+		seps := strings.Split(string(line), " ")
+		if len(seps) != 3 && len(seps) != 4 {
+			return nil, errors.Errorf("invalid full sync checkpoint response, rsp = '%s'", line)
+		}
+		offset, err1 := strconv.ParseInt(seps[1], 10, 64)
+		count, err2 := strconv.Atoi(seps[2])
+		if err1 != nil || err2 != nil || count <= 0 {
+			return nil, errors.Errorf("invalid full sync checkpoint response, rsp = '%s'", line)
+		}
+		db := uint64(0)
+		if len(seps) == 4 {
+			if db, err = strconv.ParseUint(seps[3], 10, 32); err != nil {
+				return nil, errors.Errorf("invalid full sync checkpoint response, rsp = '%s'", line)
+			}
+		}
+
+		return func(c *conn) error {
+			h.masterConnState.Set(masterConnSync)
+			log.Infof("sync checkpoint files = %d, offset = %d, db = %d", count, offset, db)
+			if err := h.doSyncCheckpoint(c, offset, uint32(db), count); err != nil {
+				return errors.Trace(err)
+			}
+			log.Infof("sync checkpoint done")
+			return nil
+		}, nil
 	}
 
 	if line[0] != '$' {
-		return 0, errors.Errorf("invalid full sync response, rsp = '%s'", line)
+		return nil, errors.Errorf("invalid full sync response, rsp = '%s'", line)
 	}
 
 	n, err := strconv.ParseInt(string(line[1:]), 10, 64)
 	if err != nil || n <= 0 {
-		return 0, errors.Errorf("invalid full sync response = '%s', error = '%s', n = %d", line, err, n)
+		return nil, errors.Errorf("invalid full sync response = '%s', error = '%s', n = %d", line, err, n)
 	}
 
-	return n, nil
+	return func(c *conn) error {
+		if err := c.Store().Reset(); err != nil {
+			return errors.Trace(err)
+		}
+
+		h.masterConnState.Set(masterConnSync)
+		log.Infof("sync rdb file size = %d bytes\n", n)
+		if err := h.doSyncRDB(c, n); err != nil {
+			return errors.Trace(err)
+		}
+		log.Infof("sync rdb done")
+		return nil
+	}, nil
 }
 
 func (h *Handler) psync(c *conn, masterRunID string, syncOffset int64) error {
-	// first, we send PSYNC command
-	resp, err := c.prePSync(masterRunID, syncOffset)
+	// first, we send PSYNC command, filtered stream needs the db selected at offset
+	args := []interface{}{masterRunID, syncOffset}
+	if h.replFilter != nil && masterRunID != "?" {
+		args = append(args, h.syncDB.Get())
+	}
+	resp, err := c.prePSync(args...)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
 	resp = strings.ToLower(resp)
-	rdbSize := int64(0)
+	var full func(c *conn) error
 
-	if resp == "+continue" {
+	if resp == "+continue" || strings.HasPrefix(resp, "+continue ") {
 		// do parital Resynchronization
 		log.Infof("master %s support psync, start from %d now", h.masterAddr.Get(), syncOffset)
+		if seps := strings.Split(resp, " "); len(seps) == 2 && seps[1] != h.masterRunID.Get() {
+			// master promoted from a slave continues the stream with a new run id
+			log.Infof("master %s continues with new run id %s", h.masterAddr.Get(), seps[1])
+			h.masterRunID.Set(seps[1])
+			h.repl.Lock()
+			h.replicationShiftRunID([]byte(seps[1]))
+			h.repl.Unlock()
+		}
+		// master doesn't select db again for the continued stream
+		c.db = uint32(h.syncDB.Get())
 	} else {
 		initialSyncOffset := int64(-1)
 		h.syncOffset.Set(-1)
+		h.syncDB.Set(0)
 
 		if strings.HasPrefix(resp, "+fullresync") {
 			// go here we need full resync
-			h.masterRunID, initialSyncOffset = h.parseFullResyncReply(resp)
-			log.Infof("start fullresync from %d", initialSyncOffset)
+			var runID string
+			var db uint32
+			runID, initialSyncOffset, db = h.parseFullResyncReply(resp)
+			h.masterRunID.Set(runID)
+			log.Infof("start fullresync from %d, db = %d", initialSyncOffset, db)
 			h.syncOffset.Set(initialSyncOffset)
+			h.syncDB.Set(int64(db))
 		} else {
 			// here master does not support PSYNC, we use SYNC instead
 			log.Errorf("master %s doesn't support PSYNC, reply is %s, try SYNC", h.masterAddr.Get(), resp)
 
+			h.masterRunID.Set("?")
 			if err = c.sendCommand("SYNC"); err != nil {
 				return errors.Trace(err)
 			}
 		}
 
-		rdbSize, err = h.readSyncRDBSize(c)
+		// sub-slaves can't sync from us until full resync is done
+		h.masterConnState.Set(masterConnSync)
+		h.replicationResetStream(h.masterRunID.Get(), initialSyncOffset, uint32(h.syncDB.Get()))
+
+		full, err = h.readSyncFullHeader(c)
 		if err != nil {
 			return errors.Trace(err)
 		}
 	}
 
-	return h.startSyncFromMaster(c, rdbSize)
+	return h.startSyncFromMaster(c, full)
 }
 
 func (h *Handler) openSyncPipe() (pipe.Reader, pipe.Writer) {
@@ -389,7 +585,7 @@ func (h *Handler) openSyncPipe() (pipe.Reader, pipe.Writer) {
 	return pr, pw
 }
 
-func (h *Handler) startSyncFromMaster(c *conn, size int64) error {
+func (h *Handler) startSyncFromMaster(c *conn, full func(c *conn) error) error {
 	c.isSyncing = true
 	defer func() {
 		h.counters.syncTotalBytes.Set(0)
@@ -447,129 +643,421 @@ func (h *Handler) startSyncFromMaster(c *conn, size int64) error {
 	}()
 
 	var counter atomic2.Int64
-	c.r = bufio.NewReader(ioutils.NewCountReader(pr, &counter))
+	stream := &syncStreamReader{r: ioutils.NewCountReader(pr, &counter)}
+	c.r = bufio.NewReader(stream)
 
-	if size > 0 {
+	if full != nil {
 		// we need full sync first
-		if err := c.Store().Reset(); err != nil {
+		if err := full(c); err != nil {
 			return errors.Trace(err)
 		}
 
-		h.masterConnState.Set(masterConnSync)
-		log.Infof("sync rdb file size = %d bytes\n", size)
-		if err := h.doSyncRDB(c, size); err != nil {
-			return errors.Trace(err)
+		// the stream continues with the db selected at the sync offset
+		c.db = uint32(h.syncDB.Get())
+		if h.syncOffset.Get() != -1 {
+			st := &store.ReplState{RunID: h.masterRunID.Get(), Offset: h.syncOffset.Get(), DB: c.db, Filter: h.replFilter.String()}
+			if err := c.Store().SaveReplState(st); err != nil {
+				return errors.Trace(err)
+			}
 		}
-		log.Infof("sync rdb done")
+		h.replicationResetStream(h.masterRunID.Get(), h.syncOffset.Get(), c.db)
 	}
 
 	h.masterConnState.Set(masterConnConnected)
-	return h.doSyncFromMater(c, &counter)
+	return h.doSyncFromMater(c, &counter, stream)
 }
 
-func (h *Handler) doSyncFromMater(c *conn, counter *atomic2.Int64) error {
+// records bytes read from master once started, so slave can feed sub-slaves with the same stream
+type syncStreamReader struct {
+	r   io.Reader
+	buf []byte
+
+	recording bool
+}
+
+func (r *syncStreamReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.recording {
+		r.buf = append(r.buf, p[:n]...)
+	}
+	return n, err
+}
+
+// starts recording, bytes buffered by br are not handled yet
+func (r *syncStreamReader) start(br *bufio.Reader) {
+	b, _ := br.Peek(br.Buffered())
+	r.buf = append(r.buf[:0], b...)
+	r.recording = true
+}
+
+// returns the next n bytes recorded
+func (r *syncStreamReader) next(n int) []byte {
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+// db selected in the stream after the request from master
+func syncSelectedDB(request redis.Resp, db uint32) uint32 {
+	cmd, args, err := redis.ParseArgs(request)
+	if err != nil || cmd != "select" || len(args) != 1 {
+		return db
+	}
+	if n, err := strconv.ParseUint(string(args[0]), 10, 32); err == nil {
+		return uint32(n)
+	}
+	return db
+}
+
+// returns the offset in REPLCONF OFFSET sent by master filtering the stream
+func syncStreamOffset(request redis.Resp) (int64, bool) {
+	cmd, args, err := redis.ParseArgs(request)
+	if err != nil || cmd != "replconf" || len(args) != 2 || !strings.EqualFold(string(args[0]), "offset") {
+		return 0, false
+	}
+	if n, err := strconv.ParseInt(string(args[1]), 10, 64); err == nil {
+		return n, true
+	}
+	return 0, false
+}
+
+func (h *Handler) doSyncFromMater(c *conn, counter *atomic2.Int64, stream *syncStreamReader) error {
 	c.authenticated = true
 
-	lastACKTime := time.Now()
-	for {
-		readTotalSize := counter.Get()
+	// writes not from master must not save the replication state
+	defer c.Store().SetReplState(nil)
+
+	// bytes of the replication stream handled, buffered bytes are not handled yet
+	handled := func() int64 {
+		return counter.Get() - int64(c.r.Buffered())
+	}
+	base, baseOffset := handled(), h.syncOffset.Get()
+
+	stream.start(c.r)
+	last, db := base, c.db
+
+	// offset the next request ends at, told by master filtering the stream
+	next := int64(-1)
+
+	// requests of a transaction broken by the last connection are not applied
+	h.repl.Lock()
+	h.repl.pendingStream = nil
+	h.repl.Unlock()
+
+	// ack once synchronization starts, then every second even if master sends nothing,
+	// so master knows our progress and that we are alive
+	h.sendSyncACK(c)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				h.sendSyncACK(c)
+			}
+		}
+	}()
 
-		if _, err := c.handleRequest(h); err != nil {
+	for {
+		request, err := c.readRequest()
+		if err != nil {
 			return errors.Trace(err)
 		}
 
-		if h.syncOffset.Get() != -1 {
-			h.syncOffset.Add(counter.Get() - readTotalSize)
-
-			n := time.Now()
-			if n.Sub(lastACKTime) > time.Second {
-				lastACKTime = n
-				// this command has no reply
-				if err := c.sendCommand("REPLCONF", "ACK", h.syncOffset.Get()); err != nil {
-					log.Errorf("send REPLCONF ACK %d err - %s", h.syncOffset.Get(), err)
-				}
+		n := handled()
+		buf := stream.next(int(n - last))
+		last = n
+
+		if o, ok := syncStreamOffset(request); ok {
+			next = o
+			continue
+		}
+
+		offset := int64(-1)
+		if baseOffset != -1 {
+			offset = baseOffset + n - base
+			if next != -1 {
+				// requests skipped by master are counted
+				baseOffset, offset, next = baseOffset+next-offset, next, -1
+			}
+			// writes of the request are saved with the offset after the request
+			c.Store().SetReplState(&store.ReplState{RunID: h.masterRunID.Get(), Offset: offset, DB: c.db, Filter: h.replFilter.String()})
+
+			// sub-slaves get the request once it is committed, so state pinned for
+			// their full resync matches data, we don't have the whole stream if filtered
+			db = syncSelectedDB(request, db)
+			if h.replFilter == nil {
+				h.replicationAppendStream(buf, offset, db)
+			}
+		}
+
+		c.handleDecodedRequest(h, request)
+
+		if offset != -1 && c.multi == nil {
+			// transaction is resynced and fed to sub-slaves as a whole once executed
+			h.syncOffset.Set(offset)
+			h.syncDB.Set(int64(c.db))
+
+			// requests not committed, e.g. SELECT and PING
+			if err := h.replicationFlushStream(); err != nil {
+				log.Errorf("feed sub-slaves err - %s", err)
 			}
 		}
+
+		if c.syncACKRequested {
+			c.syncACKRequested = false
+			h.sendSyncACK(c)
+		}
 	}
 
 	return nil
 }
 
+func (h *Handler) sendSyncACK(c *conn) {
+	offset := h.syncOffset.Get()
+	if offset == -1 {
+		return
+	}
+
+	// this command has no reply
+	if err := c.sendCommand("REPLCONF", "ACK", offset); err != nil {
+		log.Errorf("send REPLCONF ACK %d err - %s", offset, err)
+	}
+}
+
+// files of the checkpoint are written into a directory and then replace the database
+func (h *Handler) doSyncCheckpoint(c *conn, offset int64, db uint32, count int) error {
+	h.counters.syncLoadedFiles.Set(0)
+
+	dir := filepath.Join(h.config.SyncCheckpointPath, "slave")
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Trace(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < count; i++ {
+		if err := h.doSyncCheckpointFile(c, dir); err != nil {
+			return errors.Trace(err)
+		}
+		h.counters.syncLoadedFiles.Add(1)
+	}
+
+	if err := c.Store().RestoreCheckpoint(dir); err != nil {
+		return errors.Trace(err)
+	}
+	h.syncOffset.Set(offset)
+	h.syncDB.Set(int64(db))
+	return nil
+}
+
+func (h *Handler) doSyncCheckpointFile(c *conn, dir string) error {
+	resp, err := redis.Decode(c.r)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	b, ok := resp.(*redis.BulkBytes)
+	if !ok {
+		return errors.Errorf("invalid checkpoint file name, rsp = %v", resp)
+	}
+	name := string(b.Value)
+	if name == "" || filepath.Base(name) != name {
+		return errors.Errorf("invalid checkpoint file name '%s'", name)
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if line[0] != '$' {
+		return errors.Errorf("invalid checkpoint file size, rsp = '%s'", line)
+	}
+	n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || n < 0 {
+		return errors.Errorf("invalid checkpoint file size = '%s', error = '%s', n = %d", line, err, n)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+
+	if _, err := io.CopyN(f, c.r, n); err != nil {
+		return errors.Trace(err)
+	}
+	if err := f.Sync(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(f.Close())
+}
+
+// entries of the same db restored by one commit
+type syncRDBBatch struct {
+	db   uint32
+	args [][]byte
+	size int
+}
+
+// values of one batch are limited to this size besides number of entries
+const syncRDBBatchMaxBytes = 4 * 1024 * 1024
+
 func (h *Handler) doSyncRDB(c *conn, size int64) error {
 	defer h.counters.syncRdbRemains.Set(0)
 	h.counters.syncRdbRemains.Set(size)
 
+	h.resetSyncRDBProgress(size)
+
 	r := ioutils.NewCountReader(c.r, nil)
 	l := rdb.NewLoader(r)
 	if err := l.Header(); err != nil {
 		return errors.Trace(err)
 	}
 
-	ncpu := 1
-	errs := make(chan error, ncpu)
+	workers := h.config.SyncRDBWorkers
+	if workers <= 0 {
+		workers = 1
+	}
 
-	var lock sync.Mutex
-	var flag atomic2.Int64
-	loadNextEntry := func() (*rdb.BinEntry, error) {
-		lock.Lock()
-		defer lock.Unlock()
-		if flag.Get() != 0 {
-			return nil, nil
-		}
-		entry, err := l.NextBinEntry()
-		if err != nil || entry == nil {
-			flag.Set(1)
-			return nil, errors.Trace(err)
-		}
-		return entry, nil
+	// closed once the loading fails
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() { close(done) })
 	}
 
+	errs := make(chan error, workers+1)
+	batches := make(chan *syncRDBBatch, workers*2)
+
+	// entries are decoded in order and grouped into batches by db
 	go func() {
-		defer flag.Set(1)
-		for {
-			entry, err := loadNextEntry()
-			if err != nil || entry == nil {
-				errs <- err
-				return
-			}
-			db, key, value := entry.DB, entry.Key, entry.Value
-			ttlms := int64(0)
-			if entry.ExpireAt != 0 {
-				if v, ok := store.ExpireAtToTTLms(int64(entry.ExpireAt)); ok && v > 0 {
-					ttlms = v
-				} else {
-					ttlms = 1
+		defer close(batches)
+		errs <- h.loadSyncRDBBatches(l, batches, done)
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for b := range batches {
+				select {
+				case <-done:
+					continue
+				default:
 				}
+				if err := c.Store().SlotsRestore(b.db, b.args); err != nil {
+					errs <- errors.Trace(err)
+					return
+				}
+				h.counters.syncRdbEntries.Add(int64(len(b.args) / 3))
 			}
-			if err := c.Store().SlotsRestore(db, [][]byte{key, store.FormatInt(ttlms), value}); err != nil {
-				errs <- err
-				return
-			}
-		}
-	}()
+			errs <- nil
+		}()
+	}
 
-	for {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var err error
+	for n := workers + 1; n != 0; {
 		select {
-		case <-time.After(time.Second):
+		case <-ticker.C:
 			h.counters.syncRdbRemains.Set(size - r.Count())
-		case err := <-errs:
-			for i := 1; i < cap(errs); i++ {
-				e := <-errs
-				if err == nil && e != nil {
-					err = e
-				}
+		case e := <-errs:
+			if n--; e != nil && err == nil {
+				err = e
+				stop()
 			}
-			if err != nil {
-				return errors.Trace(err)
+		}
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return l.Footer()
+}
+
+func (h *Handler) loadSyncRDBBatches(l *rdb.Loader, batches chan<- *syncRDBBatch, done <-chan struct{}) error {
+	batchSize := h.config.SyncRDBBatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	var b *syncRDBBatch
+	flush := func() bool {
+		if b == nil {
+			return true
+		}
+		select {
+		case <-done:
+			return false
+		case batches <- b:
+			b = nil
+			return true
+		}
+	}
+
+	for {
+		entry, err := l.NextBinEntry()
+		if err != nil {
+			return errors.Trace(err)
+		} else if entry == nil {
+			break
+		}
+
+		ttlms := int64(0)
+		if entry.ExpireAt != 0 {
+			if v, ok := store.ExpireAtToTTLms(int64(entry.ExpireAt)); ok && v > 0 {
+				ttlms = v
+			} else {
+				ttlms = 1
 			}
+		}
 
-			return l.Footer()
+		if b != nil && b.db != entry.DB && !flush() {
+			return nil
 		}
+		if b == nil {
+			b = &syncRDBBatch{db: entry.DB}
+		}
+		b.args = append(b.args, entry.Key, store.FormatInt(ttlms), entry.Value)
+		b.size += len(entry.Value)
+
+		if len(b.args)/3 >= batchSize || b.size >= syncRDBBatchMaxBytes {
+			if !flush() {
+				return nil
+			}
+		}
+	}
+	flush()
+	return nil
+}
+
+// progress of the last loading is kept until next full resync
+func (h *Handler) resetSyncRDBProgress(size int64) {
+	h.counters.syncRdbSize.Set(size)
+	h.counters.syncRdbEntries.Set(0)
+	h.counters.syncRdbSince.Set(time.Now().UnixNano() / int64(time.Millisecond))
+}
+
+// estimated seconds to finish loading rdb, -1 if unknown
+func (h *Handler) syncRDBETA() int64 {
+	size, remains := h.counters.syncRdbSize.Get(), h.counters.syncRdbRemains.Get()
+	since := h.counters.syncRdbSince.Get()
+	if size == 0 || since == 0 || remains >= size {
+		return -1
 	}
+	elapsed := time.Now().UnixNano()/int64(time.Millisecond) - since
+	return elapsed * remains / (size - remains) / 1000
 }
 
 func init() {
 	Register("bgsave", BgsaveCmd, CmdReadonly)
-	Register("bgsaveto", BgsaveToCmd, CmdReadonly)
-	Register("slaveof", SlaveOfCmd, CmdReadonly)
+	Register("bgsaveto", BgsaveToCmd, CmdReadonly|CmdNoMulti)
+	Register("lastsave", LastSaveCmd, CmdReadonly)
+	Register("save", SaveCmd, CmdReadonly|CmdNoMulti)
+	Register("slaveof", SlaveOfCmd, CmdReadonly|CmdNoMulti)
 }
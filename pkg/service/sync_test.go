@@ -6,8 +6,11 @@ package service
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/reborndb/go/redis/rdb"
+	redis "github.com/reborndb/go/redis/resp"
 	"github.com/reborndb/qdb/pkg/store"
 	. "gopkg.in/check.v1"
 )
@@ -21,6 +24,10 @@ func (s *testServiceSuite) TestBgsaveTo(c *C) {
 	}
 	path := "/tmp/testdb-dump.rdb"
 	s.checkOK(c, "bgsaveto", path)
+	s.checkDumpFile(c, path, k, max)
+}
+
+func (s *testServiceSuite) checkDumpFile(c *C, path string, k string, max int) {
 	f, err := os.Open(path)
 	c.Assert(err, IsNil)
 	defer f.Close()
@@ -38,6 +45,7 @@ func (s *testServiceSuite) TestBgsaveTo(c *C) {
 		m[string(e.Key)] = e.Value
 	}
 	c.Assert(l.Footer(), IsNil)
+	c.Assert(len(m), Equals, max)
 	for i := 0; i < max; i++ {
 		b := m[k+strconv.Itoa(i)]
 		o, err := rdb.DecodeDump(b)
@@ -47,3 +55,51 @@ func (s *testServiceSuite) TestBgsaveTo(c *C) {
 		c.Assert(string(x), Equals, string(store.FormatInt(int64(i))))
 	}
 }
+
+func (s *testServiceSuite) infoField(c *C, section string, field string) string {
+	nc := s.getConn(c)
+	defer nc.Recycle()
+
+	resp := nc.doCmd(c, "INFO", section)
+	c.Assert(resp, FitsTypeOf, (*redis.BulkBytes)(nil))
+	for _, line := range strings.Split(string(resp.(*redis.BulkBytes).Value), "\r\n") {
+		if strings.HasPrefix(line, field+":") {
+			return line[len(field)+1:]
+		}
+	}
+	c.Fatalf("field %s not found in info %s", field, section)
+	return ""
+}
+
+func (s *testServiceSuite) TestSave(c *C) {
+	k := randomKey(c)
+	s.checkOK(c, "flushall")
+	const max = 1000
+	for i := 0; i < max; i++ {
+		s.checkOK(c, "set", k+strconv.Itoa(i), i)
+	}
+
+	path := s.s.h.config.DumpPath
+	s.checkOK(c, "save")
+	s.checkDumpFile(c, path, k, max)
+	s.checkIntApprox(c, time.Now().Unix(), 1, "lastsave")
+	c.Assert(s.infoField(c, "persistence", "rdb_last_bgsave_status"), Equals, "ok")
+	c.Assert(s.infoField(c, "persistence", "rdb_current_bgsave_keys"), Equals, strconv.Itoa(max))
+
+	s.checkString(c, "Background saving started", "bgsave")
+	for i := 0; i < 100; i++ {
+		if s.infoField(c, "persistence", "rdb_bgsave_in_progress") == "0" {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(s.infoField(c, "persistence", "rdb_bgsave_in_progress"), Equals, "0")
+	c.Assert(s.infoField(c, "persistence", "rdb_last_bgsave_status"), Equals, "ok")
+	s.checkDumpFile(c, path, k, max)
+
+	// failed save is reported, the last save time is kept
+	s.checkContainError(c, "no such file", "bgsaveto", "/tmp/test_qdb/not_exists/dump.rdb")
+	c.Assert(s.infoField(c, "persistence", "rdb_last_bgsave_status"), Equals, "err")
+	s.checkIntApprox(c, time.Now().Unix(), 1, "lastsave")
+	s.checkOK(c, "flushall")
+}
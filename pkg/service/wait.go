@@ -0,0 +1,141 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	redis "github.com/reborndb/go/redis/resp"
+	"github.com/reborndb/qdb/pkg/store"
+)
+
+// WAIT numslaves timeout
+func WaitCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if len(args) != 2 {
+		return toRespErrorf("len(args) = %d, expect = 2", len(args))
+	}
+
+	c, _ := s.(*conn)
+	if c == nil {
+		return nil, errors.New("invalid connection")
+	}
+
+	if c.h.masterAddr.Get() != "" {
+		return toRespErrorf("WAIT cannot be used with slave instances")
+	}
+
+	n, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return toRespErrorf("parse numslaves = %s failed - %s", args[0], err)
+	}
+	ms, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || ms < 0 {
+		return toRespErrorf("invalid timeout = %s", args[1])
+	}
+
+	// writes of others after ours are not waited for
+	acked := c.h.waitSlavesACK(c.writeOffset, int(n), time.Duration(ms)*time.Millisecond)
+	return redis.NewInt(int64(acked)), nil
+}
+
+// current offset of the replication stream, writes committed are fed into the stream already
+func (h *Handler) replicationOffset() int64 {
+	h.repl.RLock()
+	defer h.repl.RUnlock()
+
+	return h.repl.masterOffset
+}
+
+// blocks until n slaves acked offset or timeout, 0 means no timeout,
+// returns the number of acked slaves
+func (h *Handler) waitSlavesACK(offset int64, n int, timeout time.Duration) int {
+	acked, signal := h.countSlavesACK(offset)
+	if acked >= n {
+		return acked
+	}
+
+	// ask slaves to ack now instead of waiting for their next ack
+	f := &store.Forward{Op: "REPLCONF",
+		DB:   uint32(h.repl.lastSelectDB.Get()),
+		Args: [][]byte{[]byte("GETACK"), []byte("*")}}
+	if err := h.replicationFeedSlaves(f); err != nil {
+		log.Errorf("ask slaves to ack error - %s", err)
+	}
+
+	var expire <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expire = t.C
+	}
+
+	for {
+		select {
+		case <-signal:
+		case <-expire:
+			acked, _ = h.countSlavesACK(offset)
+			return acked
+		case <-h.signal:
+			return acked
+		}
+		if acked, signal = h.countSlavesACK(offset); acked >= n {
+			return acked
+		}
+	}
+}
+
+// returns number of slaves acked offset, and the signal closed once a slave acks later
+func (h *Handler) countSlavesACK(offset int64) (int, <-chan struct{}) {
+	h.repl.RLock()
+	defer h.repl.RUnlock()
+
+	n := 0
+	for c, _ := range h.repl.slaves {
+		if c.backlogACKOffset.Get() >= offset {
+			n++
+		}
+	}
+	return n, h.repl.ackSignal
+}
+
+func (h *Handler) replicationNotifyACK() {
+	h.repl.Lock()
+	defer h.repl.Unlock()
+
+	close(h.repl.ackSignal)
+	h.repl.ackSignal = make(chan struct{})
+}
+
+// whether writes are allowed by min_slaves_to_write and min_slaves_max_lag
+func (h *Handler) enoughGoodSlaves() bool {
+	min, lag := h.config.MinSlavesToWrite, h.config.MinSlavesMaxLag
+	if min <= 0 || lag <= 0 {
+		return true
+	}
+
+	h.repl.RLock()
+	defer h.repl.RUnlock()
+
+	return h.goodSlaves(int64(lag)) >= min
+}
+
+// number of slaves acked in lag seconds, must be called with repl lock held
+func (h *Handler) goodSlaves(lag int64) int {
+	now := time.Now().Unix()
+
+	n := 0
+	for c, _ := range h.repl.slaves {
+		if now-c.backlogACKTime.Get() <= lag {
+			n++
+		}
+	}
+	return n
+}
+
+func init() {
+	Register("wait", WaitCmd, CmdReadonly|CmdNoMulti)
+}
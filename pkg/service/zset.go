@@ -21,6 +21,15 @@ func ZGetAllCmd(s Session, args [][]byte) (redis.Resp, error) {
 	}
 }
 
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func ZScanCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if cursor, a, err := s.Store().ZScan(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return newScanResp(cursor, a), nil
+	}
+}
+
 // ZCARD key
 func ZCardCmd(s Session, args [][]byte) (redis.Resp, error) {
 	if n, err := s.Store().ZCard(s.DB(), args); err != nil {
@@ -213,12 +222,31 @@ func ZRemRangeByScoreCmd(s Session, args [][]byte) (redis.Resp, error) {
 	}
 }
 
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func ZUnionStoreCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if v, err := s.Store().ZUnionStore(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewInt(v), nil
+	}
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func ZInterStoreCmd(s Session, args [][]byte) (redis.Resp, error) {
+	if v, err := s.Store().ZInterStore(s.DB(), args); err != nil {
+		return toRespError(err)
+	} else {
+		return redis.NewInt(v), nil
+	}
+}
+
 func init() {
 	Register("zadd", ZAddCmd, CmdWrite)
 	Register("zcard", ZCardCmd, CmdReadonly)
 	Register("zcount", ZCountCmd, CmdReadonly)
 	Register("zgetall", ZGetAllCmd, CmdReadonly)
 	Register("zincrby", ZIncrByCmd, CmdWrite)
+	Register("zinterstore", ZInterStoreCmd, CmdWrite)
 	Register("zlexcount", ZLexCountCmd, CmdReadonly)
 	Register("zrange", ZRangeCmd, CmdReadonly)
 	Register("zrangebylex", ZRangeByLexCmd, CmdReadonly)
@@ -232,5 +260,7 @@ func init() {
 	Register("zrevrangebylex", ZRevRangeByLexCmd, CmdReadonly)
 	Register("zrevrangebyscore", ZRevRangeByScoreCmd, CmdReadonly)
 	Register("zrevrank", ZRevRankCmd, CmdReadonly)
+	Register("zscan", ZScanCmd, CmdReadonly)
 	Register("zscore", ZScoreCmd, CmdReadonly)
+	Register("zunionstore", ZUnionStoreCmd, CmdWrite)
 }
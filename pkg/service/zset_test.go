@@ -182,3 +182,44 @@ func (s *testServiceSuite) TestZRemRangeByScore(c *C) {
 	s.checkInt(c, 2, "zremrangebyscore", k, "2", "+inf")
 	s.checkInt(c, 0, "zremrangebyscore", k, "-inf", "+inf")
 }
+
+func (s *testServiceSuite) TestZScan(c *C) {
+	key := randomKey(c)
+	nc := s.getConn(c)
+	defer nc.Recycle()
+
+	c.Assert(nc.checkScanAll(c, "zscan", 1, key), HasLen, 0)
+	nc.checkInt(c, 3, "zadd", key, 1, "a", 2, "b", 3, "c")
+	c.Assert(nc.checkScanAll(c, "zscan", 1, key), HasLen, 6)
+	_, ay := nc.checkScan(c, "zscan", key, 0, "match", "c")
+	c.Assert(ay, HasLen, 2)
+	c.Assert(string(ay[0]), Equals, "c")
+	nc.checkContainError(c, "TYPE", "zscan", key, 0, "type", "zset")
+}
+
+func (s *testServiceSuite) TestZUnionStore(c *C) {
+	k1, k2, k3 := randomKey(c), randomKey(c), randomKey(c)
+	s.checkInt(c, 2, "zadd", k1, 1, "a", 2, "b")
+	s.checkInt(c, 2, "zadd", k2, 3, "b", 4, "c")
+	s.checkInt(c, 3, "zunionstore", k3, 2, k1, k2)
+	s.checkZSet(c, k3, map[string]float64{"a": 1, "b": 5, "c": 4})
+	s.checkInt(c, 3, "zunionstore", k3, 2, k1, k2, "WEIGHTS", 2, 1, "AGGREGATE", "MAX")
+	s.checkZSet(c, k3, map[string]float64{"a": 2, "b": 4, "c": 4})
+	s.checkZRange(c, "zrange", []interface{}{"a", 2.0, "b", 4.0, "c", 4.0}, k3, 0, -1, "WITHSCORES")
+	s.checkContainError(c, "at least 1 input key", "zunionstore", k3, 0, k1)
+	s.checkInt(c, 3, "del", k1, k2, k3)
+}
+
+func (s *testServiceSuite) TestZInterStore(c *C) {
+	k1, k2, k3 := randomKey(c), randomKey(c), randomKey(c)
+	s.checkInt(c, 2, "zadd", k1, 1, "a", 2, "b")
+	s.checkInt(c, 2, "zadd", k2, 3, "b", 4, "c")
+	s.checkInt(c, 1, "zinterstore", k3, 2, k1, k2)
+	s.checkZSet(c, k3, map[string]float64{"b": 5})
+	s.checkInt(c, 1, "zinterstore", k3, 2, k1, k2, "AGGREGATE", "MIN")
+	s.checkZSet(c, k3, map[string]float64{"b": 2})
+
+	s.checkOK(c, "set", k3, "value")
+	s.checkContainError(c, "not zset", "zinterstore", k1, 2, k1, k3)
+	s.checkInt(c, 3, "del", k1, k2, k3)
+}
@@ -0,0 +1,227 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package store
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/qdb/pkg/engine"
+)
+
+const (
+	expireCheckInterval = time.Millisecond * 100
+	expireBatchSize     = 128
+)
+
+// expireat in expire index key, encoded as big endian uint64 so index keys are sorted by expireat
+type expireInt uint64
+
+// Expire index key is expireCode + expireat + meta key, value is empty.
+func EncodeExpireKey(metaKey []byte, expireat int64) []byte {
+	at := expireInt(expireat)
+	w := NewBufWriter(nil)
+	encodeRawBytes(w, expireCode, &at)
+	return append(w.Bytes(), metaKey...)
+}
+
+func DecodeExpireKey(p []byte) (metaKey []byte, expireat int64, err error) {
+	var at expireInt
+	r := NewBufReader(p)
+	if err = decodeRawBytes(r, err, expireCode, &at); err != nil {
+		return
+	}
+	metaKey, expireat = p[len(p)-r.Len():], int64(at)
+	return
+}
+
+func (o *storeRowHelper) ExpireKey() []byte {
+	return EncodeExpireKey(o.metaKey, o.ExpireAt)
+}
+
+// add the row into expire index if it has an expireat
+func (o *storeRowHelper) setExpireIndex(bt *engine.Batch) {
+	if o.ExpireAt != 0 {
+		bt.Set(o.ExpireKey(), []byte{})
+	}
+}
+
+// remove the row from expire index, must be called before changing expireat
+func (o *storeRowHelper) delExpireIndex(bt *engine.Batch) {
+	if o.ExpireAt != 0 {
+		bt.Del(o.ExpireKey())
+	}
+}
+
+// delete meta key and its expire index
+func (o *storeRowHelper) deleteMetaKey(bt *engine.Batch) {
+	o.delExpireIndex(bt)
+	bt.Del(o.MetaKey())
+}
+
+// Set once all keys with expireat are in expire index, keys given expireat before
+// the index was added are indexed by buildExpireIndex.
+var expireIndexedKey = []byte{expireIndexedCode}
+
+// adds keys with expireat into expire index if it is not built, runs once for
+// databases written before the index was added, must be called with store locked
+func (s *Store) buildExpireIndex() error {
+	if v, err := s.db.Get(expireIndexedKey); err != nil || v != nil {
+		return errors.Trace(err)
+	}
+
+	it := s.db.NewIterator()
+	defer it.Close()
+
+	n := 0
+	bt := engine.NewBatch()
+	pfx := []byte{MetaCode}
+	for it.SeekTo(pfx); it.Valid(); it.Next() {
+		metaKey := it.Key()
+		if !bytes.HasPrefix(metaKey, pfx) {
+			break
+		}
+		_, o, err := decodeMetaEntry(metaKey, it.Value())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if o.GetExpireAt() == 0 {
+			continue
+		}
+		o.setExpireIndex(bt)
+		if n++; bt.Len() >= expireBatchSize {
+			if err := s.commit(bt, nil); err != nil {
+				return errors.Trace(err)
+			}
+			bt = engine.NewBatch()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return errors.Trace(err)
+	}
+
+	bt.Set(expireIndexedKey, []byte{})
+	if err := s.commit(bt, nil); err != nil {
+		return errors.Trace(err)
+	}
+	log.Infof("store expire index is built, %d keys with expireat", n)
+	return nil
+}
+
+// background loop deleting expired keys, exits when store is closed
+func (s *Store) expireLoop() {
+	for {
+		n, err := s.deleteExpired(expireBatchSize)
+		if err != nil {
+			if errors.Cause(err) == ErrClosed {
+				return
+			}
+			log.Warningf("store delete expired keys failed - %s", err)
+		}
+		if n < expireBatchSize {
+			time.Sleep(expireCheckInterval)
+		}
+	}
+}
+
+// delete at most limit due keys in expire index, DEL of every expired key is forwarded
+// Returns the number of index entries handled.
+func (s *Store) deleteExpired(limit int) (int, error) {
+	// slaves wait DEL from master
+	if !s.needDeleteIfExpired() {
+		return 0, nil
+	}
+
+	ekeys, err := s.getExpiredKeys(limit)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	for _, ekey := range ekeys {
+		if err := s.deleteExpiredKey(ekey); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	return len(ekeys), nil
+}
+
+// delete key of the expire index entry with the key locked, other keys are not blocked
+func (s *Store) deleteExpiredKey(ekey []byte) error {
+	metaKey, expireat, err := DecodeExpireKey(ekey)
+	if err != nil {
+		return s.deleteBadExpireKey(ekey, err)
+	}
+	db, key, err := DecodeMetaKey(metaKey)
+	if err != nil {
+		return s.deleteBadExpireKey(ekey, err)
+	}
+
+	if err := s.acquire(key); err != nil {
+		return errors.Trace(err)
+	}
+	defer s.release(key)
+
+	bt := engine.NewBatch()
+	o, err := loadStoreRow(s, db, key)
+	if err != nil {
+		// bad entry would be retried forever, the key is left without expiring
+		log.Errorf("store load expired key failed, db = %d, key = %q, delete its expire index - %s", db, key, err)
+		bt.Del(ekey)
+		return s.commit(bt, nil)
+	}
+
+	if o == nil || o.GetExpireAt() != expireat {
+		// stale index, key has been deleted or its expireat has been changed
+		bt.Del(ekey)
+		return s.commit(bt, nil)
+	}
+
+	if err := o.deleteObject(s, bt); err != nil {
+		return errors.Trace(err)
+	}
+	fw := &Forward{DB: db, Op: "Del", Args: [][]byte{key}}
+	return s.commit(bt, fw)
+}
+
+// delete expire index entry which can't be decoded, or it would be retried forever
+func (s *Store) deleteBadExpireKey(ekey []byte, err error) error {
+	log.Errorf("store decode expire index %q failed, delete it - %s", ekey, err)
+
+	if err := s.acquire(); err != nil {
+		return errors.Trace(err)
+	}
+	defer s.release()
+
+	bt := engine.NewBatch()
+	bt.Del(ekey)
+	return s.commit(bt, nil)
+}
+
+func (s *Store) getExpiredKeys(limit int) ([][]byte, error) {
+	if err := s.acquire(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer s.release()
+
+	it := s.getIterator()
+	defer s.putIterator(it)
+
+	now := nowms()
+	pfx := []byte{expireCode}
+	var ekeys [][]byte
+	for it.SeekTo(pfx); it.Valid() && len(ekeys) < limit; it.Next() {
+		ekey := it.Key()
+		if !bytes.HasPrefix(ekey, pfx) {
+			break
+		}
+		// bad entries are returned to be deleted
+		if _, expireat, err := DecodeExpireKey(ekey); err == nil && expireat > now {
+			break
+		}
+		ekeys = append(ekeys, append([]byte{}, ekey...))
+	}
+	return ekeys, errors.Trace(it.Error())
+}
@@ -0,0 +1,196 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package store
+
+import (
+	"bytes"
+
+	"github.com/reborndb/qdb/pkg/engine"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testStoreSuite) expireIndexLen(c *C) int {
+	err := s.s.acquire()
+	c.Assert(err, IsNil)
+	defer s.s.release()
+
+	it := s.s.getIterator()
+	defer s.s.putIterator(it)
+
+	n := 0
+	pfx := []byte{expireCode}
+	for it.SeekTo(pfx); it.Valid(); it.Next() {
+		if !bytes.HasPrefix(it.Key(), pfx) {
+			break
+		}
+		n++
+	}
+	c.Assert(it.Error(), IsNil)
+	return n
+}
+
+func (s *testStoreSuite) checkMetaExists(c *C, db uint32, key string, exists bool) {
+	p, err := s.s.getRowValue(EncodeMetaKey(db, []byte(key)))
+	c.Assert(err, IsNil)
+	c.Assert(p != nil, Equals, exists)
+}
+
+func (s *testStoreSuite) TestEncodeExpireKey(c *C) {
+	metaKey := EncodeMetaKey(1, []byte("key"))
+	for _, expireat := range []int64{1, 255, 256, nowms(), MaxExpireAt} {
+		p := EncodeExpireKey(metaKey, expireat)
+		k, v, err := DecodeExpireKey(p)
+		c.Assert(err, IsNil)
+		c.Assert(k, DeepEquals, metaKey)
+		c.Assert(v, Equals, expireat)
+	}
+	k1 := EncodeExpireKey(EncodeMetaKey(0, []byte("b")), 255)
+	k2 := EncodeExpireKey(EncodeMetaKey(0, []byte("a")), 256)
+	c.Assert(bytes.Compare(k1, k2) < 0, Equals, true)
+
+	_, _, err := DecodeExpireKey(metaKey)
+	c.Assert(err, NotNil)
+}
+
+func (s *testStoreSuite) TestExpireIndex(c *C) {
+	s.xset(c, 0, "a", "a")
+	c.Assert(s.expireIndexLen(c), Equals, 0)
+	s.kpexpireat(c, 0, "a", nowms()+100000, 1)
+	c.Assert(s.expireIndexLen(c), Equals, 1)
+	s.kpexpireat(c, 0, "a", nowms()+200000, 1)
+	c.Assert(s.expireIndexLen(c), Equals, 1)
+	s.kpersist(c, 0, "a", 1)
+	c.Assert(s.expireIndexLen(c), Equals, 0)
+
+	s.xpsetex(c, 0, "a", "b", 100000)
+	c.Assert(s.expireIndexLen(c), Equals, 1)
+	s.xset(c, 0, "a", "a")
+	c.Assert(s.expireIndexLen(c), Equals, 0)
+	s.xsetex(c, 0, "a", "b", 100)
+	s.xgetset(c, 0, "a", "c", "b")
+	c.Assert(s.expireIndexLen(c), Equals, 0)
+
+	s.hset(c, 0, "h", "f", "v", 1)
+	s.kpexpireat(c, 0, "h", nowms()+100000, 1)
+	s.lpush(c, 0, "l", 1, "a")
+	s.kpexpireat(c, 0, "l", nowms()+100000, 1)
+	s.xrestore(c, 0, "r", 100000, "hello")
+	c.Assert(s.expireIndexLen(c), Equals, 3)
+
+	s.hdel(c, 0, "h", 1, "f")
+	s.kdel(c, 0, 1, "l")
+	s.kdel(c, 0, 1, "r")
+	c.Assert(s.expireIndexLen(c), Equals, 0)
+
+	s.kdel(c, 0, 1, "a")
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestDeleteExpired(c *C) {
+	s.xset(c, 0, "a", "a")
+	s.hset(c, 0, "h", "f", "v", 1)
+	s.lpush(c, 0, "l", 1, "a")
+	s.sadd(c, 0, "s", 1, "a")
+	s.zadd(c, 0, "z", 1, "a", 1)
+	s.xset(c, 1, "b", "b")
+	for _, key := range []string{"a", "h", "l", "s", "z"} {
+		s.kpexpireat(c, 0, key, nowms()+50, 1)
+	}
+	s.kpexpireat(c, 1, "b", nowms()+100000, 1)
+	c.Assert(s.expireIndexLen(c), Equals, 6)
+
+	sleepms(100)
+	_, err := s.s.deleteExpired(expireBatchSize)
+	c.Assert(err, IsNil)
+	for _, key := range []string{"a", "h", "l", "s", "z"} {
+		s.checkMetaExists(c, 0, key, false)
+	}
+	s.checkMetaExists(c, 1, "b", true)
+	c.Assert(s.expireIndexLen(c), Equals, 1)
+	s.kdel(c, 1, 1, "b")
+
+	// expired keys are kept if we don't delete expired automatically
+	s.s.SetDeleteIfExpired(false)
+	s.xset(c, 0, "a", "a")
+	s.kpexpireat(c, 0, "a", nowms()+50, 1)
+	sleepms(100)
+	n, err := s.s.deleteExpired(expireBatchSize)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	s.checkMetaExists(c, 0, "a", true)
+
+	s.s.SetDeleteIfExpired(true)
+	_, err = s.s.deleteExpired(expireBatchSize)
+	c.Assert(err, IsNil)
+	s.checkMetaExists(c, 0, "a", false)
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestBuildExpireIndex(c *C) {
+	s.xset(c, 0, "a", "a")
+	s.hset(c, 0, "h", "f", "v", 1)
+	s.kpexpireat(c, 0, "h", nowms()+50, 1)
+	s.sadd(c, 1, "s", 1, "a")
+	s.kpexpireat(c, 1, "s", nowms()+100000, 1)
+	c.Assert(s.expireIndexLen(c), Equals, 2)
+
+	// index is built once
+	c.Assert(s.s.buildExpireIndex(), IsNil)
+	c.Assert(s.expireIndexLen(c), Equals, 2)
+
+	// keys given expireat before the index was added
+	bt := engine.NewBatch()
+	it := s.s.getIterator()
+	pfx := []byte{expireCode}
+	for it.SeekTo(pfx); it.Valid() && bytes.HasPrefix(it.Key(), pfx); it.Next() {
+		bt.Del(it.Key())
+	}
+	c.Assert(it.Error(), IsNil)
+	s.s.putIterator(it)
+	bt.Del(expireIndexedKey)
+	c.Assert(s.s.commit(bt, nil), IsNil)
+	c.Assert(s.expireIndexLen(c), Equals, 0)
+
+	c.Assert(s.s.buildExpireIndex(), IsNil)
+	c.Assert(s.expireIndexLen(c), Equals, 2)
+	p, err := s.s.getRowValue(expireIndexedKey)
+	c.Assert(err, IsNil)
+	c.Assert(p, NotNil)
+
+	sleepms(100)
+	_, err = s.s.deleteExpired(expireBatchSize)
+	c.Assert(err, IsNil)
+	s.checkMetaExists(c, 0, "h", false)
+	s.checkMetaExists(c, 1, "s", true)
+	c.Assert(s.expireIndexLen(c), Equals, 1)
+
+	s.kdel(c, 0, 1, "a")
+	s.kdel(c, 1, 1, "s")
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestDeleteBadExpireKeys(c *C) {
+	s.xset(c, 0, "a", "a")
+	s.kpexpireat(c, 0, "a", nowms()+50, 1)
+
+	// bad entries are deleted instead of being retried every time
+	bt := engine.NewBatch()
+	bt.Set([]byte{expireCode, 1}, []byte{})
+	bt.Set(EncodeExpireKey([]byte("bad"), 1), []byte{})
+	metaKey := EncodeMetaKey(0, []byte("c"))
+	bt.Set(metaKey, []byte("bad"))
+	bt.Set(EncodeExpireKey(metaKey, 1), []byte{})
+	c.Assert(s.s.commit(bt, nil), IsNil)
+
+	sleepms(100)
+	_, err := s.s.deleteExpired(expireBatchSize)
+	c.Assert(err, IsNil)
+	c.Assert(s.expireIndexLen(c), Equals, 0)
+	s.checkMetaExists(c, 0, "a", false)
+
+	bt = engine.NewBatch()
+	bt.Del(metaKey)
+	c.Assert(s.s.commit(bt, nil), IsNil)
+	s.checkEmpty(c)
+}
@@ -17,20 +17,20 @@ type ForwardHandler func(f *Forward) error
 
 // Register the handler that will be called before db storage commit
 func (s *Store) RegPreCommitHandler(h ForwardHandler) {
-	if err := s.acquire(); err != nil {
+	if err := s.acquireAll(); err != nil {
 		return
 	}
-	defer s.release()
+	defer s.releaseAll()
 
 	s.preCommitHandlers = append(s.preCommitHandlers, h)
 }
 
 // Register the handler that will be called after db storage committed
 func (s *Store) RegPostCommitHandler(h ForwardHandler) {
-	if err := s.acquire(); err != nil {
+	if err := s.acquireAll(); err != nil {
 		return
 	}
-	defer s.release()
+	defer s.releaseAll()
 
 	s.postCommitHandlers = append(s.postCommitHandlers, h)
 }
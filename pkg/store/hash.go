@@ -20,9 +20,9 @@ type hashRow struct {
 	Value []byte
 }
 
-func newHashRow(db uint32, key []byte) *hashRow {
+func newHashRow(db uint32, key []byte, version uint64) *hashRow {
 	o := &hashRow{}
-	o.lazyInit(db, key, newStoreRowHelper(db, key, HashCode))
+	o.lazyInit(db, key, newStoreRowHelper(db, key, HashCode, version))
 	return o
 }
 
@@ -43,7 +43,7 @@ func (o *hashRow) deleteObject(s *Store, bt *engine.Batch) error {
 		}
 		bt.Del(key)
 	}
-	bt.Del(o.MetaKey())
+	o.deleteMetaKey(bt)
 	return it.Error()
 }
 
@@ -174,17 +174,18 @@ func (s *Store) HGetAll(db uint32, args [][]byte) ([][]byte, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadHashRow(db, key)
+	o, err := rd.loadHashRow(db, key)
 	if err != nil || o == nil {
 		return nil, errors.Trace(err)
 	}
 
-	x, err := o.loadObjectValue(s)
+	x, err := o.loadObjectValue(rd)
 	if err != nil || x == nil {
 		return nil, errors.Trace(err)
 	}
@@ -197,6 +198,51 @@ func (s *Store) HGetAll(db uint32, args [][]byte) ([][]byte, error) {
 	return rets, nil
 }
 
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func (s *Store) HScan(db uint32, args [][]byte) ([]byte, [][]byte, error) {
+	if len(args) < 2 {
+		return nil, nil, errArguments("len(args) = %d, expect >= 2", len(args))
+	}
+
+	key := args[0]
+	spec, err := parseDataScanSpec(args[1:])
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
+	rd, err := s.acquireReader()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	defer s.releaseReader(rd)
+
+	o, err := rd.loadHashRow(db, key)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	} else if o == nil {
+		return encodeScanCursor(nil), nil, nil
+	}
+
+	var rets [][]byte
+	cursor, err := scanDataKeys(rd, o.DataKeyPrefix(), spec, func(sfx, value []byte) error {
+		if err := o.ParseDataKeySuffix(sfx); err != nil {
+			return errors.Trace(err)
+		}
+		if !spec.matchKey(o.Field) {
+			return nil
+		}
+		if err := o.ParseDataValue(value); err != nil {
+			return errors.Trace(err)
+		}
+		rets = append(rets, o.Field, o.Value)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return cursor, rets, nil
+}
+
 // HDEL key field [field ...]
 func (s *Store) HDel(db uint32, args [][]byte) (int64, error) {
 	if len(args) < 2 {
@@ -206,10 +252,10 @@ func (s *Store) HDel(db uint32, args [][]byte) (int64, error) {
 	key := args[0]
 	fields := args[1:]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadHashRow(db, key)
 	if err != nil || o == nil {
@@ -236,7 +282,7 @@ func (s *Store) HDel(db uint32, args [][]byte) (int64, error) {
 		if o.Size -= n; o.Size > 0 {
 			bt.Set(o.MetaKey(), o.MetaValue())
 		} else {
-			bt.Del(o.MetaKey())
+			o.deleteMetaKey(bt)
 		}
 	}
 	fw := &Forward{DB: db, Op: "HDel", Args: args}
@@ -252,18 +298,19 @@ func (s *Store) HExists(db uint32, args [][]byte) (int64, error) {
 	key := args[0]
 	field := args[1]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadHashRow(db, key)
+	o, err := rd.loadHashRow(db, key)
 	if err != nil || o == nil {
 		return 0, errors.Trace(err)
 	}
 
 	o.Field = field
-	exists, err := o.TestDataValue(s)
+	exists, err := o.TestDataValue(rd)
 	if err != nil || !exists {
 		return 0, errors.Trace(err)
 	} else {
@@ -280,18 +327,19 @@ func (s *Store) HGet(db uint32, args [][]byte) ([]byte, error) {
 	key := args[0]
 	field := args[1]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadHashRow(db, key)
+	o, err := rd.loadHashRow(db, key)
 	if err != nil || o == nil {
 		return nil, errors.Trace(err)
 	}
 
 	o.Field = field
-	exists, err := o.LoadDataValue(s)
+	exists, err := o.LoadDataValue(rd)
 	if err != nil || !exists {
 		return nil, errors.Trace(err)
 	} else {
@@ -307,12 +355,13 @@ func (s *Store) HLen(db uint32, args [][]byte) (int64, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadHashRow(db, key)
+	o, err := rd.loadHashRow(db, key)
 	if err != nil || o == nil {
 		return 0, errors.Trace(err)
 	}
@@ -332,10 +381,10 @@ func (s *Store) HIncrBy(db uint32, args [][]byte) (int64, error) {
 		return 0, errArguments("parse args failed - %s", err)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadHashRow(db, key)
 	if err != nil {
@@ -350,7 +399,7 @@ func (s *Store) HIncrBy(db uint32, args [][]byte) (int64, error) {
 			return 0, errors.Trace(err)
 		}
 	} else {
-		o = newHashRow(db, key)
+		o = newHashRow(db, key, s.currentRowVersion())
 		o.Field = field
 	}
 
@@ -384,10 +433,10 @@ func (s *Store) HIncrByFloat(db uint32, args [][]byte) (float64, error) {
 		return 0, errArguments("parse args failed - %s", err)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadHashRow(db, key)
 	if err != nil {
@@ -402,7 +451,7 @@ func (s *Store) HIncrByFloat(db uint32, args [][]byte) (float64, error) {
 			return 0, errors.Trace(err)
 		}
 	} else {
-		o = newHashRow(db, key)
+		o = newHashRow(db, key, s.currentRowVersion())
 		o.Field = field
 	}
 
@@ -436,16 +485,17 @@ func (s *Store) HKeys(db uint32, args [][]byte) ([][]byte, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadHashRow(db, key)
+	o, err := rd.loadHashRow(db, key)
 	if err != nil || o == nil {
 		return nil, errors.Trace(err)
 	}
-	return o.getAllFields(s)
+	return o.getAllFields(rd)
 }
 
 // HVALS key
@@ -456,16 +506,17 @@ func (s *Store) HVals(db uint32, args [][]byte) ([][]byte, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadHashRow(db, key)
+	o, err := rd.loadHashRow(db, key)
 	if err != nil || o == nil {
 		return nil, errors.Trace(err)
 	}
-	return o.getAllValues(s)
+	return o.getAllValues(rd)
 }
 
 // HSET key field value
@@ -478,10 +529,10 @@ func (s *Store) HSet(db uint32, args [][]byte) (int64, error) {
 	field := args[1]
 	value := args[2]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadHashRow(db, key)
 	if err != nil {
@@ -496,7 +547,7 @@ func (s *Store) HSet(db uint32, args [][]byte) (int64, error) {
 			return 0, errors.Trace(err)
 		}
 	} else {
-		o = newHashRow(db, key)
+		o = newHashRow(db, key, s.currentRowVersion())
 		o.Field = field
 	}
 
@@ -526,10 +577,10 @@ func (s *Store) HSetNX(db uint32, args [][]byte) (int64, error) {
 	field := args[1]
 	value := args[2]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadHashRow(db, key)
 	if err != nil {
@@ -547,7 +598,7 @@ func (s *Store) HSetNX(db uint32, args [][]byte) (int64, error) {
 			return 0, nil
 		}
 	} else {
-		o = newHashRow(db, key)
+		o = newHashRow(db, key, s.currentRowVersion())
 		o.Field = field
 	}
 
@@ -576,10 +627,10 @@ func (s *Store) HMSet(db uint32, args [][]byte) error {
 		eles[i] = e
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadHashRow(db, key)
 	if err != nil {
@@ -587,7 +638,7 @@ func (s *Store) HMSet(db uint32, args [][]byte) error {
 	}
 
 	if o == nil {
-		o = newHashRow(db, key)
+		o = newHashRow(db, key, s.currentRowVersion())
 	}
 
 	ms := &markSet{}
@@ -624,12 +675,13 @@ func (s *Store) HMGet(db uint32, args [][]byte) ([][]byte, error) {
 
 	var values = make([][]byte, len(fields))
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadHashRow(db, key)
+	o, err := rd.loadHashRow(db, key)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -637,7 +689,7 @@ func (s *Store) HMGet(db uint32, args [][]byte) ([][]byte, error) {
 	if o != nil {
 		for i, field := range fields {
 			o.Field = field
-			exists, err := o.LoadDataValue(s)
+			exists, err := o.LoadDataValue(rd)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
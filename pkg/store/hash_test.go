@@ -414,3 +414,27 @@ func (s *testStoreSuite) TestHMSet(c *C) {
 	s.hmget(c, 0, "hash", "a", "")
 	s.checkEmpty(c)
 }
+
+func (s *testStoreSuite) TestHScan(c *C) {
+	c.Assert(s.scanall(c, s.s.HScan, 0, "hash", 1), HasLen, 0)
+	s.hmset(c, 0, "hash", "a", "0", "b", "1", "c", "2", "d", "3")
+
+	for _, count := range []int64{1, 3, 10} {
+		a := s.scanall(c, s.s.HScan, 0, "hash", count)
+		c.Assert(a, DeepEquals, []string{"a", "0", "b", "1", "c", "2", "d", "3"})
+	}
+	c.Assert(s.scanall(c, s.s.HScan, 0, "hash", 1, "match", "[bd]"), DeepEquals, []string{"b", "1", "d", "3"})
+
+	_, _, err := s.s.HScan(0, FormatBytes("hash", "0", "type", "hash"))
+	c.Assert(err, NotNil)
+	_, _, err = s.s.HScan(0, FormatBytes("hash", "xyz"))
+	c.Assert(err, NotNil)
+
+	s.xset(c, 0, "string", "a")
+	_, _, err = s.s.HScan(0, FormatBytes("string", "0"))
+	c.Assert(err, NotNil)
+
+	s.hdelall(c, 0, "hash", 1)
+	s.kdel(c, 0, 1, "string")
+	s.checkEmpty(c)
+}
@@ -4,6 +4,10 @@
 package store
 
 import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/juju/errors"
@@ -23,6 +27,10 @@ func (s *Store) loadStoreRow(db uint32, key []byte) (storeRow, error) {
 	}
 
 	if s.needDeleteIfExpired() && o.IsExpired() {
+		if s.view != nil {
+			s.view.expired = append(s.view.expired, storeKey{db, key})
+			return nil, nil
+		}
 		bt := engine.NewBatch()
 		if err := o.deleteObject(s, bt); err != nil {
 			return nil, errors.Trace(err)
@@ -33,34 +41,58 @@ func (s *Store) loadStoreRow(db uint32, key []byte) (storeRow, error) {
 	return o, nil
 }
 
+// delete key if it is still expired, used after reading from a view
+func (s *Store) deleteIfExpiredKey(db uint32, key []byte) error {
+	if err := s.acquire(key); err != nil {
+		return errors.Trace(err)
+	}
+	defer s.release(key)
+
+	_, err := s.loadStoreRow(db, key)
+	return errors.Trace(err)
+}
+
 func (s *Store) deleteIfExists(bt *engine.Batch, db uint32, key []byte) (bool, error) {
+	return s.freeIfExists(bt, db, key, s.needLazyFree())
+}
+
+func (s *Store) freeIfExists(bt *engine.Batch, db uint32, key []byte, lazy bool) (bool, error) {
 	o, err := loadStoreRow(s, db, key)
 	if err != nil || o == nil {
 		return false, errors.Trace(err)
 	}
 	// if key is already expired, we think it is not exists
 	exists := !o.IsExpired()
-	return exists, o.deleteObject(s, bt)
+	return exists, s.freeObject(o, bt, lazy)
 }
 
 // DEL key [key ...]
 func (s *Store) Del(db uint32, args [][]byte) (int64, error) {
+	return s.deleteKeys(db, args, "Del", s.needLazyFree())
+}
+
+// UNLINK key [key ...]
+func (s *Store) Unlink(db uint32, args [][]byte) (int64, error) {
+	return s.deleteKeys(db, args, "Unlink", true)
+}
+
+func (s *Store) deleteKeys(db uint32, args [][]byte, op string, lazy bool) (int64, error) {
 	if len(args) == 0 {
 		return 0, errArguments("len(args) = %d, expect != 0", len(args))
 	}
 
 	keys := args
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(keys...); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(keys...)
 
 	ms := &markSet{}
 	bt := engine.NewBatch()
 	for _, key := range keys {
 		if !ms.Has(key) {
-			exists, err := s.deleteIfExists(bt, db, key)
+			exists, err := s.freeIfExists(bt, db, key, lazy)
 			if err != nil {
 				return 0, errors.Trace(err)
 			}
@@ -69,7 +101,7 @@ func (s *Store) Del(db uint32, args [][]byte) (int64, error) {
 			}
 		}
 	}
-	fw := &Forward{DB: db, Op: "Del", Args: args}
+	fw := &Forward{DB: db, Op: op, Args: args}
 	return ms.Len(), s.commit(bt, fw)
 }
 
@@ -81,16 +113,17 @@ func (s *Store) Dump(db uint32, args [][]byte) (interface{}, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadStoreRow(db, key)
+	o, err := rd.loadStoreRow(db, key)
 	if err != nil || o == nil {
 		return nil, errors.Trace(err)
 	} else {
-		x, err := o.loadObjectValue(s)
+		x, err := o.loadObjectValue(rd)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -106,12 +139,13 @@ func (s *Store) Type(db uint32, args [][]byte) (ObjectCode, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadStoreRow(db, key)
+	o, err := rd.loadStoreRow(db, key)
 	if err != nil || o == nil {
 		return 0, errors.Trace(err)
 	}
@@ -126,12 +160,13 @@ func (s *Store) Exists(db uint32, args [][]byte) (int64, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadStoreRow(db, key)
+	o, err := rd.loadStoreRow(db, key)
 	if err != nil || o == nil {
 		return 0, errors.Trace(err)
 	} else {
@@ -147,12 +182,13 @@ func (s *Store) TTL(db uint32, args [][]byte) (int64, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	v, err := s.getExpireTTLms(db, key)
+	v, err := rd.getExpireTTLms(db, key)
 	if err != nil || v < 0 {
 		return v, errors.Trace(err)
 	}
@@ -167,12 +203,13 @@ func (s *Store) PTTL(db uint32, args [][]byte) (int64, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	return s.getExpireTTLms(db, key)
+	return rd.getExpireTTLms(db, key)
 }
 
 func (s *Store) getExpireTTLms(db uint32, key []byte) (int64, error) {
@@ -195,7 +232,9 @@ func (s *Store) setExpireAt(db uint32, key []byte, expireat int64) (int64, error
 	}
 	bt := engine.NewBatch()
 	if !IsExpired(expireat) {
+		o.delExpireIndex(bt)
 		o.SetExpireAt(expireat)
+		o.setExpireIndex(bt)
 		bt.Set(o.MetaKey(), o.MetaValue())
 		fw := &Forward{DB: db, Op: "PExpireAt", Args: [][]byte{key, FormatInt(expireat)}}
 		return 1, s.commit(bt, fw)
@@ -217,10 +256,10 @@ func (s *Store) Persist(db uint32, args [][]byte) (int64, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadStoreRow(db, key)
 	if err != nil || o == nil {
@@ -232,6 +271,7 @@ func (s *Store) Persist(db uint32, args [][]byte) (int64, error) {
 
 	fw := &Forward{DB: db, Op: "Persist", Args: args}
 	bt := engine.NewBatch()
+	o.delExpireIndex(bt)
 	o.SetExpireAt(0)
 	bt.Set(o.MetaKey(), o.MetaValue())
 	return 1, s.commit(bt, fw)
@@ -256,10 +296,10 @@ func (s *Store) Expire(db uint32, args [][]byte) (int64, error) {
 		return 0, errArguments("invalid ttls = %d", ttls)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.setExpireAt(db, key, expireat)
 }
@@ -283,10 +323,10 @@ func (s *Store) PExpire(db uint32, args [][]byte) (int64, error) {
 		return 0, errArguments("invalid ttlms = %d", ttlms)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.setExpireAt(db, key, expireat)
 }
@@ -312,10 +352,10 @@ func (s *Store) ExpireAt(db uint32, args [][]byte) (int64, error) {
 		expireat = timestamp * 1e3
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.setExpireAt(db, key, expireat)
 }
@@ -341,10 +381,10 @@ func (s *Store) PExpireAt(db uint32, args [][]byte) (int64, error) {
 		expireat = mtimestamp
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.setExpireAt(db, key, expireat)
 }
@@ -376,10 +416,10 @@ func (s *Store) Restore(db uint32, args [][]byte) error {
 		return errors.Trace(err)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	fw := &Forward{DB: db, Op: "Restore", Args: args}
 	bt := engine.NewBatch()
@@ -403,24 +443,267 @@ func (s *Store) restore(bt *engine.Batch, db uint32, key []byte, expireat int64,
 		case rdb.String:
 			o = newStringRow(db, key)
 		case rdb.Hash:
-			o = newHashRow(db, key)
+			o = newHashRow(db, key, s.currentRowVersion())
 		case rdb.List:
-			o = newListRow(db, key)
+			o = newListRow(db, key, s.currentRowVersion())
 		case rdb.ZSet:
-			o = newZSetRow(db, key)
+			o = newZSetRow(db, key, s.currentRowVersion())
 		case rdb.Set:
-			o = newSetRow(db, key)
+			o = newSetRow(db, key, s.currentRowVersion())
 		}
-		return o.storeObject(s, bt, expireat, obj)
+		if err := o.storeObject(s, bt, expireat, obj); err != nil {
+			return errors.Trace(err)
+		}
+		o.setExpireIndex(bt)
+		return nil
 	}
 
 	log.Debugf("restore an expired object, db = %d, key = %v, expireat = %d", db, key, expireat)
 	return nil
 }
 
+const (
+	defaultScanCount = 10
+)
+
+type scanSpec struct {
+	Cursor []byte
+	Match  []byte
+	Count  int64
+	Type   string
+}
+
+// parse cursor [MATCH pattern] [COUNT count] [TYPE type]
+func parseScanSpec(args [][]byte) (*scanSpec, error) {
+	if len(args) == 0 || len(args)%2 != 1 {
+		return nil, errArguments("len(args) = %d, expect != 0 && mod 2 = 1", len(args))
+	}
+
+	spec := &scanSpec{Cursor: args[0], Count: defaultScanCount}
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			spec.Match = args[i+1]
+		case "COUNT":
+			n, err := ParseInt(args[i+1])
+			if err != nil {
+				return nil, errArguments("parse args[%d] failed - %s", i+1, err)
+			} else if n <= 0 {
+				return nil, errArguments("parse args[%d] failed, count = %d", i+1, n)
+			}
+			spec.Count = n
+		case "TYPE":
+			spec.Type = strings.ToLower(string(args[i+1]))
+		default:
+			return nil, errArguments("parse args[%d] failed, %s", i, args[i])
+		}
+	}
+	return spec, nil
+}
+
+func (spec *scanSpec) isFirst() bool {
+	return len(spec.Cursor) == 1 && spec.Cursor[0] == '0'
+}
+
+func (spec *scanSpec) matchKey(key []byte) bool {
+	return len(spec.Match) == 0 || MatchPattern(spec.Match, key)
+}
+
+func encodeScanCursor(p []byte) []byte {
+	if p == nil {
+		return []byte("0")
+	}
+	return []byte(hex.EncodeToString(p))
+}
+
+func decodeScanCursor(cursor []byte, pfx []byte) ([]byte, error) {
+	p, err := hex.DecodeString(string(cursor))
+	if err != nil || !bytes.HasPrefix(p, pfx) {
+		return nil, errArguments("invalid cursor %s", cursor)
+	}
+	return p, nil
+}
+
+// parse cursor [MATCH pattern] [COUNT count] for HSCAN, SSCAN and ZSCAN
+func parseDataScanSpec(args [][]byte) (*scanSpec, error) {
+	spec, err := parseScanSpec(args)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(spec.Type) != 0 {
+		return nil, errArguments("TYPE is not supported")
+	}
+	return spec, nil
+}
+
+// scan data keys with prefix pfx from the cursor, cursor is the hex encoded data key suffix,
+// f is called with the suffix and value of every examined data key, returns the next cursor
+func scanDataKeys(r storeReader, pfx []byte, spec *scanSpec, f func(sfx, value []byte) error) ([]byte, error) {
+	start := pfx
+	if !spec.isFirst() {
+		sfx, err := decodeScanCursor(spec.Cursor, nil)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		start = append(append([]byte{}, pfx...), sfx...)
+	}
+
+	it := r.getIterator()
+	defer r.putIterator(it)
+
+	var n int64
+	for it.SeekTo(start); it.Valid(); it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, pfx) {
+			break
+		}
+		sfx := key[len(pfx):]
+		if n >= spec.Count {
+			return encodeScanCursor(sfx), nil
+		}
+		n++
+		if err := f(sfx, it.Value()); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return encodeScanCursor(nil), nil
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// cursor is the hex encoded meta key that the next SCAN will start from, "0" means start or end.
+func (s *Store) Scan(db uint32, args [][]byte) ([]byte, [][]byte, error) {
+	spec, err := parseScanSpec(args)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
+	pfx := EncodeMetaKeyPrefixDB(db)
+	start := pfx
+	if !spec.isFirst() {
+		if start, err = decodeScanCursor(spec.Cursor, pfx); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+	}
+
+	if err := s.acquire(); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	defer s.release()
+
+	it := s.getIterator()
+	defer s.putIterator(it)
+
+	// like redis, count is the number of keys examined, not returned
+	var keys [][]byte
+	var n int64
+	for it.SeekTo(start); it.Valid(); it.Next() {
+		metaKey := it.Key()
+		if !bytes.HasPrefix(metaKey, pfx) {
+			break
+		}
+		if n >= spec.Count {
+			return encodeScanCursor(metaKey), keys, nil
+		}
+		n++
+		key, o, err := decodeMetaEntry(metaKey, it.Value())
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		if o.IsExpired() || !spec.matchKey(key) {
+			continue
+		}
+		if len(spec.Type) != 0 && o.Code().String() != spec.Type {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if err := it.Error(); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return encodeScanCursor(nil), keys, nil
+}
+
+// KEYS pattern
+func (s *Store) Keys(db uint32, args [][]byte) ([][]byte, error) {
+	if len(args) != 1 {
+		return nil, errArguments("len(args) = %d, expect = 1", len(args))
+	}
+
+	pattern := args[0]
+
+	if err := s.acquire(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer s.release()
+
+	it := s.getIterator()
+	defer s.putIterator(it)
+
+	var keys [][]byte
+	pfx := EncodeMetaKeyPrefixDB(db)
+	for it.SeekTo(pfx); it.Valid(); it.Next() {
+		metaKey := it.Key()
+		if !bytes.HasPrefix(metaKey, pfx) {
+			break
+		}
+		key, o, err := decodeMetaEntry(metaKey, it.Value())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if !o.IsExpired() && MatchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return keys, nil
+}
+
+// RANDOMKEY
+func (s *Store) RandomKey(db uint32, args [][]byte) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errArguments("len(args) = %d, expect = 0", len(args))
+	}
+
+	if err := s.acquire(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer s.release()
+
+	it := s.getIterator()
+	defer s.putIterator(it)
+
+	// start from a random slot, then wrap around to the first slot if nothing found
+	pfx := EncodeMetaKeyPrefixDB(db)
+	start := EncodeMetaKeyPrefixSlot(db, uint32(rand.Intn(MaxSlotNum)))
+	for _, seek := range [][]byte{start, pfx} {
+		for it.SeekTo(seek); it.Valid(); it.Next() {
+			metaKey := it.Key()
+			if !bytes.HasPrefix(metaKey, pfx) {
+				break
+			}
+			key, o, err := decodeMetaEntry(metaKey, it.Value())
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if !o.IsExpired() {
+				return key, nil
+			}
+		}
+		if err := it.Error(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return nil, nil
+}
+
 func (s *Store) CompactAll() error {
 	if err := s.acquire(); err != nil {
-		errors.Trace(err)
+		return errors.Trace(err)
 	}
 	defer s.release()
 	log.Infof("store is compacting all...")
@@ -430,6 +713,15 @@ func (s *Store) CompactAll() error {
 	if err := s.compact([]byte{DataCode}, []byte{DataCode + 1}); err != nil {
 		return errors.Trace(err)
 	}
+	if err := s.compact([]byte{expireCode}, []byte{expireCode + 1}); err != nil {
+		return errors.Trace(err)
+	}
+	if err := s.compact([]byte{garbageCode}, []byte{garbageCode + 1}); err != nil {
+		return errors.Trace(err)
+	}
+	if err := s.compact([]byte{rankCode}, []byte{rankCode + 1}); err != nil {
+		return errors.Trace(err)
+	}
 	log.Infof("store is compacted")
 	return nil
 }
@@ -5,6 +5,7 @@ package store
 
 import (
 	"math"
+	"sort"
 
 	. "gopkg.in/check.v1"
 )
@@ -276,3 +277,179 @@ func (s *testStoreSuite) TestRestore(c *C) {
 	s.kexists(c, 0, "key", 0)
 	s.checkEmpty(c)
 }
+
+func (s *testStoreSuite) kscan(c *C, db uint32, args ...interface{}) ([]byte, []string) {
+	cursor, keys, err := s.s.Scan(db, FormatBytes(args...))
+	c.Assert(err, IsNil)
+	a := make([]string, len(keys))
+	for i, key := range keys {
+		a[i] = string(key)
+	}
+	return cursor, a
+}
+
+func (s *testStoreSuite) kscanall(c *C, db uint32, count int64, args ...interface{}) []string {
+	var all []string
+	cursor := []byte("0")
+	for {
+		next, keys := s.kscan(c, db, append([]interface{}{cursor, "count", count}, args...)...)
+		c.Assert(len(keys) <= int(count), Equals, true)
+		all = append(all, keys...)
+		if string(next) == "0" {
+			break
+		}
+		cursor = next
+	}
+	sort.Strings(all)
+	return all
+}
+
+func (s *testStoreSuite) kkeys(c *C, db uint32, pattern string, expect ...string) {
+	keys, err := s.s.Keys(db, FormatBytes(pattern))
+	c.Assert(err, IsNil)
+	a := make([]string, len(keys))
+	for i, key := range keys {
+		a[i] = string(key)
+	}
+	sort.Strings(a)
+	sort.Strings(expect)
+	c.Assert(len(a), Equals, len(expect))
+	for i := range a {
+		c.Assert(a[i], Equals, expect[i])
+	}
+}
+
+func (s *testStoreSuite) krandomkey(c *C, db uint32, expect ...string) {
+	key, err := s.s.RandomKey(db, nil)
+	c.Assert(err, IsNil)
+	if len(expect) == 0 {
+		c.Assert(key, IsNil)
+		return
+	}
+	for _, e := range expect {
+		if string(key) == e {
+			return
+		}
+	}
+	c.Fatalf("unexpected random key %s", key)
+}
+
+func (s *testStoreSuite) TestScan(c *C) {
+	cursor, keys := s.kscan(c, 0, 0)
+	c.Assert(string(cursor), Equals, "0")
+	c.Assert(keys, HasLen, 0)
+
+	s.xset(c, 0, "a", "a")
+	s.xset(c, 0, "b", "b")
+	s.xset(c, 0, "c", "c")
+	s.hset(c, 0, "h", "f", "v", 1)
+	s.zadd(c, 0, "z", 1, "m", 1)
+	s.xset(c, 1, "d", "d")
+
+	for _, count := range []int64{1, 2, 10} {
+		keys := s.kscanall(c, 0, count)
+		c.Assert(keys, DeepEquals, []string{"a", "b", "c", "h", "z"})
+	}
+	c.Assert(s.kscanall(c, 0, 2, "match", "[ab]"), DeepEquals, []string{"a", "b"})
+	c.Assert(s.kscanall(c, 0, 2, "type", "string"), DeepEquals, []string{"a", "b", "c"})
+	c.Assert(s.kscanall(c, 0, 2, "type", "zset"), DeepEquals, []string{"z"})
+	c.Assert(s.kscanall(c, 1, 1), DeepEquals, []string{"d"})
+
+	s.kpexpireat(c, 0, "c", nowms()-10, 1)
+	c.Assert(s.kscanall(c, 0, 10), DeepEquals, []string{"a", "b", "h", "z"})
+
+	_, _, err := s.s.Scan(0, FormatBytes("xyz"))
+	c.Assert(err, NotNil)
+	_, _, err = s.s.Scan(0, FormatBytes(0, "count", 0))
+	c.Assert(err, NotNil)
+	_, _, err = s.s.Scan(0, FormatBytes(0, "match"))
+	c.Assert(err, NotNil)
+
+	s.kdel(c, 0, 4, "a", "b", "h", "z")
+	s.kdel(c, 1, 1, "d")
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestKeys(c *C) {
+	s.kkeys(c, 0, "*")
+	s.xset(c, 0, "hello", "a")
+	s.xset(c, 0, "hallo", "a")
+	s.xset(c, 0, "hxllo", "a")
+	s.xset(c, 0, "heeeello", "a")
+	s.xset(c, 1, "hello", "a")
+
+	s.kkeys(c, 0, "*", "hello", "hallo", "hxllo", "heeeello")
+	s.kkeys(c, 0, "h?llo", "hello", "hallo", "hxllo")
+	s.kkeys(c, 0, "h*llo", "hello", "hallo", "hxllo", "heeeello")
+	s.kkeys(c, 0, "h[ae]llo", "hello", "hallo")
+	s.kkeys(c, 0, "h[^e]llo", "hallo", "hxllo")
+	s.kkeys(c, 0, "h[a-b]llo", "hallo")
+	s.kkeys(c, 1, "*", "hello")
+
+	s.kpexpireat(c, 0, "hxllo", nowms()-10, 1)
+	s.kkeys(c, 0, "h?llo", "hello", "hallo")
+
+	s.kdel(c, 0, 3, "hello", "hallo", "heeeello")
+	s.kdel(c, 1, 1, "hello")
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestRandomKey(c *C) {
+	s.krandomkey(c, 0)
+	s.xset(c, 0, "a", "a")
+	s.krandomkey(c, 0, "a")
+	s.krandomkey(c, 1)
+
+	s.xset(c, 0, "b", "b")
+	s.lpush(c, 0, "c", 1, "c")
+	for i := 0; i < 16; i++ {
+		s.krandomkey(c, 0, "a", "b", "c")
+	}
+
+	s.kdel(c, 0, 3, "a", "b", "c")
+	s.krandomkey(c, 0)
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestMatchPattern(c *C) {
+	tests := []struct {
+		pattern, str string
+		expect       bool
+	}{
+		{"*", "", true},
+		{"*", "abc", true},
+		{"a*", "abc", true},
+		{"a*c", "abc", true},
+		{"a*d", "abc", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"[abc]", "b", true},
+		{"[^abc]", "b", false},
+		{"[a-c]x", "bx", true},
+		{"[c-a]x", "bx", true},
+		{"[a-c]x", "dx", false},
+		{"a\\*", "a*", true},
+		{"a\\*", "ab", false},
+		{"[", "", false},
+	}
+	for _, t := range tests {
+		c.Assert(MatchPattern([]byte(t.pattern), []byte(t.str)), Equals, t.expect, Commentf("%s %s", t.pattern, t.str))
+	}
+}
+
+func (s *testStoreSuite) scanall(c *C, scan func(uint32, [][]byte) ([]byte, [][]byte, error), db uint32, key string, count int64, args ...interface{}) []string {
+	var all []string
+	cursor := []byte("0")
+	for {
+		next, a, err := scan(db, FormatBytes(append([]interface{}{key, cursor, "count", count}, args...)...))
+		c.Assert(err, IsNil)
+		for _, v := range a {
+			all = append(all, string(v))
+		}
+		if string(next) == "0" {
+			break
+		}
+		cursor = next
+	}
+	return all
+}
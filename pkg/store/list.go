@@ -5,6 +5,7 @@ package store
 
 import (
 	"bytes"
+	"strings"
 
 	"github.com/juju/errors"
 	"github.com/reborndb/go/redis/rdb"
@@ -25,9 +26,9 @@ type listRow struct {
 	Value  []byte
 }
 
-func newListRow(db uint32, key []byte) *listRow {
+func newListRow(db uint32, key []byte, version uint64) *listRow {
 	o := &listRow{}
-	o.lazyInit(db, key, newStoreRowHelper(db, key, ListCode))
+	o.lazyInit(db, key, newStoreRowHelper(db, key, ListCode, version))
 	return o
 }
 
@@ -48,7 +49,7 @@ func (o *listRow) deleteObject(s *Store, bt *engine.Batch) error {
 		}
 		bt.Del(key)
 	}
-	bt.Del(o.MetaKey())
+	o.deleteMetaKey(bt)
 	return it.Error()
 }
 
@@ -86,6 +87,19 @@ func (o *listRow) loadObjectValue(r storeReader) (interface{}, error) {
 	return rdb.List(list), nil
 }
 
+// load values in [beg, end)
+func (o *listRow) loadValues(r storeReader, beg, end int64) ([][]byte, error) {
+	values := make([][]byte, 0, int(end-beg))
+	for o.Index = beg; o.Index < end; o.Index++ {
+		_, err := o.LoadDataValue(r)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		values = append(values, o.Value)
+	}
+	return values, nil
+}
+
 func (s *Store) loadListRow(db uint32, key []byte) (*listRow, error) {
 	o, err := s.loadStoreRow(db, key)
 	if err != nil {
@@ -112,14 +126,20 @@ func (s *Store) LIndex(db uint32, args [][]byte) ([]byte, error) {
 		return nil, errArguments("parse args failed - %s", err)
 	}
 
-	o, err := s.loadListRow(db, key)
+	rd, err := s.acquireReader()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer s.releaseReader(rd)
+
+	o, err := rd.loadListRow(db, key)
 	if err != nil || o == nil {
 		return nil, errors.Trace(err)
 	}
 
 	o.Index = adjustIndex(index, o.Lindex, o.Rindex)
 	if o.Index >= o.Lindex && o.Index < o.Rindex {
-		_, err := o.LoadDataValue(s)
+		_, err := o.LoadDataValue(rd)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -137,12 +157,13 @@ func (s *Store) LLen(db uint32, args [][]byte) (int64, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadListRow(db, key)
+	o, err := rd.loadListRow(db, key)
 	if err != nil || o == nil {
 		return 0, errors.Trace(err)
 	}
@@ -165,12 +186,13 @@ func (s *Store) LRange(db uint32, args [][]byte) ([][]byte, error) {
 		return nil, errArguments("parse args failed - %s", err)
 	}
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadListRow(db, key)
+	o, err := rd.loadListRow(db, key)
 	if err != nil || o == nil {
 		return nil, errors.Trace(err)
 	}
@@ -180,7 +202,7 @@ func (s *Store) LRange(db uint32, args [][]byte) ([][]byte, error) {
 	if beg <= end {
 		values := make([][]byte, 0, end-beg+1)
 		for o.Index = beg; o.Index <= end; o.Index++ {
-			_, err := o.LoadDataValue(s)
+			_, err := o.LoadDataValue(rd)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
@@ -205,10 +227,10 @@ func (s *Store) LSet(db uint32, args [][]byte) error {
 	}
 	value := args[2]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadListRow(db, key)
 	if err != nil {
@@ -247,10 +269,10 @@ func (s *Store) LTrim(db uint32, args [][]byte) error {
 		return errArguments("parse args failed - %s", err)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadListRow(db, key)
 	if err != nil || o == nil {
@@ -277,7 +299,7 @@ func (s *Store) LTrim(db uint32, args [][]byte) error {
 		for o.Index = o.Lindex; o.Index < o.Rindex; o.Index++ {
 			bt.Del(o.DataKey())
 		}
-		bt.Del(o.MetaKey())
+		o.deleteMetaKey(bt)
 	}
 	fw := &Forward{DB: db, Op: "LTrim", Args: args}
 	return s.commit(bt, fw)
@@ -291,10 +313,10 @@ func (s *Store) LPop(db uint32, args [][]byte) ([]byte, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadListRow(db, key)
 	if err != nil || o == nil {
@@ -310,7 +332,7 @@ func (s *Store) LPop(db uint32, args [][]byte) ([]byte, error) {
 		if o.Lindex++; o.Lindex < o.Rindex {
 			bt.Set(o.MetaKey(), o.MetaValue())
 		} else {
-			bt.Del(o.MetaKey())
+			o.deleteMetaKey(bt)
 		}
 		fw := &Forward{DB: db, Op: "LPop", Args: args}
 		return o.Value, s.commit(bt, fw)
@@ -325,10 +347,10 @@ func (s *Store) RPop(db uint32, args [][]byte) ([]byte, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadListRow(db, key)
 	if err != nil || o == nil {
@@ -344,7 +366,7 @@ func (s *Store) RPop(db uint32, args [][]byte) ([]byte, error) {
 		if o.Rindex--; o.Lindex < o.Rindex {
 			bt.Set(o.MetaKey(), o.MetaValue())
 		} else {
-			bt.Del(o.MetaKey())
+			o.deleteMetaKey(bt)
 		}
 		fw := &Forward{DB: db, Op: "RPop", Args: args}
 		return o.Value, s.commit(bt, fw)
@@ -360,10 +382,10 @@ func (s *Store) LPush(db uint32, args [][]byte) (int64, error) {
 	key := args[0]
 	values := args[1:]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.lpush(db, key, true, values...)
 }
@@ -377,10 +399,10 @@ func (s *Store) LPushX(db uint32, args [][]byte) (int64, error) {
 	key := args[0]
 	value := args[1]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.lpush(db, key, false, value)
 }
@@ -394,10 +416,10 @@ func (s *Store) RPush(db uint32, args [][]byte) (int64, error) {
 	key := args[0]
 	values := args[1:]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.rpush(db, key, true, values...)
 }
@@ -411,10 +433,10 @@ func (s *Store) RPushX(db uint32, args [][]byte) (int64, error) {
 	key := args[0]
 	value := args[1]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.rpush(db, key, false, value)
 }
@@ -429,7 +451,7 @@ func (s *Store) lpush(db uint32, key []byte, create bool, values ...[]byte) (int
 		if !create {
 			return 0, nil
 		}
-		o = newListRow(db, key)
+		o = newListRow(db, key, s.currentRowVersion())
 	}
 
 	fw := &Forward{DB: db, Op: "LPush", Args: [][]byte{key}}
@@ -454,7 +476,7 @@ func (s *Store) rpush(db uint32, key []byte, create bool, values ...[]byte) (int
 		if !create {
 			return 0, nil
 		}
-		o = newListRow(db, key)
+		o = newListRow(db, key, s.currentRowVersion())
 	}
 
 	fw := &Forward{DB: db, Op: "RPush", Args: [][]byte{key}}
@@ -468,3 +490,329 @@ func (s *Store) rpush(db uint32, key []byte, create bool, values ...[]byte) (int
 	bt.Set(o.MetaKey(), o.MetaValue())
 	return o.Rindex - o.Lindex, s.commit(bt, fw)
 }
+
+// LINSERT key BEFORE|AFTER pivot value
+func (s *Store) LInsert(db uint32, args [][]byte) (int64, error) {
+	if len(args) != 4 {
+		return 0, errArguments("len(args) = %d, expect = 4", len(args))
+	}
+
+	key := args[0]
+	var after bool
+	switch strings.ToUpper(string(args[1])) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return 0, errArguments("invalid linsert argument %s", args[1])
+	}
+	pivot, value := args[2], args[3]
+
+	if err := s.acquire(key); err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer s.release(key)
+
+	o, err := s.loadListRow(db, key)
+	if err != nil || o == nil {
+		return 0, errors.Trace(err)
+	}
+
+	values, err := o.loadValues(s, o.Lindex, o.Rindex)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	pos, found := int64(0), false
+	for i, v := range values {
+		if bytes.Equal(v, pivot) {
+			pos, found = o.Lindex+int64(i), true
+			break
+		}
+	}
+	if !found {
+		return -1, nil
+	}
+	if after {
+		pos++
+	}
+
+	// shift the shorter side to make room for the new element
+	bt := engine.NewBatch()
+	if pos-o.Lindex < o.Rindex-pos {
+		for i := o.Lindex; i < pos; i++ {
+			o.Index, o.Value = i-1, values[i-o.Lindex]
+			bt.Set(o.DataKey(), o.DataValue())
+		}
+		o.Lindex--
+		o.Index, o.Value = pos-1, value
+	} else {
+		for i := o.Rindex - 1; i >= pos; i-- {
+			o.Index, o.Value = i+1, values[i-o.Lindex]
+			bt.Set(o.DataKey(), o.DataValue())
+		}
+		o.Rindex++
+		o.Index, o.Value = pos, value
+	}
+	bt.Set(o.DataKey(), o.DataValue())
+	bt.Set(o.MetaKey(), o.MetaValue())
+	fw := &Forward{DB: db, Op: "LInsert", Args: args}
+	return o.Rindex - o.Lindex, s.commit(bt, fw)
+}
+
+// LREM key count value
+func (s *Store) LRem(db uint32, args [][]byte) (int64, error) {
+	if len(args) != 3 {
+		return 0, errArguments("len(args) = %d, expect = 3", len(args))
+	}
+
+	key := args[0]
+	count, err := ParseInt(args[1])
+	if err != nil {
+		return 0, errArguments("parse args failed - %s", err)
+	}
+	value := args[2]
+
+	if err := s.acquire(key); err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer s.release(key)
+
+	o, err := s.loadListRow(db, key)
+	if err != nil || o == nil {
+		return 0, errors.Trace(err)
+	}
+
+	values, err := o.loadValues(s, o.Lindex, o.Rindex)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	// mark removed elements, from tail to head if count is negative
+	removed := make([]bool, len(values))
+	n := int64(0)
+	for i := range values {
+		j := i
+		if count < 0 {
+			j = len(values) - 1 - i
+		}
+		if bytes.Equal(values[j], value) {
+			removed[j] = true
+			if n++; n == count || n == -count {
+				break
+			}
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	// move the rest elements forward, only elements after the first removed one are rewritten
+	bt := engine.NewBatch()
+	idx := o.Lindex
+	for i, v := range values {
+		if removed[i] {
+			continue
+		}
+		if o.Index = o.Lindex + int64(i); o.Index != idx {
+			o.Index, o.Value = idx, v
+			bt.Set(o.DataKey(), o.DataValue())
+		}
+		idx++
+	}
+	for o.Index = idx; o.Index < o.Rindex; o.Index++ {
+		bt.Del(o.DataKey())
+	}
+	if o.Rindex = idx; o.Lindex < o.Rindex {
+		bt.Set(o.MetaKey(), o.MetaValue())
+	} else {
+		o.deleteMetaKey(bt)
+	}
+	fw := &Forward{DB: db, Op: "LRem", Args: args}
+	return n, s.commit(bt, fw)
+}
+
+// RPOPLPUSH source destination
+func (s *Store) RPopLPush(db uint32, args [][]byte) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errArguments("len(args) = %d, expect = 2", len(args))
+	}
+
+	if err := s.acquire(args[0], args[1]); err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer s.release(args[0], args[1])
+
+	fw := &Forward{DB: db, Op: "RPopLPush", Args: args}
+	return s.lmove(db, args[0], args[1], false, true, fw)
+}
+
+func parseListSide(arg []byte) (bool, error) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, nil
+	case "RIGHT":
+		return false, nil
+	default:
+		return false, errArguments("invalid list side %s", arg)
+	}
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func (s *Store) LMove(db uint32, args [][]byte) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, errArguments("len(args) = %d, expect = 4", len(args))
+	}
+
+	srcLeft, err := parseListSide(args[2])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	dstLeft, err := parseListSide(args[3])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := s.acquire(args[0], args[1]); err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer s.release(args[0], args[1])
+
+	fw := &Forward{DB: db, Op: "LMove", Args: args}
+	return s.lmove(db, args[0], args[1], srcLeft, dstLeft, fw)
+}
+
+// pop from source and push into destination in one batch
+func (s *Store) lmove(db uint32, src, dst []byte, srcLeft, dstLeft bool, fw *Forward) ([]byte, error) {
+	o, err := s.loadListRow(db, src)
+	if err != nil || o == nil {
+		return nil, errors.Trace(err)
+	}
+
+	d := o
+	if !bytes.Equal(src, dst) {
+		if d, err = s.loadListRow(db, dst); err != nil {
+			return nil, errors.Trace(err)
+		} else if d == nil {
+			d = newListRow(db, dst, s.currentRowVersion())
+		}
+	}
+
+	if srcLeft {
+		o.Index = o.Lindex
+	} else {
+		o.Index = o.Rindex - 1
+	}
+	if _, err := o.LoadDataValue(s); err != nil {
+		return nil, errors.Trace(err)
+	}
+	value := o.Value
+
+	bt := engine.NewBatch()
+	bt.Del(o.DataKey())
+	if srcLeft {
+		o.Lindex++
+	} else {
+		o.Rindex--
+	}
+	if d != o {
+		if o.Lindex < o.Rindex {
+			bt.Set(o.MetaKey(), o.MetaValue())
+		} else {
+			o.deleteMetaKey(bt)
+		}
+	}
+
+	if dstLeft {
+		d.Lindex--
+		d.Index = d.Lindex
+	} else {
+		d.Index = d.Rindex
+		d.Rindex++
+	}
+	d.Value = value
+	bt.Set(d.DataKey(), d.DataValue())
+	bt.Set(d.MetaKey(), d.MetaValue())
+	return value, s.commit(bt, fw)
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func (s *Store) LPos(db uint32, args [][]byte) ([]int64, error) {
+	if len(args) < 2 {
+		return nil, errArguments("len(args) = %d, expect >= 2", len(args))
+	}
+
+	key := args[0]
+	element := args[1]
+
+	rank, count, maxlen := int64(1), int64(1), int64(0)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errArguments("invalid lpos argument at %d", i)
+		}
+		v, err := ParseInt(args[i+1])
+		if err != nil {
+			return nil, errArguments("parse args failed - %s", err)
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "RANK":
+			if v == 0 {
+				return nil, errArguments("RANK can't be zero")
+			}
+			rank = v
+		case "COUNT":
+			if v < 0 {
+				return nil, errArguments("COUNT can't be negative")
+			}
+			count = v
+		case "MAXLEN":
+			if v < 0 {
+				return nil, errArguments("MAXLEN can't be negative")
+			}
+			maxlen = v
+		default:
+			return nil, errArguments("invalid lpos argument at %d", i)
+		}
+	}
+
+	rd, err := s.acquireReader()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer s.releaseReader(rd)
+
+	o, err := rd.loadListRow(db, key)
+	if err != nil || o == nil {
+		return nil, errors.Trace(err)
+	}
+
+	// skip the first rank-1 matches, scan from tail if rank is negative
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+	var pos []int64
+	size := o.Rindex - o.Lindex
+	for i := int64(0); i < size && (maxlen == 0 || i < maxlen); i++ {
+		if rank > 0 {
+			o.Index = o.Lindex + i
+		} else {
+			o.Index = o.Rindex - 1 - i
+		}
+		if _, err := o.LoadDataValue(rd); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if !bytes.Equal(o.Value, element) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		pos = append(pos, o.Index-o.Lindex)
+		if count != 0 && int64(len(pos)) == count {
+			break
+		}
+	}
+	return pos, nil
+}
@@ -4,6 +4,7 @@
 package store
 
 import (
+	"bytes"
 	"math/rand"
 	"strconv"
 
@@ -356,3 +357,178 @@ func (s *testStoreSuite) TestLTrim(c *C) {
 	s.llen(c, 0, "list", 0)
 	s.checkEmpty(c)
 }
+
+// number of data keys of list, must be equal to its length
+func (s *testStoreSuite) ldatalen(c *C, db uint32, key string) int64 {
+	it := s.s.getIterator()
+	defer s.s.putIterator(it)
+
+	n := int64(0)
+	pfx := EncodeDataKeyPrefix(db, []byte(key))
+	for it.SeekTo(pfx); it.Valid(); it.Next() {
+		if !bytes.HasPrefix(it.Key(), pfx) {
+			break
+		}
+		n++
+	}
+	c.Assert(it.Error(), IsNil)
+	return n
+}
+
+func (s *testStoreSuite) linsert(c *C, db uint32, key string, where string, pivot, value string, expect int64) {
+	x, err := s.s.LInsert(db, FormatBytes(key, where, pivot, value))
+	c.Assert(err, IsNil)
+	c.Assert(x, Equals, expect)
+	if expect > 0 {
+		c.Assert(s.ldatalen(c, db, key), Equals, expect)
+	}
+}
+
+func (s *testStoreSuite) lrem(c *C, db uint32, key string, count int, value string, expect int64) {
+	x, err := s.s.LRem(db, FormatBytes(key, count, value))
+	c.Assert(err, IsNil)
+	c.Assert(x, Equals, expect)
+}
+
+func (s *testStoreSuite) lmove(c *C, db uint32, src, dst string, from, to string, expect string) {
+	x, err := s.s.LMove(db, FormatBytes(src, dst, from, to))
+	c.Assert(err, IsNil)
+	if expect == "" {
+		c.Assert(x, IsNil)
+	} else {
+		c.Assert(string(x), Equals, expect)
+	}
+}
+
+func (s *testStoreSuite) lpos(c *C, db uint32, key string, value string, args []interface{}, expect ...int64) {
+	x, err := s.s.LPos(db, FormatBytes(append([]interface{}{key, value}, args...)...))
+	c.Assert(err, IsNil)
+	c.Assert(x, HasLen, len(expect))
+	for i, v := range expect {
+		c.Assert(x[i], Equals, v)
+	}
+}
+
+func (s *testStoreSuite) TestLInsert(c *C) {
+	s.linsert(c, 0, "list", "before", "a", "x", 0)
+	s.rpush(c, 0, "list", 4, "a", "b", "c", "d")
+	s.linsert(c, 0, "list", "before", "z", "x", -1)
+
+	// shift left part
+	s.linsert(c, 0, "list", "BEFORE", "b", "x", 5)
+	s.ldump(c, 0, "list", "a", "x", "b", "c", "d")
+	s.linsert(c, 0, "list", "before", "a", "y", 6)
+	s.ldump(c, 0, "list", "y", "a", "x", "b", "c", "d")
+
+	// shift right part
+	s.linsert(c, 0, "list", "AFTER", "c", "z", 7)
+	s.ldump(c, 0, "list", "y", "a", "x", "b", "c", "z", "d")
+	s.linsert(c, 0, "list", "after", "d", "w", 8)
+	s.ldump(c, 0, "list", "y", "a", "x", "b", "c", "z", "d", "w")
+
+	_, err := s.s.LInsert(0, FormatBytes("list", "middle", "a", "x"))
+	c.Assert(err, NotNil)
+
+	s.xset(c, 0, "string", "a")
+	_, err = s.s.LInsert(0, FormatBytes("string", "before", "a", "x"))
+	c.Assert(err, NotNil)
+	s.kdel(c, 0, 1, "string")
+
+	s.ltrim(c, 0, "list", 1, 0)
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestLRem(c *C) {
+	s.lrem(c, 0, "list", 0, "a", 0)
+	ss := []string{"a", "b", "a", "c", "a", "b", "a"}
+	s.rpush(c, 0, "list", int64(len(ss)), ss...)
+	s.lrem(c, 0, "list", 0, "z", 0)
+
+	s.lrem(c, 0, "list", 2, "a", 2)
+	s.ldump(c, 0, "list", "b", "c", "a", "b", "a")
+	c.Assert(s.ldatalen(c, 0, "list"), Equals, int64(5))
+	s.lrem(c, 0, "list", -1, "b", 1)
+	s.ldump(c, 0, "list", "b", "c", "a", "a")
+	c.Assert(s.ldatalen(c, 0, "list"), Equals, int64(4))
+	s.lrem(c, 0, "list", 0, "a", 2)
+	s.ldump(c, 0, "list", "b", "c")
+	c.Assert(s.ldatalen(c, 0, "list"), Equals, int64(2))
+
+	// list is deleted once empty
+	s.lpush(c, 0, "list", 4, "c", "b")
+	s.lrem(c, 0, "list", -10, "b", 2)
+	s.lrem(c, 0, "list", 0, "c", 2)
+	s.llen(c, 0, "list", 0)
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestLMove(c *C) {
+	s.lmove(c, 0, "src", "dst", "left", "right", "")
+	s.rpush(c, 0, "src", 3, "a", "b", "c")
+
+	x, err := s.s.RPopLPush(0, FormatBytes("src", "dst"))
+	c.Assert(err, IsNil)
+	c.Assert(string(x), Equals, "c")
+	s.ldump(c, 0, "src", "a", "b")
+	s.ldump(c, 0, "dst", "c")
+
+	s.lmove(c, 0, "src", "dst", "LEFT", "RIGHT", "a")
+	s.ldump(c, 0, "src", "b")
+	s.ldump(c, 0, "dst", "c", "a")
+
+	// rotate
+	s.lmove(c, 0, "dst", "dst", "left", "right", "c")
+	s.ldump(c, 0, "dst", "a", "c")
+	s.lmove(c, 0, "src", "src", "right", "left", "b")
+	s.ldump(c, 0, "src", "b")
+
+	// source is kept if destination is not a list
+	s.xset(c, 0, "string", "a")
+	_, err = s.s.LMove(0, FormatBytes("src", "string", "left", "left"))
+	c.Assert(err, NotNil)
+	s.ldump(c, 0, "src", "b")
+	s.kdel(c, 0, 1, "string")
+
+	_, err = s.s.LMove(0, FormatBytes("src", "dst", "up", "left"))
+	c.Assert(err, NotNil)
+
+	s.lmove(c, 0, "src", "dst", "right", "left", "b")
+	s.llen(c, 0, "src", 0)
+	s.ldump(c, 0, "dst", "b", "a", "c")
+	c.Assert(s.ldatalen(c, 0, "dst"), Equals, int64(3))
+
+	s.lpop(c, 0, "dst", "b")
+	s.lpop(c, 0, "dst", "a")
+	s.lpop(c, 0, "dst", "c")
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestLPos(c *C) {
+	s.lpos(c, 0, "list", "a", nil)
+	ss := []string{"a", "b", "c", "1", "2", "3", "c", "c"}
+	s.rpush(c, 0, "list", int64(len(ss)), ss...)
+
+	s.lpos(c, 0, "list", "c", nil, 2)
+	s.lpos(c, 0, "list", "z", nil)
+	s.lpos(c, 0, "list", "c", []interface{}{"RANK", 2}, 6)
+	s.lpos(c, 0, "list", "c", []interface{}{"RANK", -1}, 7)
+	s.lpos(c, 0, "list", "c", []interface{}{"COUNT", 2}, 2, 6)
+	s.lpos(c, 0, "list", "c", []interface{}{"COUNT", 0}, 2, 6, 7)
+	s.lpos(c, 0, "list", "c", []interface{}{"RANK", -1, "COUNT", 0}, 7, 6, 2)
+	s.lpos(c, 0, "list", "c", []interface{}{"COUNT", 0, "MAXLEN", 3}, 2)
+	s.lpos(c, 0, "list", "c", []interface{}{"RANK", -2, "MAXLEN", 1})
+
+	for _, args := range [][]interface{}{
+		{"RANK", 0},
+		{"COUNT", -1},
+		{"MAXLEN", -1},
+		{"RANK"},
+		{"UNKNOWN", 1},
+	} {
+		_, err := s.s.LPos(0, FormatBytes(append([]interface{}{"list", "c"}, args...)...))
+		c.Assert(err, NotNil)
+	}
+
+	s.ltrim(c, 0, "list", 1, 0)
+	s.checkEmpty(c)
+}
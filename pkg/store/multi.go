@@ -0,0 +1,162 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package store
+
+import (
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/qdb/pkg/engine"
+)
+
+type storeTx struct {
+	// db of the last forward, valid if forwarded is true
+	db        uint32
+	forwarded bool
+
+	// replication state is saved once transaction ends, see attachReplState
+	replPending bool
+}
+
+// Multi calls f with the whole store locked, commands called on tx will not acquire the lock again,
+// so they are executed atomically.
+// Forwards in transaction are wrapped by Multi and Exec forwards, slaves can apply them as one unit.
+func (s *Store) Multi(f func(tx *Store) error) error {
+	if s.tx != nil {
+		return f(s)
+	}
+
+	if err := s.acquireAll(); err != nil {
+		return errors.Trace(err)
+	}
+	defer s.releaseAll()
+
+	tx := &Store{storeCore: s.storeCore, tx: &storeTx{}}
+	err := f(tx)
+	if tx.tx.replPending {
+		if err := s.commitTxReplState(); err != nil {
+			log.Errorf("store save replication state of transaction failed - %s", err)
+		}
+	}
+	if tx.tx.forwarded {
+		fw := &Forward{DB: tx.tx.db, Op: "Exec"}
+		s.travelPreCommitHandlers(fw)
+		s.travelPostCommitHandlers(fw)
+	}
+	return errors.Trace(err)
+}
+
+// forward Multi before the first forward in transaction
+func (tx *storeTx) beginForward(s *Store, fw *Forward) {
+	if !tx.forwarded {
+		m := &Forward{DB: fw.DB, Op: "Multi"}
+		s.travelPreCommitHandlers(m)
+		s.travelPostCommitHandlers(m)
+		tx.forwarded = true
+	}
+	tx.db = fw.DB
+}
+
+type keyWatch struct {
+	refs    int
+	version uint64
+}
+
+func encodeWatchKey(db uint32, key []byte) string {
+	return string(EncodeDataKeyPrefix(db, key))
+}
+
+// get watch key from meta key or data key, returns "" for other keys
+func decodeWatchKey(p []byte) string {
+	if len(p) == 0 {
+		return ""
+	}
+	switch p[0] {
+	case MetaCode:
+		if db, key, err := DecodeMetaKey(p); err == nil {
+			return encodeWatchKey(db, key)
+		}
+	case DataCode:
+		var db uint32
+		var key []byte
+		r := NewBufReader(p)
+		if err := decodeRawBytes(r, nil, DataCode, &db, &key); err == nil {
+			return string(p[:len(p)-r.Len()])
+		}
+	}
+	return ""
+}
+
+// Watch key, returns its version which will be changed once the key is modified
+func (s *Store) Watch(db uint32, key []byte) (uint64, error) {
+	if err := s.acquire(key); err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer s.release(key)
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	k := encodeWatchKey(db, key)
+	w := s.watches[k]
+	if w == nil {
+		w = &keyWatch{version: s.serial}
+		s.watches[k] = w
+	}
+	w.refs++
+	return w.version, nil
+}
+
+// Unwatch key, must be called once for every Watch
+func (s *Store) Unwatch(db uint32, key []byte) error {
+	if err := s.acquire(key); err != nil {
+		return errors.Trace(err)
+	}
+	defer s.release(key)
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	k := encodeWatchKey(db, key)
+	if w := s.watches[k]; w != nil {
+		if w.refs--; w.refs <= 0 {
+			delete(s.watches, k)
+		}
+	}
+	return nil
+}
+
+// WatchVersion returns current version of a watched key
+func (s *Store) WatchVersion(db uint32, key []byte) (uint64, error) {
+	if err := s.acquire(key); err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer s.release(key)
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	if w := s.watches[encodeWatchKey(db, key)]; w != nil {
+		return w.version, nil
+	}
+	return 0, errors.Errorf("key is not watched")
+}
+
+// bump versions of watched keys modified by batch, called with commitMu held
+func (s *Store) touchWatchedKeys(bt *engine.Batch) {
+	if len(s.watches) == 0 {
+		return
+	}
+	for e := bt.OpList.Front(); e != nil; e = e.Next() {
+		var key []byte
+		switch x := e.Value.(type) {
+		case *engine.BatchOpSet:
+			key = x.Key
+		case *engine.BatchOpDel:
+			key = x.Key
+		}
+		if w := s.watches[decodeWatchKey(key)]; w != nil {
+			w.version = s.serial
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package store
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *testStoreSuite) TestWatch(c *C) {
+	_, err := s.s.WatchVersion(0, []byte("a"))
+	c.Assert(err, NotNil)
+
+	v1, err := s.s.Watch(0, []byte("a"))
+	c.Assert(err, IsNil)
+	v2, err := s.s.Watch(0, []byte("h"))
+	c.Assert(err, IsNil)
+
+	checkVersion := func(key string, expect uint64, changed bool) uint64 {
+		v, err := s.s.WatchVersion(0, []byte(key))
+		c.Assert(err, IsNil)
+		c.Assert(v != expect, Equals, changed)
+		return v
+	}
+
+	s.xset(c, 0, "b", "b")
+	s.xset(c, 1, "a", "a")
+	checkVersion("a", v1, false)
+	s.xset(c, 0, "a", "a")
+	v1 = checkVersion("a", v1, true)
+
+	s.hset(c, 0, "h", "f", "v", 1)
+	v2 = checkVersion("h", v2, true)
+	// only data key is modified
+	s.hset(c, 0, "h", "f", "x", 0)
+	v2 = checkVersion("h", v2, true)
+	checkVersion("a", v1, false)
+
+	// watched twice
+	_, err = s.s.Watch(0, []byte("a"))
+	c.Assert(err, IsNil)
+	c.Assert(s.s.Unwatch(0, []byte("a")), IsNil)
+	checkVersion("a", v1, false)
+	c.Assert(s.s.Unwatch(0, []byte("a")), IsNil)
+	_, err = s.s.WatchVersion(0, []byte("a"))
+	c.Assert(err, NotNil)
+
+	s.kdel(c, 0, 1, "h")
+	checkVersion("h", v2, true)
+	c.Assert(s.s.Unwatch(0, []byte("h")), IsNil)
+
+	s.kdel(c, 0, 2, "a", "b")
+	s.kdel(c, 1, 1, "a")
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestMulti(c *C) {
+	var ops []string
+	enabled := true
+	s.s.RegPostCommitHandler(func(f *Forward) error {
+		if enabled {
+			ops = append(ops, f.Op)
+		}
+		return nil
+	})
+	defer func() {
+		enabled = false
+	}()
+
+	err := s.s.Multi(func(tx *Store) error {
+		// lock is held by tx
+		c.Assert(tx.Acquire(), IsNil)
+		tx.Release()
+
+		if err := tx.Set(0, FormatBytes("a", "1")); err != nil {
+			return err
+		}
+		if _, err := tx.IncrBy(0, FormatBytes("a", 10)); err != nil {
+			return err
+		}
+		return tx.Multi(func(tx *Store) error {
+			_, err := tx.Del(0, FormatBytes("b"))
+			return err
+		})
+	})
+	c.Assert(err, IsNil)
+	s.xget(c, 0, "a", "11")
+	c.Assert(ops, DeepEquals, []string{"Multi", "Set", "IncrBy", "Exec"})
+
+	// no forward, no Multi
+	ops = nil
+	err = s.s.Multi(func(tx *Store) error {
+		_, err := tx.Get(0, FormatBytes("a"))
+		return err
+	})
+	c.Assert(err, IsNil)
+	c.Assert(ops, HasLen, 0)
+
+	s.kdel(c, 0, 1, "a")
+	s.checkEmpty(c)
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package store
+
+import (
+	"github.com/juju/errors"
+	"github.com/reborndb/qdb/pkg/engine"
+)
+
+// ReplState is the position in the replication stream of a master,
+// data of the store have applied the stream up to Offset.
+type ReplState struct {
+	RunID  string
+	Offset int64
+
+	// db selected by the stream at Offset
+	DB uint32
+
+	// filter of the stream if not empty, data of the store only have the matched keys
+	Filter string
+}
+
+var replStateKey = []byte{replCode}
+
+func encodeReplState(st *ReplState) []byte {
+	runID, filter := []byte(st.RunID), []byte(st.Filter)
+	w := NewBufWriter(nil)
+	encodeRawBytes(w, replCode, &runID, &st.Offset, &st.DB, &filter)
+	return w.Bytes()
+}
+
+func decodeReplState(p []byte) (*ReplState, error) {
+	var runID, filter []byte
+	st := &ReplState{}
+	r := NewBufReader(p)
+	err := decodeRawBytes(r, nil, replCode, &runID, &st.Offset, &st.DB, &filter)
+	err = decodeRawBytes(r, err)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	st.RunID, st.Filter = string(runID), string(filter)
+	return st, nil
+}
+
+// commits with forward are writes of the replication stream if the state is set,
+// otherwise they are local writes and the saved state becomes invalid.
+// A transaction of the stream is committed by commands one by one, so the state
+// is saved after its last commit, and a crash in the middle never skips the rest.
+// Must be called with commitMu held.
+func (s *Store) attachReplState(bt *engine.Batch) {
+	if s.replState != nil {
+		if s.tx != nil {
+			s.tx.replPending = true
+			return
+		}
+		bt.Set(replStateKey, s.replState)
+		s.replSaved = true
+	} else if s.replSaved {
+		bt.Del(replStateKey)
+		s.replSaved = false
+	}
+}
+
+// SetReplState sets the state saved atomically with the following writes,
+// nil means the following writes are not from the replication stream.
+func (s *Store) SetReplState(st *ReplState) {
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	if st != nil {
+		s.replState = encodeReplState(st)
+	} else {
+		s.replState = nil
+	}
+}
+
+// SaveReplState saves the state immediately, e.g. after full sync.
+func (s *Store) SaveReplState(st *ReplState) error {
+	s.SetReplState(st)
+
+	bt := engine.NewBatch()
+	bt.Set(replStateKey, encodeReplState(st))
+	return s.commitReplState(bt)
+}
+
+// LoadReplState returns the saved state, or nil if not found.
+func (s *Store) LoadReplState() (*ReplState, error) {
+	if err := s.acquire(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer s.release()
+
+	p, err := s.db.Get(replStateKey)
+	if err != nil || p == nil {
+		return nil, errors.Trace(err)
+	}
+	return decodeReplState(p)
+}
+
+// ClearReplState deletes the saved state, the store is not a slave any more.
+func (s *Store) ClearReplState() error {
+	s.SetReplState(nil)
+
+	bt := engine.NewBatch()
+	bt.Del(replStateKey)
+	return s.commitReplState(bt)
+}
+
+// saves the state after the last commit of transaction, store is locked by Multi
+func (s *Store) commitTxReplState() error {
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	bt := engine.NewBatch()
+	s.attachReplState(bt)
+	if bt.Len() == 0 {
+		return nil
+	}
+	return errors.Trace(s.db.Commit(bt))
+}
+
+func (s *Store) commitReplState(bt *engine.Batch) error {
+	if err := s.acquire(); err != nil {
+		return errors.Trace(err)
+	}
+	defer s.release()
+
+	if err := s.commit(bt, nil); err != nil {
+		return errors.Trace(err)
+	}
+
+	s.commitMu.Lock()
+	s.replSaved = s.replState != nil
+	s.commitMu.Unlock()
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package store
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *testStoreSuite) checkReplState(c *C, expect *ReplState) {
+	st, err := s.s.LoadReplState()
+	c.Assert(err, IsNil)
+	c.Assert(st, DeepEquals, expect)
+}
+
+func (s *testStoreSuite) TestReplState(c *C) {
+	s.checkReplState(c, nil)
+
+	st := &ReplState{RunID: "run", Offset: 10, DB: 1}
+	err := s.s.SaveReplState(st)
+	c.Assert(err, IsNil)
+	s.checkReplState(c, st)
+
+	// writes of the stream save the state in the same batch
+	s.s.SetReplState(&ReplState{RunID: "run", Offset: 20, DB: 2, Filter: "db=2"})
+	s.xset(c, 0, "key", "value")
+	s.checkReplState(c, &ReplState{RunID: "run", Offset: 20, DB: 2, Filter: "db=2"})
+
+	// transaction of the stream saves the state after its last commit
+	s.s.SetReplState(&ReplState{RunID: "run", Offset: 30, DB: 0})
+	err = s.s.Multi(func(tx *Store) error {
+		for _, key := range []string{"key", "key2"} {
+			if err := tx.Set(0, FormatBytes(key, "value")); err != nil {
+				return err
+			}
+			p, err := tx.db.Get(replStateKey)
+			c.Assert(err, IsNil)
+			c.Assert(p, DeepEquals, encodeReplState(&ReplState{RunID: "run", Offset: 20, DB: 2, Filter: "db=2"}))
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	s.checkReplState(c, &ReplState{RunID: "run", Offset: 30, DB: 0})
+	s.kdel(c, 0, 1, "key2")
+
+	// the state becomes invalid after local writes
+	s.s.SetReplState(nil)
+	s.kdel(c, 0, 1, "key")
+	s.checkReplState(c, nil)
+
+	err = s.s.SaveReplState(st)
+	c.Assert(err, IsNil)
+	err = s.s.ClearReplState()
+	c.Assert(err, IsNil)
+	s.checkReplState(c, nil)
+
+	s.checkEmpty(c)
+}
@@ -71,6 +71,12 @@ func EncodeMetaKeyPrefixTag(db uint32, tag []byte) []byte {
 	return w.Bytes()
 }
 
+func EncodeMetaKeyPrefixDB(db uint32) []byte {
+	w := NewBufWriter(nil)
+	encodeRawBytes(w, MetaCode, &db)
+	return w.Bytes()
+}
+
 func EncodeDataKeyPrefix(db uint32, key []byte) []byte {
 	if len(key) == 0 {
 		log.Errorf("encode nil data key")
@@ -80,6 +86,15 @@ func EncodeDataKeyPrefix(db uint32, key []byte) []byte {
 	return w.Bytes()
 }
 
+// Data keys of hash, list, set and zset are prefixed with the version of the row,
+// so data keys of an unlinked row never mix with data keys of a new row.
+// Rows written before versioning have no version, see metaVersionFlag.
+func encodeVersionDataKeyPrefix(db uint32, key []byte, version uint64) []byte {
+	w := NewBufWriter(EncodeDataKeyPrefix(db, key))
+	encodeRawBytes(w, &version)
+	return w.Bytes()
+}
+
 type storeRow interface {
 	Code() ObjectCode
 
@@ -96,20 +111,32 @@ type storeRow interface {
 
 	GetExpireAt() int64
 	SetExpireAt(expireat int64)
+	GetVersion() uint64
 	IsExpired() bool
 
+	setExpireIndex(bt *engine.Batch)
+	delExpireIndex(bt *engine.Batch)
+
 	lazyInit(db uint32, key []byte, h *storeRowHelper)
 	storeObject(s *Store, bt *engine.Batch, expireat int64, obj interface{}) error
 	deleteObject(s *Store, bt *engine.Batch) error
+	unlinkObject(s *Store, bt *engine.Batch)
+	isVersioned() bool
 	loadObjectValue(r storeReader) (interface{}, error)
 }
 
 type storeRowHelper struct {
+	db            uint32
+	key           []byte
 	code          ObjectCode
 	metaKey       []byte
 	dataKeyPrefix []byte
 
 	ExpireAt int64
+	Version  uint64
+
+	// false for strings and rows written before versioning
+	versioned bool
 
 	dataKeyRefs   []interface{}
 	metaValueRefs []interface{}
@@ -122,11 +149,28 @@ func loadStoreRow(r storeReader, db uint32, key []byte) (storeRow, error) {
 	if err != nil || p == nil {
 		return nil, errors.Trace(err)
 	}
+	return parseStoreRow(db, key, metaKey, p)
+}
+
+// parse meta key and meta value from iterator, returns key and its row
+func decodeMetaEntry(metaKey []byte, p []byte) ([]byte, storeRow, error) {
+	db, key, err := DecodeMetaKey(metaKey)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	o, err := parseStoreRow(db, key, EncodeMetaKey(db, key), p)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return key, o, nil
+}
+
+func parseStoreRow(db uint32, key []byte, metaKey []byte, p []byte) (storeRow, error) {
 	if len(p) == 0 {
 		return nil, errors.Trace(ErrObjectCode)
 	}
 	var o storeRow
-	var code = ObjectCode(p[0])
+	var code = ObjectCode(p[0] &^ metaVersionFlag)
 	switch code {
 	default:
 		return nil, errors.Trace(ErrObjectCode)
@@ -142,18 +186,24 @@ func loadStoreRow(r storeReader, db uint32, key []byte) (storeRow, error) {
 		o = new(setRow)
 	}
 	o.lazyInit(db, key, &storeRowHelper{
-		code:          code,
-		metaKey:       metaKey,
-		dataKeyPrefix: EncodeDataKeyPrefix(db, key),
+		db:      db,
+		key:     key,
+		code:    code,
+		metaKey: metaKey,
 	})
 	return o, o.ParseMetaValue(p)
 }
 
-func newStoreRowHelper(db uint32, key []byte, code ObjectCode) *storeRowHelper {
+// version is ignored by strings
+func newStoreRowHelper(db uint32, key []byte, code ObjectCode, version uint64) *storeRowHelper {
 	return &storeRowHelper{
-		code:          code,
-		metaKey:       EncodeMetaKey(db, key),
-		dataKeyPrefix: EncodeDataKeyPrefix(db, key),
+		db:      db,
+		key:     key,
+		code:    code,
+		metaKey: EncodeMetaKey(db, key),
+		Version: version,
+
+		versioned: code != StringCode,
 	}
 }
 
@@ -167,14 +217,26 @@ func (o *storeRowHelper) MetaKey() []byte {
 
 func (o *storeRowHelper) MetaValue() []byte {
 	w := NewBufWriter(nil)
-	encodeRawBytes(w, o.code, &o.ExpireAt)
+	if o.versioned {
+		encodeRawBytes(w, byte(o.code)|metaVersionFlag, &o.ExpireAt, &o.Version)
+	} else {
+		encodeRawBytes(w, o.code, &o.ExpireAt)
+	}
 	encodeRawBytes(w, o.metaValueRefs...)
 	return w.Bytes()
 }
 
 func (o *storeRowHelper) ParseMetaValue(p []byte) (err error) {
+	var code byte
 	r := NewBufReader(p)
-	err = decodeRawBytes(r, err, o.code, &o.ExpireAt)
+	err = decodeRawBytes(r, err, &code)
+	if err == nil && ObjectCode(code&^metaVersionFlag) != o.code {
+		err = errors.Errorf("read code [%s], expect [%s]", ObjectCode(code&^metaVersionFlag), o.code)
+	}
+	err = decodeRawBytes(r, err, &o.ExpireAt)
+	if o.versioned = code&metaVersionFlag != 0; o.versioned {
+		err = decodeRawBytes(r, err, &o.Version)
+	}
 	err = decodeRawBytes(r, err, o.metaValueRefs...)
 	err = decodeRawBytes(r, err)
 	return
@@ -190,10 +252,30 @@ func (o *storeRowHelper) DataKey() []byte {
 	}
 }
 
+// data key prefix depends on version parsed from meta value, so it is built lazily
 func (o *storeRowHelper) DataKeyPrefix() []byte {
+	if o.dataKeyPrefix == nil {
+		o.dataKeyPrefix = o.encodeKeyPrefix(DataCode)
+	}
 	return o.dataKeyPrefix
 }
 
+// returns code + db + key, followed by version if the row is versioned
+func (o *storeRowHelper) encodeKeyPrefix(code byte) []byte {
+	w := NewBufWriter(nil)
+	encodeRawBytes(w, code, &o.db, &o.key)
+	if o.versioned {
+		encodeRawBytes(w, &o.Version)
+	}
+	return w.Bytes()
+}
+
+// rows written before versioning can't be unlinked, their data key prefix is
+// a prefix of data keys of versioned rows with the same key
+func (o *storeRowHelper) isVersioned() bool {
+	return o.versioned
+}
+
 func (o *storeRowHelper) ParseDataKeySuffix(p []byte) (err error) {
 	r := NewBufReader(p)
 	err = decodeRawBytes(r, err, o.dataKeyRefs...)
@@ -240,6 +322,10 @@ func (o *storeRowHelper) SetExpireAt(expireat int64) {
 	o.ExpireAt = expireat
 }
 
+func (o *storeRowHelper) GetVersion() uint64 {
+	return o.Version
+}
+
 func (o *storeRowHelper) IsExpired() bool {
 	return IsExpired(o.ExpireAt)
 }
@@ -254,8 +340,29 @@ const (
 
 	// for zset
 	indexCode = byte('+')
+	rankCode  = byte('^')
+
+	// for expire index
+	expireCode = byte('!')
+
+	// for the marker of expire index built for all keys
+	expireIndexedCode = byte('@')
+
+	// for data keys of unlinked rows
+	garbageCode = byte('-')
+
+	// for data keys of rows written before their meta keys
+	pendingCode = byte('%')
+
+	// for replication state of slave
+	replCode = byte('$')
 )
 
+// Set in the code byte of meta values which are followed by the row version.
+// Meta values without it are written before versioning, their data keys have
+// no version, and they are still read and updated in that layout.
+const metaVersionFlag = byte(0x80)
+
 type ObjectCode byte
 
 const (
@@ -305,6 +412,8 @@ func encodeRawBytes(w *BufWriter, refs ...interface{}) {
 			err = w.WriteVarbytes(*x)
 		case *scoreInt:
 			err = w.WriteUint64(uint64(*x))
+		case *expireInt:
+			err = w.WriteUint64(uint64(*x))
 		default:
 			log.Fatalf("unsupported type in row value: %+v", x)
 		}
@@ -374,6 +483,12 @@ func decodeRawBytes(r *BufReader, err error, refs ...interface{}) error {
 				return errors.Trace(err)
 			}
 			*x = scoreInt(v)
+		case *expireInt:
+			v, err := r.ReadUint64()
+			if err != nil {
+				return errors.Trace(err)
+			}
+			*x = expireInt(v)
 		case *byte:
 			v, err := r.ReadByte()
 			if err != nil {
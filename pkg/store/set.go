@@ -7,10 +7,14 @@ import (
 	"bytes"
 
 	"github.com/juju/errors"
+	"github.com/ngaut/log"
 	"github.com/reborndb/go/redis/rdb"
 	"github.com/reborndb/qdb/pkg/engine"
 )
 
+// result of SINTERSTORE, SUNIONSTORE and SDIFFSTORE is committed in batches of the size
+const setsStoreBatchSize = 1024
+
 type setRow struct {
 	*storeRowHelper
 
@@ -18,9 +22,9 @@ type setRow struct {
 	Member []byte
 }
 
-func newSetRow(db uint32, key []byte) *setRow {
+func newSetRow(db uint32, key []byte, version uint64) *setRow {
 	o := &setRow{}
-	o.lazyInit(db, key, newStoreRowHelper(db, key, SetCode))
+	o.lazyInit(db, key, newStoreRowHelper(db, key, SetCode, version))
 	return o
 }
 
@@ -41,7 +45,7 @@ func (o *setRow) deleteObject(s *Store, bt *engine.Batch) error {
 		}
 		bt.Del(key)
 	}
-	bt.Del(o.MetaKey())
+	o.deleteMetaKey(bt)
 	return it.Error()
 }
 
@@ -148,10 +152,10 @@ func (s *Store) SAdd(db uint32, args [][]byte) (int64, error) {
 	key := args[0]
 	members := args[1:]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadSetRow(db, key)
 	if err != nil {
@@ -159,7 +163,7 @@ func (s *Store) SAdd(db uint32, args [][]byte) (int64, error) {
 	}
 
 	if o == nil {
-		o = newSetRow(db, key)
+		o = newSetRow(db, key, s.currentRowVersion())
 	}
 
 	ms := &markSet{}
@@ -192,12 +196,13 @@ func (s *Store) SCard(db uint32, args [][]byte) (int64, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadSetRow(db, key)
+	o, err := rd.loadSetRow(db, key)
 	if err != nil || o == nil {
 		return 0, errors.Trace(err)
 	}
@@ -213,18 +218,19 @@ func (s *Store) SIsMember(db uint32, args [][]byte) (int64, error) {
 	key := args[0]
 	member := args[1]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadSetRow(db, key)
+	o, err := rd.loadSetRow(db, key)
 	if err != nil || o == nil {
 		return 0, errors.Trace(err)
 	}
 
 	o.Member = member
-	exists, err := o.TestDataValue(s)
+	exists, err := o.TestDataValue(rd)
 	if err != nil || !exists {
 		return 0, errors.Trace(err)
 	} else {
@@ -240,17 +246,59 @@ func (s *Store) SMembers(db uint32, args [][]byte) ([][]byte, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadSetRow(db, key)
+	o, err := rd.loadSetRow(db, key)
 	if err != nil || o == nil {
 		return nil, errors.Trace(err)
 	}
 
-	return o.getMembers(s, o.Size)
+	return o.getMembers(rd, o.Size)
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func (s *Store) SScan(db uint32, args [][]byte) ([]byte, [][]byte, error) {
+	if len(args) < 2 {
+		return nil, nil, errArguments("len(args) = %d, expect >= 2", len(args))
+	}
+
+	key := args[0]
+	spec, err := parseDataScanSpec(args[1:])
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
+	rd, err := s.acquireReader()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	defer s.releaseReader(rd)
+
+	o, err := rd.loadSetRow(db, key)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	} else if o == nil {
+		return encodeScanCursor(nil), nil, nil
+	}
+
+	var rets [][]byte
+	cursor, err := scanDataKeys(rd, o.DataKeyPrefix(), spec, func(sfx, value []byte) error {
+		if err := o.ParseDataKeySuffix(sfx); err != nil {
+			return errors.Trace(err)
+		}
+		if spec.matchKey(o.Member) {
+			rets = append(rets, o.Member)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return cursor, rets, nil
 }
 
 // SPOP key
@@ -261,10 +309,10 @@ func (s *Store) SPop(db uint32, args [][]byte) ([]byte, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadSetRow(db, key)
 	if err != nil || o == nil {
@@ -282,7 +330,7 @@ func (s *Store) SPop(db uint32, args [][]byte) ([]byte, error) {
 	if o.Size--; o.Size > 0 {
 		bt.Set(o.MetaKey(), o.MetaValue())
 	} else {
-		bt.Del(o.MetaKey())
+		o.deleteMetaKey(bt)
 	}
 	fw := &Forward{DB: db, Op: "SRem", Args: [][]byte{key, members[0]}}
 	return o.Member, s.commit(bt, fw)
@@ -305,12 +353,13 @@ func (s *Store) SRandMember(db uint32, args [][]byte) ([][]byte, error) {
 		}
 	}
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadSetRow(db, key)
+	o, err := rd.loadSetRow(db, key)
 	if err != nil || o == nil {
 		return nil, errors.Trace(err)
 	}
@@ -319,7 +368,7 @@ func (s *Store) SRandMember(db uint32, args [][]byte) ([][]byte, error) {
 		count += o.Size
 	}
 	if count > 0 {
-		return o.getMembers(s, count)
+		return o.getMembers(rd, count)
 	} else {
 		return nil, nil
 	}
@@ -334,10 +383,10 @@ func (s *Store) SRem(db uint32, args [][]byte) (int64, error) {
 	key := args[0]
 	members := args[1:]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadSetRow(db, key)
 	if err != nil || o == nil {
@@ -364,9 +413,426 @@ func (s *Store) SRem(db uint32, args [][]byte) (int64, error) {
 		if o.Size -= n; o.Size > 0 {
 			bt.Set(o.MetaKey(), o.MetaValue())
 		} else {
-			bt.Del(o.MetaKey())
+			o.deleteMetaKey(bt)
 		}
 	}
 	fw := &Forward{DB: db, Op: "SRem", Args: args}
 	return n, s.commit(bt, fw)
 }
+
+// iterates members of a set or zset in data key order
+type memberIterator struct {
+	it  *storeIterator
+	pfx []byte
+	sfx []byte
+}
+
+// nil prefix means the key doesn't exist, returns an exhausted iterator
+func newMemberIterator(r storeReader, pfx []byte) *memberIterator {
+	x := &memberIterator{}
+	if pfx != nil {
+		x.it = r.getIterator()
+		x.pfx = pfx
+		x.seek(nil)
+	}
+	return x
+}
+
+func (x *memberIterator) close(r storeReader) {
+	if x.it != nil {
+		r.putIterator(x.it)
+	}
+}
+
+// move to the first member whose data key suffix >= sfx
+func (x *memberIterator) seek(sfx []byte) {
+	key := make([]byte, 0, len(x.pfx)+len(sfx))
+	key = append(append(key, x.pfx...), sfx...)
+	x.it.SeekTo(key)
+	x.load()
+}
+
+func (x *memberIterator) next() {
+	x.it.Next()
+	x.load()
+}
+
+func (x *memberIterator) load() {
+	x.sfx = nil
+	if x.it.Valid() {
+		if key := x.it.Key(); bytes.HasPrefix(key, x.pfx) {
+			x.sfx = append([]byte{}, key[len(x.pfx):]...)
+		}
+	}
+}
+
+func (x *memberIterator) valid() bool {
+	return x.sfx != nil
+}
+
+// data value of current member, iterator must be valid
+func (x *memberIterator) value() []byte {
+	return x.it.Value()
+}
+
+func (x *memberIterator) err() error {
+	if x.it != nil {
+		return x.it.Error()
+	}
+	return nil
+}
+
+const (
+	setInter = iota
+	setUnion
+	setDiff
+)
+
+// merge sets by walking their data keys at the same time, f is called with
+// the data key suffix of every member of the result in data key order
+func mergeSets(r storeReader, sets []*setRow, op int, f func(sfx []byte)) error {
+	pfxs := make([][]byte, len(sets))
+	for i, o := range sets {
+		if o != nil {
+			pfxs[i] = o.DataKeyPrefix()
+		}
+	}
+	return mergeMembers(r, pfxs, op, func(its []*memberIterator, sfx []byte) {
+		f(sfx)
+	})
+}
+
+// merge members under the data key prefixes, nil prefix is an empty set,
+// iterators of the members equal to sfx are still positioned on it when f is called
+func mergeMembers(r storeReader, pfxs [][]byte, op int, f func(its []*memberIterator, sfx []byte)) error {
+	if op == setInter {
+		for _, pfx := range pfxs {
+			if pfx == nil {
+				return nil
+			}
+		}
+	}
+
+	its := make([]*memberIterator, len(pfxs))
+	for i, pfx := range pfxs {
+		its[i] = newMemberIterator(r, pfx)
+		defer its[i].close(r)
+	}
+
+	g := func(sfx []byte) {
+		f(its, sfx)
+	}
+	switch op {
+	case setInter:
+		mergeSetsInter(its, g)
+	case setUnion:
+		mergeSetsUnion(its, g)
+	case setDiff:
+		mergeSetsDiff(its, g)
+	}
+
+	for _, x := range its {
+		if err := x.err(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// leapfrog all iterators to the largest current member until they agree
+func mergeSetsInter(its []*memberIterator, f func(sfx []byte)) {
+	for {
+		var max []byte
+		for _, x := range its {
+			if !x.valid() {
+				return
+			}
+			if max == nil || bytes.Compare(x.sfx, max) > 0 {
+				max = x.sfx
+			}
+		}
+		match := true
+		for _, x := range its {
+			if bytes.Compare(x.sfx, max) < 0 {
+				if x.seek(max); !x.valid() {
+					return
+				}
+			}
+			if !bytes.Equal(x.sfx, max) {
+				match = false
+			}
+		}
+		if match {
+			f(max)
+			its[0].next()
+		}
+	}
+}
+
+// emit the smallest current member and advance all iterators on it
+func mergeSetsUnion(its []*memberIterator, f func(sfx []byte)) {
+	for {
+		var min []byte
+		for _, x := range its {
+			if x.valid() && (min == nil || bytes.Compare(x.sfx, min) < 0) {
+				min = x.sfx
+			}
+		}
+		if min == nil {
+			return
+		}
+		f(min)
+		for _, x := range its {
+			if x.valid() && bytes.Equal(x.sfx, min) {
+				x.next()
+			}
+		}
+	}
+}
+
+// walk the first set, seek the others to each of its members
+func mergeSetsDiff(its []*memberIterator, f func(sfx []byte)) {
+	for first := its[0]; first.valid(); first.next() {
+		found := false
+		for _, x := range its[1:] {
+			if x.valid() && bytes.Compare(x.sfx, first.sfx) < 0 {
+				x.seek(first.sfx)
+			}
+			if x.valid() && bytes.Equal(x.sfx, first.sfx) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			f(first.sfx)
+		}
+	}
+}
+
+func (s *Store) loadSetRows(db uint32, keys [][]byte) ([]*setRow, error) {
+	sets := make([]*setRow, len(keys))
+	for i, key := range keys {
+		o, err := s.loadSetRow(db, key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		sets[i] = o
+	}
+	return sets, nil
+}
+
+func (s *Store) setsMembers(db uint32, keys [][]byte, op int) ([][]byte, error) {
+	rd, err := s.acquireReader()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer s.releaseReader(rd)
+
+	sets, err := rd.loadSetRows(db, keys)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var members [][]byte
+	var perr error
+	o := newSetRow(db, nil, 0)
+	err = mergeSets(rd, sets, op, func(sfx []byte) {
+		if perr == nil {
+			perr = o.ParseDataKeySuffix(sfx)
+			members = append(members, o.Member)
+		}
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return members, errors.Trace(perr)
+}
+
+func (s *Store) setsStore(db uint32, args [][]byte, op int, fw *Forward) (int64, error) {
+	dest := args[0]
+	keys := args[1:]
+
+	if err := s.acquire(args...); err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer s.release(args...)
+
+	sets, err := s.loadSetRows(db, keys)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	d, err := loadStoreRow(s, db, dest)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	// Big result is committed in batches as a pending row, then dest is replaced by
+	// linking its meta key, so dest can be one of the sets. Data key prefix of an
+	// unversioned dest covers the new row, so it is deleted first in one batch.
+	inBatches := d == nil || d.isVersioned() || d.Code() == StringCode
+
+	bt := engine.NewBatch()
+	if !inBatches {
+		if _, err := s.deleteIfExists(bt, db, dest); err != nil {
+			return 0, errors.Trace(err)
+		}
+	} else if d != nil && d.isVersioned() {
+		s.retireRowVersion(d.GetVersion())
+	}
+
+	o := newSetRow(db, dest, s.currentRowVersion())
+	pfx, value := o.DataKeyPrefix(), o.DataValue()
+	pending := false
+	var perr error
+	err = mergeSets(s, sets, op, func(sfx []byte) {
+		if perr != nil {
+			return
+		}
+		key := make([]byte, 0, len(pfx)+len(sfx))
+		bt.Set(append(append(key, pfx...), sfx...), value)
+		o.Size++
+
+		if inBatches && bt.Len() >= setsStoreBatchSize {
+			if !pending {
+				bt.Set(encodePendingKey(pfx), []byte{byte(SetCode)})
+				pending = true
+			}
+			perr = s.commit(bt, nil)
+			bt = engine.NewBatch()
+		}
+	})
+	if err == nil {
+		err = perr
+	}
+	if err == nil && inBatches {
+		_, err = s.deleteIfExists(bt, db, dest)
+	}
+	if err != nil {
+		if pending {
+			if err := s.abandonPendingRow(pfx, SetCode, o.Version); err != nil {
+				log.Warningf("abandon pending set failed - %s", err)
+			}
+		}
+		return 0, errors.Trace(err)
+	}
+
+	if pending {
+		bt.Del(encodePendingKey(pfx))
+	}
+	if o.Size != 0 {
+		bt.Set(o.MetaKey(), o.MetaValue())
+	}
+	return o.Size, s.commit(bt, fw)
+}
+
+// SINTER key [key ...]
+func (s *Store) SInter(db uint32, args [][]byte) ([][]byte, error) {
+	if len(args) == 0 {
+		return nil, errArguments("len(args) = %d, expect != 0", len(args))
+	}
+	return s.setsMembers(db, args, setInter)
+}
+
+// SUNION key [key ...]
+func (s *Store) SUnion(db uint32, args [][]byte) ([][]byte, error) {
+	if len(args) == 0 {
+		return nil, errArguments("len(args) = %d, expect != 0", len(args))
+	}
+	return s.setsMembers(db, args, setUnion)
+}
+
+// SDIFF key [key ...]
+func (s *Store) SDiff(db uint32, args [][]byte) ([][]byte, error) {
+	if len(args) == 0 {
+		return nil, errArguments("len(args) = %d, expect != 0", len(args))
+	}
+	return s.setsMembers(db, args, setDiff)
+}
+
+// SINTERSTORE destination key [key ...]
+func (s *Store) SInterStore(db uint32, args [][]byte) (int64, error) {
+	if len(args) < 2 {
+		return 0, errArguments("len(args) = %d, expect >= 2", len(args))
+	}
+	fw := &Forward{DB: db, Op: "SInterStore", Args: args}
+	return s.setsStore(db, args, setInter, fw)
+}
+
+// SUNIONSTORE destination key [key ...]
+func (s *Store) SUnionStore(db uint32, args [][]byte) (int64, error) {
+	if len(args) < 2 {
+		return 0, errArguments("len(args) = %d, expect >= 2", len(args))
+	}
+	fw := &Forward{DB: db, Op: "SUnionStore", Args: args}
+	return s.setsStore(db, args, setUnion, fw)
+}
+
+// SDIFFSTORE destination key [key ...]
+func (s *Store) SDiffStore(db uint32, args [][]byte) (int64, error) {
+	if len(args) < 2 {
+		return 0, errArguments("len(args) = %d, expect >= 2", len(args))
+	}
+	fw := &Forward{DB: db, Op: "SDiffStore", Args: args}
+	return s.setsStore(db, args, setDiff, fw)
+}
+
+// SMOVE source destination member
+func (s *Store) SMove(db uint32, args [][]byte) (int64, error) {
+	if len(args) != 3 {
+		return 0, errArguments("len(args) = %d, expect = 3", len(args))
+	}
+
+	src := args[0]
+	dst := args[1]
+	member := args[2]
+
+	if err := s.acquire(src, dst); err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer s.release(src, dst)
+
+	o, err := s.loadSetRow(db, src)
+	if err != nil || o == nil {
+		return 0, errors.Trace(err)
+	}
+
+	d, err := s.loadSetRow(db, dst)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	o.Member = member
+	exists, err := o.TestDataValue(s)
+	if err != nil || !exists {
+		return 0, errors.Trace(err)
+	}
+
+	if bytes.Equal(src, dst) {
+		return 1, nil
+	}
+
+	bt := engine.NewBatch()
+	bt.Del(o.DataKey())
+	if o.Size--; o.Size > 0 {
+		bt.Set(o.MetaKey(), o.MetaValue())
+	} else {
+		o.deleteMetaKey(bt)
+	}
+
+	if d == nil {
+		d = newSetRow(db, dst, s.currentRowVersion())
+	}
+	d.Member = member
+	exists, err = d.TestDataValue(s)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if !exists {
+		d.Size++
+		bt.Set(d.DataKey(), d.DataValue())
+		bt.Set(d.MetaKey(), d.MetaValue())
+	}
+	fw := &Forward{DB: db, Op: "SMove", Args: args}
+	return 1, s.commit(bt, fw)
+}
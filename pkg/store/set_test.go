@@ -4,9 +4,11 @@
 package store
 
 import (
+	"sort"
 	"strconv"
 
 	"github.com/reborndb/go/redis/rdb"
+	"github.com/reborndb/qdb/pkg/engine"
 	. "gopkg.in/check.v1"
 )
 
@@ -149,6 +151,42 @@ func (s *testStoreSuite) srem(c *C, db uint32, key string, expect int64, members
 	}
 }
 
+func (s *testStoreSuite) setsop(c *C, f func(uint32, [][]byte) ([][]byte, error), db uint32, keys []string, expect ...string) {
+	args := []interface{}{}
+	for _, k := range keys {
+		args = append(args, k)
+	}
+
+	x, err := f(db, FormatBytes(args...))
+	c.Assert(err, IsNil)
+
+	a := []string{}
+	for _, b := range x {
+		a = append(a, string(b))
+	}
+	sort.Strings(a)
+	sort.Strings(expect)
+	c.Assert(a, DeepEquals, append([]string{}, expect...))
+}
+
+func (s *testStoreSuite) setsstore(c *C, f func(uint32, [][]byte) (int64, error), db uint32, dest string, keys []string, expect ...string) {
+	args := []interface{}{dest}
+	for _, k := range keys {
+		args = append(args, k)
+	}
+
+	x, err := f(db, FormatBytes(args...))
+	c.Assert(err, IsNil)
+	c.Assert(x, Equals, int64(len(expect)))
+	s.smembers(c, db, dest, expect...)
+}
+
+func (s *testStoreSuite) smove(c *C, db uint32, src, dst, member string, expect int64) {
+	x, err := s.s.SMove(db, FormatBytes(src, dst, member))
+	c.Assert(err, IsNil)
+	c.Assert(x, Equals, expect)
+}
+
 func (s *testStoreSuite) TestSRestore(c *C) {
 	s.srestore(c, 0, "set", 100, "hello", "world")
 	s.srestore(c, 0, "set", 0, "hello", "world", "!!")
@@ -227,3 +265,149 @@ func (s *testStoreSuite) TestSRandMember(c *C) {
 	s.scard(c, 0, "set", 0)
 	s.checkEmpty(c)
 }
+
+func (s *testStoreSuite) TestSScan(c *C) {
+	c.Assert(s.scanall(c, s.s.SScan, 0, "set", 1), HasLen, 0)
+	s.sadd(c, 0, "set", 4, "a", "b", "c", "d")
+
+	for _, count := range []int64{1, 3, 10} {
+		a := s.scanall(c, s.s.SScan, 0, "set", count)
+		c.Assert(a, DeepEquals, []string{"a", "b", "c", "d"})
+	}
+	c.Assert(s.scanall(c, s.s.SScan, 0, "set", 2, "match", "[^c]"), DeepEquals, []string{"a", "b", "d"})
+
+	s.sdel(c, 0, "set", 1)
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestSInter(c *C) {
+	s.sadd(c, 0, "set1", 4, "a", "b", "c", "d")
+	s.sadd(c, 0, "set2", 3, "c", "d", "e")
+	s.sadd(c, 0, "set3", 4, "a", "c", "d", "long member")
+
+	s.setsop(c, s.s.SInter, 0, []string{"set1"}, "a", "b", "c", "d")
+	s.setsop(c, s.s.SInter, 0, []string{"set1", "set2"}, "c", "d")
+	s.setsop(c, s.s.SInter, 0, []string{"set1", "set2", "set3"}, "c", "d")
+	s.setsop(c, s.s.SInter, 0, []string{"set1", "set1"}, "a", "b", "c", "d")
+	s.setsop(c, s.s.SInter, 0, []string{"set1", "none"})
+
+	s.setsstore(c, s.s.SInterStore, 0, "dest", []string{"set1", "set3"}, "a", "c", "d")
+	s.setsstore(c, s.s.SInterStore, 0, "dest", []string{"dest", "set2"}, "c", "d")
+	s.setsstore(c, s.s.SInterStore, 0, "dest", []string{"set1", "none"})
+
+	s.kpexpire(c, 0, "set2", 100, 1)
+	sleepms(200)
+	s.setsop(c, s.s.SInter, 0, []string{"set1", "set2"})
+
+	s.xset(c, 0, "string", "value")
+	_, err := s.s.SInter(0, FormatBytes("set1", "string"))
+	c.Assert(err, NotNil)
+
+	s.sdel(c, 0, "set1", 1)
+	s.sdel(c, 0, "set2", 0)
+	s.sdel(c, 0, "set3", 1)
+	s.kdel(c, 0, 1, "string")
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestSUnion(c *C) {
+	s.sadd(c, 0, "set1", 3, "a", "b", "c")
+	s.sadd(c, 0, "set2", 3, "c", "d", "long member")
+
+	s.setsop(c, s.s.SUnion, 0, []string{"set1"}, "a", "b", "c")
+	s.setsop(c, s.s.SUnion, 0, []string{"set1", "set2", "none"}, "a", "b", "c", "d", "long member")
+	s.setsop(c, s.s.SUnion, 0, []string{"none"})
+
+	s.setsstore(c, s.s.SUnionStore, 0, "set1", []string{"set1", "set2"}, "a", "b", "c", "d", "long member")
+	s.setsstore(c, s.s.SUnionStore, 0, "dest", []string{"none"})
+
+	s.xset(c, 0, "dest", "value")
+	s.setsstore(c, s.s.SUnionStore, 0, "dest", []string{"set2"}, "c", "d", "long member")
+
+	s.sdel(c, 0, "set1", 1)
+	s.sdel(c, 0, "set2", 1)
+	s.sdel(c, 0, "dest", 1)
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestSDiff(c *C) {
+	s.sadd(c, 0, "set1", 5, "a", "b", "c", "d", "long member")
+	s.sadd(c, 0, "set2", 2, "c", "e")
+	s.sadd(c, 0, "set3", 2, "a", "long member")
+
+	s.setsop(c, s.s.SDiff, 0, []string{"set1"}, "a", "b", "c", "d", "long member")
+	s.setsop(c, s.s.SDiff, 0, []string{"set1", "set2"}, "a", "b", "d", "long member")
+	s.setsop(c, s.s.SDiff, 0, []string{"set1", "set2", "set3", "none"}, "b", "d")
+	s.setsop(c, s.s.SDiff, 0, []string{"none", "set1"})
+	s.setsop(c, s.s.SDiff, 0, []string{"set1", "set1"})
+
+	s.setsstore(c, s.s.SDiffStore, 0, "dest", []string{"set1", "set3"}, "b", "c", "d")
+	s.setsstore(c, s.s.SDiffStore, 0, "dest", []string{"set2", "dest"}, "e")
+	s.setsstore(c, s.s.SDiffStore, 0, "dest", []string{"set2", "set2"})
+
+	s.sdel(c, 0, "set1", 1)
+	s.sdel(c, 0, "set2", 1)
+	s.sdel(c, 0, "set3", 1)
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestSetsStoreInBatches(c *C) {
+	n := setsStoreBatchSize*2 + 10
+	var members []string
+	for i := 0; i < n; i++ {
+		members = append(members, strconv.Itoa(i))
+	}
+	s.sadd(c, 0, "set1", int64(n), members...)
+	s.sadd(c, 0, "set2", 1, "x")
+
+	s.setsstore(c, s.s.SUnionStore, 0, "dest", []string{"set1", "set2"}, append(members, "x")...)
+	s.setsstore(c, s.s.SDiffStore, 0, "dest", []string{"dest", "set2"}, members...)
+	s.setsstore(c, s.s.SInterStore, 0, "set1", []string{"dest", "set1"}, members...)
+	c.Assert(s.pendingLen(c), Equals, 0)
+
+	// pending row left by a crash becomes garbage once loaded
+	o := newSetRow(0, []byte("set3"), s.s.currentRowVersion())
+	bt := engine.NewBatch()
+	bt.Set(encodePendingKey(o.DataKeyPrefix()), []byte{byte(SetCode)})
+	o.Member = []byte("a")
+	bt.Set(o.DataKey(), o.DataValue())
+	c.Assert(s.s.commit(bt, nil), IsNil)
+	c.Assert(s.s.loadRowVersion(), IsNil)
+	c.Assert(s.pendingLen(c), Equals, 0)
+	c.Assert(s.garbageLen(c), Equals, 1)
+	c.Assert(s.s.currentRowVersion() > o.Version, Equals, true)
+	s.sweepAll(c)
+
+	s.kdel(c, 0, 3, "set1", "set2", "dest")
+	s.checkEmpty(c)
+	s.checkCompact(c)
+}
+
+func (s *testStoreSuite) TestSMove(c *C) {
+	s.sadd(c, 0, "set1", 2, "a", "b")
+	s.sadd(c, 0, "set2", 1, "b")
+
+	s.smove(c, 0, "set1", "set2", "x", 0)
+	s.smove(c, 0, "none", "set2", "a", 0)
+	s.smove(c, 0, "set1", "set1", "a", 1)
+	s.smembers(c, 0, "set1", "a", "b")
+
+	s.smove(c, 0, "set1", "set2", "a", 1)
+	s.smembers(c, 0, "set1", "b")
+	s.smembers(c, 0, "set2", "a", "b")
+	s.smove(c, 0, "set1", "set2", "b", 1)
+	s.smembers(c, 0, "set1")
+	s.smembers(c, 0, "set2", "a", "b")
+	s.smove(c, 0, "set2", "set3", "a", 1)
+	s.smembers(c, 0, "set3", "a")
+
+	s.xset(c, 0, "string", "value")
+	_, err := s.s.SMove(0, FormatBytes("set2", "string", "b"))
+	c.Assert(err, NotNil)
+	s.smembers(c, 0, "set2", "b")
+
+	s.sdel(c, 0, "set2", 1)
+	s.sdel(c, 0, "set3", 1)
+	s.kdel(c, 0, 1, "string")
+	s.checkEmpty(c)
+}
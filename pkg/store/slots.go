@@ -121,10 +121,15 @@ func (s *Store) SlotsRestore(db uint32, args [][]byte) error {
 		}
 	}
 
-	if err := s.acquire(); err != nil {
+	keys := make([][]byte, len(objs))
+	for i, e := range objs {
+		keys[i] = e.Key
+	}
+
+	if err := s.acquire(keys...); err != nil {
 		return errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(keys...)
 
 	ms := &markSet{}
 	bt := engine.NewBatch()
@@ -175,10 +180,10 @@ func (s *Store) SlotsMgrtSlot(db uint32, args [][]byte) (int64, error) {
 	}
 	addr := fmt.Sprintf("%s:%d", host, port)
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquireSlot(uint32(slot)); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseSlot(uint32(slot))
 
 	log.Debugf("migrate slot, addr = %s, timeout = %d, db = %d, slot = %d", addr, timeout, db, slot)
 
@@ -218,10 +223,10 @@ func (s *Store) SlotsMgrtTagSlot(db uint32, args [][]byte) (int64, error) {
 	}
 	addr := fmt.Sprintf("%s:%d", host, port)
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquireSlot(uint32(slot)); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseSlot(uint32(slot))
 
 	log.Debugf("migrate slot with tag, addr = %s, timeout = %d, db = %d, slot = %d", addr, timeout, db, slot)
 
@@ -260,10 +265,10 @@ func (s *Store) SlotsMgrtOne(db uint32, args [][]byte) (int64, error) {
 	}
 	addr := fmt.Sprintf("%s:%d", host, port)
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	log.Debugf("migrate one, addr = %s, timeout = %d, db = %d, key = %v", addr, timeout, db, key)
 
@@ -293,10 +298,12 @@ func (s *Store) SlotsMgrtTagOne(db uint32, args [][]byte) (int64, error) {
 	}
 	addr := fmt.Sprintf("%s:%d", host, port)
 
-	if err := s.acquire(); err != nil {
+	// keys with the same tag are in the same slot
+	_, slot := HashKeyToSlot(key)
+	if err := s.acquireSlot(slot); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseSlot(slot)
 
 	log.Debugf("migrate one with tag, addr = %s, timeout = %d, db = %d, key = %v", addr, timeout, db, key)
 
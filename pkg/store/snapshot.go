@@ -4,6 +4,7 @@
 package store
 
 import (
+	"bytes"
 	"container/list"
 	"sync"
 	"time"
@@ -116,6 +117,19 @@ func (s *snapshotReader) cleanup() {
 	}
 }
 
+type storeKey struct {
+	db  uint32
+	key []byte
+}
+
+// read only view of store, see Store.acquireReader
+type storeView struct {
+	*snapshotReader
+
+	// expired keys can't be deleted by the view, they are deleted after reading
+	expired []storeKey
+}
+
 func (s *StoreSnapshot) LoadObjCron(wait time.Duration, ncpu, step int) ([]*rdb.ObjEntry, bool, error) {
 	if err := s.acquire(); err != nil {
 		return nil, false, errors.Trace(err)
@@ -171,6 +185,116 @@ func (s *StoreSnapshot) LoadObjCron(wait time.Duration, ncpu, step int) ([]*rdb.
 	return rets.objs, rets.more, rets.err
 }
 
+// LoadObjSlots loads all objects by ncpu workers, every worker scans a range of
+// slots in all databases, objects are passed to f in batches of at most step
+// entries, calls of f are serialized.
+func (s *StoreSnapshot) LoadObjSlots(ncpu, step int, f func(objs []*rdb.ObjEntry) error) error {
+	return s.LoadObjSlotsMatch(ncpu, step, nil, f)
+}
+
+// LoadObjSlotsMatch is like LoadObjSlots, but only loads objects in slots of databases
+// accepted by match, nil match accepts all.
+func (s *StoreSnapshot) LoadObjSlotsMatch(ncpu, step int, match func(db, slot uint32) bool, f func(objs []*rdb.ObjEntry) error) error {
+	if err := s.acquire(); err != nil {
+		return errors.Trace(err)
+	}
+	defer s.release()
+
+	if ncpu <= 0 || step <= 0 {
+		return errors.Errorf("ncpu = %d, step = %d", ncpu, step)
+	}
+	if ncpu > MaxSlotNum {
+		ncpu = MaxSlotNum
+	}
+
+	rets := &struct {
+		sync.Mutex
+		err error
+	}{}
+	emit := func(objs []*rdb.ObjEntry) error {
+		rets.Lock()
+		defer rets.Unlock()
+		if rets.err == nil {
+			rets.err = errors.Trace(f(objs))
+		}
+		return rets.err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < ncpu; i++ {
+		wg.Add(1)
+		from, to := uint32(i*MaxSlotNum/ncpu), uint32((i+1)*MaxSlotNum/ncpu)
+		go func() {
+			defer wg.Done()
+			err := s.loadObjSlots(from, to, step, match, emit)
+			rets.Lock()
+			if rets.err == nil && err != nil {
+				rets.err = errors.Trace(err)
+			}
+			rets.Unlock()
+		}()
+	}
+	wg.Wait()
+	return rets.err
+}
+
+func (s *StoreSnapshot) loadObjSlots(from, to uint32, step int, match func(db, slot uint32) bool, emit func(objs []*rdb.ObjEntry) error) error {
+	r := s.getReader()
+	defer s.putReader(r)
+
+	it := r.getIterator()
+	defer r.putIterator(it)
+
+	var objs []*rdb.ObjEntry
+	pos := []byte{MetaCode}
+	for pos != nil {
+		// meta keys are ordered by db, then by slot
+		if it.SeekTo(pos); !it.Valid() || it.Key()[0] != MetaCode {
+			break
+		}
+		db, _, err := DecodeMetaKey(it.Key())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for slot := from; slot < to; slot++ {
+			if match != nil && !match(db, slot) {
+				continue
+			}
+			pfx := EncodeMetaKeyPrefixSlot(db, slot)
+			for it.SeekTo(pfx); it.Valid() && bytes.HasPrefix(it.Key(), pfx); it.Next() {
+				_, key, err := DecodeMetaKey(it.Key())
+				if err != nil {
+					return errors.Trace(err)
+				}
+				_, obj, err := loadObjEntry(r, db, key)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				if obj == nil {
+					continue
+				}
+				if objs = append(objs, obj); len(objs) >= step {
+					if err := emit(objs); err != nil {
+						return err
+					}
+					objs = nil
+				}
+			}
+			if err := it.Error(); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		pos = prefixLimit(EncodeMetaKeyPrefixDB(db))
+	}
+	if err := it.Error(); err != nil {
+		return errors.Trace(err)
+	}
+	if len(objs) != 0 {
+		return emit(objs)
+	}
+	return nil
+}
+
 func (s *StoreSnapshot) scanMetaKey() (metaKey []byte, err error) {
 	s.cursor.Lock()
 	defer s.cursor.Unlock()
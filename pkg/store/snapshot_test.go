@@ -81,3 +81,86 @@ func (s *testStoreSuite) TestSnapshot(c *C) {
 	s.s.ReleaseSnapshot(ss)
 	s.checkEmpty(c)
 }
+
+func (s *testStoreSuite) TestSnapshotLoadObjSlots(c *C) {
+	dbs := []uint32{0, 1, 200}
+	for _, db := range dbs {
+		for i := 0; i < 100; i++ {
+			s.xset(c, db, fmt.Sprintf("key_%d", i), fmt.Sprintf("val_%d_%d", db, i))
+		}
+	}
+
+	ss, err := s.s.NewSnapshot()
+	c.Assert(err, IsNil)
+
+	// f is called by workers, so errors are returned instead of asserted
+	m := make(map[string]string)
+	err = ss.LoadObjSlots(3, 7, func(objs []*rdb.ObjEntry) error {
+		if len(objs) > 7 {
+			return fmt.Errorf("batch size = %d", len(objs))
+		}
+		for _, obj := range objs {
+			k := fmt.Sprintf("%d/%s", obj.DB, obj.Key)
+			if _, ok := m[k]; ok {
+				return fmt.Errorf("duplicated key %s", k)
+			}
+			m[k] = string(obj.Value.(rdb.String))
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	s.s.ReleaseSnapshot(ss)
+
+	c.Assert(len(m), Equals, len(dbs)*100)
+	for _, db := range dbs {
+		keys := make([]string, 100)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key_%d", i)
+			c.Assert(m[fmt.Sprintf("%d/%s", db, keys[i])], Equals, fmt.Sprintf("val_%d_%d", db, i))
+		}
+		s.kdel(c, db, 100, keys...)
+	}
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestSnapshotLoadObjSlotsMatch(c *C) {
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key_%d", i)
+		for _, db := range []uint32{0, 1} {
+			s.xset(c, db, keys[i], keys[i])
+		}
+	}
+
+	ss, err := s.s.NewSnapshot()
+	c.Assert(err, IsNil)
+
+	match := func(db, slot uint32) bool {
+		return db == 1 && slot < MaxSlotNum/2
+	}
+	m := make(map[string]uint32)
+	err = ss.LoadObjSlotsMatch(3, 7, match, func(objs []*rdb.ObjEntry) error {
+		for _, obj := range objs {
+			m[string(obj.Key)] = obj.DB
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	s.s.ReleaseSnapshot(ss)
+
+	n := 0
+	for _, key := range keys {
+		if _, slot := HashKeyToSlot([]byte(key)); slot < MaxSlotNum/2 {
+			c.Assert(m[key], Equals, uint32(1))
+			n++
+		}
+	}
+	c.Assert(len(m), Equals, n)
+
+	for _, db := range []uint32{0, 1} {
+		s.kdel(c, db, 100, keys...)
+	}
+	s.checkEmpty(c)
+}
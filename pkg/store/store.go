@@ -5,6 +5,7 @@ package store
 
 import (
 	"container/list"
+	"sort"
 	"sync"
 
 	"github.com/juju/errors"
@@ -18,39 +19,161 @@ var (
 )
 
 type Store struct {
-	mu sync.Mutex
+	*storeCore
+
+	// not nil if store is bound to a transaction which holds the lock, see Multi
+	tx *storeTx
+
+	// not nil if store is a read only view on an engine snapshot, see acquireReader
+	view *storeView
+}
+
+type storeCore struct {
+	// held shared by commands on keys, exclusively by commands on the whole store
+	mu sync.RWMutex
 	db engine.Database
 
+	// keys are locked by their slots, see acquire
+	stripes [MaxSlotNum]sync.Mutex
+
+	// commits are serialized, so forwards are handled in the same order as commits
+	commitMu sync.Mutex
+
 	splist list.List
+
+	itmu   sync.Mutex
 	itlist list.List
 	serial uint64
 
 	preCommitHandlers  []ForwardHandler
 	postCommitHandlers []ForwardHandler
 
+	// replication state saved with commits, see SetReplState
+	replState []byte
+	// replication state may exist in db
+	replSaved bool
+
 	deleteIfExpired atomic2.Int64
+	lazyFree        atomic2.Int64
+
+	// version of new rows, it is increased once a row of the current version is unlinked,
+	// so a new row never shares data keys with the unlinked rows of the same key
+	rowVersion atomic2.Int64
+
+	watches map[string]*keyWatch
 }
 
 func New(db engine.Database) *Store {
-	s := &Store{db: db}
+	s := &Store{storeCore: &storeCore{db: db, replSaved: true}}
 
 	s.preCommitHandlers = make([]ForwardHandler, 0)
 	s.postCommitHandlers = make([]ForwardHandler, 0)
+	s.watches = make(map[string]*keyWatch)
 
 	s.deleteIfExpired.Set(1)
 
+	if err := s.loadRowVersion(); err != nil {
+		log.Errorf("store load row version failed - %s", err)
+	}
+	if err := s.buildExpireIndex(); err != nil {
+		log.Errorf("store build expire index failed - %s", err)
+	}
+
+	go s.expireLoop()
+	go s.sweepLoop()
+
 	return s
 }
 
+// Acquire locks the whole store
 func (s *Store) Acquire() error {
-	return s.acquire()
+	return s.acquireAll()
 }
 
 func (s *Store) Release() {
+	s.releaseAll()
+}
+
+// returns sorted and deduplicated stripes of keys, keys with the same tag share one stripe
+func keyStripes(keys [][]byte) []uint32 {
+	stripes := make([]uint32, 0, len(keys))
+	for _, key := range keys {
+		_, slot := HashKeyToSlot(key)
+		stripes = append(stripes, slot)
+	}
+	if len(stripes) > 1 {
+		sort.Sort(uint32Slice(stripes))
+		n := 1
+		for i := 1; i < len(stripes); i++ {
+			if stripes[i] != stripes[n-1] {
+				stripes[n] = stripes[i]
+				n++
+			}
+		}
+		stripes = stripes[:n]
+	}
+	return stripes
+}
+
+type uint32Slice []uint32
+
+func (p uint32Slice) Len() int           { return len(p) }
+func (p uint32Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p uint32Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// acquire locks the keys, commands on keys of other stripes can run at the same time.
+// Stripes are always locked in ascending order, so multi-keys commands can't deadlock.
+// Without keys, it only keeps the store from being closed or reset, e.g. scanning all keys.
+func (s *Store) acquire(keys ...[]byte) error {
+	if s.tx != nil {
+		return nil
+	}
+	s.mu.RLock()
+	if s.db == nil {
+		s.mu.RUnlock()
+		return errors.Trace(ErrClosed)
+	}
+	for _, i := range keyStripes(keys) {
+		s.stripes[i].Lock()
+	}
+	return nil
+}
+
+// release must be called with the same keys of acquire
+func (s *Store) release(keys ...[]byte) {
+	if s.tx != nil {
+		return
+	}
+	stripes := keyStripes(keys)
+	for i := len(stripes) - 1; i >= 0; i-- {
+		s.stripes[stripes[i]].Unlock()
+	}
+	s.mu.RUnlock()
+}
+
+// lock all keys under slot, used by slot migration
+func (s *Store) acquireSlot(slot uint32) error {
+	if err := s.acquire(); err != nil {
+		return errors.Trace(err)
+	}
+	if s.tx == nil {
+		s.stripes[slot].Lock()
+	}
+	return nil
+}
+
+func (s *Store) releaseSlot(slot uint32) {
+	if s.tx == nil {
+		s.stripes[slot].Unlock()
+	}
 	s.release()
 }
 
-func (s *Store) acquire() error {
+// acquireAll locks the whole store exclusively
+func (s *Store) acquireAll() error {
+	if s.tx != nil {
+		return nil
+	}
 	s.mu.Lock()
 	if s.db != nil {
 		return nil
@@ -59,37 +182,94 @@ func (s *Store) acquire() error {
 	return errors.Trace(ErrClosed)
 }
 
-func (s *Store) release() {
+func (s *Store) releaseAll() {
+	if s.tx != nil {
+		return
+	}
 	s.mu.Unlock()
 }
 
+// acquireReader returns a read only view of the store on a new engine snapshot.
+// Keys are not locked, so read commands don't block writers or each other.
+// Store bound to a transaction or a view is returned as it is.
+func (s *Store) acquireReader() (*Store, error) {
+	if s.tx != nil || s.view != nil {
+		return s, nil
+	}
+	if err := s.acquire(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	view := &storeView{snapshotReader: &snapshotReader{sp: s.db.NewSnapshot()}}
+	return &Store{storeCore: s.storeCore, view: view}, nil
+}
+
+// releaseReader releases the view returned by acquireReader.
+// Expired keys found by the view are deleted here with keys locked.
+func (s *Store) releaseReader(rd *Store) {
+	if rd == s {
+		return
+	}
+	rd.view.cleanup()
+	rd.view.sp.Close()
+	s.release()
+
+	for _, k := range rd.view.expired {
+		if err := s.deleteIfExpiredKey(k.db, k.key); err != nil {
+			log.Warningf("delete expired key failed - %s", err)
+		}
+	}
+}
+
 func (s *Store) commit(bt *engine.Batch, fw *Forward) error {
 	if bt.Len() == 0 {
 		return nil
 	}
 
-	s.travelPreCommitHandlers(fw)
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	// nil forward means local only changes, e.g. cleaning expire index
+	if fw != nil {
+		if s.tx != nil {
+			s.tx.beginForward(s, fw)
+		}
+		s.travelPreCommitHandlers(fw)
+		s.attachReplState(bt)
+	}
 
 	if err := s.db.Commit(bt); err != nil {
 		log.Warningf("store commit failed - %s", err)
 		return errors.Trace(err)
 	}
+	s.itmu.Lock()
 	for i := s.itlist.Len(); i != 0; i-- {
 		v := s.itlist.Remove(s.itlist.Front()).(*storeIterator)
 		v.Close()
 	}
 	s.serial++
+	s.itmu.Unlock()
 
-	s.travelPostCommitHandlers(fw)
+	if fw != nil {
+		s.touchWatchedKeys(bt)
+		s.travelPostCommitHandlers(fw)
+	}
 
 	return nil
 }
 
 func (s *Store) getRowValue(key []byte) ([]byte, error) {
+	if s.view != nil {
+		return s.view.getRowValue(key)
+	}
 	return s.db.Get(key)
 }
 
 func (s *Store) getIterator() (it *storeIterator) {
+	if s.view != nil {
+		return s.view.getIterator()
+	}
+	s.itmu.Lock()
+	defer s.itmu.Unlock()
 	if e := s.itlist.Front(); e != nil {
 		return s.itlist.Remove(e).(*storeIterator)
 	}
@@ -100,6 +280,12 @@ func (s *Store) getIterator() (it *storeIterator) {
 }
 
 func (s *Store) putIterator(it *storeIterator) {
+	if s.view != nil {
+		s.view.putIterator(it)
+		return
+	}
+	s.itmu.Lock()
+	defer s.itmu.Unlock()
 	if it.serial == s.serial && it.Error() == nil {
 		s.itlist.PushFront(it)
 	} else {
@@ -108,10 +294,10 @@ func (s *Store) putIterator(it *storeIterator) {
 }
 
 func (s *Store) Close() {
-	if err := s.acquire(); err != nil {
+	if err := s.acquireAll(); err != nil {
 		return
 	}
-	defer s.release()
+	defer s.releaseAll()
 	log.Infof("store is closing ...")
 	for i := s.splist.Len(); i != 0; i-- {
 		v := s.splist.Remove(s.splist.Front()).(*StoreSnapshot)
@@ -134,10 +320,10 @@ func (s *Store) NewSnapshot() (*StoreSnapshot, error) {
 
 // New a snapshot and then call f if not nil
 func (s *Store) NewSnapshotFunc(f func()) (*StoreSnapshot, error) {
-	if err := s.acquire(); err != nil {
+	if err := s.acquireAll(); err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseAll()
 	sp := &StoreSnapshot{sp: s.db.NewSnapshot()}
 	s.splist.PushBack(sp)
 	log.Infof("store create new snapshot, address = %p", sp)
@@ -149,11 +335,33 @@ func (s *Store) NewSnapshotFunc(f func()) (*StoreSnapshot, error) {
 	return sp, nil
 }
 
+// Save a checkpoint of database files into dir, f is called if not nil
+// after the state of the checkpoint is pinned and before any other writes
+func (s *Store) Checkpoint(dir string, f func()) error {
+	if err := s.acquireAll(); err != nil {
+		return errors.Trace(err)
+	}
+	cp, err := s.db.NewCheckpoint()
+	if err != nil {
+		s.releaseAll()
+		return errors.Trace(err)
+	}
+	log.Infof("store create new checkpoint, dir = %s", dir)
+
+	if f != nil {
+		f()
+	}
+	s.releaseAll()
+
+	defer cp.Close()
+	return errors.Trace(cp.Save(dir))
+}
+
 func (s *Store) ReleaseSnapshot(sp *StoreSnapshot) {
-	if err := s.acquire(); err != nil {
+	if err := s.acquireAll(); err != nil {
 		return
 	}
-	defer s.release()
+	defer s.releaseAll()
 	log.Infof("store release snapshot, address = %p", sp)
 	for i := s.splist.Len(); i != 0; i-- {
 		v := s.splist.Remove(s.splist.Front()).(*StoreSnapshot)
@@ -165,10 +373,23 @@ func (s *Store) ReleaseSnapshot(sp *StoreSnapshot) {
 }
 
 func (s *Store) Reset() error {
-	if err := s.acquire(); err != nil {
+	return s.reset(func(db engine.Database) error {
+		return db.Clear()
+	})
+}
+
+// RestoreCheckpoint replaces all data by database files saved by a checkpoint in dir
+func (s *Store) RestoreCheckpoint(dir string) error {
+	return s.reset(func(db engine.Database) error {
+		return db.Restore(dir)
+	})
+}
+
+func (s *Store) reset(f func(db engine.Database) error) error {
+	if err := s.acquireAll(); err != nil {
 		return errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseAll()
 	log.Infof("store is reseting...")
 	for i := s.splist.Len(); i != 0; i-- {
 		v := s.splist.Remove(s.splist.Front()).(*StoreSnapshot)
@@ -178,18 +399,38 @@ func (s *Store) Reset() error {
 		v := s.itlist.Remove(s.itlist.Front()).(*storeIterator)
 		v.Close()
 	}
-	if err := s.db.Clear(); err != nil {
+	if err := f(s.db); err != nil {
 		s.db.Close()
 		s.db = nil
 		log.Errorf("store reset failed - %s", err)
 		return errors.Trace(err)
 	} else {
+		if err := s.loadRowVersion(); err != nil {
+			log.Errorf("store load row version failed - %s", err)
+		}
+		if err := s.buildExpireIndex(); err != nil {
+			log.Errorf("store build expire index failed - %s", err)
+		}
 		s.serial++
+		s.replSaved = true
+		for _, w := range s.watches {
+			w.version = s.serial
+		}
 		log.Infof("store is reset")
 		return nil
 	}
 }
 
+// EngineName returns name of the database engine
+func (s *Store) EngineName() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.db == nil {
+		return ""
+	}
+	return s.db.Name()
+}
+
 // Caveat: if you set false, we will not delete expired automatically except
 // you call del command explicitly.
 // You may get a expired data, so any write operations may be incorrect.
@@ -4,9 +4,12 @@
 package store
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -45,9 +48,11 @@ func (s *testStoreSuite) checkEmpty(c *C) {
 	it := s.s.getIterator()
 	defer s.s.putIterator(it)
 
-	it.SeekToFirst()
+	// marker of expire index is kept without keys
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		c.Assert(bytes.Equal(it.Key(), expireIndexedKey), Equals, true, Commentf("key = %q", it.Key()))
+	}
 	c.Assert(it.Error(), IsNil)
-	c.Assert(it.Valid(), Equals, false)
 }
 
 func testCreateStore(c *C) *Store {
@@ -66,6 +71,113 @@ func testCreateStore(c *C) *Store {
 	return s
 }
 
+func (s *testStoreSuite) TestKeyStripes(c *C) {
+	_, sa := HashKeyToSlot([]byte("a"))
+	_, sb := HashKeyToSlot([]byte("b"))
+	if sa > sb {
+		sa, sb = sb, sa
+	}
+	c.Assert(keyStripes(FormatBytes("b", "a", "b")), DeepEquals, []uint32{sa, sb})
+	c.Assert(keyStripes(FormatBytes("{a}1", "{a}2")), DeepEquals, []uint32{keyStripes(FormatBytes("a"))[0]})
+	c.Assert(keyStripes(nil), HasLen, 0)
+}
+
+func (s *testStoreSuite) TestKeyLock(c *C) {
+	k1, k2 := []byte("lock_key1"), []byte("lock_key2")
+	c.Assert(keyStripes([][]byte{k1}), Not(DeepEquals), keyStripes([][]byte{k2}))
+
+	err := s.s.acquire(k1)
+	c.Assert(err, IsNil)
+
+	// other keys are not blocked
+	err = s.s.Set(0, FormatBytes(k2, "1"))
+	c.Assert(err, IsNil)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.s.Set(0, FormatBytes(k1, "1"))
+	}()
+	select {
+	case <-done:
+		c.Fatal("locked key is modified")
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.s.release(k1)
+	c.Assert(<-done, IsNil)
+
+	s.kdel(c, 0, 2, string(k1), string(k2))
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestReadView(c *C) {
+	k := []byte("view_key")
+	s.xset(c, 0, string(k), "1")
+
+	// reads are not blocked by locked keys
+	err := s.s.acquire(k)
+	c.Assert(err, IsNil)
+	v, err := s.s.Get(0, FormatBytes(k))
+	c.Assert(err, IsNil)
+	c.Assert(string(v), Equals, "1")
+	s.s.release(k)
+
+	// view keeps reading the snapshot
+	rd, err := s.s.acquireReader()
+	c.Assert(err, IsNil)
+	s.xset(c, 0, string(k), "2")
+	v, err = rd.Get(0, FormatBytes(k))
+	c.Assert(err, IsNil)
+	c.Assert(string(v), Equals, "1")
+	s.s.releaseReader(rd)
+	s.xget(c, 0, string(k), "2")
+
+	// expired key found by reading is deleted
+	err = s.s.PSetEX(0, FormatBytes(k, 10, "3"))
+	c.Assert(err, IsNil)
+	time.Sleep(20 * time.Millisecond)
+	s.xget(c, 0, string(k), "")
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestConcurrentCommands(c *C) {
+	keys := []string{"c1", "c2", "c3", "c4", "c5", "c6"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				// multi-keys commands lock keys in different order
+				k1, k2 := keys[(i+j)%len(keys)], keys[(i+j+1)%len(keys)]
+				if i%2 == 0 {
+					k1, k2 = k2, k1
+				}
+				err := s.s.MSet(0, FormatBytes(k1+"_m", i, k2+"_m", j))
+				c.Check(err, IsNil)
+				_, err = s.s.Incr(0, FormatBytes(k1))
+				c.Check(err, IsNil)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var sum int64
+	for _, k := range keys {
+		v, err := s.s.Get(0, FormatBytes(k))
+		c.Assert(err, IsNil)
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		c.Assert(err, IsNil)
+		sum += n
+	}
+	c.Assert(sum, Equals, int64(800))
+
+	for _, k := range keys {
+		s.kdel(c, 0, 2, k, k+"_m")
+	}
+	s.checkEmpty(c)
+}
+
 func init() {
 	log.SetLevel(log.LOG_LEVEL_ERROR)
 }
@@ -74,7 +74,7 @@ type stringRow struct {
 
 func newStringRow(db uint32, key []byte) *stringRow {
 	o := &stringRow{}
-	o.lazyInit(db, key, newStoreRowHelper(db, key, StringCode))
+	o.lazyInit(db, key, newStoreRowHelper(db, key, StringCode, 0))
 	return o
 }
 
@@ -87,7 +87,7 @@ func (o *stringRow) lazyInit(db uint32, key []byte, h *storeRowHelper) {
 
 func (o *stringRow) deleteObject(s *Store, bt *engine.Batch) error {
 	bt.Del(o.DataKey())
-	bt.Del(o.MetaKey())
+	o.deleteMetaKey(bt)
 	return nil
 }
 
@@ -134,16 +134,17 @@ func (s *Store) Get(db uint32, args [][]byte) ([]byte, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadStringRow(db, key)
+	o, err := rd.loadStringRow(db, key)
 	if err != nil || o == nil {
 		return nil, errors.Trace(err)
 	} else {
-		_, err := o.LoadDataValue(s)
+		_, err := o.LoadDataValue(rd)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -161,10 +162,10 @@ func (s *Store) Append(db uint32, args [][]byte) (int64, error) {
 	key := args[0]
 	value := args[1]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadStringRow(db, key)
 	if err != nil {
@@ -249,10 +250,10 @@ func (s *Store) Set(db uint32, args [][]byte) error {
 		}
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	bt := engine.NewBatch()
 
@@ -272,15 +273,18 @@ func (s *Store) Set(db uint32, args [][]byte) error {
 		// if we are string type, we will overwrite it directly
 		// if not, we may delete it first
 		if o != nil && o.Code() != StringCode {
-			if err := o.deleteObject(s, bt); err != nil {
+			if err := s.freeObject(o, bt, s.needLazyFree()); err != nil {
 				return errors.Trace(err)
 			}
+		} else if o != nil {
+			o.delExpireIndex(bt)
 		}
 	}
 
 	no := newStringRow(db, key)
 	no.Value = value
 	no.ExpireAt = expireat
+	no.setExpireIndex(bt)
 	bt.Set(no.DataKey(), no.DataValue())
 	bt.Set(no.MetaKey(), no.MetaValue())
 
@@ -346,10 +350,10 @@ func (s *Store) GetSet(db uint32, args [][]byte) ([]byte, error) {
 	key := args[0]
 	value := args[1]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadStringRow(db, key)
 	if err != nil {
@@ -364,6 +368,7 @@ func (s *Store) GetSet(db uint32, args [][]byte) ([]byte, error) {
 		}
 
 		if o.ExpireAt != 0 {
+			o.delExpireIndex(bt)
 			o.ExpireAt = 0
 			bt.Set(o.MetaKey(), o.MetaValue())
 		}
@@ -386,6 +391,7 @@ func (s *Store) incrInt(db uint32, key []byte, delta int64) (int64, error) {
 	}
 
 	bt := engine.NewBatch()
+	value := delta
 	if o != nil {
 		_, err := o.LoadDataValue(s)
 		if err != nil {
@@ -395,17 +401,18 @@ func (s *Store) incrInt(db uint32, key []byte, delta int64) (int64, error) {
 		if err != nil {
 			return 0, errors.Trace(err)
 		}
-		delta += v
+		value += v
 	} else {
 		o = newStringRow(db, key)
 		bt.Set(o.MetaKey(), o.MetaValue())
 	}
 
-	o.Value = FormatInt(delta)
+	o.Value = FormatInt(value)
 	bt.Set(o.DataKey(), o.DataValue())
 
+	// forward the increment, not the result
 	fw := &Forward{DB: db, Op: "IncrBy", Args: [][]byte{key, FormatInt(delta)}}
-	return delta, s.commit(bt, fw)
+	return value, s.commit(bt, fw)
 }
 
 func (s *Store) incrFloat(db uint32, key []byte, delta float64) (float64, error) {
@@ -415,6 +422,7 @@ func (s *Store) incrFloat(db uint32, key []byte, delta float64) (float64, error)
 	}
 
 	bt := engine.NewBatch()
+	value := delta
 	if o != nil {
 		_, err := o.LoadDataValue(s)
 		if err != nil {
@@ -424,21 +432,22 @@ func (s *Store) incrFloat(db uint32, key []byte, delta float64) (float64, error)
 		if err != nil {
 			return 0, errors.Trace(err)
 		}
-		delta += v
+		value += v
 	} else {
 		o = newStringRow(db, key)
 		bt.Set(o.MetaKey(), o.MetaValue())
 	}
 
-	if math.IsNaN(delta) || math.IsInf(delta, 0) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
 		return 0, errors.New("increment would produce NaN or Infinity")
 	}
 
-	o.Value = FormatFloat(delta)
+	o.Value = FormatFloat(value)
 	bt.Set(o.DataKey(), o.DataValue())
 
+	// forward the increment, not the result
 	fw := &Forward{DB: db, Op: "IncrByFloat", Args: [][]byte{key, FormatFloat(delta)}}
-	return delta, s.commit(bt, fw)
+	return value, s.commit(bt, fw)
 }
 
 // INCR key
@@ -449,10 +458,10 @@ func (s *Store) Incr(db uint32, args [][]byte) (int64, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.incrInt(db, key, 1)
 }
@@ -469,10 +478,10 @@ func (s *Store) IncrBy(db uint32, args [][]byte) (int64, error) {
 		return 0, errArguments("parse args failed - %s", err)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.incrInt(db, key, delta)
 }
@@ -485,10 +494,10 @@ func (s *Store) Decr(db uint32, args [][]byte) (int64, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.incrInt(db, key, -1)
 }
@@ -505,10 +514,10 @@ func (s *Store) DecrBy(db uint32, args [][]byte) (int64, error) {
 		return 0, errArguments("parse args failed - %s", err)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.incrInt(db, key, -delta)
 }
@@ -525,10 +534,10 @@ func (s *Store) IncrByFloat(db uint32, args [][]byte) (float64, error) {
 		return 0, errArguments("parse args failed - %s", err)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	return s.incrFloat(db, key, delta)
 }
@@ -557,10 +566,10 @@ func (s *Store) SetBit(db uint32, args [][]byte) (int64, error) {
 		return 0, errArguments("bit is not an integer or out of range, bit = %d", value)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadStringRow(db, key)
 	if err != nil {
@@ -621,10 +630,10 @@ func (s *Store) SetRange(db uint32, args [][]byte) (int64, error) {
 		return 0, errArguments("offset = %d", offset)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadStringRow(db, key)
 	if err != nil {
@@ -659,10 +668,15 @@ func (s *Store) MSet(db uint32, args [][]byte) error {
 		return errArguments("len(args) = %d, expect != 0 && mod 2 = 0", len(args))
 	}
 
-	if err := s.acquire(); err != nil {
+	keys := make([][]byte, len(args)/2)
+	for i := range keys {
+		keys[i] = args[i*2]
+	}
+
+	if err := s.acquire(keys...); err != nil {
 		return errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(keys...)
 
 	ms := &markSet{}
 	bt := engine.NewBatch()
@@ -691,10 +705,15 @@ func (s *Store) MSetNX(db uint32, args [][]byte) (int64, error) {
 		return 0, errArguments("len(args) = %d, expect != 0 && mod 2 = 0", len(args))
 	}
 
-	if err := s.acquire(); err != nil {
+	keys := make([][]byte, len(args)/2)
+	for i := range keys {
+		keys[i] = args[i*2]
+	}
+
+	if err := s.acquire(keys...); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(keys...)
 
 	for i := 0; i < len(args); i += 2 {
 		o, err := s.loadStoreRow(db, args[i])
@@ -728,19 +747,20 @@ func (s *Store) MGet(db uint32, args [][]byte) ([][]byte, error) {
 
 	keys := args
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
 	values := make([][]byte, len(keys))
 	for i, key := range keys {
-		o, err := s.loadStringRow(db, key)
+		o, err := rd.loadStringRow(db, key)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
 		if o != nil {
-			_, err := o.LoadDataValue(s)
+			_, err := o.LoadDataValue(rd)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
@@ -768,17 +788,18 @@ func (s *Store) GetBit(db uint32, args [][]byte) (int64, error) {
 		return 0, errArguments("bit offset is not an integer or out of range, offset=%d", offset)
 	}
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadStringRow(db, key)
+	o, err := rd.loadStringRow(db, key)
 	if err != nil || o == nil {
 		return 0, errors.Trace(err)
 	}
 
-	if _, err := o.LoadDataValue(s); err != nil {
+	if _, err := o.LoadDataValue(rd); err != nil {
 		return 0, errors.Trace(err)
 	}
 
@@ -813,18 +834,19 @@ func (s *Store) GetRange(db uint32, args [][]byte) ([]byte, error) {
 		return nil, errArguments("parse args failed - %s", err)
 	}
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadStringRow(db, key)
+	o, err := rd.loadStringRow(db, key)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
 	if o != nil {
-		_, err := o.LoadDataValue(s)
+		_, err := o.LoadDataValue(rd)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -848,18 +870,19 @@ func (s *Store) Strlen(db uint32, args [][]byte) (int64, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadStringRow(db, key)
+	o, err := rd.loadStringRow(db, key)
 	if err != nil {
 		return 0, errors.Trace(err)
 	}
 
 	if o != nil {
-		_, err := o.LoadDataValue(s)
+		_, err := o.LoadDataValue(rd)
 		if err != nil {
 			return 0, errors.Trace(err)
 		}
@@ -892,12 +915,13 @@ func (s *Store) BitCount(db uint32, args [][]byte) (int64, error) {
 		}
 	}
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadStringRow(db, key)
+	o, err := rd.loadStringRow(db, key)
 	if err != nil {
 		return 0, errors.Trace(err)
 	}
@@ -905,7 +929,7 @@ func (s *Store) BitCount(db uint32, args [][]byte) (int64, error) {
 	var n int64 = 0
 
 	if o != nil {
-		_, err := o.LoadDataValue(s)
+		_, err := o.LoadDataValue(rd)
 		if err != nil {
 			return 0, errors.Trace(err)
 		}
@@ -946,10 +970,10 @@ func (s *Store) BitOp(db uint32, args [][]byte) (int64, error) {
 		return 0, errArguments("BITOP NOT must be called with a single source key, len(srcKeys)=%d", len(srcKeys))
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(args[1:]...); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(args[1:]...)
 
 	var value []byte
 	o, err := s.loadStringRow(db, srcKeys[0])
@@ -0,0 +1,247 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package store
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/qdb/pkg/engine"
+)
+
+const (
+	sweepCheckInterval = time.Millisecond * 100
+	sweepBatchSize     = 1024
+
+	// collections with more elements are unlinked lazily
+	lazyFreeThreshold = 64
+)
+
+// version of new rows, see storeCore.rowVersion
+func (s *Store) currentRowVersion() uint64 {
+	return uint64(s.rowVersion.Get())
+}
+
+// new rows will use a version greater than version
+func (s *Store) retireRowVersion(version uint64) {
+	for {
+		v := s.rowVersion.Get()
+		if uint64(v) > version || s.rowVersion.CompareAndSwap(v, int64(version)+1) {
+			return
+		}
+	}
+}
+
+// Garbage key is garbageCode + data key prefix of the unlinked row, value is its object code.
+func EncodeGarbageKey(dataKeyPrefix []byte) []byte {
+	return append([]byte{garbageCode}, dataKeyPrefix...)
+}
+
+func DecodeGarbageKey(p []byte) (db uint32, key []byte, version uint64, err error) {
+	r := NewBufReader(p)
+	err = decodeRawBytes(r, err, garbageCode, DataCode, &db, &key, &version)
+	err = decodeRawBytes(r, err)
+	return
+}
+
+// Pending key is pendingCode + data key prefix of a row whose data keys are committed
+// in batches before its meta key, value is its object code. The row becomes garbage
+// if the store stops before it is linked by its meta key.
+func encodePendingKey(dataKeyPrefix []byte) []byte {
+	return append([]byte{pendingCode}, dataKeyPrefix...)
+}
+
+// data keys committed for the pending row are left to the sweeper
+func (s *Store) abandonPendingRow(dataKeyPrefix []byte, code ObjectCode, version uint64) error {
+	bt := engine.NewBatch()
+	bt.Del(encodePendingKey(dataKeyPrefix))
+	bt.Set(EncodeGarbageKey(dataKeyPrefix), []byte{byte(code)})
+	s.retireRowVersion(version)
+	return s.commit(bt, nil)
+}
+
+// delete meta key only, data keys are left to the sweeper, see sweepLoop
+func (o *storeRowHelper) unlinkObject(s *Store, bt *engine.Batch) {
+	o.deleteMetaKey(bt)
+	bt.Set(EncodeGarbageKey(o.DataKeyPrefix()), []byte{byte(o.code)})
+	s.retireRowVersion(o.Version)
+}
+
+// number of data keys of the row
+func rowLength(o storeRow) int64 {
+	switch x := o.(type) {
+	case *hashRow:
+		return x.Size
+	case *listRow:
+		return x.Rindex - x.Lindex
+	case *setRow:
+		return x.Size
+	case *zsetRow:
+		return x.Size
+	default:
+		return 1
+	}
+}
+
+// delete the row, big collections are unlinked if lazy is true
+func (s *Store) freeObject(o storeRow, bt *engine.Batch, lazy bool) error {
+	if lazy && o.isVersioned() && rowLength(o) > lazyFreeThreshold {
+		o.unlinkObject(s, bt)
+		return nil
+	}
+	return o.deleteObject(s, bt)
+}
+
+// Caveat: if you set true, DEL and commands overwriting keys unlink big collections,
+// their data keys are deleted in background like UNLINK.
+func (s *Store) SetLazyFree(b bool) {
+	if b {
+		s.lazyFree.Set(1)
+	} else {
+		s.lazyFree.Set(0)
+	}
+}
+
+func (s *Store) needLazyFree() bool {
+	return s.lazyFree.Get() == 1
+}
+
+// versions of unlinked rows must be retired again after restarting or resetting,
+// rows still pending are unlinked first
+func (s *Store) loadRowVersion() error {
+	s.rowVersion.Set(0)
+
+	if err := s.abandonPendingRows(); err != nil {
+		return errors.Trace(err)
+	}
+
+	it := s.db.NewIterator()
+	defer it.Close()
+
+	pfx := []byte{garbageCode}
+	for it.SeekTo(pfx); it.Valid(); it.Next() {
+		gkey := it.Key()
+		if !bytes.HasPrefix(gkey, pfx) {
+			break
+		}
+		_, _, version, err := DecodeGarbageKey(gkey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		s.retireRowVersion(version)
+	}
+	return it.Error()
+}
+
+func (s *Store) abandonPendingRows() error {
+	it := s.db.NewIterator()
+	defer it.Close()
+
+	bt := engine.NewBatch()
+	pfx := []byte{pendingCode}
+	for it.SeekTo(pfx); it.Valid(); it.Next() {
+		pkey := it.Key()
+		if !bytes.HasPrefix(pkey, pfx) {
+			break
+		}
+		bt.Del(pkey)
+		bt.Set(EncodeGarbageKey(pkey[1:]), it.Value())
+	}
+	if err := it.Error(); err != nil {
+		return errors.Trace(err)
+	}
+	return s.commit(bt, nil)
+}
+
+// background loop deleting data keys of unlinked rows, exits when store is closed
+func (s *Store) sweepLoop() {
+	for {
+		n, err := s.sweepGarbage(sweepBatchSize)
+		if err != nil {
+			if errors.Cause(err) == ErrClosed {
+				return
+			}
+			log.Warningf("store sweep unlinked rows failed - %s", err)
+		}
+		if n < sweepBatchSize {
+			time.Sleep(sweepCheckInterval)
+		}
+	}
+}
+
+// delete at most limit data keys of unlinked rows in one batch, returns number of deleted keys.
+// Ranges of the swept rows are compacted at last.
+func (s *Store) sweepGarbage(limit int) (int, error) {
+	if err := s.acquire(); err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer s.release()
+
+	it := s.getIterator()
+	defer s.putIterator(it)
+
+	var swept [][]byte
+	bt := engine.NewBatch()
+	cursor := []byte{garbageCode}
+	for n := 0; n < limit; {
+		it.SeekTo(cursor)
+		if !it.Valid() || it.Key()[0] != garbageCode {
+			break
+		}
+		gkey, value := it.Key(), it.Value()
+		cursor = append(gkey, 0)
+		db, key, version, err := DecodeGarbageKey(gkey)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		pfxs := [][]byte{gkey[1:]}
+		if len(value) != 0 && ObjectCode(value[0]) == ZSetCode {
+			pfxs = append(pfxs, encodeIndexKeyPrefix(db, key, version), encodeRankKeyPrefix(db, key, version))
+		}
+
+		for _, pfx := range pfxs {
+			for it.SeekTo(pfx); it.Valid() && n < limit; it.Next() {
+				if !bytes.HasPrefix(it.Key(), pfx) {
+					break
+				}
+				bt.Del(it.Key())
+				n++
+			}
+		}
+		if err := it.Error(); err != nil {
+			return 0, errors.Trace(err)
+		}
+		if n >= limit {
+			break
+		}
+		bt.Del(gkey)
+		swept = append(swept, pfxs...)
+		n++
+	}
+
+	n := bt.Len()
+	if err := s.commit(bt, nil); err != nil {
+		return 0, errors.Trace(err)
+	}
+	for _, pfx := range swept {
+		if err := s.compact(pfx, prefixLimit(pfx)); err != nil {
+			return n, errors.Trace(err)
+		}
+	}
+	return n, nil
+}
+
+// returns the smallest key greater than all keys with prefix p
+func prefixLimit(p []byte) []byte {
+	limit := append([]byte{}, p...)
+	for i := len(limit) - 1; i >= 0; i-- {
+		if limit[i] != 0xff {
+			limit[i]++
+			return limit[:i+1]
+		}
+	}
+	return nil
+}
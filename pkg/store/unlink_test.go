@@ -0,0 +1,220 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package store
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/reborndb/qdb/pkg/engine"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testStoreSuite) kunlink(c *C, db uint32, expect int64, keys ...string) {
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+
+	n, err := s.s.Unlink(db, FormatBytes(args...))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, expect)
+
+	for _, key := range keys {
+		s.kexists(c, db, key, 0)
+	}
+}
+
+// number of unlinked rows not swept yet
+func (s *testStoreSuite) garbageLen(c *C) int {
+	it := s.s.getIterator()
+	defer s.s.putIterator(it)
+
+	n := 0
+	pfx := []byte{garbageCode}
+	for it.SeekTo(pfx); it.Valid(); it.Next() {
+		if !bytes.HasPrefix(it.Key(), pfx) {
+			break
+		}
+		n++
+	}
+	c.Assert(it.Error(), IsNil)
+	return n
+}
+
+// number of rows written in batches not linked yet
+func (s *testStoreSuite) pendingLen(c *C) int {
+	it := s.s.getIterator()
+	defer s.s.putIterator(it)
+
+	n := 0
+	pfx := []byte{pendingCode}
+	for it.SeekTo(pfx); it.Valid(); it.Next() {
+		if !bytes.HasPrefix(it.Key(), pfx) {
+			break
+		}
+		n++
+	}
+	c.Assert(it.Error(), IsNil)
+	return n
+}
+
+func (s *testStoreSuite) sweepAll(c *C) {
+	for s.garbageLen(c) != 0 {
+		_, err := s.s.sweepGarbage(16)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *testStoreSuite) TestEncodeGarbageKey(c *C) {
+	pfx := encodeVersionDataKeyPrefix(1, []byte("key"), 10)
+	db, key, version, err := DecodeGarbageKey(EncodeGarbageKey(pfx))
+	c.Assert(err, IsNil)
+	c.Assert(db, Equals, uint32(1))
+	c.Assert(string(key), Equals, "key")
+	c.Assert(version, Equals, uint64(10))
+
+	c.Assert(prefixLimit([]byte{1, 2, 0xff}), DeepEquals, []byte{1, 3})
+	c.Assert(prefixLimit([]byte{0xff}), IsNil)
+}
+
+func (s *testStoreSuite) TestUnlink(c *C) {
+	var pairs []interface{}
+	for i := 0; i < lazyFreeThreshold*2; i++ {
+		s.hset(c, 0, "hash", strconv.Itoa(i), "v", 1)
+		s.rpush(c, 0, "list", int64(i+1), strconv.Itoa(i))
+		pairs = append(pairs, strconv.Itoa(i), i)
+	}
+	s.zadd(c, 0, "zset", lazyFreeThreshold*2, pairs...)
+	s.sadd(c, 0, "set", 2, "a", "b")
+
+	version := s.s.currentRowVersion()
+	s.kunlink(c, 0, 4, "hash", "list", "zset", "set", "none")
+	c.Assert(s.s.currentRowVersion() > version, Equals, true)
+
+	// new rows never see the unlinked data keys
+	s.hset(c, 0, "hash", "a", "b", 1)
+	s.hgetall(c, 0, "hash", "a", "b")
+	s.rpush(c, 0, "list", 1, "a")
+	s.llen(c, 0, "list", 1)
+	s.zadd(c, 0, "zset", 1, "a", 1)
+	s.zrange(c, 0, "zset", 0, -1, false, "a")
+
+	s.sweepAll(c)
+	s.hgetall(c, 0, "hash", "a", "b")
+	s.llen(c, 0, "list", 1)
+	s.zcard(c, 0, "zset", 1)
+
+	s.kdel(c, 0, 3, "hash", "list", "zset")
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestResetRowVersion(c *C) {
+	for i := 0; i < lazyFreeThreshold*2; i++ {
+		s.sadd(c, 0, "set", 1, strconv.Itoa(i))
+	}
+	s.kunlink(c, 0, 1, "set")
+	c.Assert(s.s.currentRowVersion() > 0, Equals, true)
+
+	// versions are loaded from the new database
+	c.Assert(s.s.Reset(), IsNil)
+	c.Assert(s.s.currentRowVersion(), Equals, uint64(0))
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestLazyFree(c *C) {
+	for i := 0; i < lazyFreeThreshold*2; i++ {
+		s.sadd(c, 0, "set", 1, strconv.Itoa(i))
+	}
+	o, err := loadStoreRow(s.s, 0, []byte("set"))
+	c.Assert(err, IsNil)
+
+	// meta key and garbage key only
+	bt := engine.NewBatch()
+	c.Assert(s.s.freeObject(o, bt, true), IsNil)
+	c.Assert(bt.Len(), Equals, 2)
+
+	bt = engine.NewBatch()
+	c.Assert(s.s.freeObject(o, bt, false), IsNil)
+	c.Assert(bt.Len(), Equals, lazyFreeThreshold*2+1)
+
+	s.s.SetLazyFree(true)
+	defer s.s.SetLazyFree(false)
+
+	s.kdel(c, 0, 1, "set")
+
+	// overwritten by string
+	for i := 0; i < lazyFreeThreshold*2; i++ {
+		s.sadd(c, 0, "set", 1, strconv.Itoa(i))
+	}
+	s.xset(c, 0, "set", "a")
+	s.xget(c, 0, "set", "a")
+
+	s.sweepAll(c)
+	s.kdel(c, 0, 1, "set")
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestUnversionedRows(c *C) {
+	raw := func(refs ...interface{}) []byte {
+		w := NewBufWriter(nil)
+		encodeRawBytes(w, refs...)
+		return w.Bytes()
+	}
+
+	// rows written before versioning, meta values have no version and
+	// data keys are data key prefix + field
+	var db uint32
+	var expireat int64
+	var size, lindex, rindex int64 = lazyFreeThreshold * 2, 0, lazyFreeThreshold * 2
+	hkey, lkey, skey, zkey := []byte("hash"), []byte("list"), []byte("set"), []byte("zset")
+
+	bt := engine.NewBatch()
+	bt.Set(EncodeMetaKey(db, hkey), raw(HashCode, &expireat, &size))
+	bt.Set(EncodeMetaKey(db, lkey), raw(ListCode, &expireat, &lindex, &rindex))
+	bt.Set(EncodeMetaKey(db, skey), raw(SetCode, &expireat, &size))
+	bt.Set(EncodeMetaKey(db, zkey), raw(ZSetCode, &expireat, &size))
+	for i := int64(0); i < size; i++ {
+		member := []byte(strconv.Itoa(int(i)))
+		score := float64(i)
+		bt.Set(raw(DataCode, &db, &hkey, &member), raw(HashCode, &member))
+		bt.Set(raw(DataCode, &db, &lkey, &i), raw(ListCode, &member))
+		bt.Set(raw(DataCode, &db, &skey, &member), raw(SetCode))
+		bt.Set(raw(DataCode, &db, &zkey, &member), raw(ZSetCode, &score))
+		bt.Set(raw(indexCode, &db, &zkey, &score, &member), raw(ZSetCode))
+	}
+	c.Assert(s.s.commit(bt, nil), IsNil)
+
+	s.hlen(c, 0, "hash", size)
+	s.hget(c, 0, "hash", "1", "1")
+	s.llen(c, 0, "list", size)
+	s.lindex(c, 0, "list", 2, "2")
+	s.scard(c, 0, "set", size)
+	s.sismember(c, 0, "set", "3", 1)
+	s.zcard(c, 0, "zset", size)
+	s.zscore(c, 0, "zset", "4", 4)
+	s.zrank(c, 0, "zset", "5", false, 5)
+
+	// updated in the same layout
+	s.hset(c, 0, "hash", "a", "b", 1)
+	s.hget(c, 0, "hash", "a", "b")
+	s.rpush(c, 0, "list", size+1, "a")
+	s.lindex(c, 0, "list", -1, "a")
+	s.srem(c, 0, "set", 1, "3")
+	s.sismember(c, 0, "set", "3", 0)
+	s.zadd(c, 0, "zset", 1, "a", -1)
+	s.zrange(c, 0, "zset", 0, 1, false, "a", "0")
+
+	o, err := loadStoreRow(s.s, 0, hkey)
+	c.Assert(err, IsNil)
+	c.Assert(o.isVersioned(), Equals, false)
+
+	// deleted at once even if lazy, garbage of their prefixes would cover newer rows
+	s.s.SetLazyFree(true)
+	defer s.s.SetLazyFree(false)
+
+	s.kunlink(c, 0, 4, "hash", "list", "set", "zset")
+	c.Assert(s.garbageLen(c), Equals, 0)
+	s.checkEmpty(c)
+}
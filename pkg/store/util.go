@@ -25,3 +25,90 @@ func uint64ToFloat64(u uint64) float64 {
 	}
 	return math.Float64frombits(u)
 }
+
+// Glob-style pattern matching, same as redis stringmatchlen.
+// Supports '*', '?', '[abc]', '[^abc]', '[a-z]' and '\' to escape.
+func MatchPattern(pattern, str []byte) bool {
+	for len(pattern) != 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if MatchPattern(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			p := pattern[1:]
+			not := len(p) != 0 && p[0] == '^'
+			if not {
+				p = p[1:]
+			}
+			match := false
+			for len(p) != 0 && p[0] != ']' {
+				switch {
+				case p[0] == '\\' && len(p) >= 2:
+					if p[1] == str[0] {
+						match = true
+					}
+					p = p[2:]
+				case len(p) >= 3 && p[1] == '-':
+					beg, end := p[0], p[2]
+					if beg > end {
+						beg, end = end, beg
+					}
+					if str[0] >= beg && str[0] <= end {
+						match = true
+					}
+					p = p[3:]
+				default:
+					if p[0] == str[0] {
+						match = true
+					}
+					p = p[1:]
+				}
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+			if len(p) == 0 {
+				// missing closing ']', treat the whole rest as consumed
+				return len(str) == 0
+			}
+			pattern = p
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
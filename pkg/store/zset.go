@@ -29,17 +29,19 @@ type zsetRow struct {
 	indexKeyPrefix []byte
 	indexKeyRefs   []interface{}
 	indexValueRefs []interface{}
+
+	rankKeyPrefix []byte
 }
 
-func encodeIndexKeyPrefix(db uint32, key []byte) []byte {
+func encodeIndexKeyPrefix(db uint32, key []byte, version uint64) []byte {
 	w := NewBufWriter(nil)
-	encodeRawBytes(w, indexCode, &db, &key)
+	encodeRawBytes(w, indexCode, &db, &key, &version)
 	return w.Bytes()
 }
 
-func newZSetRow(db uint32, key []byte) *zsetRow {
+func newZSetRow(db uint32, key []byte, version uint64) *zsetRow {
 	o := &zsetRow{}
-	o.lazyInit(db, key, newStoreRowHelper(db, key, ZSetCode))
+	o.lazyInit(db, key, newStoreRowHelper(db, key, ZSetCode, version))
 	return o
 }
 
@@ -50,12 +52,14 @@ func (o *zsetRow) lazyInit(db uint32, key []byte, h *storeRowHelper) {
 	o.dataKeyRefs = []interface{}{&o.Member}
 	o.dataValueRefs = []interface{}{&o.Score}
 
-	o.indexKeyPrefix = encodeIndexKeyPrefix(db, key)
 	o.indexKeyRefs = []interface{}{&o.Score, &o.Member}
 	o.indexValueRefs = nil
 }
 
 func (o *zsetRow) IndexKeyPrefix() []byte {
+	if o.indexKeyPrefix == nil {
+		o.indexKeyPrefix = o.encodeKeyPrefix(indexCode)
+	}
 	return o.indexKeyPrefix
 }
 
@@ -135,7 +139,15 @@ func (o *zsetRow) deleteObject(s *Store, bt *engine.Batch) error {
 		bt.Del(key)
 	}
 
-	bt.Del(o.MetaKey())
+	for pfx := it.SeekTo(o.RankKeyPrefix()); it.Valid(); it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, pfx) {
+			break
+		}
+		bt.Del(key)
+	}
+
+	o.deleteMetaKey(bt)
 	return it.Error()
 }
 
@@ -153,6 +165,11 @@ func (o *zsetRow) storeObject(s *Store, bt *engine.Batch, expireat int64, obj in
 		}
 	}
 
+	x, err := o.newRankIndex(s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	ms := &markSet{}
 	for _, e := range zset {
 		o.Member, o.Score = e.Member, e.Score
@@ -163,10 +180,13 @@ func (o *zsetRow) storeObject(s *Store, bt *engine.Batch, expireat int64, obj in
 		ms.Set(o.Member)
 		bt.Set(o.DataKey(), o.DataValue())
 		bt.Set(o.IndexKey(), o.IndexValue())
+		if err := x.insert(o); err != nil {
+			return errors.Trace(err)
+		}
 	}
 	o.Size, o.ExpireAt = ms.Len(), expireat
 	bt.Set(o.MetaKey(), o.MetaValue())
-	return nil
+	return x.flush(bt)
 }
 
 func (o *zsetRow) loadObjectValue(r storeReader) (interface{}, error) {
@@ -218,17 +238,18 @@ func (s *Store) ZGetAll(db uint32, args [][]byte) ([][]byte, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadZSetRow(db, key)
+	o, err := rd.loadZSetRow(db, key)
 	if err != nil || o == nil {
 		return nil, errors.Trace(err)
 	}
 
-	x, err := o.loadObjectValue(s)
+	x, err := o.loadObjectValue(rd)
 	if err != nil || x == nil {
 		return nil, errors.Trace(err)
 	}
@@ -241,6 +262,51 @@ func (s *Store) ZGetAll(db uint32, args [][]byte) ([][]byte, error) {
 	return rets, nil
 }
 
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func (s *Store) ZScan(db uint32, args [][]byte) ([]byte, [][]byte, error) {
+	if len(args) < 2 {
+		return nil, nil, errArguments("len(args) = %d, expect >= 2", len(args))
+	}
+
+	key := args[0]
+	spec, err := parseDataScanSpec(args[1:])
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
+	rd, err := s.acquireReader()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	defer s.releaseReader(rd)
+
+	o, err := rd.loadZSetRow(db, key)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	} else if o == nil {
+		return encodeScanCursor(nil), nil, nil
+	}
+
+	var rets [][]byte
+	cursor, err := scanDataKeys(rd, o.DataKeyPrefix(), spec, func(sfx, value []byte) error {
+		if err := o.ParseDataKeySuffix(sfx); err != nil {
+			return errors.Trace(err)
+		}
+		if !spec.matchKey(o.Member) {
+			return nil
+		}
+		if err := o.ParseDataValue(value); err != nil {
+			return errors.Trace(err)
+		}
+		rets = append(rets, o.Member, FormatFloat(o.Score))
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return cursor, rets, nil
+}
+
 // ZCARD key
 func (s *Store) ZCard(db uint32, args [][]byte) (int64, error) {
 	if len(args) != 1 {
@@ -249,12 +315,13 @@ func (s *Store) ZCard(db uint32, args [][]byte) (int64, error) {
 
 	key := args[0]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadZSetRow(db, key)
+	o, err := rd.loadZSetRow(db, key)
 	if err != nil || o == nil {
 		return 0, errors.Trace(err)
 	}
@@ -288,10 +355,10 @@ func (s *Store) ZAdd(db uint32, args [][]byte) (int64, error) {
 		}
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadZSetRow(db, key)
 	if err != nil {
@@ -299,12 +366,26 @@ func (s *Store) ZAdd(db uint32, args [][]byte) (int64, error) {
 	}
 
 	if o == nil {
-		o = newZSetRow(db, key)
+		o = newZSetRow(db, key, s.currentRowVersion())
+	}
+
+	x, err := o.newRankIndex(s)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	// the last score wins if a member is given more than once
+	last := make(map[string]int, len(eles))
+	for i, e := range eles {
+		last[string(e.Member)] = i
 	}
 
 	ms := &markSet{}
 	bt := engine.NewBatch()
-	for _, e := range eles {
+	for i, e := range eles {
+		if last[string(e.Member)] != i {
+			continue
+		}
 		o.Member = e.Member
 		exists, err := o.LoadDataValue(s)
 		if err != nil {
@@ -315,12 +396,18 @@ func (s *Store) ZAdd(db uint32, args [][]byte) (int64, error) {
 		} else {
 			// if old exists, remove index key first
 			bt.Del(o.IndexKey())
+			if err := x.remove(o); err != nil {
+				return 0, errors.Trace(err)
+			}
 		}
 
 		o.Score = e.Score
 
 		bt.Set(o.DataKey(), o.DataValue())
 		bt.Set(o.IndexKey(), o.IndexValue())
+		if err := x.insert(o); err != nil {
+			return 0, errors.Trace(err)
+		}
 	}
 
 	n := ms.Len()
@@ -328,8 +415,15 @@ func (s *Store) ZAdd(db uint32, args [][]byte) (int64, error) {
 		o.Size += n
 		bt.Set(o.MetaKey(), o.MetaValue())
 	}
+	if err := x.flush(bt); err != nil {
+		return 0, errors.Trace(err)
+	}
 	fw := &Forward{DB: db, Op: "ZAdd", Args: args}
-	return n, s.commit(bt, fw)
+	if err := s.commit(bt, fw); err != nil {
+		return 0, errors.Trace(err)
+	}
+	s.rebalanceRankIndex(x)
+	return n, nil
 }
 
 // ZREM key member [member ...]
@@ -341,16 +435,21 @@ func (s *Store) ZRem(db uint32, args [][]byte) (int64, error) {
 	key := args[0]
 	members := args[1:]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadZSetRow(db, key)
 	if err != nil || o == nil {
 		return 0, errors.Trace(err)
 	}
 
+	x, err := o.newRankIndex(s)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
 	ms := &markSet{}
 	bt := engine.NewBatch()
 	for _, o.Member = range members {
@@ -362,6 +461,9 @@ func (s *Store) ZRem(db uint32, args [][]byte) (int64, error) {
 			if exists {
 				bt.Del(o.DataKey())
 				bt.Del(o.IndexKey())
+				if err := x.remove(o); err != nil {
+					return 0, errors.Trace(err)
+				}
 				ms.Set(o.Member)
 			}
 		}
@@ -372,11 +474,18 @@ func (s *Store) ZRem(db uint32, args [][]byte) (int64, error) {
 		if o.Size -= n; o.Size > 0 {
 			bt.Set(o.MetaKey(), o.MetaValue())
 		} else {
-			bt.Del(o.MetaKey())
+			o.deleteMetaKey(bt)
+		}
+		if err := x.flush(bt); err != nil {
+			return 0, errors.Trace(err)
 		}
 	}
 	fw := &Forward{DB: db, Op: "ZRem", Args: args}
-	return n, s.commit(bt, fw)
+	if err := s.commit(bt, fw); err != nil {
+		return 0, errors.Trace(err)
+	}
+	s.rebalanceRankIndex(x)
+	return n, nil
 }
 
 // ZSCORE key member
@@ -388,18 +497,19 @@ func (s *Store) ZScore(db uint32, args [][]byte) (float64, bool, error) {
 	key := args[0]
 	member := args[1]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, false, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadZSetRow(db, key)
+	o, err := rd.loadZSetRow(db, key)
 	if err != nil || o == nil {
 		return 0, false, errors.Trace(err)
 	}
 
 	o.Member = member
-	exists, err := o.LoadDataValue(s)
+	exists, err := o.LoadDataValue(rd)
 	if err != nil || !exists {
 		return 0, false, errors.Trace(err)
 	} else {
@@ -420,10 +530,10 @@ func (s *Store) ZIncrBy(db uint32, args [][]byte) (float64, error) {
 	}
 	member := args[2]
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadZSetRow(db, key)
 	if err != nil {
@@ -433,17 +543,25 @@ func (s *Store) ZIncrBy(db uint32, args [][]byte) (float64, error) {
 	bt := engine.NewBatch()
 
 	var exists bool = false
-	if o != nil {
-		o.Member = member
+	if o == nil {
+		o = newZSetRow(db, key, s.currentRowVersion())
+	}
+	x, err := o.newRankIndex(s)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	o.Member = member
+	if o.Size != 0 {
 		exists, err = o.LoadDataValue(s)
 		if err != nil {
 			return 0, errors.Trace(err)
 		} else if exists {
 			bt.Del(o.IndexKey())
+			if err := x.remove(o); err != nil {
+				return 0, errors.Trace(err)
+			}
 		}
-	} else {
-		o = newZSetRow(db, key)
-		o.Member = member
 	}
 
 	if exists {
@@ -459,9 +577,19 @@ func (s *Store) ZIncrBy(db uint32, args [][]byte) (float64, error) {
 
 	bt.Set(o.DataKey(), o.DataValue())
 	bt.Set(o.IndexKey(), o.IndexValue())
+	if err := x.insert(o); err != nil {
+		return 0, errors.Trace(err)
+	}
+	if err := x.flush(bt); err != nil {
+		return 0, errors.Trace(err)
+	}
 
 	fw := &Forward{DB: db, Op: "ZIncrBy", Args: args}
-	return delta, s.commit(bt, fw)
+	if err := s.commit(bt, fw); err != nil {
+		return 0, errors.Trace(err)
+	}
+	s.rebalanceRankIndex(x)
+	return delta, nil
 }
 
 // holds a inclusive/exclusive range spec by score comparison
@@ -646,12 +774,13 @@ func (s *Store) ZCount(db uint32, args [][]byte) (int64, error) {
 		return 0, errors.Trace(err)
 	}
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadZSetRow(db, key)
+	o, err := rd.loadZSetRow(db, key)
 	if err != nil {
 		return 0, errors.Trace(err)
 	}
@@ -662,7 +791,7 @@ func (s *Store) ZCount(db uint32, args [][]byte) (int64, error) {
 		return nil
 	}
 
-	if err = o.travelInRange(s, r, f); err != nil {
+	if err = o.travelInRange(rd, r, f); err != nil {
 		return 0, errors.Trace(err)
 	}
 
@@ -903,12 +1032,13 @@ func (s *Store) ZLexCount(db uint32, args [][]byte) (int64, error) {
 		return 0, errors.Trace(err)
 	}
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadZSetRow(db, key)
+	o, err := rd.loadZSetRow(db, key)
 	if err != nil {
 		return 0, errors.Trace(err)
 	}
@@ -919,7 +1049,7 @@ func (s *Store) ZLexCount(db uint32, args [][]byte) (int64, error) {
 		return nil
 	}
 
-	if err = o.travelInLexRange(s, r, f); err != nil {
+	if err = o.travelInLexRange(rd, r, f); err != nil {
 		return 0, errors.Trace(err)
 	}
 
@@ -949,12 +1079,13 @@ func (s *Store) genericZRange(db uint32, args [][]byte, reverse bool) ([][]byte,
 		withScore = 2
 	}
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadZSetRow(db, key)
+	o, err := rd.loadZSetRow(db, key)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -966,34 +1097,42 @@ func (s *Store) genericZRange(db uint32, args [][]byte, reverse bool) ([][]byte,
 		return [][]byte{}, nil
 	}
 
-	r := &rangeSpec{Min: math.Inf(-1), Max: math.Inf(1), MinEx: true, MaxEx: true}
+	it := rd.getIterator()
+	defer rd.putIterator(it)
 
-	res := make([][]byte, 0, rangeLen*int64(withScore))
-	offset := int64(0)
-	f := func(o *zsetRow) error {
-		if offset >= start {
-			res = append(res, o.Member)
-			if withScore == 2 {
-				res = append(res, FormatFloat(o.Score))
-			}
+	// seek to the first element by rank index, then travel index keys
+	if !reverse {
+		err = o.seekToRank(rd, it, start)
+	} else {
+		err = o.seekToRank(rd, it, o.Size-1-start)
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
-			rangeLen--
-			if rangeLen <= 0 {
-				return errors.Trace(errTravelBreak)
-			}
+	res := make([][]byte, 0, rangeLen*int64(withScore))
+	prefixKey := o.IndexKeyPrefix()
+	for ; rangeLen > 0 && it.Valid(); rangeLen-- {
+		key := it.Key()
+		if !bytes.HasPrefix(key, prefixKey) {
+			break
+		}
+		if err := o.ParseIndexKeySuffix(key[len(prefixKey):]); err != nil {
+			return nil, errors.Trace(err)
+		}
 
+		res = append(res, o.Member)
+		if withScore == 2 {
+			res = append(res, FormatFloat(o.Score))
 		}
-		offset++
-		return nil
-	}
 
-	if !reverse {
-		err = o.travelInRange(s, r, f)
-	} else {
-		err = o.reverseTravelInRange(s, r, f)
+		if !reverse {
+			it.Next()
+		} else {
+			it.Prev()
+		}
 	}
-
-	return res, errors.Trace(err)
+	return res, errors.Trace(it.Error())
 }
 
 // ZRANGE key start stop [WITHSCORES]
@@ -1063,12 +1202,13 @@ func (s *Store) genericZRangeBylex(db uint32, args [][]byte, reverse bool) ([][]
 		return nil, errors.Trace(err)
 	}
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadZSetRow(db, key)
+	o, err := rd.loadZSetRow(db, key)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -1091,9 +1231,9 @@ func (s *Store) genericZRangeBylex(db uint32, args [][]byte, reverse bool) ([][]
 	}
 
 	if !reverse {
-		err = o.travelInLexRange(s, r, f)
+		err = o.travelInLexRange(rd, r, f)
 	} else {
-		err = o.reverseTravelInLexRange(s, r, f)
+		err = o.reverseTravelInLexRange(rd, r, f)
 	}
 
 	return res, errors.Trace(err)
@@ -1172,20 +1312,21 @@ func (s *Store) genericZRangeByScore(db uint32, args [][]byte, reverse bool) ([]
 		return nil
 	}
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadZSetRow(db, key)
+	o, err := rd.loadZSetRow(db, key)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
 	if !reverse {
-		err = o.travelInRange(s, r, f)
+		err = o.travelInRange(rd, r, f)
 	} else {
-		err = o.reverseTravelInRange(s, r, f)
+		err = o.reverseTravelInRange(rd, r, f)
 	}
 
 	return res, errors.Trace(err)
@@ -1209,12 +1350,13 @@ func (s *Store) genericZRank(db uint32, args [][]byte, reverse bool) (int64, err
 	key := args[0]
 	member := args[1]
 
-	if err := s.acquire(); err != nil {
+	rd, err := s.acquireReader()
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.releaseReader(rd)
 
-	o, err := s.loadZSetRow(db, key)
+	o, err := rd.loadZSetRow(db, key)
 	if err != nil {
 		return 0, errors.Trace(err)
 	} else if o == nil {
@@ -1222,31 +1364,22 @@ func (s *Store) genericZRank(db uint32, args [][]byte, reverse bool) (int64, err
 	}
 
 	o.Member = member
-	exists, err := o.LoadDataValue(s)
+	exists, err := o.LoadDataValue(rd)
 	if err != nil {
 		return 0, errors.Trace(err)
 	} else if !exists {
 		return -1, nil
 	}
 
-	r := &rangeSpec{Min: math.Inf(-1), Max: o.Score, MinEx: true, MaxEx: false}
-	n := int64(1)
-	checkScore := o.Score
-	f := func(o *zsetRow) error {
-		if checkScore > o.Score {
-			n++
-		}
-		return nil
-	}
-
-	if err := o.travelInRange(s, r, f); err != nil {
+	n, err := o.rankOfSuffix(rd, o.indexKeySuffix())
+	if err != nil {
 		return 0, errors.Trace(err)
 	}
 
 	if !reverse {
-		return n - 1, nil
+		return n, nil
 	} else {
-		return o.Size - n, nil
+		return o.Size - 1 - n, nil
 	}
 }
 
@@ -1275,10 +1408,10 @@ func (s *Store) ZRemRangeByLex(db uint32, args [][]byte) (int64, error) {
 		return 0, errors.Trace(err)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadZSetRow(db, key)
 	if err != nil {
@@ -1287,6 +1420,11 @@ func (s *Store) ZRemRangeByLex(db uint32, args [][]byte) (int64, error) {
 		return 0, nil
 	}
 
+	x, err := o.newRankIndex(s)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
 	bt := engine.NewBatch()
 	n := int64(0)
 
@@ -1294,7 +1432,7 @@ func (s *Store) ZRemRangeByLex(db uint32, args [][]byte) (int64, error) {
 		bt.Del(o.DataKey())
 		bt.Del(o.IndexKey())
 		n++
-		return nil
+		return x.remove(o)
 	}
 
 	if err := o.travelInLexRange(s, r, f); err != nil {
@@ -1305,12 +1443,19 @@ func (s *Store) ZRemRangeByLex(db uint32, args [][]byte) (int64, error) {
 		if o.Size -= n; o.Size > 0 {
 			bt.Set(o.MetaKey(), o.MetaValue())
 		} else {
-			bt.Del(o.MetaKey())
+			o.deleteMetaKey(bt)
+		}
+		if err := x.flush(bt); err != nil {
+			return 0, errors.Trace(err)
 		}
 	}
 
 	fw := &Forward{DB: db, Op: "ZRemRangeByLex", Args: args}
-	return n, s.commit(bt, fw)
+	if err := s.commit(bt, fw); err != nil {
+		return 0, errors.Trace(err)
+	}
+	s.rebalanceRankIndex(x)
+	return n, nil
 }
 
 // ZREMRANGEBYRANK key start stop
@@ -1329,12 +1474,10 @@ func (s *Store) ZRemRangeByRank(db uint32, args [][]byte) (int64, error) {
 		return 0, errArguments("parse args failed - %s", err)
 	}
 
-	r := &rangeSpec{Min: math.Inf(-1), Max: math.Inf(1), MinEx: true, MaxEx: true}
-
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadZSetRow(db, key)
 	if err != nil {
@@ -1350,27 +1493,38 @@ func (s *Store) ZRemRangeByRank(db uint32, args [][]byte) (int64, error) {
 		return 0, nil
 	}
 
-	bt := engine.NewBatch()
-	n := int64(0)
+	x, err := o.newRankIndex(s)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
 
-	offset := int64(0)
+	it := s.getIterator()
+	defer s.putIterator(it)
 
-	f := func(o *zsetRow) error {
-		if offset >= start {
-			bt.Del(o.DataKey())
-			bt.Del(o.IndexKey())
-			n++
-			rangeLen--
-			if rangeLen <= 0 {
-				return errors.Trace(errTravelBreak)
-			}
+	if err := o.seekToRank(s, it, start); err != nil {
+		return 0, errors.Trace(err)
+	}
 
+	bt := engine.NewBatch()
+	n := int64(0)
+
+	prefixKey := o.IndexKeyPrefix()
+	for ; n < rangeLen && it.Valid(); it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, prefixKey) {
+			break
 		}
-		offset++
-		return nil
+		if err := o.ParseIndexKeySuffix(key[len(prefixKey):]); err != nil {
+			return 0, errors.Trace(err)
+		}
+		bt.Del(o.DataKey())
+		bt.Del(o.IndexKey())
+		if err := x.remove(o); err != nil {
+			return 0, errors.Trace(err)
+		}
+		n++
 	}
-
-	if err := o.travelInRange(s, r, f); err != nil {
+	if err := it.Error(); err != nil {
 		return 0, errors.Trace(err)
 	}
 
@@ -1378,12 +1532,19 @@ func (s *Store) ZRemRangeByRank(db uint32, args [][]byte) (int64, error) {
 		if o.Size -= n; o.Size > 0 {
 			bt.Set(o.MetaKey(), o.MetaValue())
 		} else {
-			bt.Del(o.MetaKey())
+			o.deleteMetaKey(bt)
+		}
+		if err := x.flush(bt); err != nil {
+			return 0, errors.Trace(err)
 		}
 	}
 
 	fw := &Forward{DB: db, Op: "ZRemRangeByRank", Args: args}
-	return n, s.commit(bt, fw)
+	if err := s.commit(bt, fw); err != nil {
+		return 0, errors.Trace(err)
+	}
+	s.rebalanceRankIndex(x)
+	return n, nil
 }
 
 // ZREMRANGEBYSCORE key min max
@@ -1401,10 +1562,10 @@ func (s *Store) ZRemRangeByScore(db uint32, args [][]byte) (int64, error) {
 		return 0, errors.Trace(err)
 	}
 
-	if err := s.acquire(); err != nil {
+	if err := s.acquire(key); err != nil {
 		return 0, errors.Trace(err)
 	}
-	defer s.release()
+	defer s.release(key)
 
 	o, err := s.loadZSetRow(db, key)
 	if err != nil {
@@ -1413,6 +1574,11 @@ func (s *Store) ZRemRangeByScore(db uint32, args [][]byte) (int64, error) {
 		return 0, nil
 	}
 
+	x, err := o.newRankIndex(s)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
 	bt := engine.NewBatch()
 	n := int64(0)
 
@@ -1420,7 +1586,7 @@ func (s *Store) ZRemRangeByScore(db uint32, args [][]byte) (int64, error) {
 		bt.Del(o.DataKey())
 		bt.Del(o.IndexKey())
 		n++
-		return nil
+		return x.remove(o)
 	}
 
 	if err := o.travelInRange(s, r, f); err != nil {
@@ -1431,10 +1597,214 @@ func (s *Store) ZRemRangeByScore(db uint32, args [][]byte) (int64, error) {
 		if o.Size -= n; o.Size > 0 {
 			bt.Set(o.MetaKey(), o.MetaValue())
 		} else {
-			bt.Del(o.MetaKey())
+			o.deleteMetaKey(bt)
+		}
+		if err := x.flush(bt); err != nil {
+			return 0, errors.Trace(err)
 		}
 	}
 
 	fw := &Forward{DB: db, Op: "ZRemRangeByScore", Args: args}
-	return n, s.commit(bt, fw)
+	if err := s.commit(bt, fw); err != nil {
+		return 0, errors.Trace(err)
+	}
+	s.rebalanceRankIndex(x)
+	return n, nil
+}
+
+const (
+	zsetAggregateSum = iota
+	zsetAggregateMin
+	zsetAggregateMax
+)
+
+type zsetStoreArgs struct {
+	dest      []byte
+	keys      [][]byte
+	weights   []float64
+	aggregate int
+}
+
+// destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func parseZSetStoreArgs(args [][]byte) (*zsetStoreArgs, error) {
+	if len(args) < 3 {
+		return nil, errArguments("len(args) = %d, expect >= 3", len(args))
+	}
+
+	numkeys, err := ParseInt(args[1])
+	if err != nil {
+		return nil, errArguments("parse args failed - %s", err)
+	}
+	if numkeys <= 0 {
+		return nil, errArguments("at least 1 input key is needed")
+	}
+	if numkeys > int64(len(args)-2) {
+		return nil, errArguments("numkeys = %d, len(keys) = %d", numkeys, len(args)-2)
+	}
+
+	n := int(numkeys)
+	a := &zsetStoreArgs{
+		dest:      args[0],
+		keys:      args[2 : 2+n],
+		weights:   make([]float64, n),
+		aggregate: zsetAggregateSum,
+	}
+	for i := range a.weights {
+		a.weights[i] = 1
+	}
+
+	for i := 2 + n; i < len(args); {
+		switch strings.ToUpper(FormatString(args[i])) {
+		case "WEIGHTS":
+			if i+n >= len(args) {
+				return nil, errArguments("parse args[%d] failed, invalid weights format", i)
+			}
+			for j := range a.weights {
+				if a.weights[j], err = ParseFloat(args[i+1+j]); err != nil {
+					return nil, errArguments("parse args[%d] failed, %v", i+1+j, err)
+				}
+			}
+			i += 1 + n
+		case "AGGREGATE":
+			if i+1 >= len(args) {
+				return nil, errArguments("parse args[%d] failed, invalid aggregate format", i)
+			}
+			switch strings.ToUpper(FormatString(args[i+1])) {
+			case "SUM":
+				a.aggregate = zsetAggregateSum
+			case "MIN":
+				a.aggregate = zsetAggregateMin
+			case "MAX":
+				a.aggregate = zsetAggregateMax
+			default:
+				return nil, errArguments("parse args[%d] failed, %s", i+1, args[i+1])
+			}
+			i += 2
+		default:
+			return nil, errArguments("parse args[%d] failed, %s", i, args[i])
+		}
+	}
+	return a, nil
+}
+
+func (a *zsetStoreArgs) aggregateScore(score float64, v float64) float64 {
+	switch a.aggregate {
+	case zsetAggregateMin:
+		return math.Min(score, v)
+	case zsetAggregateMax:
+		return math.Max(score, v)
+	default:
+		// inf + -inf is nan, same as redis we use 0 instead
+		if score += v; math.IsNaN(score) {
+			return 0
+		}
+		return score
+	}
+}
+
+func (s *Store) zsetsStore(db uint32, args [][]byte, op int, fw *Forward) (int64, error) {
+	a, err := parseZSetStoreArgs(args)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	keys := append([][]byte{a.dest}, a.keys...)
+	if err := s.acquire(keys...); err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer s.release(keys...)
+
+	// sets are accepted as input, members of set have score 1
+	pfxs := make([][]byte, len(a.keys))
+	isZSet := make([]bool, len(a.keys))
+	for i, key := range a.keys {
+		o, err := s.loadStoreRow(db, key)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		switch x := o.(type) {
+		case nil:
+		case *zsetRow:
+			pfxs[i], isZSet[i] = x.DataKeyPrefix(), true
+		case *setRow:
+			pfxs[i] = x.DataKeyPrefix()
+		default:
+			return 0, errors.Trace(ErrNotZSet)
+		}
+	}
+
+	bt := engine.NewBatch()
+	if _, err := s.deleteIfExists(bt, db, a.dest); err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	// batch is applied after merging, so dest can be one of the inputs
+	o := newZSetRow(db, a.dest, s.currentRowVersion())
+	e := newZSetRow(db, nil, 0)
+	x, err := o.newRankIndex(s)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	var perr error
+	err = mergeMembers(s, pfxs, op, func(its []*memberIterator, sfx []byte) {
+		if perr != nil {
+			return
+		}
+		first := true
+		for i, x := range its {
+			if !x.valid() || !bytes.Equal(x.sfx, sfx) {
+				continue
+			}
+			score := float64(1)
+			if isZSet[i] {
+				if perr = e.ParseDataValue(x.value()); perr != nil {
+					return
+				}
+				score = e.Score
+			}
+			if score *= a.weights[i]; math.IsNaN(score) {
+				score = 0
+			}
+			if first {
+				o.Score, first = score, false
+			} else {
+				o.Score = a.aggregateScore(o.Score, score)
+			}
+		}
+		if perr = o.ParseDataKeySuffix(sfx); perr != nil {
+			return
+		}
+		bt.Set(o.DataKey(), o.DataValue())
+		bt.Set(o.IndexKey(), o.IndexValue())
+		if perr = x.insert(o); perr != nil {
+			return
+		}
+		o.Size++
+	})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if perr != nil {
+		return 0, errors.Trace(perr)
+	}
+
+	if o.Size != 0 {
+		bt.Set(o.MetaKey(), o.MetaValue())
+		if err := x.flush(bt); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	return o.Size, s.commit(bt, fw)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func (s *Store) ZUnionStore(db uint32, args [][]byte) (int64, error) {
+	fw := &Forward{DB: db, Op: "ZUnionStore", Args: args}
+	return s.zsetsStore(db, args, setUnion, fw)
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func (s *Store) ZInterStore(db uint32, args [][]byte) (int64, error) {
+	fw := &Forward{DB: db, Op: "ZInterStore", Args: args}
+	return s.zsetsStore(db, args, setInter, fw)
 }
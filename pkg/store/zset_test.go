@@ -222,6 +222,18 @@ func (s *testStoreSuite) zremrangebyscore(c *C, db uint32, key string, min strin
 	c.Assert(x, Equals, expect)
 }
 
+func (s *testStoreSuite) zunionstore(c *C, db uint32, expect int64, args ...interface{}) {
+	x, err := s.s.ZUnionStore(db, FormatBytes(args...))
+	c.Assert(err, IsNil)
+	c.Assert(x, Equals, expect)
+}
+
+func (s *testStoreSuite) zinterstore(c *C, db uint32, expect int64, args ...interface{}) {
+	x, err := s.s.ZInterStore(db, FormatBytes(args...))
+	c.Assert(err, IsNil)
+	c.Assert(x, Equals, expect)
+}
+
 func (s *testStoreSuite) TestZAdd(c *C) {
 	s.zadd(c, 0, "zset", 1, "0", 0)
 	for i := 0; i < 32; i++ {
@@ -482,3 +494,88 @@ func (s *testStoreSuite) TestZRemRangeByScore(c *C) {
 	s.zdel(c, 0, "zset", 0)
 	s.checkEmpty(c)
 }
+
+func (s *testStoreSuite) TestZScan(c *C) {
+	c.Assert(s.scanall(c, s.s.ZScan, 0, "zset", 1), HasLen, 0)
+	s.zadd(c, 0, "zset", 3, "a", 3, "b", 2, "c", 1.5)
+
+	for _, count := range []int64{1, 2, 10} {
+		a := s.scanall(c, s.s.ZScan, 0, "zset", count)
+		c.Assert(a, DeepEquals, []string{"a", string(FormatFloat(3)), "b", string(FormatFloat(2)), "c", string(FormatFloat(1.5))})
+	}
+	c.Assert(s.scanall(c, s.s.ZScan, 0, "zset", 1, "match", "b"), DeepEquals, []string{"b", string(FormatFloat(2))})
+
+	s.zdel(c, 0, "zset", 1)
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestZUnionStore(c *C) {
+	s.zadd(c, 0, "zset1", 3, "a", 1, "b", 2, "c", 3)
+	s.zadd(c, 0, "zset2", 2, "b", 10, "d", 20)
+	s.sadd(c, 0, "set", 2, "a", "e")
+
+	s.zunionstore(c, 0, 4, "dest", 2, "zset1", "zset2")
+	s.zdump(c, 0, "dest", "a", 1, "b", 12, "c", 3, "d", 20)
+	s.zrange(c, 0, "dest", 0, -1, false, "a", "c", "b", "d")
+
+	s.zunionstore(c, 0, 5, "dest", 3, "zset1", "zset2", "set", "WEIGHTS", 2, 0.5, 3)
+	s.zdump(c, 0, "dest", "a", 5, "b", 9, "c", 6, "d", 10, "e", 3)
+	s.zrange(c, 0, "dest", 0, -1, false, "e", "a", "c", "b", "d")
+
+	s.zunionstore(c, 0, 4, "dest", 2, "zset1", "zset2", "AGGREGATE", "MIN")
+	s.zdump(c, 0, "dest", "a", 1, "b", 2, "c", 3, "d", 20)
+	s.zunionstore(c, 0, 4, "dest", 2, "zset1", "zset2", "weights", 1, -1, "aggregate", "max")
+	s.zdump(c, 0, "dest", "a", 1, "b", 2, "c", 3, "d", -20)
+
+	// dest is one of the inputs
+	s.zunionstore(c, 0, 4, "zset1", 2, "zset1", "zset2")
+	s.zdump(c, 0, "zset1", "a", 1, "b", 12, "c", 3, "d", 20)
+
+	s.zunionstore(c, 0, 0, "dest", 1, "none")
+	s.kexists(c, 0, "dest", 0)
+
+	for _, args := range [][]interface{}{
+		{"dest", 0, "zset1"},
+		{"dest", 3, "zset1", "zset2"},
+		{"dest", 2, "zset1", "zset2", "WEIGHTS", 1},
+		{"dest", 2, "zset1", "zset2", "AGGREGATE", "AVG"},
+	} {
+		_, err := s.s.ZUnionStore(0, FormatBytes(args...))
+		c.Assert(err, NotNil)
+	}
+
+	s.xset(c, 0, "string", "value")
+	_, err := s.s.ZUnionStore(0, FormatBytes("dest", 2, "zset1", "string"))
+	c.Assert(err, NotNil)
+
+	s.zdel(c, 0, "zset1", 1)
+	s.zdel(c, 0, "zset2", 1)
+	s.sdel(c, 0, "set", 1)
+	s.kdel(c, 0, 1, "string")
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestZInterStore(c *C) {
+	s.zadd(c, 0, "zset1", 3, "a", 1, "b", 2, "c", 3)
+	s.zadd(c, 0, "zset2", 3, "b", 10, "c", 20, "d", 30)
+	s.sadd(c, 0, "set", 2, "c", "d")
+
+	s.zinterstore(c, 0, 2, "dest", 2, "zset1", "zset2")
+	s.zdump(c, 0, "dest", "b", 12, "c", 23)
+	s.zinterstore(c, 0, 1, "dest", 3, "zset1", "zset2", "set", "WEIGHTS", 1, 2, 10)
+	s.zdump(c, 0, "dest", "c", 53)
+	s.zinterstore(c, 0, 2, "dest", 2, "zset1", "zset2", "AGGREGATE", "MAX")
+	s.zdump(c, 0, "dest", "b", 10, "c", 20)
+	s.zrange(c, 0, "dest", 0, -1, true, "c", "b")
+
+	s.zinterstore(c, 0, 0, "dest", 2, "zset1", "none")
+	s.kexists(c, 0, "dest", 0)
+
+	s.zinterstore(c, 0, 2, "zset2", 2, "zset2", "set", "AGGREGATE", "MIN")
+	s.zdump(c, 0, "zset2", "c", 1, "d", 1)
+
+	s.zdel(c, 0, "zset1", 1)
+	s.zdel(c, 0, "zset2", 1)
+	s.sdel(c, 0, "set", 1)
+	s.checkEmpty(c)
+}
@@ -0,0 +1,608 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package store
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/qdb/pkg/engine"
+)
+
+// Rank index of zset is a tree of counted nodes, so ranks are found without traveling
+// all elements before them.
+//
+// A node holds the start and the number of elements of its children. A child of level 1
+// node is a range of index keys, a child of upper node is the node of lower level with
+// the same start. Starts are index key suffixes, the root is the only node of the top
+// level, and it starts with empty suffix like the first node of every level.
+// Nodes are loaded by key only, so frequently updated nodes are never iterated.
+//
+// Rank key is rankCode + db + key + version + level + start, value is list of children,
+// version is left out for rows written before versioning like their data keys.
+
+const (
+	// ranges or nodes holding more children are split after commit
+	rankNodeMaxSize = 128
+
+	// ranges or nodes holding fewer children are merged into their neighbors after commit
+	rankNodeMinSize = rankNodeMaxSize / 4
+
+	// max size of ranges or nodes made by splitting or building, they are split evenly,
+	// so they are never smaller than rankNodeMinSize unless there is only one
+	rankNodeFillSize = rankNodeMaxSize / 2
+)
+
+func encodeRankKeyPrefix(db uint32, key []byte, version uint64) []byte {
+	w := NewBufWriter(nil)
+	encodeRawBytes(w, rankCode, &db, &key, &version)
+	return w.Bytes()
+}
+
+func (o *zsetRow) RankKeyPrefix() []byte {
+	if o.rankKeyPrefix == nil {
+		o.rankKeyPrefix = o.encodeKeyPrefix(rankCode)
+	}
+	return o.rankKeyPrefix
+}
+
+func (o *zsetRow) rankKey(level int, start []byte) []byte {
+	pfx := o.RankKeyPrefix()
+	key := make([]byte, 0, len(pfx)+1+len(start))
+	return append(append(append(key, pfx...), byte(level)), start...)
+}
+
+// index key suffix of current score and member
+func (o *zsetRow) indexKeySuffix() []byte {
+	w := NewBufWriter(nil)
+	encodeRawBytes(w, o.indexKeyRefs...)
+	return w.Bytes()
+}
+
+func (o *zsetRow) indexKeyWithSuffix(sfx []byte) []byte {
+	pfx := o.IndexKeyPrefix()
+	key := make([]byte, 0, len(pfx)+len(sfx))
+	return append(append(key, pfx...), sfx...)
+}
+
+type rankChild struct {
+	Start []byte
+	Count int64
+}
+
+type rankNode struct {
+	Level    int
+	Start    []byte
+	Children []*rankChild
+}
+
+func (n *rankNode) Encode() []byte {
+	w := NewBufWriter(nil)
+	for _, c := range n.Children {
+		encodeRawBytes(w, &c.Start, &c.Count)
+	}
+	return w.Bytes()
+}
+
+func (n *rankNode) Decode(p []byte) (err error) {
+	r := NewBufReader(p)
+	for r.Len() != 0 && err == nil {
+		c := &rankChild{}
+		err = decodeRawBytes(r, err, &c.Start, &c.Count)
+		n.Children = append(n.Children, c)
+	}
+	return
+}
+
+// index of the child holding sfx, i.e. the last child starting at or before sfx
+func (n *rankNode) find(sfx []byte) int {
+	i := sort.Search(len(n.Children), func(i int) bool {
+		return bytes.Compare(n.Children[i].Start, sfx) > 0
+	})
+	if i != 0 {
+		i--
+	}
+	return i
+}
+
+func (o *zsetRow) loadRankNode(r storeReader, level int, start []byte) (*rankNode, error) {
+	p, err := r.getRowValue(o.rankKey(level, start))
+	if err != nil {
+		return nil, errors.Trace(err)
+	} else if p == nil {
+		return nil, errors.Errorf("rank node not found, level = %d", level)
+	}
+	n := &rankNode{Level: level, Start: start}
+	if err := n.Decode(p); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return n, nil
+}
+
+// level of the root, 0 if rank index doesn't exist
+func (o *zsetRow) loadRankLevels(r storeReader) (int, error) {
+	for level := 1; ; level++ {
+		p, err := r.getRowValue(o.rankKey(level, nil))
+		if err != nil {
+			return 0, errors.Trace(err)
+		} else if p == nil {
+			return level - 1, nil
+		}
+	}
+}
+
+func (o *zsetRow) deleteRankKeys(r storeReader, bt *engine.Batch) error {
+	it := r.getIterator()
+	defer r.putIterator(it)
+	for pfx := it.SeekTo(o.RankKeyPrefix()); it.Valid(); it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, pfx) {
+			break
+		}
+		bt.Del(key)
+	}
+	return it.Error()
+}
+
+// number of elements before index key suffix sfx
+func (o *zsetRow) rankOfSuffix(r storeReader, sfx []byte) (int64, error) {
+	levels, err := o.loadRankLevels(r)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	var rank int64
+	start := []byte{}
+	for level := levels; level != 0; level-- {
+		n, err := o.loadRankNode(r, level, start)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		i := n.find(sfx)
+		for _, c := range n.Children[:i] {
+			rank += c.Count
+		}
+		start = n.Children[i].Start
+	}
+
+	it := r.getIterator()
+	defer r.putIterator(it)
+
+	pfx := o.IndexKeyPrefix()
+	for it.SeekTo(o.indexKeyWithSuffix(start)); it.Valid(); it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, pfx) || bytes.Compare(key[len(pfx):], sfx) >= 0 {
+			break
+		}
+		rank++
+	}
+	return rank, errors.Trace(it.Error())
+}
+
+// seek to the index key of element at rank, rank must be in [0, size)
+func (o *zsetRow) seekToRank(r storeReader, it *storeIterator, rank int64) error {
+	levels, err := o.loadRankLevels(r)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	start := []byte{}
+	for level := levels; level != 0; level-- {
+		n, err := o.loadRankNode(r, level, start)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, c := range n.Children {
+			if start = c.Start; rank < c.Count {
+				break
+			}
+			rank -= c.Count
+		}
+	}
+
+	for it.SeekTo(o.indexKeyWithSuffix(start)); rank > 0 && it.Valid(); rank-- {
+		it.Next()
+	}
+	return errors.Trace(it.Error())
+}
+
+// changes of rank index made by one command, nodes are written by flush
+type zsetRankIndex struct {
+	o *zsetRow
+	r storeReader
+
+	levels int
+	nodes  map[string]*rankNode
+
+	// not nil if rank index of new zset is built by flush
+	elems map[string]bool
+
+	// rank index is missing, it is rebuilt from index keys after commit
+	rebuild bool
+
+	// starts of nodes to be rebalanced after commit, see rebalanceRankIndex
+	unbalanced [][]byte
+}
+
+// rank index of zset o, that of new zset is built at flush
+func (o *zsetRow) newRankIndex(r storeReader) (*zsetRankIndex, error) {
+	x := &zsetRankIndex{o: o, r: r}
+	if o.Size == 0 {
+		x.elems = make(map[string]bool)
+		return x, nil
+	}
+
+	levels, err := o.loadRankLevels(r)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if levels == 0 {
+		// missing, rebuild it by scanning index keys after commit, see buildRankIndex
+		x.rebuild = true
+		return x, nil
+	}
+
+	x.levels = levels
+	x.nodes = make(map[string]*rankNode)
+	return x, nil
+}
+
+// loads node once, changes of it are kept in x
+func (x *zsetRankIndex) node(level int, start []byte) (*rankNode, error) {
+	key := string(x.o.rankKey(level, start))
+	if n := x.nodes[key]; n != nil {
+		return n, nil
+	}
+	n, err := x.o.loadRankNode(x.r, level, start)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	x.nodes[key] = n
+	return n, nil
+}
+
+// nodes from the root to level 1 holding sfx
+func (x *zsetRankIndex) path(sfx []byte) ([]*rankNode, error) {
+	var nodes []*rankNode
+	start := []byte{}
+	for level := x.levels; level != 0; level-- {
+		n, err := x.node(level, start)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		nodes = append(nodes, n)
+		start = n.Children[n.find(sfx)].Start
+	}
+	return nodes, nil
+}
+
+// index key of o is added
+func (x *zsetRankIndex) insert(o *zsetRow) error {
+	return x.update(o.indexKeySuffix(), 1)
+}
+
+// index key of o is deleted
+func (x *zsetRankIndex) remove(o *zsetRow) error {
+	return x.update(o.indexKeySuffix(), -1)
+}
+
+func (x *zsetRankIndex) update(sfx []byte, delta int64) error {
+	if x.rebuild {
+		return nil
+	}
+	if x.elems != nil {
+		if delta > 0 {
+			x.elems[string(sfx)] = true
+		} else {
+			delete(x.elems, string(sfx))
+		}
+		return nil
+	}
+
+	nodes, err := x.path(sfx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, n := range nodes {
+		n.Children[n.find(sfx)].Count += delta
+	}
+	return nil
+}
+
+// write changes into batch, size of zset must have been updated
+func (x *zsetRankIndex) flush(bt *engine.Batch) error {
+	if x.o.Size == 0 {
+		return x.o.deleteRankKeys(x.r, bt)
+	}
+	if x.rebuild {
+		return nil
+	}
+	if x.elems != nil {
+		x.build(bt)
+		return nil
+	}
+	for _, n := range x.nodes {
+		bt.Set(x.o.rankKey(n.Level, n.Start), n.Encode())
+		if !n.balanced(n.Level == x.levels) {
+			x.unbalanced = append(x.unbalanced, n.Start)
+		}
+	}
+	return nil
+}
+
+// node is balanced if none of it and its ranges need to be split or merged, see rebalanceRankNode
+func (n *rankNode) balanced(root bool) bool {
+	switch {
+	case len(n.Children) > rankNodeMaxSize:
+		return false
+	case root && n.Level != 1 && len(n.Children) == 1:
+		// root of upper level with only one child is removed
+		return false
+	case !root && len(n.Children) < rankNodeMinSize:
+		return false
+	}
+	if n.Level == 1 {
+		for _, c := range n.Children {
+			if c.Count > rankNodeMaxSize || (c.Count < rankNodeMinSize && len(n.Children) != 1) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (x *zsetRankIndex) build(bt *engine.Batch) {
+	elems := make([]string, 0, len(x.elems))
+	for sfx := range x.elems {
+		elems = append(elems, sfx)
+	}
+	sort.Strings(elems)
+
+	var ranges []*rankChild
+	i := 0
+	for _, size := range splitRankSize(int64(len(elems)), rankNodeFillSize) {
+		ranges = append(ranges, &rankChild{Start: []byte(elems[i]), Count: size})
+		i += int(size)
+	}
+	ranges[0].Start = []byte{}
+	x.o.writeRankNodes(bt, ranges)
+}
+
+// writes nodes of all levels over level 1 ranges, the first range must start with empty suffix
+func (o *zsetRow) writeRankNodes(bt *engine.Batch, children []*rankChild) {
+	for level := 1; ; level++ {
+		nodes := splitRankChildren(children, rankNodeFillSize)
+		children = nil
+		for _, n := range nodes {
+			bt.Set(o.rankKey(level, n.Start), n.Encode())
+			children = append(children, n.child())
+		}
+		if len(nodes) == 1 {
+			return
+		}
+	}
+}
+
+// ranges of count index keys from start split evenly, the first range begins at start
+func (o *zsetRow) loadRankRanges(it *storeIterator, start []byte, count int64) ([]*rankChild, error) {
+	sizes := splitRankSize(count, rankNodeFillSize)
+
+	var ranges []*rankChild
+	var n int64
+	pfx := o.IndexKeyPrefix()
+	for it.SeekTo(o.indexKeyWithSuffix(start)); it.Valid() && n < count; it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, pfx) {
+			break
+		}
+		if len(ranges) == 0 || ranges[len(ranges)-1].Count == sizes[len(ranges)-1] {
+			ranges = append(ranges, &rankChild{Start: append([]byte{}, key[len(pfx):]...)})
+		}
+		ranges[len(ranges)-1].Count++
+		n++
+	}
+	if err := it.Error(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if n != count || n == 0 {
+		return nil, errors.Errorf("rank range count = %d, but %d index keys found", count, n)
+	}
+	ranges[0].Start = start
+	return ranges, nil
+}
+
+// node as a child of upper node
+func (n *rankNode) child() *rankChild {
+	c := &rankChild{Start: n.Start}
+	for _, v := range n.Children {
+		c.Count += v.Count
+	}
+	return c
+}
+
+// nodes holding size children at most, children are split evenly
+func splitRankChildren(children []*rankChild, size int) []*rankNode {
+	var nodes []*rankNode
+	i := 0
+	for _, m := range splitRankSize(int64(len(children)), size) {
+		j := i + int(m)
+		nodes = append(nodes, &rankNode{Start: children[i].Start, Children: children[i:j:j]})
+		i = j
+	}
+	return nodes
+}
+
+// sizes of the fewest parts holding size at most that n is split into evenly
+func splitRankSize(n int64, size int) []int64 {
+	parts := (n + int64(size) - 1) / int64(size)
+	sizes := make([]int64, parts)
+	for i := range sizes {
+		sizes[i] = n / parts
+		if int64(i) < n%parts {
+			sizes[i]++
+		}
+	}
+	return sizes
+}
+
+// builds missing rank index or rebalances nodes after commit, every node is rebalanced
+// in its own batch, caller must hold the key lock
+func (s *Store) rebalanceRankIndex(x *zsetRankIndex) {
+	if x.rebuild {
+		if x.o.Size != 0 {
+			if err := s.buildRankIndex(x.o); err != nil {
+				log.Warningf("build rank index of zset failed - %s", err)
+			}
+		}
+		return
+	}
+	for _, start := range x.unbalanced {
+		// node merged with an underfull sibling may be still underfull
+		for i := 0; i < rankNodeMaxSize; i++ {
+			done, err := s.rebalanceRankNode(x.o, start)
+			if err != nil {
+				log.Warningf("rebalance rank index of zset failed - %s", err)
+				return
+			} else if done {
+				break
+			}
+		}
+	}
+}
+
+// builds rank index by scanning index keys, elements are never loaded all at once
+func (s *Store) buildRankIndex(o *zsetRow) error {
+	it := s.getIterator()
+	defer s.putIterator(it)
+
+	ranges, err := o.loadRankRanges(it, []byte{}, o.Size)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	bt := engine.NewBatch()
+	o.writeRankNodes(bt, ranges)
+	return s.commit(bt, nil)
+}
+
+// rebalances nodes from the root to level 1 holding start, returns true if they are balanced already
+func (s *Store) rebalanceRankNode(o *zsetRow, start []byte) (bool, error) {
+	levels, err := o.loadRankLevels(s)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	x := &zsetRankIndex{o: o, r: s, levels: levels, nodes: make(map[string]*rankNode)}
+	nodes, err := x.path(start)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+
+	done := true
+	for i, n := range nodes {
+		if !n.balanced(i == 0) {
+			done = false
+		}
+	}
+	if done {
+		return true, nil
+	}
+
+	it := s.getIterator()
+	defer s.putIterator(it)
+
+	leaf := nodes[len(nodes)-1]
+	if leaf.Children, err = o.rebalanceRankRanges(it, leaf.Children); err != nil {
+		return false, errors.Trace(err)
+	}
+
+	// merge underfull nodes into siblings, then split oversized nodes, from bottom to top
+	bt := engine.NewBatch()
+	for i := len(nodes) - 1; i > 0; i-- {
+		n, p := nodes[i], nodes[i-1]
+		j := p.find(n.Start)
+		if len(n.Children) < rankNodeMinSize && len(p.Children) > 1 {
+			// the latter of n and its sibling is merged into the former
+			if j == 0 {
+				j = 1
+			}
+			a, err := x.node(n.Level, p.Children[j-1].Start)
+			if err != nil {
+				return false, errors.Trace(err)
+			}
+			b, err := x.node(n.Level, p.Children[j].Start)
+			if err != nil {
+				return false, errors.Trace(err)
+			}
+			a.Children = append(a.Children, b.Children...)
+			if a.Level == 1 {
+				if a.Children, err = o.rebalanceRankRanges(it, a.Children); err != nil {
+					return false, errors.Trace(err)
+				}
+			}
+			p.Children[j-1].Count += p.Children[j].Count
+			p.Children = append(p.Children[:j], p.Children[j+1:]...)
+			bt.Del(o.rankKey(b.Level, b.Start))
+			n, j = a, j-1
+			nodes[i] = a
+		}
+		if len(n.Children) <= rankNodeMaxSize {
+			bt.Set(o.rankKey(n.Level, n.Start), n.Encode())
+			continue
+		}
+		var uppers []*rankChild
+		for _, v := range splitRankChildren(n.Children, rankNodeFillSize) {
+			bt.Set(o.rankKey(n.Level, v.Start), v.Encode())
+			uppers = append(uppers, v.child())
+		}
+		p.Children = append(p.Children[:j], append(uppers, p.Children[j+1:]...)...)
+	}
+
+	// roots of upper levels with only one child are removed, the child starting
+	// with empty suffix becomes the root
+	root := nodes[0]
+	for i := 1; len(root.Children) == 1 && root.Level != 1; i++ {
+		bt.Del(o.rankKey(root.Level, root.Start))
+		root = nodes[i]
+	}
+	if len(root.Children) <= rankNodeMaxSize {
+		bt.Set(o.rankKey(root.Level, root.Start), root.Encode())
+	} else {
+		// root is split, a new root is added on top
+		var uppers []*rankChild
+		for _, v := range splitRankChildren(root.Children, rankNodeFillSize) {
+			bt.Set(o.rankKey(root.Level, v.Start), v.Encode())
+			uppers = append(uppers, v.child())
+		}
+		top := &rankNode{Level: root.Level + 1, Start: []byte{}, Children: uppers}
+		bt.Set(o.rankKey(top.Level, top.Start), top.Encode())
+	}
+	return false, s.commit(bt, nil)
+}
+
+// merges underfull ranges into previous ones, then splits oversized ranges by index keys
+func (o *zsetRow) rebalanceRankRanges(it *storeIterator, children []*rankChild) ([]*rankChild, error) {
+	var merged []*rankChild
+	for _, c := range children {
+		if k := len(merged) - 1; k >= 0 && (c.Count < rankNodeMinSize || merged[k].Count < rankNodeMinSize) {
+			merged[k].Count += c.Count
+		} else {
+			merged = append(merged, c)
+		}
+	}
+
+	var ranges []*rankChild
+	for _, c := range merged {
+		if c.Count <= rankNodeMaxSize {
+			ranges = append(ranges, c)
+			continue
+		}
+		splits, err := o.loadRankRanges(it, c.Start, c.Count)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ranges = append(ranges, splits...)
+	}
+	return ranges, nil
+}
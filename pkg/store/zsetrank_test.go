@@ -0,0 +1,242 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/reborndb/qdb/pkg/engine"
+	. "gopkg.in/check.v1"
+)
+
+type testZSetElement struct {
+	member string
+	score  int
+}
+
+type testZSetElements []testZSetElement
+
+func (a testZSetElements) Len() int {
+	return len(a)
+}
+
+func (a testZSetElements) Less(i, j int) bool {
+	if a[i].score != a[j].score {
+		return a[i].score < a[j].score
+	}
+	// members are encoded as varbytes in index keys
+	if len(a[i].member) != len(a[j].member) {
+		return len(a[i].member) < len(a[j].member)
+	}
+	return a[i].member < a[j].member
+}
+
+func (a testZSetElements) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+// check counts of every rank node, returns level of the root
+func (s *testStoreSuite) checkRankIndex(c *C, db uint32, key string) int {
+	o, err := s.s.loadZSetRow(db, []byte(key))
+	c.Assert(err, IsNil)
+	c.Assert(o, NotNil)
+
+	levels, err := o.loadRankLevels(s.s)
+	c.Assert(err, IsNil)
+	c.Assert(levels > 0, Equals, true)
+
+	// ranges of index keys from left to right
+	var ranges []*rankChild
+	nodes := 0
+	var walk func(level int, start []byte) int64
+	walk = func(level int, start []byte) int64 {
+		n, err := o.loadRankNode(s.s, level, start)
+		c.Assert(err, IsNil)
+		c.Assert(n.Children[0].Start, DeepEquals, start)
+		nodes++
+
+		c.Assert(n.balanced(level == levels), Equals, true, Commentf("level = %d, children = %d", level, len(n.Children)))
+
+		var count int64
+		for _, v := range n.Children {
+			if level == 1 {
+				ranges = append(ranges, v)
+			} else {
+				c.Assert(walk(level-1, v.Start), Equals, v.Count)
+			}
+			count += v.Count
+		}
+		return count
+	}
+	c.Assert(walk(levels, []byte{}), Equals, o.Size)
+
+	it := s.s.getIterator()
+	defer s.s.putIterator(it)
+
+	ipfx := o.IndexKeyPrefix()
+	for i, v := range ranges {
+		var n int64
+		for it.SeekTo(o.indexKeyWithSuffix(v.Start)); it.Valid() && bytes.HasPrefix(it.Key(), ipfx); it.Next() {
+			if i+1 < len(ranges) && bytes.Compare(it.Key()[len(ipfx):], ranges[i+1].Start) >= 0 {
+				break
+			}
+			n++
+		}
+		c.Assert(n, Equals, v.Count)
+	}
+
+	// no other rank keys
+	rpfx := o.RankKeyPrefix()
+	for it.SeekTo(rpfx); it.Valid() && bytes.HasPrefix(it.Key(), rpfx); it.Next() {
+		nodes--
+	}
+	c.Assert(it.Error(), IsNil)
+	c.Assert(nodes, Equals, 0)
+	return levels
+}
+
+func (s *testStoreSuite) checkZSetRanks(c *C, db uint32, key string, eles testZSetElements) {
+	sort.Sort(eles)
+	for i := 0; i < len(eles); i += 3 {
+		s.zrank(c, db, key, eles[i].member, false, int64(i))
+		s.zrank(c, db, key, eles[i].member, true, int64(len(eles)-1-i))
+	}
+
+	for _, start := range []int{0, 1, rankNodeMaxSize - 1, rankNodeMaxSize, len(eles) / 2, len(eles) - 3} {
+		var expect, reverse []string
+		for i := start; i < start+3; i++ {
+			expect = append(expect, eles[i].member)
+			reverse = append(reverse, eles[len(eles)-1-i].member)
+		}
+		s.zrange(c, db, key, int64(start), int64(start+2), false, expect...)
+		s.zrange(c, db, key, int64(start), int64(start+2), true, reverse...)
+	}
+	s.checkRankIndex(c, db, key)
+}
+
+func (s *testStoreSuite) TestZSetRankIndex(c *C) {
+	r := rand.New(rand.NewSource(0))
+
+	var eles testZSetElements
+	var pairs []interface{}
+	for i := 0; i < rankNodeMaxSize*3; i++ {
+		e := testZSetElement{fmt.Sprintf("m%04d", i), r.Intn(1000)}
+		eles = append(eles, e)
+		pairs = append(pairs, e.member, e.score)
+	}
+	s.zadd(c, 0, "zset", int64(len(eles)), pairs...)
+	c.Assert(s.checkRankIndex(c, 0, "zset"), Equals, 1)
+
+	// ranges and nodes are split by adding members one by one
+	for i := len(eles); i < rankNodeMaxSize*rankNodeMaxSize; i += 8 {
+		pairs = pairs[:0]
+		for j := i; j < i+8; j++ {
+			e := testZSetElement{fmt.Sprintf("m%04d", j), r.Intn(1000)}
+			eles = append(eles, e)
+			pairs = append(pairs, e.member, e.score)
+		}
+		s.zadd(c, 0, "zset", 8, pairs...)
+	}
+	c.Assert(s.checkRankIndex(c, 0, "zset"), Equals, 2)
+	s.checkZSetRanks(c, 0, "zset", eles)
+
+	// scores are changed
+	for i := 0; i < len(eles); i += 7 {
+		e := &eles[i]
+		e.score = r.Intn(1000)
+		s.zadd(c, 0, "zset", 0, e.member, e.score)
+	}
+	s.zincrby(c, 0, "zset", eles[1].member, 2000, float64(eles[1].score+2000))
+	eles[1].score += 2000
+	s.checkZSetRanks(c, 0, "zset", eles)
+
+	// underfull ranges and nodes are merged
+	sort.Sort(eles)
+	n := len(eles) / 4
+	s.zremrangebyrank(c, 0, "zset", int64(n), int64(n*3-1), int64(n*2))
+	eles = append(eles[:n], eles[n*3:]...)
+	for i := 0; i < n; i++ {
+		s.zrem(c, 0, "zset", 1, eles[i].member)
+	}
+	eles = eles[n:]
+	s.checkZSetRanks(c, 0, "zset", eles)
+
+	// root with only one child is removed
+	for len(eles) > rankNodeMaxSize+4 {
+		s.zrem(c, 0, "zset", 2, eles[0].member, eles[len(eles)/2].member)
+		eles = append(eles[1:len(eles)/2], eles[len(eles)/2+1:]...)
+	}
+	c.Assert(s.checkRankIndex(c, 0, "zset"), Equals, 1)
+	s.checkZSetRanks(c, 0, "zset", eles)
+
+	s.zremrangebyscore(c, 0, "zset", "-inf", "+inf", int64(len(eles)))
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestZSetRankIndexRebuild(c *C) {
+	var eles testZSetElements
+	var pairs []interface{}
+	for i := 0; i < rankNodeMaxSize*rankNodeFillSize; i++ {
+		e := testZSetElement{fmt.Sprintf("m%05d", i), i}
+		eles = append(eles, e)
+		pairs = append(pairs, e.member, e.score)
+	}
+	s.zadd(c, 0, "zset", int64(len(eles)), pairs...)
+	c.Assert(s.checkRankIndex(c, 0, "zset"), Equals, 2)
+
+	// rank index of zset written before it is added
+	o, err := s.s.loadZSetRow(0, []byte("zset"))
+	c.Assert(err, IsNil)
+	bt := engine.NewBatch()
+	c.Assert(o.deleteRankKeys(s.s, bt), IsNil)
+	c.Assert(s.s.commit(bt, nil), IsNil)
+	levels, err := o.loadRankLevels(s.s)
+	c.Assert(err, IsNil)
+	c.Assert(levels, Equals, 0)
+	s.zrank(c, 0, "zset", eles[100].member, false, 100)
+
+	// rebuilt after the next write
+	s.zadd(c, 0, "zset", 1, "x", -1)
+	eles = append(eles, testZSetElement{"x", -1})
+	c.Assert(s.checkRankIndex(c, 0, "zset"), Equals, 2)
+	s.checkZSetRanks(c, 0, "zset", eles)
+
+	s.zdel(c, 0, "zset", 1)
+	s.checkEmpty(c)
+}
+
+func (s *testStoreSuite) TestSplitRankSize(c *C) {
+	for _, n := range []int64{1, rankNodeFillSize, rankNodeFillSize + 1, rankNodeMaxSize + 1, 1000} {
+		sizes := splitRankSize(n, rankNodeFillSize)
+		var sum int64
+		for _, size := range sizes {
+			c.Assert(size <= rankNodeFillSize, Equals, true)
+			c.Assert(size >= rankNodeMinSize || len(sizes) == 1, Equals, true)
+			sum += size
+		}
+		c.Assert(sum, Equals, n)
+		c.Assert(int64(len(sizes)), Equals, (n+rankNodeFillSize-1)/rankNodeFillSize)
+	}
+	c.Assert(splitRankSize(0, rankNodeFillSize), HasLen, 0)
+}
+
+func (s *testStoreSuite) TestZAddDuplicatedMembers(c *C) {
+	// the last score wins
+	n, err := s.s.ZAdd(0, FormatBytes("zset", 1, "a", 2, "a"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(1))
+	n, err = s.s.ZAdd(0, FormatBytes("zset", 3, "b", 4, "a", 0, "b"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(1))
+	s.zrange(c, 0, "zset", 0, -1, false, "b", "a")
+	s.zscore(c, 0, "zset", "a", 4)
+	s.zcount(c, 0, "zset", "-inf", "+inf", 2)
+	s.checkRankIndex(c, 0, "zset")
+
+	s.zdel(c, 0, "zset", 1)
+	s.checkEmpty(c)
+}